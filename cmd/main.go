@@ -1,12 +1,15 @@
 package main
 
 import (
+	"finance/internal/clearing"
 	"finance/internal/handlers"
 	"finance/internal/storage"
 	"finance/internal/utils"
 	"log"
+	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -21,6 +24,37 @@ func main() {
 	storage.InitDB()
 	defer storage.CloseDB()
 
+	// Finish salary payouts interrupted by a shutdown or a failed run
+	go runPeriodically(15*time.Minute, "salary disbursement", storage.ResumeSalaryDisbursements)
+
+	// Payroll calendar: create drafts, submit confirmed ones and send reminders
+	go runPeriodically(time.Hour, "payroll calendar", func() error {
+		return storage.RunPayrollCalendar(time.Now())
+	})
+
+	// Term deposit maturity: roll over or pay out as the client instructed
+	go runPeriodically(time.Hour, "deposit maturity", func() error {
+		return storage.ProcessMaturedDeposits(time.Now())
+	})
+
+	// Pay merchants for checkout plans whose loan a manager has since activated
+	go runPeriodically(15*time.Minute, "merchant settlement", storage.SettleInstallmentPurchases)
+
+	// Send queued interbank payments and apply settlements and returns. Until a clearing
+	// network is connected the bundled file simulator exchanges files in CLEARING_DIR.
+	clearingDir := os.Getenv("CLEARING_DIR")
+	if clearingDir == "" {
+		clearingDir = filepath.Join(".", "clearing")
+	}
+	connector := clearing.NewFileSimulator(clearingDir)
+	go runPeriodically(5*time.Minute, "interbank clearing", func() error {
+		return storage.RunClearing(connector)
+	})
+
+	// Screen deposit movements for fraud and money laundering right after every logged
+	// transaction, and periodically for postings made without one
+	go storage.RunAMLScreening(5 * time.Minute)
+
 	// Set up Gin router
 	r := gin.Default()
 	// Find the path to the static files
@@ -55,6 +89,9 @@ func main() {
 		c.File(filepath.Join(staticPath, "external.html"))
 	})
 
+	// Money-moving POST routes accept an Idempotency-Key header so that clients can retry them
+	idempotent := handlers.IdempotencyMiddleware()
+
 	// Public routes
 	r.GET("/health", handlers.HealthCheck)
 	r.POST("/auth/register", handlers.RegisterUser)
@@ -85,10 +122,86 @@ func main() {
 		adminRoutes.POST("/loans/approve", handlers.ApproveLoan)
 		adminRoutes.POST("/loans/reject", handlers.RejectLoan)
 
+		// Dual control for large operations
+		adminRoutes.GET("/approval-policies", handlers.GetApprovalPolicies)
+		adminRoutes.PUT("/approval-policies/:operation_type", handlers.SetApprovalPolicy)
+		adminRoutes.DELETE("/approval-policies/:operation_type", handlers.DeleteApprovalPolicy)
+		adminRoutes.GET("/approvals/:operation_type/:id", handlers.GetOperationApprovals)
+
+		// Transaction limits at global, product and user level
+		adminRoutes.GET("/transaction-limits", handlers.GetTransactionLimits)
+		adminRoutes.PUT("/transaction-limits", handlers.SetTransactionLimit)
+		adminRoutes.DELETE("/transaction-limits/:id", handlers.DeleteTransactionLimit)
+
+		// Loan product catalog
+		adminRoutes.GET("/loan-products", handlers.GetLoanProducts)
+		adminRoutes.POST("/loan-products", handlers.CreateLoanProduct)
+		adminRoutes.POST("/loan-products/:id/retire", handlers.RetireLoanProduct)
+
+		// Deposit product catalog
+		adminRoutes.GET("/deposit-products", handlers.GetDepositProducts)
+		adminRoutes.POST("/deposit-products", handlers.CreateDepositProduct)
+		adminRoutes.POST("/deposit-products/:id/retire", handlers.RetireDepositProduct)
+
+		// Credit scoring configuration
+		adminRoutes.GET("/scoring", handlers.GetScoringConfig)
+		adminRoutes.POST("/scoring/rules", handlers.CreateScoringRule)
+		adminRoutes.PUT("/scoring/rules/:id", handlers.UpdateScoringRule)
+		adminRoutes.DELETE("/scoring/rules/:id", handlers.DeleteScoringRule)
+		adminRoutes.PUT("/scoring/settings", handlers.UpdateScoringSettings)
+
+		// Fraud and AML rules
+		adminRoutes.GET("/aml/rules", handlers.GetAMLRules)
+		adminRoutes.PUT("/aml/rules/:code", handlers.UpdateAMLRule)
+
+		// Merchant registry and checkout installment plans
+		adminRoutes.GET("/merchants", handlers.GetMerchants)
+		adminRoutes.POST("/merchants", handlers.CreateMerchant)
+		adminRoutes.POST("/merchants/:id/active", handlers.SetMerchantActive)
+		adminRoutes.POST("/merchants/:id/rotate-key", handlers.RotateMerchantKey)
+		adminRoutes.GET("/merchants/:id/plans", handlers.GetMerchantPlans)
+		adminRoutes.POST("/merchants/:id/plans", handlers.CreateMerchantPlan)
+		adminRoutes.POST("/merchant-plans/:id/active", handlers.SetMerchantPlanActive)
+		adminRoutes.GET("/installment-purchases", handlers.GetInstallmentPurchases)
+		adminRoutes.POST("/installment-purchases/settle", handlers.SettleInstallmentPurchases)
+
 		// External specialist request management
 		adminRoutes.GET("/external/pending-requests", handlers.GetPendingExternalRequests)
 		adminRoutes.POST("/external/approve", handlers.ApproveExternalRequest)
 		adminRoutes.POST("/external/reject", handlers.RejectExternalRequest)
+
+		// Enterprise settlement accounts
+		adminRoutes.GET("/enterprises/:id/accounts", handlers.GetEnterpriseAccounts)
+		adminRoutes.POST("/enterprises/:id/accounts", handlers.OpenEnterpriseAccount)
+		adminRoutes.POST("/enterprises/:id/credit", idempotent, handlers.CreditEnterpriseAccount)
+		adminRoutes.POST("/enterprise-accounts/:id/overdraft", handlers.SetEnterpriseOverdraftLimit)
+
+		// Enterprise onboarding and specialist access
+		adminRoutes.GET("/onboarding/applications", handlers.GetEnterpriseApplications)
+		adminRoutes.GET("/onboarding/applications/:id", handlers.GetEnterpriseApplication)
+		adminRoutes.POST("/onboarding/applications/:id/approve", handlers.ApproveEnterpriseApplication)
+		adminRoutes.POST("/onboarding/applications/:id/reject", handlers.RejectEnterpriseApplication)
+		adminRoutes.GET("/enterprises/:id/access", handlers.GetEnterpriseAccess)
+		adminRoutes.POST("/enterprises/:id/access", handlers.GrantEnterpriseAccess)
+		adminRoutes.DELETE("/enterprises/:id/access/:user_id", handlers.RevokeEnterpriseAccess)
+
+		// Salary project disbursement
+		adminRoutes.POST("/salary-projects/:id/disburse", idempotent, handlers.DisburseSalaryProject)
+		adminRoutes.GET("/salary-projects/:id/report", handlers.GetSalaryDisbursementReport)
+
+		// Interbank payment queue
+		adminRoutes.GET("/outgoing-payments", handlers.GetOutgoingPayments)
+		adminRoutes.POST("/outgoing-payments/:id/return", idempotent, handlers.ReturnOutgoingPayment)
+		adminRoutes.POST("/outgoing-payments/:id/credit", idempotent, handlers.CreditHeldPaymentReturn)
+	}
+
+	// Unified approval inbox for admins, managers and operators
+	inboxRoutes := r.Group("/inbox")
+	inboxRoutes.Use(handlers.AuthMiddleware())
+	{
+		inboxRoutes.GET("", handlers.GetInbox)
+		inboxRoutes.POST("/assign", handlers.AssignInboxItem)
+		inboxRoutes.POST("/bulk", handlers.BulkInboxDecision)
 	}
 
 	// Operator routes
@@ -102,19 +215,43 @@ func main() {
 		operatorRoutes.GET("/users/:id/last-action", handlers.GetUserLastAction)
 		operatorRoutes.POST("/cancel-action", handlers.CancelLastOperation)
 		operatorRoutes.GET("/transactions", handlers.GetTransactions)
+
+		// Temporary raises of a client's transaction limits
+		operatorRoutes.POST("/limit-raises", handlers.GrantLimitRaise)
+		operatorRoutes.GET("/limit-raises", handlers.GetLimitRaises)
+		operatorRoutes.POST("/limit-raises/:id/revoke", handlers.RevokeLimitRaise)
+
+		// AML alerts; open ones are worked in the inbox
+		operatorRoutes.GET("/aml/alerts", handlers.GetAMLAlerts)
+		operatorRoutes.GET("/aml/suspicious-activity-report", handlers.GetSuspiciousActivityReport)
+
+		// Cash desk: the operator is recorded as the teller together with the branch
+		operatorRoutes.POST("/cash-desk/top-up", idempotent, handlers.CashDeskTopUp)
+		operatorRoutes.POST("/cash-desk/withdraw", idempotent, handlers.CashDeskWithdraw)
+		operatorRoutes.POST("/cash-desk/close", idempotent, handlers.CashDeskCloseDeposit)
 	}
 
 	// Register deposit API endpoints
 	depositRoutes := r.Group("/deposit")
 	depositRoutes.Use(handlers.AuthMiddleware())
 	{
-		depositRoutes.POST("/create", handlers.CreateDeposit)
-		depositRoutes.DELETE("/delete", handlers.DeleteDeposit)
-		depositRoutes.POST("/transfer", handlers.TransferBetweenAccounts)
+		depositRoutes.POST("/create", idempotent, handlers.CreateDeposit)
+		depositRoutes.POST("/close", idempotent, handlers.CloseDeposit)
+		depositRoutes.DELETE("/delete", handlers.CloseDeposit) // Kept for existing clients; closes instead of deleting
+		depositRoutes.POST("/transfer", idempotent, handlers.TransferBetweenAccounts)
+		depositRoutes.POST("/top-up", idempotent, handlers.TopUpDeposit)
+		depositRoutes.POST("/withdraw", idempotent, handlers.WithdrawFromDeposit)
+		depositRoutes.POST("/interbank-transfer", idempotent, handlers.SendInterbankTransfer)
+		depositRoutes.GET("/interbank-transfers", handlers.GetInterbankTransfers)
+		depositRoutes.GET("/limits", handlers.GetMyLimits)
+		depositRoutes.GET("/:id/movements", handlers.GetDepositMovements)
+		depositRoutes.GET("/:id/statement", handlers.GetDepositStatement)
 		depositRoutes.POST("/freeze", handlers.FreezeDeposit)
 		depositRoutes.POST("/block", handlers.BlockDeposit)
 		depositRoutes.POST("/unblock", handlers.UnblockDeposit)
 		depositRoutes.GET("/list", handlers.GetDeposits)
+		depositRoutes.GET("/products", handlers.GetDepositProductOffers)
+		depositRoutes.POST("/maturity-instruction", handlers.SetMaturityInstruction)
 	}
 
 	// Register loan API endpoints
@@ -124,8 +261,30 @@ func main() {
 		loanRoutes.POST("/request", handlers.RequestLoan)
 		loanRoutes.GET("/list", handlers.GetUserLoans)
 		loanRoutes.GET("/:id", handlers.GetLoanDetails)
-		loanRoutes.POST("/payment", handlers.MakeLoanPayment)
+		loanRoutes.GET("/:id/history", handlers.GetLoanHistory)
+		loanRoutes.POST("/:id/collateral", handlers.AddLoanCollateral)
+		loanRoutes.POST("/:id/guarantors", handlers.AddLoanGuarantor)
+		loanRoutes.GET("/guarantees", handlers.GetGuaranteeRequests)
+		loanRoutes.POST("/guarantees/:id/accept", handlers.AcceptGuarantee)
+		loanRoutes.POST("/guarantees/:id/decline", handlers.DeclineGuarantee)
+		loanRoutes.POST("/payment", idempotent, handlers.MakeLoanPayment)
 		loanRoutes.GET("/rates", handlers.GetLoanRates)
+
+		// Installment plans created by merchants at checkout
+		loanRoutes.GET("/installments", handlers.GetMyInstallmentPurchases)
+		loanRoutes.POST("/installments/:id/confirm", idempotent, handlers.ConfirmInstallmentPurchase)
+		loanRoutes.POST("/installments/:id/decline", handlers.DeclineInstallmentPurchase)
+	}
+
+	// Merchant checkout API, authenticated with the merchant API key
+	merchantRoutes := r.Group("/merchant")
+	merchantRoutes.Use(handlers.MerchantAuthMiddleware())
+	{
+		merchantRoutes.GET("/plans", handlers.GetMerchantOwnPlans)
+		merchantRoutes.POST("/purchases", idempotent, handlers.CreateCheckoutPurchase)
+		merchantRoutes.GET("/purchases", handlers.GetMerchantPurchases)
+		merchantRoutes.GET("/purchases/:id", handlers.GetMerchantPurchase)
+		merchantRoutes.POST("/purchases/:id/cancel", idempotent, handlers.CancelMerchantPurchase)
 	}
 
 	// Manager routes
@@ -133,15 +292,43 @@ func main() {
 	managerRoutes.Use(handlers.AuthMiddleware())
 	handlers.RegisterManagerRoutes(managerRoutes)
 
-	// External Enterprise Specialist routes
+	// External Enterprise Specialist routes - requests must also carry an HMAC signature
 	externalRoutes := r.Group("/external")
-	externalRoutes.Use(handlers.AuthMiddleware())
+	externalRoutes.Use(handlers.AuthMiddleware(), handlers.SignatureMiddleware())
 	{
-		externalRoutes.POST("/salary-project", handlers.SubmitSalaryProject)
-		externalRoutes.POST("/transfer-request", handlers.RequestEnterpriseTransfer)
+		externalRoutes.POST("/salary-project", handlers.SubmitSalaryProject) // Retired, answers 410
+		externalRoutes.POST("/salary-project/upload", idempotent, handlers.UploadSalaryProject)
+		externalRoutes.POST("/transfer-request", idempotent, handlers.RequestEnterpriseTransfer)
 		externalRoutes.GET("/transfers", handlers.GetEnterpriseTransfers)
 		externalRoutes.GET("/salary-projects", handlers.GetSalaryProjects)
+		externalRoutes.GET("/salary-projects/:id/report", handlers.GetSalaryProjectReport)
 		externalRoutes.GET("/enterprises", handlers.GetUserEnterprises)
+		externalRoutes.GET("/enterprises/:id/statement", handlers.GetEnterpriseStatement)
+		externalRoutes.POST("/enterprises/:id/payment-batches", idempotent, handlers.UploadPaymentBatch)
+		externalRoutes.GET("/enterprises/:id/payment-batches", handlers.GetPaymentBatches)
+		externalRoutes.GET("/payment-batches/:id", handlers.GetPaymentBatch)
+
+		// Enterprise onboarding
+		externalRoutes.POST("/onboarding/applications", handlers.SubmitEnterpriseApplication)
+		externalRoutes.GET("/onboarding/applications", handlers.GetMyEnterpriseApplications)
+		externalRoutes.GET("/onboarding/applications/:id", handlers.GetEnterpriseApplication)
+		externalRoutes.POST("/onboarding/applications/:id/documents", handlers.UploadEnterpriseApplicationDocument)
+
+		// Employee registry
+		externalRoutes.GET("/enterprises/:id/employees", handlers.GetEnterpriseEmployees)
+		externalRoutes.POST("/enterprises/:id/employees", handlers.CreateEnterpriseEmployee)
+		externalRoutes.GET("/enterprises/:id/employees/:employee_id", handlers.GetEnterpriseEmployee)
+		externalRoutes.PUT("/enterprises/:id/employees/:employee_id", handlers.UpdateEnterpriseEmployee)
+		externalRoutes.DELETE("/enterprises/:id/employees/:employee_id", handlers.DeleteEnterpriseEmployee)
+
+		// Recurring payroll
+		externalRoutes.GET("/enterprises/:id/payroll-templates", handlers.GetPayrollTemplates)
+		externalRoutes.POST("/enterprises/:id/payroll-templates", handlers.CreatePayrollTemplate)
+		externalRoutes.GET("/enterprises/:id/payroll-reminders", handlers.GetPayrollReminders)
+		externalRoutes.POST("/payroll-templates/:id/active", handlers.SetPayrollTemplateActive)
+		externalRoutes.POST("/payroll-templates/:id/copy-forward", handlers.CopyForwardPayrollTemplate)
+		externalRoutes.PUT("/salary-projects/:id/payments/:payment_id", handlers.UpdateDraftSalaryPayment)
+		externalRoutes.POST("/salary-projects/:id/confirm", handlers.ConfirmDraftSalaryProject)
 	}
 
 	// Start server
@@ -150,3 +337,15 @@ func main() {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
+
+// runPeriodically runs job immediately and then every interval, logging failures
+func runPeriodically(interval time.Duration, name string, job func() error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := job(); err != nil {
+			log.Printf("Warning: %s job failed: %v", name, err)
+		}
+		<-ticker.C
+	}
+}
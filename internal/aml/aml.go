@@ -0,0 +1,264 @@
+// Package aml screens deposit activity for signs of fraud and money laundering.
+// Every rule has a fixed check whose thresholds and severity are data, so compliance can
+// tune them without a release.
+package aml
+
+import (
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"time"
+)
+
+// Severities of a rule. High severity hits freeze the deposit until the alert is worked.
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Rules the engine knows how to check
+const (
+	// RuleStructuring flags repeated operations of the same kind just below a reporting threshold
+	RuleStructuring = "structuring"
+	// RuleRapidInOut flags a deposit that passes on most of the money it received shortly before
+	RuleRapidInOut = "rapid_in_out"
+	// RuleNewAccountSalary flags large salary payments to a client who only just joined the bank
+	RuleNewAccountSalary = "new_account_salary"
+	// RuleTransferToNewDeposit flags large transfers from other clients to a recently opened deposit
+	RuleTransferToNewDeposit = "transfer_to_new_deposit"
+)
+
+// ErrInvalidRule is returned for a rule with an unknown code or settings out of range
+var ErrInvalidRule = errors.New("invalid AML rule")
+
+// inflowMovements and outflowMovements are the movements that bring money in from or send it
+// out to someone. Interest, fees and cancellations are the bank's own bookings.
+var (
+	inflowMovements = map[string]bool{
+		models.MovementOpening: true, models.MovementTopUp: true, models.MovementTransferIn: true,
+		models.MovementSalary: true, models.MovementPaymentReturn: true,
+	}
+	outflowMovements = map[string]bool{
+		models.MovementWithdrawal: true, models.MovementTransferOut: true,
+	}
+)
+
+// Movement is a posting to a deposit as the rules see it
+type Movement struct {
+	ID        int64     `json:"id"`
+	DepositID int64     `json:"deposit_id"`
+	ClientID  int64     `json:"client_id"`
+	Type      string    `json:"type"`
+	Amount    float64   `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Activity is a movement being screened together with what the bank knows around it
+type Activity struct {
+	Movement        Movement
+	DepositOpenedAt time.Time
+	// ClientSince is when the client registered with the bank
+	ClientSince time.Time
+	// SenderClientID is the owner of the deposit a transfer_in came from, 0 if unknown
+	SenderClientID int64
+	// History holds the earlier movements of all deposits of the client inside the longest
+	// rule window, oldest first. Cancelled movements are left out.
+	History []Movement
+}
+
+// Rule is the configuration of one check
+type Rule struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Active   bool   `json:"active"`
+	// Threshold is the amount the check is about: the reporting threshold for structuring,
+	// the least money received for rapid in-out and the least amount for the other rules
+	Threshold float64 `json:"threshold"`
+	// Ratio is the band below the threshold for structuring and the share of the money
+	// received that must leave again for rapid in-out
+	Ratio float64 `json:"ratio"`
+	// WindowHours is the period looked back on, or the age under which an account is new
+	WindowHours int `json:"window_hours"`
+	// MinCount is how many operations in the band make structuring
+	MinCount int `json:"min_count"`
+}
+
+// Window returns WindowHours as a duration
+func (r Rule) Window() time.Duration {
+	return time.Duration(r.WindowHours) * time.Hour
+}
+
+// Validate checks the severity and the settings the rule's check uses
+func (r Rule) Validate() error {
+	switch r.Severity {
+	case SeverityLow, SeverityMedium, SeverityHigh:
+	default:
+		return fmt.Errorf("%w: severity must be low, medium or high", ErrInvalidRule)
+	}
+	if r.Threshold <= 0 {
+		return fmt.Errorf("%w: threshold must be positive", ErrInvalidRule)
+	}
+	if r.WindowHours <= 0 {
+		return fmt.Errorf("%w: window_hours must be positive", ErrInvalidRule)
+	}
+	switch r.Code {
+	case RuleStructuring:
+		if r.Ratio <= 0 || r.Ratio >= 1 {
+			return fmt.Errorf("%w: ratio must be between 0 and 1", ErrInvalidRule)
+		}
+		if r.MinCount < 2 {
+			return fmt.Errorf("%w: min_count must be at least 2", ErrInvalidRule)
+		}
+	case RuleRapidInOut:
+		if r.Ratio <= 0 || r.Ratio > 1 {
+			return fmt.Errorf("%w: ratio must be above 0 and at most 1", ErrInvalidRule)
+		}
+	case RuleNewAccountSalary, RuleTransferToNewDeposit:
+	default:
+		return fmt.Errorf("%w: unknown rule %q", ErrInvalidRule, r.Code)
+	}
+	return nil
+}
+
+// DefaultRules are seeded for rules that are not configured yet
+func DefaultRules() []Rule {
+	return []Rule{
+		{Code: RuleStructuring, Name: "Structuring below the reporting threshold", Severity: SeverityHigh, Active: true,
+			Threshold: 10000, Ratio: 0.9, WindowHours: 72, MinCount: 3},
+		{Code: RuleRapidInOut, Name: "Money passed on right after it arrived", Severity: SeverityMedium, Active: true,
+			Threshold: 5000, Ratio: 0.8, WindowHours: 48},
+		{Code: RuleNewAccountSalary, Name: "Large salary to a new client", Severity: SeverityMedium, Active: true,
+			Threshold: 5000, WindowHours: 30 * 24},
+		{Code: RuleTransferToNewDeposit, Name: "Transfer to a recently opened deposit", Severity: SeverityHigh, Active: true,
+			Threshold: 3000, WindowHours: 7 * 24},
+	}
+}
+
+// Hit is a rule that matched a movement
+type Hit struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Reason   string `json:"reason"`
+}
+
+// Evaluate runs the active rules against a movement
+func Evaluate(activity Activity, rules []Rule) []Hit {
+	hits := []Hit{}
+	for _, rule := range rules {
+		if !rule.Active {
+			continue
+		}
+		var reason string
+		switch rule.Code {
+		case RuleStructuring:
+			reason = structuring(activity, rule)
+		case RuleRapidInOut:
+			reason = rapidInOut(activity, rule)
+		case RuleNewAccountSalary:
+			reason = newAccountSalary(activity, rule)
+		case RuleTransferToNewDeposit:
+			reason = transferToNewDeposit(activity, rule)
+		}
+		if reason != "" {
+			hits = append(hits, Hit{Rule: rule.Code, Severity: rule.Severity, Reason: reason})
+		}
+	}
+	return hits
+}
+
+// structuring matches the operation that brings the number of same-type operations of the
+// client just below the threshold up to MinCount. Later ones in the same window do not match
+// again, so one pattern raises one alert.
+func structuring(activity Activity, rule Rule) string {
+	m := activity.Movement
+	if !inflowMovements[m.Type] && !outflowMovements[m.Type] || !inBand(m.Amount, rule) {
+		return ""
+	}
+	since := m.CreatedAt.Add(-rule.Window())
+	count := 1
+	for _, earlier := range activity.History {
+		if earlier.Type == m.Type && !earlier.CreatedAt.Before(since) && inBand(earlier.Amount, rule) {
+			count++
+		}
+	}
+	if count != rule.MinCount {
+		return ""
+	}
+	return fmt.Sprintf("%d %s operations between %.2f and %.2f within %d hours",
+		count, m.Type, rule.Threshold*rule.Ratio, rule.Threshold, rule.WindowHours)
+}
+
+func inBand(amount float64, rule Rule) bool {
+	return amount >= rule.Threshold*rule.Ratio && amount < rule.Threshold
+}
+
+// rapidInOut matches the outflow that takes the money leaving a deposit over Ratio of what
+// came in during the window
+func rapidInOut(activity Activity, rule Rule) string {
+	m := activity.Movement
+	if !outflowMovements[m.Type] {
+		return ""
+	}
+	since := m.CreatedAt.Add(-rule.Window())
+	var in, out float64
+	for _, earlier := range activity.History {
+		if earlier.DepositID != m.DepositID || earlier.CreatedAt.Before(since) {
+			continue
+		}
+		if inflowMovements[earlier.Type] {
+			in += earlier.Amount
+		} else if outflowMovements[earlier.Type] {
+			out += earlier.Amount
+		}
+	}
+	if in < rule.Threshold || out >= in*rule.Ratio || out+m.Amount < in*rule.Ratio {
+		return ""
+	}
+	return fmt.Sprintf("%.2f of %.2f received within %d hours left the deposit",
+		out+m.Amount, in, rule.WindowHours)
+}
+
+// newAccountSalary matches a large salary credited to a client registered within the window
+func newAccountSalary(activity Activity, rule Rule) string {
+	m := activity.Movement
+	if m.Type != models.MovementSalary || m.Amount < rule.Threshold {
+		return ""
+	}
+	since := activity.ClientSince
+	if since.IsZero() {
+		since = activity.DepositOpenedAt
+	}
+	age := m.CreatedAt.Sub(since)
+	if age >= rule.Window() {
+		return ""
+	}
+	return fmt.Sprintf("salary of %.2f to a client who joined %s ago", m.Amount, roundAge(age))
+}
+
+// transferToNewDeposit matches a large transfer from another client to a deposit opened within
+// the window. Moving money between one's own deposits is not suspicious.
+func transferToNewDeposit(activity Activity, rule Rule) string {
+	m := activity.Movement
+	if m.Type != models.MovementTransferIn || m.Amount < rule.Threshold {
+		return ""
+	}
+	if activity.SenderClientID == 0 || activity.SenderClientID == m.ClientID {
+		return ""
+	}
+	age := m.CreatedAt.Sub(activity.DepositOpenedAt)
+	if age >= rule.Window() {
+		return ""
+	}
+	return fmt.Sprintf("transfer of %.2f from client #%d to a deposit opened %s ago",
+		m.Amount, activity.SenderClientID, roundAge(age))
+}
+
+// roundAge shortens an age to whole hours for reasons
+func roundAge(age time.Duration) time.Duration {
+	if age < time.Hour {
+		return age.Round(time.Minute)
+	}
+	return age.Round(time.Hour)
+}
@@ -0,0 +1,64 @@
+package aml
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+const reportTimeLayout = "2006-01-02 15:04:05"
+
+// SuspiciousActivity is an alert confirmed by an operator, as filed in the suspicious
+// activity report
+type SuspiciousActivity struct {
+	AlertID       int64     `json:"alert_id"`
+	Rule          string    `json:"rule"`
+	Severity      string    `json:"severity"`
+	Reason        string    `json:"reason"`
+	ClientID      int64     `json:"client_id"`
+	Username      string    `json:"username"`
+	DepositID     int64     `json:"deposit_id"`
+	BankName      string    `json:"bank_name"`
+	MovementID    int64     `json:"movement_id"`
+	MovementType  string    `json:"movement_type"`
+	Amount        float64   `json:"amount"`
+	Counterparty  string    `json:"counterparty,omitempty"`
+	Reference     string    `json:"reference,omitempty"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	DetectedAt    time.Time `json:"detected_at"`
+	DepositFrozen bool      `json:"deposit_frozen"`
+	ReportedBy    int64     `json:"reported_by"`
+	ReportedAt    time.Time `json:"reported_at"`
+	Comment       string    `json:"comment,omitempty"`
+}
+
+// reportHeader is the header row of the CSV report
+var reportHeader = []string{
+	"alert_id", "rule", "severity", "reason", "client_id", "username", "deposit_id", "bank_name",
+	"movement_id", "movement_type", "amount", "counterparty", "reference", "occurred_at", "detected_at",
+	"deposit_frozen", "reported_by", "reported_at", "comment",
+}
+
+// WriteReportCSV writes the suspicious activity report with one row per confirmed alert
+func WriteReportCSV(w io.Writer, activities []SuspiciousActivity) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, a := range activities {
+		row := []string{
+			strconv.FormatInt(a.AlertID, 10), a.Rule, a.Severity, a.Reason,
+			strconv.FormatInt(a.ClientID, 10), a.Username, strconv.FormatInt(a.DepositID, 10), a.BankName,
+			strconv.FormatInt(a.MovementID, 10), a.MovementType, strconv.FormatFloat(a.Amount, 'f', 2, 64),
+			a.Counterparty, a.Reference, a.OccurredAt.Format(reportTimeLayout), a.DetectedAt.Format(reportTimeLayout),
+			strconv.FormatBool(a.DepositFrozen), strconv.FormatInt(a.ReportedBy, 10),
+			a.ReportedAt.Format(reportTimeLayout), a.Comment,
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,42 @@
+// Package clearing hands payments to accounts at other banks over to an interbank clearing
+// system. The bank talks to the clearing system through a Connector, so the file-based
+// simulator bundled here can be replaced by a real network without touching the payment queue.
+package clearing
+
+import "time"
+
+// Outcomes reported by the clearing system
+const (
+	StatusSettled  = "settled"  // The beneficiary bank credited the payment
+	StatusReturned = "returned" // The beneficiary bank sent the money back
+)
+
+// Payment is an outgoing payment submitted for clearing
+type Payment struct {
+	ID              int64 // Outgoing payment ID in the bank
+	Amount          float64
+	Currency        string
+	BeneficiaryName string
+	AccountNumber   string
+	BankName        string
+	Purpose         string
+}
+
+// Result is the outcome of a payment reported by the clearing system
+type Result struct {
+	Reference string // Clearing reference returned by Send
+	Status    string // StatusSettled or StatusReturned
+	Reason    string // Why the payment was returned
+	At        time.Time
+}
+
+// Connector is a link to an interbank clearing system
+type Connector interface {
+	// Name identifies the clearing system in logs and payment records
+	Name() string
+	// Send submits payments for clearing and returns the clearing reference of each, in order
+	Send(payments []Payment) ([]string, error)
+	// Poll passes the outcomes reported since the last poll to handle. Outcomes for which
+	// handle fails are reported again by the next poll, so handle must be idempotent.
+	Poll(handle func(Result) error) error
+}
@@ -0,0 +1,247 @@
+package clearing
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Subdirectories used by FileSimulator
+const (
+	outboxDir  = "outbox"  // Payment files sent by the bank
+	inboxDir   = "inbox"   // Result files reported back to the bank
+	archiveDir = "archive" // Files that have been answered or processed
+)
+
+var (
+	paymentFileHeader = []string{"reference", "payment_id", "amount", "currency", "beneficiary_name", "account_number", "bank_name", "purpose"}
+	resultFileHeader  = []string{"reference", "status", "reason", "at"}
+)
+
+// accountNumberPattern is what the simulated beneficiary banks accept as an account number
+var accountNumberPattern = regexp.MustCompile(`^[A-Za-z0-9]{5,34}$`)
+
+// FileSimulator is a clearing connector that exchanges CSV files in a directory, standing in
+// for the clearing network in development and tests. Send writes payment files to the outbox;
+// Poll answers them as the beneficiary banks would, writing result files to the inbox, and then
+// reports the results found in the inbox. With Manual set the outbox is left for an operator or
+// a test to answer by placing result files in the inbox.
+type FileSimulator struct {
+	Dir    string
+	Manual bool
+	// Return decides whether a beneficiary bank returns a payment, giving the reason, or settles
+	// it when the reason is empty. When nil, payments to malformed account numbers are returned.
+	Return func(Payment) string
+}
+
+// NewFileSimulator creates a simulator working in dir
+func NewFileSimulator(dir string) *FileSimulator {
+	return &FileSimulator{Dir: dir}
+}
+
+// Name identifies the simulator
+func (s *FileSimulator) Name() string {
+	return "file-simulator"
+}
+
+// Send writes the payments to a new file in the outbox
+func (s *FileSimulator) Send(payments []Payment) ([]string, error) {
+	if len(payments) == 0 {
+		return nil, nil
+	}
+	if err := s.ensureDirs(); err != nil {
+		return nil, err
+	}
+
+	name := "PAY" + time.Now().UTC().Format("20060102T150405.000000000")
+	references := make([]string, len(payments))
+	rows := [][]string{paymentFileHeader}
+	for i, payment := range payments {
+		references[i] = fmt.Sprintf("%s-%d", name, i+1)
+		rows = append(rows, []string{
+			references[i],
+			strconv.FormatInt(payment.ID, 10),
+			strconv.FormatFloat(payment.Amount, 'f', 2, 64),
+			payment.Currency,
+			payment.BeneficiaryName,
+			payment.AccountNumber,
+			payment.BankName,
+			payment.Purpose,
+		})
+	}
+	if err := writeCSVFile(filepath.Join(s.Dir, outboxDir, name+".csv"), rows); err != nil {
+		return nil, err
+	}
+	return references, nil
+}
+
+// Poll answers the outbox unless the simulator is manual and reports every result in the inbox.
+// A result file is archived once all of its results were handled.
+func (s *FileSimulator) Poll(handle func(Result) error) error {
+	if err := s.ensureDirs(); err != nil {
+		return err
+	}
+	if !s.Manual {
+		if err := s.answerOutbox(); err != nil {
+			return err
+		}
+	}
+
+	files, err := csvFiles(filepath.Join(s.Dir, inboxDir))
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, file := range files {
+		if err := s.processResultFile(file, handle); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %w", filepath.Base(file), err)
+			}
+			continue
+		}
+		if err := os.Rename(file, filepath.Join(s.Dir, archiveDir, "result-"+filepath.Base(file))); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *FileSimulator) processResultFile(file string, handle func(Result) error) error {
+	rows, err := readCSVFile(file)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && row[0] == resultFileHeader[0] {
+			continue
+		}
+		if len(row) < 2 {
+			return fmt.Errorf("line %d: expected reference and status", i+1)
+		}
+		result := Result{Reference: strings.TrimSpace(row[0]), Status: strings.TrimSpace(row[1]), At: time.Now()}
+		if len(row) > 2 {
+			result.Reason = strings.TrimSpace(row[2])
+		}
+		if len(row) > 3 && row[3] != "" {
+			if at, err := time.Parse(time.RFC3339, row[3]); err == nil {
+				result.At = at
+			}
+		}
+		if result.Status != StatusSettled && result.Status != StatusReturned {
+			return fmt.Errorf("line %d: unknown status %q", i+1, result.Status)
+		}
+		if err := handle(result); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", result.Reference, err)
+		}
+	}
+	return firstErr
+}
+
+// answerOutbox plays the beneficiary banks: every payment file in the outbox gets a result file
+func (s *FileSimulator) answerOutbox() error {
+	files, err := csvFiles(filepath.Join(s.Dir, outboxDir))
+	if err != nil {
+		return err
+	}
+	decide := s.Return
+	if decide == nil {
+		decide = defaultReturn
+	}
+
+	for _, file := range files {
+		rows, err := readCSVFile(file)
+		if err != nil {
+			return err
+		}
+		at := time.Now().UTC().Format(time.RFC3339)
+		results := [][]string{resultFileHeader}
+		for i, row := range rows {
+			if i == 0 || len(row) < len(paymentFileHeader) {
+				continue
+			}
+			payment := Payment{BeneficiaryName: row[4], AccountNumber: row[5], BankName: row[6], Currency: row[3], Purpose: row[7]}
+			payment.ID, _ = strconv.ParseInt(row[1], 10, 64)
+			payment.Amount, _ = strconv.ParseFloat(row[2], 64)
+			if reason := decide(payment); reason != "" {
+				results = append(results, []string{row[0], StatusReturned, reason, at})
+			} else {
+				results = append(results, []string{row[0], StatusSettled, "", at})
+			}
+		}
+		if err := writeCSVFile(filepath.Join(s.Dir, inboxDir, filepath.Base(file)), results); err != nil {
+			return err
+		}
+		if err := os.Rename(file, filepath.Join(s.Dir, archiveDir, "sent-"+filepath.Base(file))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultReturn returns payments the simulated beneficiary bank cannot credit
+func defaultReturn(payment Payment) string {
+	switch {
+	case !accountNumberPattern.MatchString(payment.AccountNumber):
+		return "AC01 incorrect account number"
+	case strings.TrimSpace(payment.BeneficiaryName) == "":
+		return "BE05 unrecognised beneficiary"
+	}
+	return ""
+}
+
+func (s *FileSimulator) ensureDirs() error {
+	for _, dir := range []string{outboxDir, inboxDir, archiveDir} {
+		if err := os.MkdirAll(filepath.Join(s.Dir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvFiles lists the CSV files of a directory in name order, which is the order they were written
+func csvFiles(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.csv"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readCSVFile(path string) ([][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+// writeCSVFile writes the file under a temporary name first so that a poll never reads half of it
+func writeCSVFile(path string, rows [][]string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(f)
+	if err := writer.WriteAll(rows); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
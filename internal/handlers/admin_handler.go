@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -257,14 +259,57 @@ func ApproveExternalRequest(c *gin.Context) {
 	}
 
 	if err != nil {
+		if respondApprovalPolicyError(c, err) {
+			return
+		}
+		if errors.Is(err, storage.ErrEnterpriseTransferNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrTransferFailed) {
+			// The transfer was moved to the failed status, this is not a server error
+			response := gin.H{"error": err.Error(), "status": "failed"}
+			if errors.Is(err, storage.ErrInsufficientEnterpriseBalance) {
+				response["reason"] = "insufficient_funds"
+			}
+			c.JSON(http.StatusUnprocessableEntity, response)
+			return
+		}
+		if errors.Is(err, storage.ErrInsufficientEnterpriseBalance) || errors.Is(err, storage.ErrSalaryProjectNoPayments) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to approve %s: %v", request.RequestType, err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message":    fmt.Sprintf("%s approved successfully", request.RequestType),
 		"request_id": request.RequestID,
-	})
+	}
+	// Approved salary projects are paid out right away
+	if request.RequestType == "salary_project" {
+		addDisbursementResult(response, request.RequestID, int64(userID))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// addDisbursementResult disburses an approved salary project and adds the outcome to response.
+// A failed run does not undo the approval; it is resumed later. Projects with a later pay date
+// are paid out on that day.
+func addDisbursementResult(response gin.H, projectID, adminID int64) {
+	report, err := storage.DisburseSalaryProject(projectID, adminID)
+	if errors.Is(err, storage.ErrSalaryProjectNotDue) {
+		response["disbursement_scheduled"] = err.Error()
+		return
+	}
+	if err != nil {
+		log.Printf("Error disbursing salary project %d: %v", projectID, err)
+		response["disbursement_error"] = "disbursement did not finish and will be resumed"
+		return
+	}
+	response["disbursement"] = report
 }
 
 // RejectExternalRequest rejects a request from an external specialist
@@ -375,10 +420,17 @@ func ApproveSalaryProject(c *gin.Context) {
 		return
 	}
 
-	err := storage.UpdateSalaryProjectStatus(request.ProjectID, "approved", int(userID))
+	err := storage.ApproveSalaryProject(request.ProjectID, int64(userID), "")
 	if err != nil {
+		if respondApprovalPolicyError(c, err) {
+			return
+		}
 		log.Printf("Error approving salary project: %v", err)
-		if err.Error() == "project not found or already processed" {
+		if errors.Is(err, storage.ErrInsufficientEnterpriseBalance) || errors.Is(err, storage.ErrSalaryProjectNoPayments) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err.Error() == "salary project not found" || err.Error() == "only pending salary projects can be approved" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
@@ -386,11 +438,94 @@ func ApproveSalaryProject(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "salary project approved successfully",
+	}
+	addDisbursementResult(response, request.ProjectID, int64(userID))
+
+	c.JSON(http.StatusOK, response)
+}
+
+// parseSalaryProjectIDParam reads the salary project ID from the URL
+func parseSalaryProjectIDParam(c *gin.Context) (int64, bool) {
+	projectID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || projectID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid salary project ID"})
+		return 0, false
+	}
+	return projectID, true
+}
+
+// DisburseSalaryProject starts or resumes the payout of an approved salary project
+func DisburseSalaryProject(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	projectID, ok := parseSalaryProjectIDParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := storage.DisburseSalaryProject(projectID, int64(userID))
+	if err != nil {
+		log.Printf("Error disbursing salary project %d: %v", projectID, err)
+		if errors.Is(err, storage.ErrSalaryProjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, storage.ErrSalaryProjectNotApproved) || errors.Is(err, storage.ErrSalaryProjectNoPayments) ||
+			errors.Is(err, storage.ErrSalaryProjectNotDue) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "salary project disbursement did not finish"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "salary project disbursed",
+		"report":  report,
 	})
 }
 
+// GetSalaryDisbursementReport returns the payout report of a salary project
+func GetSalaryDisbursementReport(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	projectID, ok := parseSalaryProjectIDParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := storage.GetSalaryDisbursementReport(projectID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSalaryProjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error building disbursement report for salary project %d: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build disbursement report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
 // RejectSalaryProject rejects a salary project
 func RejectSalaryProject(c *gin.Context) {
 	userID, exists := getUserID(c)
@@ -450,6 +585,11 @@ func CreateEnterprise(c *gin.Context) {
 		return
 	}
 
+	if !storage.IsValidEnterpriseRole(request.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": storage.ErrInvalidEnterpriseRole.Error()})
+		return
+	}
+
 	// Create the enterprise
 	enterpriseID, err := storage.CreateEnterprise(request.Name, request.Description)
 	if err != nil {
@@ -471,3 +611,191 @@ func CreateEnterprise(c *gin.Context) {
 		"enterprise_id": enterpriseID,
 	})
 }
+
+// parseEnterpriseIDParam reads the enterprise ID from the :id path parameter
+func parseEnterpriseIDParam(c *gin.Context) (int, bool) {
+	enterpriseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || enterpriseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enterprise ID"})
+		return 0, false
+	}
+	return enterpriseID, true
+}
+
+// OpenEnterpriseAccount opens a settlement account for an enterprise
+func OpenEnterpriseAccount(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		AccountNumber  string  `json:"account_number"`
+		Currency       string  `json:"currency"`
+		OverdraftLimit float64 `json:"overdraft_limit"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	account, err := storage.OpenEnterpriseAccount(enterpriseID, request.AccountNumber, strings.ToUpper(request.Currency), request.OverdraftLimit)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEnterpriseNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "enterprise not found"})
+		case strings.Contains(err.Error(), "UNIQUE constraint"):
+			c.JSON(http.StatusConflict, gin.H{"error": "account number already exists"})
+		case strings.Contains(err.Error(), "cannot be negative"):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error opening enterprise account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to open enterprise account"})
+		}
+		return
+	}
+
+	storage.LogTransaction(int64(userID), "enterprise_account_open", nil,
+		fmt.Sprintf("Opened account %s (%s) for enterprise ID %d", account.AccountNumber, account.Currency, enterpriseID))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "enterprise account opened successfully",
+		"account": account,
+	})
+}
+
+// GetEnterpriseAccounts lists the settlement accounts of an enterprise
+func GetEnterpriseAccounts(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+
+	accounts, err := storage.GetEnterpriseAccounts(enterpriseID)
+	if err != nil {
+		log.Printf("Error fetching enterprise accounts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve enterprise accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accounts": accounts,
+		"count":    len(accounts),
+	})
+}
+
+// CreditEnterpriseAccount funds an enterprise settlement account
+func CreditEnterpriseAccount(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		AccountID int64   `json:"account_id"`
+		Amount    float64 `json:"amount" binding:"required"`
+		Reference string  `json:"reference"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	if request.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be greater than 0"})
+		return
+	}
+
+	account, err := storage.CreditEnterpriseAccount(enterpriseID, request.AccountID, request.Amount, int64(userID), request.Reference)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEnterpriseNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "enterprise not found"})
+		case errors.Is(err, storage.ErrEnterpriseAccountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "enterprise account not found"})
+		default:
+			log.Printf("Error crediting enterprise account: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to credit enterprise account"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "enterprise account credited successfully",
+		"account": account,
+	})
+}
+
+// SetEnterpriseOverdraftLimit changes the overdraft limit of a settlement account
+func SetEnterpriseOverdraftLimit(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	accountID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || accountID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid account ID"})
+		return
+	}
+
+	var request struct {
+		OverdraftLimit float64 `json:"overdraft_limit"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.SetEnterpriseOverdraftLimit(accountID, request.OverdraftLimit); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEnterpriseAccountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "enterprise account not found"})
+		case strings.Contains(err.Error(), "cannot be negative"):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error setting overdraft limit: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set overdraft limit"})
+		}
+		return
+	}
+
+	storage.LogTransaction(int64(userID), "enterprise_overdraft_change", &request.OverdraftLimit,
+		fmt.Sprintf("Set overdraft limit of enterprise account #%d", accountID))
+
+	c.JSON(http.StatusOK, gin.H{"message": "overdraft limit updated successfully"})
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"finance/internal/aml"
+	"finance/internal/storage"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// amlRuleRequest is the body of the AML rule update endpoint
+type amlRuleRequest struct {
+	Name        string  `json:"name"`
+	Severity    string  `json:"severity" binding:"required"`
+	Active      *bool   `json:"active"`
+	Threshold   float64 `json:"threshold"`
+	Ratio       float64 `json:"ratio"`
+	WindowHours int     `json:"window_hours"`
+	MinCount    int     `json:"min_count"`
+}
+
+// GetAMLRules returns the fraud and AML rules (admin only)
+func GetAMLRules(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	rules, err := storage.GetAMLRules()
+	if err != nil {
+		log.Printf("Error fetching AML rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve AML rules"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// UpdateAMLRule changes the severity, thresholds and window of an AML rule; rules are active
+// unless stated otherwise (admin only)
+func UpdateAMLRule(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var request amlRuleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	rule := &aml.Rule{
+		Code:        c.Param("code"),
+		Name:        request.Name,
+		Severity:    request.Severity,
+		Active:      true,
+		Threshold:   request.Threshold,
+		Ratio:       request.Ratio,
+		WindowHours: request.WindowHours,
+		MinCount:    request.MinCount,
+	}
+	if request.Active != nil {
+		rule.Active = *request.Active
+	}
+	if err := storage.UpdateAMLRule(rule, int64(adminID)); err != nil {
+		switch {
+		case errors.Is(err, aml.ErrInvalidRule):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrAMLRuleNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error updating AML rule %s: %v", rule.Code, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update AML rule"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "AML rule updated successfully",
+		"rule":    rule,
+	})
+}
+
+// GetAMLAlerts lists AML alerts, optionally of one ?status and ?client_id (operator only).
+// Open alerts are worked in the inbox: approving reports them, rejecting dismisses them.
+func GetAMLAlerts(c *gin.Context) {
+	operatorID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(operatorID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	var clientID int64
+	if value := c.Query("client_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid client ID"})
+			return
+		}
+		clientID = id
+	}
+
+	alerts, err := storage.GetAMLAlerts(c.Query("status"), clientID)
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidAMLAlertStatus) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error fetching AML alerts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch AML alerts"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts})
+}
+
+// GetSuspiciousActivityReport exports the alerts reported as suspicious between from and to
+// (YYYY-MM-DD, last 30 days by default) as json (default) or csv (operator only)
+func GetSuspiciousActivityReport(c *gin.Context) {
+	operatorID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(operatorID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	from, to, ok := parseStatementPeriod(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	activities, err := storage.GetSuspiciousActivityReport(from, to)
+	if err != nil {
+		log.Printf("Error building suspicious activity report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build suspicious activity report"})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"from":       from.Format("2006-01-02"),
+			"to":         to.Format("2006-01-02"),
+			"activities": activities,
+		})
+		return
+	}
+	var body bytes.Buffer
+	if err := aml.WriteReportCSV(&body, activities); err != nil {
+		log.Printf("Error rendering suspicious activity report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render suspicious activity report"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="sar-%s-%s.csv"`,
+		from.Format("20060102"), to.Format("20060102")))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", body.Bytes())
+}
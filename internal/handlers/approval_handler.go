@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondApprovalPolicyError answers approvals stopped by the approval policy.
+// It reports whether a response was written.
+func respondApprovalPolicyError(c *gin.Context, err error) bool {
+	var pending *storage.ApprovalRequiredError
+	switch {
+	case errors.As(err, &pending):
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":            "approval recorded, waiting for another approver",
+			"operation_type":     pending.OperationType,
+			"operation_id":       pending.OperationID,
+			"approvals":          pending.Approvals,
+			"required_approvals": pending.Required,
+		})
+		return true
+	case errors.Is(err, storage.ErrSelfApproval):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return true
+	}
+	return false
+}
+
+// GetApprovalPolicies lists the configured approval policies (admin only)
+func GetApprovalPolicies(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	policies, err := storage.GetApprovalPolicies()
+	if err != nil {
+		log.Printf("Error fetching approval policies: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve approval policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policies": policies})
+}
+
+// SetApprovalPolicy creates or replaces the approval policy of an operation type (admin only)
+func SetApprovalPolicy(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	var request struct {
+		MinAmount         float64 `json:"min_amount"`
+		RequiredApprovers int     `json:"required_approvers" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	policy := &storage.ApprovalPolicy{
+		OperationType:     c.Param("operation_type"),
+		MinAmount:         request.MinAmount,
+		RequiredApprovers: request.RequiredApprovers,
+		UpdatedBy:         int64(userID),
+	}
+	if err := storage.SetApprovalPolicy(policy); err != nil {
+		log.Printf("Error saving approval policy: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "approval policy saved successfully",
+		"policy":  policy,
+	})
+}
+
+// DeleteApprovalPolicy removes the approval policy of an operation type (admin only)
+func DeleteApprovalPolicy(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	operationType := c.Param("operation_type")
+	if err := storage.DeleteApprovalPolicy(operationType, int64(userID)); err != nil {
+		if errors.Is(err, storage.ErrApprovalPolicyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error deleting approval policy: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete approval policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "approval policy removed successfully"})
+}
+
+// GetOperationApprovals lists the approvals collected by an operation (admin or manager)
+func GetOperationApprovals(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin", "manager") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin or manager privileges required"})
+		return
+	}
+
+	operationType := c.Param("operation_type")
+	if !storage.IsValidOperationType(operationType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": storage.ErrInvalidOperationType.Error()})
+		return
+	}
+	operationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || operationID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operation ID"})
+		return
+	}
+
+	approvals, err := storage.GetOperationApprovals(operationType, operationID)
+	if err != nil {
+		log.Printf("Error fetching approvals of %s #%d: %v", operationType, operationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve approvals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"operation_type": operationType,
+		"operation_id":   operationID,
+		"approvals":      approvals,
+	})
+}
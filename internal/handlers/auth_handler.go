@@ -1,16 +1,20 @@
 package handlers
 
 import (
+	"bytes"
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	_ "strings"
 	"time"
 
 	"finance/internal/models"
 	db "finance/internal/storage"
+	"finance/pkg/signing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
@@ -19,6 +23,9 @@ import (
 
 var jwtKey []byte
 
+// externalAPISecret is the shared secret enterprise partners sign /external requests with
+var externalAPISecret []byte
+
 func init() {
 
 	secretKey := os.Getenv("JWT_SECRET_KEY")
@@ -27,6 +34,13 @@ func init() {
 		log.Println("Warning: Using default JWT secret key. Set JWT_SECRET_KEY environment variable in production.")
 	}
 	jwtKey = []byte(secretKey)
+
+	apiSecret := os.Getenv("EXTERNAL_API_SECRET")
+	if apiSecret == "" {
+		apiSecret = "your_external_api_secret"
+		log.Println("Warning: Using default external API secret. Set EXTERNAL_API_SECRET environment variable in production.")
+	}
+	externalAPISecret = []byte(apiSecret)
 }
 
 type Claims struct {
@@ -231,6 +245,58 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// maxSignedBodySize limits the body of signed requests, which is read into memory to be
+// verified. It leaves room for the multipart envelope of the largest payroll file.
+const maxSignedBodySize = maxPayrollFileSize + 1<<20
+
+// SignatureMiddleware verifies the HMAC signature, timestamp and nonce of
+// enterprise-to-bank requests and rejects replayed nonces
+func SignatureMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize+1))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+				c.Abort()
+				return
+			}
+			if len(body) > maxSignedBodySize {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "request body is too large"})
+				c.Abort()
+				return
+			}
+			// Restore the body so handlers can bind it
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		err := signing.Verify(externalAPISecret, c.Request.Method, c.Request.URL.RequestURI(),
+			c.Request.Header, body, time.Now(), signing.DefaultMaxSkew)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+
+		// Verify has already validated the timestamp format
+		timestamp, _ := strconv.ParseInt(c.GetHeader(signing.HeaderTimestamp), 10, 64)
+		nonce := c.GetHeader(signing.HeaderNonce)
+		if err := db.RegisterRequestNonce(nonce, timestamp, 2*signing.DefaultMaxSkew); err != nil {
+			if err == db.ErrNonceReused {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "request has already been processed (replayed nonce)"})
+			} else {
+				log.Printf("Error storing request nonce: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify request"})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
 func RefreshToken(c *gin.Context) {
 	// Get user ID from context (set by AuthMiddleware)
 	userIDValue, exists := c.Get("userID")
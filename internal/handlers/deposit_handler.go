@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -38,7 +39,7 @@ func HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"status": "ok"})
 }
 
-// CreateDeposit now requires authentication
+// CreateDeposit opens a deposit under a deposit product; the rate comes from the product
 func CreateDeposit(c *gin.Context) {
 	userID, exists := getUserID(c)
 	if !exists {
@@ -46,41 +47,47 @@ func CreateDeposit(c *gin.Context) {
 		return
 	}
 
-	var deposit models.Deposit
-	if err := c.ShouldBindJSON(&deposit); err != nil {
+	var request models.DepositRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Always set the client ID to the authenticated user's ID
 	// This prevents users from creating deposits for other accounts
-	deposit.ClientID = int64(userID)
+	request.ClientID = int64(userID)
 
 	// Continue with the rest of the validation
-	if deposit.BankName == "" {
+	if request.BankName == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "bank_name is required"})
 		return
 	}
 
-	if deposit.Amount <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be greater than 0"})
+	if request.ProductCode == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "product_code is required"})
 		return
 	}
 
-	if deposit.Interest < 0 || deposit.Interest > 100 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "interest must be between 0 and 100"})
+	if request.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be greater than 0"})
 		return
 	}
 
-	if err := db.SaveDeposit(deposit); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save deposit"})
+	deposit, err := db.OpenDeposit(request)
+	if err != nil {
+		if errors.Is(err, db.ErrDepositProductNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error opening deposit: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Log the transaction
 	amount := deposit.Amount
-	_, err := db.LogTransaction(deposit.ClientID, "create", &amount,
-		fmt.Sprintf("Created deposit in %s", deposit.BankName))
+	_, err = db.LogTransaction(deposit.ClientID, "create", &amount,
+		fmt.Sprintf("Created deposit %d in %s at %.2f%%", deposit.DepositID, deposit.BankName, deposit.Interest))
 	if err != nil {
 		log.Printf("Error logging transaction: %v", err)
 	}
@@ -88,6 +95,48 @@ func CreateDeposit(c *gin.Context) {
 	c.JSON(http.StatusCreated, deposit)
 }
 
+// GetDepositProductOffers lists the deposit products that can be opened today
+func GetDepositProductOffers(c *gin.Context) {
+	products, err := db.GetCurrentDepositProducts(time.Now())
+	if err != nil {
+		log.Printf("Error fetching deposit products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load deposit products"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"products": products})
+}
+
+// SetMaturityInstruction changes whether a term deposit is paid out or rolled over at maturity
+func SetMaturityInstruction(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	var request struct {
+		DepositID           int64  `json:"deposit_id" binding:"required"`
+		MaturityInstruction string `json:"maturity_instruction" binding:"required"`
+		PayoutDepositID     *int64 `json:"payout_deposit_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := db.SetMaturityInstruction(int64(userID), request.DepositID, request.MaturityInstruction, request.PayoutDepositID)
+	if err != nil {
+		if errors.Is(err, db.ErrDepositNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "maturity instruction updated"})
+}
+
 // // isAdmin checks if a user has admin privileges by querying the database
 // func isAdmin(userID int) bool {
 // 	isAdmin, err := db.IsUserAdmin(userID)
@@ -99,51 +148,48 @@ func CreateDeposit(c *gin.Context) {
 // 	return isAdmin
 // }
 
-func DeleteDeposit(c *gin.Context) {
+// CloseDeposit closes a deposit of the authenticated user and pays the balance, with accrued
+// interest and less any early closure penalty, into another of their deposits
+func CloseDeposit(c *gin.Context) {
 	userID, exists := getUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
 		return
 	}
 
-	var deposit models.Deposit
-	if err := c.ShouldBindJSON(&deposit); err != nil {
+	var closure models.DepositClosure
+	if err := c.ShouldBindJSON(&closure); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-
-	// Always set client ID to the authenticated user's ID
-	// This ensures users can only modify their own deposits
-	deposit.ClientID = int64(userID)
-
-	if deposit.BankName == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "bank_name is required"})
-		return
-	}
-
-	if deposit.DepositID <= 0 {
+	if closure.DepositID <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "deposit_id is required"})
 		return
 	}
 
-	// Pass the deposit_id to the DeleteDeposit function
-	if err := db.DeleteDeposit(deposit.ClientID, deposit.BankName); err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete deposit"})
-		return
-	}
+	// Always set client ID to the authenticated user's ID; cash-outs need a teller
+	closure.ClientID = int64(userID)
+	closure.TellerID = nil
 
-	// Log the transaction
-	_, err := db.LogTransaction(deposit.ClientID, "delete", nil,
-		fmt.Sprintf("Deleted deposit %d in %s", deposit.DepositID, deposit.BankName))
+	result, err := db.CloseDeposit(closure)
 	if err != nil {
-		log.Printf("Error logging transaction: %v", err)
+		respondDepositClosureError(c, err)
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"message": "deposit closed successfully", "closure": result})
+}
 
-	c.JSON(http.StatusOK, gin.H{"message": "deposit deleted successfully"})
+// respondDepositClosureError maps deposit closure errors to responses
+func respondDepositClosureError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrDepositNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrDepositNotActive), errors.Is(err, db.ErrDepositUnavailable), errors.Is(err, db.ErrDepositChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error closing deposit: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
 }
 
 func TransferBetweenAccounts(c *gin.Context) {
@@ -192,59 +238,31 @@ func TransferBetweenAccounts(c *gin.Context) {
 		return
 	}
 
-	// Verify both deposits exist and belong to the user (or have proper permissions)
-	fromDepositExists, toDepositExists, err := db.VerifyAccountsForTransfer(transfer.ClientID, transfer.FromDepositID, transfer.ToDepositID)
-	if err != nil {
-		log.Printf("Error verifying deposits: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify deposits"})
-		return
-	}
-
-	if !fromDepositExists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "source deposit not found or doesn't belong to you"})
-		return
-	}
-
-	if !toDepositExists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "destination deposit not found"})
-		return
-	}
-
-	// Check if deposits are blocked or frozen
-	isBlocked, err := db.CheckAccountsBlockedOrFrozen(transfer.FromDepositID, transfer.ToDepositID)
-	if err != nil {
-		log.Printf("Error checking deposit status: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check deposit status"})
-		return
-	}
-
-	if isBlocked {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer funds from/to blocked or frozen deposits"})
-		return
-	}
-
-	// Execute the transfer
+	// Execute the transfer; ownership, blocks, freezes and limits are checked in the same transaction
 	if err := db.TransferBetweenAccounts(transfer); err != nil {
+		if respondLimitError(c, err) {
+			return
+		}
 		log.Printf("Transfer execution error: %v", err)
 		switch err {
 		case sql.ErrNoRows:
 			c.JSON(http.StatusNotFound, gin.H{"error": "one or both deposits not found"})
+		case db.ErrTransferSourceNotFound, db.ErrTransferDestinationNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case db.ErrDepositUnavailable:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "cannot transfer funds from/to blocked or frozen deposits"})
+		case db.ErrDepositConcurrentUpdate:
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		case db.ErrInsufficientFunds:
 			c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient funds for transfer"})
+		case db.ErrDepositWithdrawalNotAllowed, db.ErrDepositTopUpNotAllowed, db.ErrDepositMinBalance, db.ErrDepositNotActive:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
 		return
 	}
 
-	// Log the transaction
-	amount := transfer.Amount
-	_, err = db.LogTransaction(transfer.ClientID, "transfer", &amount,
-		fmt.Sprintf("Transfer from deposit %d to deposit %d", transfer.FromDepositID, transfer.ToDepositID))
-	if err != nil {
-		log.Printf("Error logging transaction: %v", err)
-	}
-
 	c.JSON(http.StatusOK, gin.H{
 		"message": "transfer completed successfully",
 		"transfer": gin.H{
@@ -349,6 +367,10 @@ func UnblockDeposit(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found or not blocked"})
 			return
 		}
+		if err == db.ErrDepositPledged {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to unblock deposit"})
 		return
 	}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// depositMovementRequest is the body of top-ups and withdrawals
+type depositMovementRequest struct {
+	DepositID int64   `json:"deposit_id" binding:"required"`
+	Amount    float64 `json:"amount" binding:"required"`
+	Branch    string  `json:"branch"` // Cash-desk operations only
+}
+
+// respondDepositMovementError maps top-up and withdrawal errors to responses
+func respondDepositMovementError(c *gin.Context, err error) {
+	if respondLimitError(c, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, storage.ErrDepositNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInsufficientFunds):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "insufficient funds on the deposit"})
+	case errors.Is(err, storage.ErrInvalidMovementAmount), errors.Is(err, storage.ErrDepositWithdrawalNotAllowed),
+		errors.Is(err, storage.ErrDepositTopUpNotAllowed), errors.Is(err, storage.ErrDepositMinBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrDepositUnavailable), errors.Is(err, storage.ErrDepositNotActive):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error moving deposit funds: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to move deposit funds"})
+	}
+}
+
+// TopUpDeposit pays money into a deposit of the authenticated user
+func TopUpDeposit(c *gin.Context) {
+	moveOwnDepositFunds(c, storage.TopUpDeposit, "top-up completed successfully")
+}
+
+// WithdrawFromDeposit takes money out of a deposit of the authenticated user
+func WithdrawFromDeposit(c *gin.Context) {
+	moveOwnDepositFunds(c, storage.WithdrawFromDeposit, "withdrawal completed successfully")
+}
+
+// moveOwnDepositFunds runs a top-up or withdrawal for the authenticated user. Withdrawals are
+// checked against the transaction limits of the user as they are made.
+func moveOwnDepositFunds(c *gin.Context, move func(*models.DepositMovement) error, message string) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	var request depositMovementRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format: " + err.Error()})
+		return
+	}
+
+	movement := &models.DepositMovement{
+		DepositID: request.DepositID,
+		ClientID:  int64(userID),
+		Amount:    request.Amount,
+		Channel:   models.ChannelOnline,
+	}
+	if err := move(movement); err != nil {
+		respondDepositMovementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "movement": movement})
+}
+
+// CashDeskTopUp records cash paid in at a branch on a client's deposit (operator only)
+func CashDeskTopUp(c *gin.Context) {
+	moveCashDeskFunds(c, storage.TopUpDeposit, "cash top-up recorded")
+}
+
+// CashDeskWithdraw records cash paid out at a branch from a client's deposit (operator only)
+func CashDeskWithdraw(c *gin.Context) {
+	moveCashDeskFunds(c, storage.WithdrawFromDeposit, "cash withdrawal recorded")
+}
+
+func moveCashDeskFunds(c *gin.Context, move func(*models.DepositMovement) error, message string) {
+	tellerID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(tellerID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	var request depositMovementRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format: " + err.Error()})
+		return
+	}
+	if request.Branch == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "branch is required"})
+		return
+	}
+
+	teller := int64(tellerID)
+	movement := &models.DepositMovement{
+		DepositID: request.DepositID,
+		Amount:    request.Amount,
+		Channel:   models.ChannelCashDesk,
+		TellerID:  &teller,
+		Branch:    request.Branch,
+	}
+	if err := move(movement); err != nil {
+		respondDepositMovementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "movement": movement})
+}
+
+// CashDeskCloseDeposit closes a client's deposit at a branch. The balance is handed out in cash
+// unless a payout deposit is given (operator only).
+func CashDeskCloseDeposit(c *gin.Context) {
+	tellerID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(tellerID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	var closure models.DepositClosure
+	if err := c.ShouldBindJSON(&closure); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format: " + err.Error()})
+		return
+	}
+	if closure.DepositID <= 0 || closure.Branch == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deposit_id and branch are required"})
+		return
+	}
+
+	teller := int64(tellerID)
+	closure.TellerID = &teller
+	closure.CashOut = closure.PayoutDepositID == nil
+	result, err := storage.CloseDeposit(closure)
+	if err != nil {
+		respondDepositClosureError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "deposit closed", "closure": result})
+}
+
+// GetDepositMovements lists the top-ups and withdrawals of a deposit of the authenticated user
+func GetDepositMovements(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	depositID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || depositID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid deposit ID"})
+		return
+	}
+
+	if !ownsDeposit(userID, depositID) && !hasRole(userID, "operator", "admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found"})
+		return
+	}
+
+	movements, err := storage.GetDepositMovements(depositID)
+	if err != nil {
+		log.Printf("Error fetching movements of deposit %d: %v", depositID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve deposit movements"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"movements": movements})
+}
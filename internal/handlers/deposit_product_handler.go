@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDepositProducts lists every version of the deposit products (admin only).
+// Pass code to see the versions of a single product.
+func GetDepositProducts(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	products, err := storage.GetDepositProducts(c.Query("code"))
+	if err != nil {
+		log.Printf("Error fetching deposit products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve deposit products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"products": products})
+}
+
+// CreateDepositProduct publishes a deposit product or a new version of an existing one (admin only).
+// effective_from uses the YYYY-MM-DD format and defaults to now.
+func CreateDepositProduct(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Code                string                   `json:"code" binding:"required"`
+		Name                string                   `json:"name" binding:"required"`
+		Type                models.DepositType       `json:"type" binding:"required"`
+		AllowedTerms        []int                    `json:"allowed_terms"`
+		MinBalance          float64                  `json:"min_balance"`
+		AllowTopUp          bool                     `json:"allow_top_up"`
+		AllowWithdrawal     bool                     `json:"allow_withdrawal"`
+		EarlyClosurePenalty float64                  `json:"early_closure_penalty"`
+		AutoRollover        bool                     `json:"auto_rollover"`
+		EffectiveFrom       string                   `json:"effective_from"`
+		Rates               []models.DepositRateTier `json:"rates" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	product := &models.DepositProduct{
+		Code:                request.Code,
+		Name:                request.Name,
+		Type:                request.Type,
+		AllowedTerms:        request.AllowedTerms,
+		MinBalance:          request.MinBalance,
+		AllowTopUp:          request.AllowTopUp,
+		AllowWithdrawal:     request.AllowWithdrawal,
+		EarlyClosurePenalty: request.EarlyClosurePenalty,
+		AutoRollover:        request.AutoRollover,
+		Rates:               request.Rates,
+	}
+	if request.EffectiveFrom != "" {
+		effectiveFrom, err := time.ParseInLocation("2006-01-02", request.EffectiveFrom, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid effective_from, expected YYYY-MM-DD"})
+			return
+		}
+		product.EffectiveFrom = effectiveFrom
+	}
+
+	if err := storage.CreateDepositProduct(product, int64(adminID)); err != nil {
+		log.Printf("Error creating deposit product: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "deposit product published successfully",
+		"product": product,
+	})
+}
+
+// RetireDepositProduct stops offering a deposit product version (admin only)
+func RetireDepositProduct(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product ID"})
+		return
+	}
+
+	if err := storage.RetireDepositProduct(productID, int64(adminID)); err != nil {
+		if errors.Is(err, storage.ErrDepositProductNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error retiring deposit product %d: %v", productID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retire deposit product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "deposit product retired successfully"})
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/payroll"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// enterpriseEmployeeRequest is the body of the employee create and update endpoints.
+// Dates use the YYYY-MM-DD format.
+type enterpriseEmployeeRequest struct {
+	EmployeeNumber  string `json:"employee_number" binding:"required"`
+	FullName        string `json:"full_name" binding:"required"`
+	Position        string `json:"position" binding:"required"`
+	UserID          int    `json:"user_id"`
+	PayoutDepositID int64  `json:"payout_deposit_id"`
+	HireDate        string `json:"hire_date"`
+	TerminationDate string `json:"termination_date"`
+}
+
+// toEmployee converts the request into a registry entry
+func (r *enterpriseEmployeeRequest) toEmployee(enterpriseID int) (*storage.EnterpriseEmployee, error) {
+	employee := &storage.EnterpriseEmployee{
+		EnterpriseID:    enterpriseID,
+		EmployeeNumber:  r.EmployeeNumber,
+		FullName:        r.FullName,
+		Position:        r.Position,
+		UserID:          r.UserID,
+		PayoutDepositID: r.PayoutDepositID,
+	}
+	if r.HireDate != "" {
+		hired, err := time.ParseInLocation("2006-01-02", r.HireDate, time.Local)
+		if err != nil {
+			return nil, errors.New("invalid hire_date, expected YYYY-MM-DD")
+		}
+		employee.HiredAt = hired.Unix()
+	}
+	if r.TerminationDate != "" {
+		terminated, err := time.ParseInLocation("2006-01-02", r.TerminationDate, time.Local)
+		if err != nil {
+			return nil, errors.New("invalid termination_date, expected YYYY-MM-DD")
+		}
+		employee.TerminatedAt = terminated.Unix()
+	}
+	return employee, nil
+}
+
+// parseEmployeeIDParam reads the registry employee ID from the URL
+func parseEmployeeIDParam(c *gin.Context) (int64, bool) {
+	employeeID, err := strconv.ParseInt(c.Param("employee_id"), 10, 64)
+	if err != nil || employeeID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid employee ID"})
+		return 0, false
+	}
+	return employeeID, true
+}
+
+// GetEnterpriseEmployees lists the employee registry of an enterprise
+func GetEnterpriseEmployees(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeExternalEnterprise(c, enterpriseID); !ok {
+		return
+	}
+
+	includeTerminated := c.Query("include_terminated") == "true"
+	employees, err := storage.GetEnterpriseEmployees(enterpriseID, includeTerminated)
+	if err != nil {
+		log.Printf("Error fetching enterprise employees: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve employees"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"employees": employees,
+		"count":     len(employees),
+	})
+}
+
+// GetEnterpriseEmployee returns a single registry entry
+func GetEnterpriseEmployee(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeExternalEnterprise(c, enterpriseID); !ok {
+		return
+	}
+	employeeID, ok := parseEmployeeIDParam(c)
+	if !ok {
+		return
+	}
+
+	employee, err := storage.GetEnterpriseEmployee(enterpriseID, employeeID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEnterpriseEmployeeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error fetching enterprise employee %d: %v", employeeID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve employee"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"employee": employee})
+}
+
+// CreateEnterpriseEmployee adds an employee to the registry of an enterprise
+func CreateEnterpriseEmployee(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeEnterprisePayments(c, enterpriseID); !ok {
+		return
+	}
+
+	var request enterpriseEmployeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	employee, err := request.toEmployee(enterpriseID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := storage.CreateEnterpriseEmployee(employee); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEmployeeNumberTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrEnterpriseNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error creating enterprise employee: %v", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "employee added successfully",
+		"employee": employee,
+	})
+}
+
+// UpdateEnterpriseEmployee replaces the details of a registry entry
+func UpdateEnterpriseEmployee(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeEnterprisePayments(c, enterpriseID); !ok {
+		return
+	}
+	employeeID, ok := parseEmployeeIDParam(c)
+	if !ok {
+		return
+	}
+
+	existing, err := storage.GetEnterpriseEmployee(enterpriseID, employeeID)
+	if err != nil {
+		if errors.Is(err, storage.ErrEnterpriseEmployeeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve employee"})
+		return
+	}
+
+	var request enterpriseEmployeeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	employee, err := request.toEmployee(enterpriseID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	employee.ID = employeeID
+	employee.CreatedAt = existing.CreatedAt
+	if employee.HiredAt == 0 {
+		employee.HiredAt = existing.HiredAt
+	}
+
+	if err := storage.UpdateEnterpriseEmployee(employee); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEmployeeNumberTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrEnterpriseEmployeeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error updating enterprise employee %d: %v", employeeID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "employee updated successfully",
+		"employee": employee,
+	})
+}
+
+// DeleteEnterpriseEmployee removes an employee that has never been paid from the registry
+func DeleteEnterpriseEmployee(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeEnterprisePayments(c, enterpriseID); !ok {
+		return
+	}
+	employeeID, ok := parseEmployeeIDParam(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DeleteEnterpriseEmployee(enterpriseID, employeeID); err != nil {
+		if errors.Is(err, storage.ErrEnterpriseEmployeeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error deleting enterprise employee %d: %v", employeeID, err)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "employee removed successfully",
+		"employee_id": employeeID,
+	})
+}
+
+// checkPayrollAgainstRegistry matches payroll rows to the employee registry.
+// Terminated employees and accounts other than the employee's payout deposit are errors.
+// Employees missing from the registry, or registered without a payout deposit, are warnings.
+func checkPayrollAgainstRegistry(report *payroll.Report, enterpriseID int) error {
+	employees, err := storage.GetEnterpriseEmployees(enterpriseID, true)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		employee := storage.FindEnterpriseEmployee(employees, row.EmployeeName, row.AccountNumber)
+		if employee == nil {
+			report.AddWarning(row.Line, payroll.ColumnEmployeeName,
+				"employee "+row.EmployeeName+" is not in the enterprise registry")
+			continue
+		}
+		if employee.IsTerminated(now) {
+			report.AddError(row.Line, payroll.ColumnEmployeeName,
+				"employee "+employee.FullName+" ("+employee.EmployeeNumber+") is terminated")
+			continue
+		}
+		// A row matched by name alone must still pay to the account the registry knows
+		if !employee.IsPayoutAccount(row.AccountNumber) {
+			if employee.PayoutDepositID != 0 {
+				report.AddError(row.Line, payroll.ColumnAccountNumber,
+					"account "+row.AccountNumber+" is not the payout deposit of employee "+employee.FullName+" ("+employee.EmployeeNumber+")")
+			} else {
+				report.AddWarning(row.Line, payroll.ColumnAccountNumber,
+					"employee "+employee.FullName+" ("+employee.EmployeeNumber+") has no payout deposit in the registry, account "+row.AccountNumber+" is not validated")
+			}
+			continue
+		}
+		row.EmployeeID = employee.ID
+	}
+	return nil
+}
@@ -1,9 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/payroll"
 	"finance/internal/storage"
+	"finance/internal/utils"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -11,9 +18,21 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// SubmitSalaryProject handles the submission of salary project documents by external specialists
+// SubmitSalaryProject used to accept a salary project as a link to a document. Such projects
+// carry no payment lines and cannot be paid out, so submissions now go through the payroll
+// file upload.
 func SubmitSalaryProject(c *gin.Context) {
-	// Authentication and role check
+	c.JSON(http.StatusGone, gin.H{
+		"error": "salary projects must be submitted as a payroll file to /external/salary-project/upload",
+	})
+}
+
+// maxPayrollFileSize limits the size of uploaded payroll files
+const maxPayrollFileSize = 10 << 20
+
+// UploadSalaryProject handles a salary project submitted as a payroll CSV or XLSX file.
+// Every row is validated and the totals are cross-checked before anything is saved.
+func UploadSalaryProject(c *gin.Context) {
 	userID, exists := getUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
@@ -23,61 +42,129 @@ func SubmitSalaryProject(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
 		return
 	}
-	// Parse request body
+	// Parse multipart form fields
 	var request struct {
-		EnterpriseID   int     `json:"enterprise_id" binding:"required"`
-		EnterpriseName string  `json:"enterprise_name" binding:"required"`
-		EmployeeCount  int     `json:"employee_count" binding:"required"`
-		TotalAmount    float64 `json:"total_amount" binding:"required"`
-		DocumentURL    string  `json:"document_url" binding:"required"`
-		Comment        string  `json:"comment"`
+		EnterpriseID   int     `form:"enterprise_id" binding:"required"`
+		EnterpriseName string  `form:"enterprise_name" binding:"required"`
+		EmployeeCount  int     `form:"employee_count" binding:"required"`
+		TotalAmount    float64 `form:"total_amount" binding:"required"`
+		PaymentPurpose string  `form:"payment_purpose"`
+		Comment        string  `form:"comment"`
 	}
-	if err := c.ShouldBindJSON(&request); err != nil {
+	if err := c.ShouldBind(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
 		return
 	}
-	// Validate parameters
 	if request.EnterpriseID <= 0 || request.EmployeeCount <= 0 || request.TotalAmount <= 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enterprise data"})
 		return
 	}
-	// Create salary project object
+	if !storage.CheckUserEnterpriseAuthorization(userID, request.EnterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+	if !storage.CheckUserEnterpriseRole(userID, request.EnterpriseID, storage.EnterprisePaymentRoles()...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "your role in this enterprise does not allow payments"})
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payroll file is required"})
+		return
+	}
+	if fileHeader.Size > maxPayrollFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "payroll file is too large"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read payroll file"})
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxPayrollFileSize+1))
+	file.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read payroll file"})
+		return
+	}
+	// Parse and validate the payroll rows
+	report, err := payroll.Parse(fileHeader.Filename, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report.CheckTotals(request.EmployeeCount, request.TotalAmount)
+	if err := checkPayrollAgainstRegistry(report, request.EnterpriseID); err != nil {
+		log.Printf("Error checking payroll against employee registry: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check employee registry"})
+		return
+	}
+	if !report.Valid() {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":        "payroll file contains errors",
+			"row_errors":   report.Errors,
+			"row_warnings": report.Warnings,
+			"row_count":    report.RowCount,
+			"total_amount": report.TotalAmount,
+		})
+		return
+	}
+	// Keep the original document alongside the project
+	stored, err := utils.SaveUploadedFile(fileHeader, "payroll")
+	if err != nil {
+		log.Printf("Error storing payroll file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store payroll file"})
+		return
+	}
+
+	payments := make([]*storage.SalaryPayment, 0, len(report.Rows))
+	for _, row := range report.Rows {
+		payments = append(payments, &storage.SalaryPayment{
+			EmployeeID:       row.EmployeeID,
+			EmployeeName:     row.EmployeeName,
+			EmployeePosition: row.Position,
+			Amount:           row.Amount,
+			AccountNumber:    row.AccountNumber,
+			BankName:         row.BankName,
+			PaymentPurpose:   request.PaymentPurpose,
+		})
+	}
 	salaryProject := &storage.SalaryProject{
 		EnterpriseID:   request.EnterpriseID,
 		EnterpriseName: request.EnterpriseName,
 		EmployeeCount:  request.EmployeeCount,
 		TotalAmount:    request.TotalAmount,
-		DocumentURL:    request.DocumentURL,
+		DocumentURL:    stored.Path,
 		Comment:        request.Comment,
 		SubmittedBy:    int(userID),
 	}
-	// Save the salary project to the database
-	projectID, err := storage.SaveSalaryProject(salaryProject)
+	projectID, err := storage.SaveSalaryProjectWithPayments(salaryProject, payments)
 	if err != nil {
 		log.Printf("Error saving salary project: %v", err)
-		if err.Error() == "database not initialized" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "database connection error"})
-			return
-		} else if err.Error() == "enterprise not found" || strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+		os.Remove(stored.Path)
+		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "enterprise not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit salary project: " + err.Error()})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit salary project"})
 		return
 	}
-	// Log the salary project submission
+
 	metadata := "Enterprise: " + request.EnterpriseName +
 		", Employees: " + strconv.Itoa(request.EmployeeCount) +
-		", Document: " + request.DocumentURL
+		", File: " + fileHeader.Filename + " (sha256 " + stored.SHA256 + ")"
 	if request.Comment != "" {
 		metadata += ", Comment: " + request.Comment
 	}
 	storage.LogTransaction(int64(userID), "salary_project_submission", &request.TotalAmount, metadata)
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "salary project documents submitted successfully",
+		"message":       "salary project uploaded successfully",
 		"submission_id": projectID,
 		"enterprise_id": request.EnterpriseID,
+		"payment_count": len(payments),
+		"total_amount":  report.TotalAmount,
+		"row_warnings":  report.Warnings,
 		"timestamp":     time.Now().Unix(),
 	})
 }
@@ -117,21 +204,65 @@ func RequestEnterpriseTransfer(c *gin.Context) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
 		return
 	}
-	// Create a unique ID for the transfer request
-	transferID := time.Now().UnixNano()
+	if !storage.CheckUserEnterpriseRole(userID, request.FromEnterpriseID, storage.EnterprisePaymentRoles()...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "your role in this enterprise does not allow payments"})
+		return
+	}
+	// Transfers to an employee must name an active employee of the receiving enterprise
+	if request.ToEmployeeID > 0 {
+		employee, err := storage.GetEnterpriseEmployee(request.ToEnterpriseID, int64(request.ToEmployeeID))
+		if err != nil {
+			if errors.Is(err, storage.ErrEnterpriseEmployeeNotFound) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "employee not found in the receiving enterprise's registry"})
+				return
+			}
+			log.Printf("Error fetching enterprise employee: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check employee registry"})
+			return
+		}
+		if employee.IsTerminated(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "employee is terminated"})
+			return
+		}
+		if employee.PayoutDepositID == 0 && employee.UserID == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "employee has no payout deposit"})
+			return
+		}
+	}
+	// Persist the request so it shows up for admin approval
+	transfer := &storage.EnterpriseTransfer{
+		FromEnterpriseID: request.FromEnterpriseID,
+		ToEnterpriseID:   request.ToEnterpriseID,
+		ToEmployeeID:     request.ToEmployeeID,
+		Amount:           request.Amount,
+		Status:           "pending",
+		Purpose:          request.TransferPurpose,
+		Comment:          request.Comment,
+		RequestedBy:      userID,
+	}
+	transferID, err := storage.SaveEnterpriseTransfer(transfer)
+	if err != nil {
+		log.Printf("Error saving enterprise transfer: %v", err)
+		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "enterprise not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to submit transfer request"})
+		return
+	}
 	// Determine if this is an enterprise-to-enterprise or enterprise-to-employee transfer
 	transferType := "enterprise_transfer"
-	transferDetails := "To Enterprise ID: " + strconv.Itoa(request.ToEnterpriseID)
+	transferDetails := "Transfer #" + strconv.FormatInt(transferID, 10) + " to Enterprise ID: " + strconv.Itoa(request.ToEnterpriseID)
 	if request.ToEmployeeID > 0 {
 		transferType = "employee_transfer"
-		transferDetails = "To Employee ID: " + strconv.Itoa(request.ToEmployeeID) + " at Enterprise ID: " + strconv.Itoa(request.ToEnterpriseID)
+		transferDetails = "Transfer #" + strconv.FormatInt(transferID, 10) + " to Employee ID: " + strconv.Itoa(request.ToEmployeeID) + " at Enterprise ID: " + strconv.Itoa(request.ToEnterpriseID)
 	}
 	// Add transfer purpose and optional comment to metadata
 	metadata := transferDetails + ", Purpose: " + request.TransferPurpose
 	if request.Comment != "" {
 		metadata += ", Comment: " + request.Comment
 	}
-	// Log the transfer request (not executing it automatically)
+	// Log the transfer request (funds are moved only once an admin approves it)
 	storage.LogTransaction(int64(userID), transferType+"_request", &request.Amount, metadata)
 
 	c.JSON(http.StatusOK, gin.H{
@@ -229,6 +360,41 @@ func GetSalaryProjects(c *gin.Context) {
 	})
 }
 
+// GetSalaryProjectReport returns the payout report of a salary project of the user's enterprise
+func GetSalaryProjectReport(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+
+	projectID, ok := parseSalaryProjectIDParam(c)
+	if !ok {
+		return
+	}
+
+	report, err := storage.GetSalaryDisbursementReport(projectID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSalaryProjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error building disbursement report for salary project %d: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build disbursement report"})
+		return
+	}
+	if !storage.CheckUserEnterpriseAuthorization(userID, report.EnterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
 // GetUserEnterprises retrieves all enterprises associated with the authenticated user
 func GetUserEnterprises(c *gin.Context) {
 	userID, exists := getUserID(c)
@@ -251,3 +417,80 @@ func GetUserEnterprises(c *gin.Context) {
 		"count":       len(enterprises),
 	})
 }
+
+// GetEnterpriseStatement returns the settlement account statement of an enterprise.
+// The period defaults to the last 30 days; from and to are dates in YYYY-MM-DD format.
+// format selects json (default), csv, pdf or camt053.
+func GetEnterpriseStatement(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+	enterpriseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || enterpriseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enterprise ID"})
+		return
+	}
+	// Check if the requesting user is authorized for this enterprise
+	if !storage.CheckUserEnterpriseAuthorization(userID, enterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+	from, to, ok := parseStatementPeriod(c)
+	if !ok {
+		return
+	}
+	// CSV, PDF and camt.053 documents are built from the same movements as the JSON statement
+	if format := c.DefaultQuery("format", models.StatementJSON); format != models.StatementJSON {
+		statements, err := storage.GetEnterpriseAccountStatements(enterpriseID, from, to)
+		if err != nil {
+			log.Printf("Error building enterprise statement: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build statement"})
+			return
+		}
+		writeStatement(c, format, fmt.Sprintf("enterprise-%d-statement", enterpriseID), statements)
+		return
+	}
+	statement, err := storage.GetEnterpriseStatement(enterpriseID, from.Unix(), to.Unix())
+	if err != nil {
+		log.Printf("Error building enterprise statement: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build statement"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"statement": statement})
+}
+
+// parseStatementPeriod reads the from/to query parameters of a statement request.
+// The end date is inclusive, so it is moved to the end of that day.
+func parseStatementPeriod(c *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now()
+	from := now.AddDate(0, 0, -30)
+	to := now
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", fromStr, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from date, expected YYYY-MM-DD"})
+			return from, to, false
+		}
+		from = parsed
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", toStr, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to date, expected YYYY-MM-DD"})
+			return from, to, false
+		}
+		to = parsed.Add(24*time.Hour - time.Second)
+	}
+	if to.Before(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from date must not be after to date"})
+		return from, to, false
+	}
+	return from, to, true
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"finance/internal/storage"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader lets a client retry a money-moving request without executing it twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayHeader marks a response that was stored for an earlier request with the same key
+const IdempotentReplayHeader = "Idempotent-Replayed"
+
+// IdempotencyKeyRetention is how long a key and its response are kept; after that the key can be reused
+const IdempotencyKeyRetention = 24 * time.Hour
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecorder keeps a copy of the response body written by the handler
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes a route safe to retry. When a request carries an Idempotency-Key
+// header the response is stored under the key, and a retry with the same key and body gets the
+// stored response instead of running the operation again. Reusing a key for a different request
+// is rejected with 422. Server errors are stored too: the operation may have been committed before
+// the error, so it is never run again under the same key. A retry while the first request runs,
+// or after the server died handling it, gets 409.
+// It must run after the middleware that authenticates the caller, since keys are per caller.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength)})
+			c.Abort()
+			return
+		}
+		scope, ok := idempotencyScope(c)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+			c.Abort()
+			return
+		}
+
+		var body []byte
+		if c.Request.Body != nil {
+			var err error
+			body, err = io.ReadAll(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read request body"})
+				c.Abort()
+				return
+			}
+			// Restore the body so handlers can bind it
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		fingerprint := requestFingerprint(c.Request.Method, c.Request.URL.RequestURI(), c.ContentType(), body)
+		stored, err := storage.BeginIdempotentRequest(scope, key, fingerprint, IdempotencyKeyRetention)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrIdempotencyKeyReused):
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			case errors.Is(err, storage.ErrIdempotentRequestInProgress), errors.Is(err, storage.ErrIdempotentRequestInterrupted):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			default:
+				log.Printf("Error checking idempotency key: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check idempotency key"})
+			}
+			c.Abort()
+			return
+		}
+		if stored != nil {
+			c.Header(IdempotentReplayHeader, "true")
+			c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+			c.Abort()
+			return
+		}
+
+		done := make(chan struct{})
+		go renewIdempotencyKey(scope, key, done)
+		defer close(done)
+
+		recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		defer func() {
+			// The handler may have committed before panicking; answer retries as the recovery will
+			if recovered := recover(); recovered != nil {
+				response := storage.IdempotentResponse{
+					StatusCode:  http.StatusInternalServerError,
+					ContentType: "application/json; charset=utf-8",
+					Body:        []byte(`{"error":"internal server error"}`),
+				}
+				if err := storage.CompleteIdempotentRequest(scope, key, response); err != nil {
+					log.Printf("Error storing idempotent response: %v", err)
+				}
+				panic(recovered)
+			}
+		}()
+		c.Next()
+
+		status := recorder.Status()
+		response := storage.IdempotentResponse{
+			StatusCode:  status,
+			ContentType: recorder.Header().Get("Content-Type"),
+			Body:        recorder.body.Bytes(),
+		}
+		if err := storage.CompleteIdempotentRequest(scope, key, response); err != nil {
+			log.Printf("Error storing idempotent response: %v", err)
+		}
+	}
+}
+
+// renewIdempotencyKey keeps the lease of a key while its request runs, until done is closed
+func renewIdempotencyKey(scope, key string, done <-chan struct{}) {
+	ticker := time.NewTicker(storage.IdempotencyLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := storage.RenewIdempotencyKey(scope, key); err != nil {
+				log.Printf("Error renewing idempotency key: %v", err)
+			}
+		}
+	}
+}
+
+// idempotencyScope identifies the caller owning the keys: a user or a merchant
+func idempotencyScope(c *gin.Context) (string, bool) {
+	if userID, exists := getUserID(c); exists {
+		return fmt.Sprintf("user:%d", userID), true
+	}
+	if merchantID := getMerchantID(c); merchantID > 0 {
+		return fmt.Sprintf("merchant:%d", merchantID), true
+	}
+	return "", false
+}
+
+// requestFingerprint identifies a request by its method, target, content type and body
+func requestFingerprint(method, uri, contentType string, body []byte) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s %s\n%s\n", method, uri, contentType)
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"finance/internal/workflow"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxBulkInboxItems caps the number of items decided in one bulk request
+const maxBulkInboxItems = 100
+
+// inboxItemRef identifies an item in a bulk or assignment request
+type inboxItemRef struct {
+	Source string `json:"source" binding:"required"`
+	ID     int64  `json:"id" binding:"required"`
+}
+
+// getInboxReviewer resolves the authenticated staff member working the inbox
+func getInboxReviewer(c *gin.Context) (workflow.Reviewer, bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return workflow.Reviewer{}, false
+	}
+
+	user, err := storage.GetUserByID(userID)
+	if err != nil || user == nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify user role"})
+		return workflow.Reviewer{}, false
+	}
+	if user.Role != "admin" && user.Role != "manager" && user.Role != "operator" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin, manager or operator privileges required"})
+		return workflow.Reviewer{}, false
+	}
+
+	return workflow.Reviewer{ID: int64(userID), Role: user.Role}, true
+}
+
+// respondInboxError maps workflow errors to status codes
+func respondInboxError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, workflow.ErrUnknownSource), errors.Is(err, workflow.ErrInvalidAction):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, workflow.ErrNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error processing inbox request: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process inbox request"})
+	}
+}
+
+// GetInbox lists all pending work the reviewer can act on.
+// Filters: source, assigned_to (user ID, "me" or "none"), overdue=true and min_amount.
+func GetInbox(c *gin.Context) {
+	reviewer, ok := getInboxReviewer(c)
+	if !ok {
+		return
+	}
+
+	filter := workflow.Filter{
+		Source:      c.Query("source"),
+		OverdueOnly: c.Query("overdue") == "true",
+	}
+	switch assignedTo := c.Query("assigned_to"); assignedTo {
+	case "":
+	case "me":
+		filter.AssignedTo = reviewer.ID
+	case "none":
+		filter.Unassigned = true
+	default:
+		assigneeID, err := strconv.ParseInt(assignedTo, 10, 64)
+		if err != nil || assigneeID <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "assigned_to must be a user ID, 'me' or 'none'"})
+			return
+		}
+		filter.AssignedTo = assigneeID
+	}
+	if minAmount := c.Query("min_amount"); minAmount != "" {
+		value, err := strconv.ParseFloat(minAmount, 64)
+		if err != nil || value < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid min_amount"})
+			return
+		}
+		filter.MinAmount = value
+	}
+
+	items, err := workflow.Inbox(reviewer, filter, time.Now())
+	if err != nil {
+		respondInboxError(c, err)
+		return
+	}
+
+	overdue := 0
+	for _, item := range items {
+		if item.Overdue {
+			overdue++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"items":   items,
+		"count":   len(items),
+		"overdue": overdue,
+		"sources": workflow.Sources(reviewer.Role),
+	})
+}
+
+// AssignInboxItem assigns an inbox item to a reviewer. An assignee_id of 0 clears the assignment.
+func AssignInboxItem(c *gin.Context) {
+	reviewer, ok := getInboxReviewer(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		inboxItemRef
+		AssigneeID int64 `json:"assignee_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := workflow.Assign(reviewer, request.Source, request.ID, request.AssigneeID); err != nil {
+		respondInboxError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "inbox item assigned successfully",
+		"source":      request.Source,
+		"id":          request.ID,
+		"assignee_id": request.AssigneeID,
+	})
+}
+
+// BulkInboxDecision approves or rejects several inbox items at once.
+// Every item is processed on its own and gets its own result.
+func BulkInboxDecision(c *gin.Context) {
+	reviewer, ok := getInboxReviewer(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Action  string         `json:"action" binding:"required"`
+		Comment string         `json:"comment"`
+		Items   []inboxItemRef `json:"items" binding:"required,dive"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	if request.Action != workflow.ActionApprove && request.Action != workflow.ActionReject {
+		c.JSON(http.StatusBadRequest, gin.H{"error": workflow.ErrInvalidAction.Error()})
+		return
+	}
+	if request.Action == workflow.ActionReject && request.Comment == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "comment is required when rejecting"})
+		return
+	}
+	if len(request.Items) == 0 || len(request.Items) > maxBulkInboxItems {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "items must contain between 1 and 100 entries"})
+		return
+	}
+
+	results := make([]gin.H, 0, len(request.Items))
+	succeeded := 0
+	for _, item := range request.Items {
+		result := gin.H{"source": item.Source, "id": item.ID}
+		err := workflow.Decide(reviewer, item.Source, item.ID, request.Action, request.Comment)
+		var pending *storage.ApprovalRequiredError
+		switch {
+		case err == nil:
+			result["status"] = "approved"
+			if request.Action == workflow.ActionReject {
+				result["status"] = "rejected"
+			}
+			succeeded++
+		case errors.As(err, &pending):
+			result["status"] = "awaiting_approval"
+			result["approvals"] = pending.Approvals
+			result["required_approvals"] = pending.Required
+		default:
+			result["status"] = "failed"
+			result["error"] = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"succeeded": succeeded,
+		"total":     len(request.Items),
+	})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SendInterbankTransfer sends money from a deposit of the authenticated user to an account at
+// another bank. The deposit is debited at once and the payment waits in the clearing queue.
+func SendInterbankTransfer(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	var transfer models.InterbankTransfer
+	if err := c.ShouldBindJSON(&transfer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format: " + err.Error()})
+		return
+	}
+	transfer.ClientID = int64(userID)
+	payment, err := storage.SendInterbankTransfer(transfer)
+	if err != nil {
+		if errors.Is(err, storage.ErrBeneficiaryHeldLocally) || errors.Is(err, storage.ErrBeneficiaryDetailsRequired) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondDepositMovementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "transfer queued for clearing", "payment": payment})
+}
+
+// GetInterbankTransfers lists the interbank transfers sent from the deposits of the authenticated user
+func GetInterbankTransfers(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	payments, err := storage.GetClientOutgoingPayments(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching interbank transfers: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch transfers"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"payments": payments})
+}
+
+// GetOutgoingPayments lists the interbank payment queue, optionally filtered by ?status (admin only)
+func GetOutgoingPayments(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	status := c.Query("status")
+	switch status {
+	case "", storage.OutgoingPaymentQueued, storage.OutgoingPaymentSent, storage.OutgoingPaymentSettled,
+		storage.OutgoingPaymentReturned, storage.OutgoingPaymentReturnHeld:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be queued, sent, settled, returned or return_held"})
+		return
+	}
+	payments, err := storage.GetOutgoingPayments(status)
+	if err != nil {
+		log.Printf("Error fetching outgoing payments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch outgoing payments"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"payments": payments})
+}
+
+// ReturnOutgoingPayment registers a payment returned by the beneficiary bank outside the
+// clearing files, crediting the originator (admin only)
+func ReturnOutgoingPayment(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	paymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || paymentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment ID"})
+		return
+	}
+	var request struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+
+	payment, err := storage.ReturnOutgoingPayment(paymentID, request.Reason, int64(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrOutgoingPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrOutgoingPaymentReturned):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error returning outgoing payment %d: %v", paymentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to return payment"})
+		}
+		return
+	}
+	if payment.Status == storage.OutgoingPaymentReturnHeld {
+		c.JSON(http.StatusOK, gin.H{
+			"message":    "payment returned; the originating deposit is closed, credit the return to another deposit of the client",
+			"payment_id": paymentID,
+			"status":     payment.Status,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "payment returned and originator credited", "payment_id": paymentID, "status": payment.Status})
+}
+
+// CreditHeldPaymentReturn credits a return held because the originating deposit was closed to
+// another open deposit of the same client (admin only)
+func CreditHeldPaymentReturn(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	paymentID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || paymentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment ID"})
+		return
+	}
+	var request struct {
+		DepositID int64 `json:"deposit_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "deposit_id is required"})
+		return
+	}
+
+	payment, err := storage.CreditHeldPaymentReturn(paymentID, request.DepositID, int64(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrOutgoingPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrOutgoingPaymentNotHeld):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrHeldReturnDeposit), errors.Is(err, storage.ErrDepositNotActive):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error crediting held return of outgoing payment %d: %v", paymentID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to credit payment return"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "payment return credited", "payment_id": paymentID,
+		"deposit_id": request.DepositID, "status": payment.Status})
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"finance/internal/models"
 	db "finance/internal/storage"
+	"log"
 	"net/http"
 	"strconv"
 	"time"
@@ -39,14 +41,12 @@ func RequestLoan(c *gin.Context) {
 		return
 	}
 
-	// Validate custom interest rate if provided
-	if request.InterestRate != nil && *request.InterestRate < 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "interest rate cannot be negative"})
-		return
-	}
+	// The rate comes from the loan product, clients cannot choose their own
+	request.InterestRate = nil
+	request.RateOverrideBy = 0
 
-	// Validate loan type
-	if request.Type != models.StandardLoan && request.Type != models.InstallmentPlan {
+	// Validate loan type; a product code selects the type itself
+	if request.ProductCode == "" && request.Type != models.StandardLoan && request.Type != models.InstallmentPlan {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "invalid loan type, must be either 'standard' or 'installment'",
 		})
@@ -56,10 +56,19 @@ func RequestLoan(c *gin.Context) {
 	// Create the loan request
 	loan, err := db.RequestLoan(request)
 	if err != nil {
+		if errors.Is(err, db.ErrLoanProductNotFound) || errors.Is(err, db.ErrLoanTermsNotOffered) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create loan request: " + err.Error()})
 		return
 	}
 
+	// The score is only a recommendation for the reviewer, a failure does not stop the request
+	if _, err := db.ScoreLoan(loan); err != nil {
+		log.Printf("Error scoring loan %d: %v", loan.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "loan request submitted successfully and is awaiting manager approval",
 		"loan":    loan,
@@ -153,8 +162,22 @@ func GetLoanDetails(c *gin.Context) {
 		}
 	}
 
+	// Collateral and guarantors securing the loan
+	collateral, err := db.GetLoanCollateral(loanID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve loan collateral: " + err.Error()})
+		return
+	}
+	guarantors, err := db.GetLoanGuarantors(loanID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve loan guarantors: " + err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"loan":             loan,
+		"collateral":       collateral,
+		"guarantors":       guarantors,
 		"payments":         payments,
 		"paid_amount":      paidAmount,
 		"remaining_amount": remainingAmount,
@@ -163,6 +186,46 @@ func GetLoanDetails(c *gin.Context) {
 	})
 }
 
+// GetLoanHistory returns the status history of a loan
+func GetLoanHistory(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	loanID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid loan ID"})
+		return
+	}
+
+	loan, err := db.GetLoan(loanID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "loan not found: " + err.Error()})
+		return
+	}
+
+	// Check if the user is authorized to view this loan
+	if loan.UserID != int64(userID) && !hasRole(userID, "admin", "operator", "manager") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not authorized to view this loan"})
+		return
+	}
+
+	history, err := db.GetLoanStatusHistory(loanID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve loan history: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"loan_id":       loanID,
+		"status":        loan.Status,
+		"next_statuses": loan.Status.NextStatuses(),
+		"history":       history,
+	})
+}
+
 // MakeLoanPayment handles a payment on a loan
 func MakeLoanPayment(c *gin.Context) {
 	userID, exists := getUserID(c)
@@ -196,9 +259,12 @@ func MakeLoanPayment(c *gin.Context) {
 		return
 	}
 
-	// Make the payment
+	// Make the payment; it counts against the limits of the borrower, who it is logged for
 	payment, err := db.MakePayment(paymentRequest)
 	if err != nil {
+		if respondLimitError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to process payment: " + err.Error()})
 		return
 	}
@@ -258,6 +324,9 @@ func ApproveLoan(c *gin.Context) {
 
 	// Approve the loan
 	if err := db.ApproveLoan(request.LoanID, int64(adminID)); err != nil {
+		if respondApprovalPolicyError(c, err) {
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve loan: " + err.Error()})
 		return
 	}
@@ -298,19 +367,16 @@ func RejectLoan(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "loan rejected successfully"})
 }
 
-// GetLoanRates returns the current fixed interest rates for different loan terms
+// GetLoanRates returns the loan products currently offered with their rate tables and fees
 func GetLoanRates(c *gin.Context) {
-	// Define the fixed rates
-	rates := map[string]float64{
-		"3":      5.0,  // 3-month loans: 5%
-		"6":      7.5,  // 6-month loans: 7.5%
-		"12":     10.0, // 12-month loans: 10%
-		"24":     15.0, // 24-month loans: 15%
-		"custom": 20.0, // Loans over 24 months: 20%
+	products, err := db.GetCurrentLoanProducts(time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve loan products: " + err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"rates": rates,
-		"note":  "Custom interest rates can be requested for special circumstances",
+		"products": products,
+		"note":     "Rates are set by the loan product; a manager can agree an individual rate",
 	})
 }
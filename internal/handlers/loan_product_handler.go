@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetLoanProducts lists every version of the loan products (admin only).
+// Pass code to see the versions of a single product.
+func GetLoanProducts(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	products, err := storage.GetLoanProducts(c.Query("code"))
+	if err != nil {
+		log.Printf("Error fetching loan products: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve loan products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"products": products})
+}
+
+// CreateLoanProduct publishes a loan product or a new version of an existing one (admin only).
+// effective_from uses the YYYY-MM-DD format and defaults to now.
+func CreateLoanProduct(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Code          string                `json:"code" binding:"required"`
+		Name          string                `json:"name" binding:"required"`
+		Type          models.LoanType       `json:"type" binding:"required"`
+		AllowedTerms  []int                 `json:"allowed_terms"`
+		MinAmount     float64               `json:"min_amount"`
+		MaxAmount     float64               `json:"max_amount"`
+		FeePercent    float64               `json:"fee_percent"`
+		FeeFixed      float64               `json:"fee_fixed"`
+		EffectiveFrom string                `json:"effective_from"`
+		Rates         []models.LoanRateTier `json:"rates" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	product := &models.LoanProduct{
+		Code:         request.Code,
+		Name:         request.Name,
+		Type:         request.Type,
+		AllowedTerms: request.AllowedTerms,
+		MinAmount:    request.MinAmount,
+		MaxAmount:    request.MaxAmount,
+		FeePercent:   request.FeePercent,
+		FeeFixed:     request.FeeFixed,
+		Rates:        request.Rates,
+	}
+	if request.EffectiveFrom != "" {
+		effectiveFrom, err := time.ParseInLocation("2006-01-02", request.EffectiveFrom, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid effective_from, expected YYYY-MM-DD"})
+			return
+		}
+		product.EffectiveFrom = effectiveFrom
+	}
+
+	if err := storage.CreateLoanProduct(product, int64(adminID)); err != nil {
+		log.Printf("Error creating loan product: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "loan product published successfully",
+		"product": product,
+	})
+}
+
+// RetireLoanProduct stops offering a loan product version (admin only)
+func RetireLoanProduct(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	productID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || productID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product ID"})
+		return
+	}
+
+	if err := storage.RetireLoanProduct(productID, int64(adminID)); err != nil {
+		if errors.Is(err, storage.ErrLoanProductNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error retiring loan product %d: %v", productID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retire loan product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "loan product retired successfully"})
+}
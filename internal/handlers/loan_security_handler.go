@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loanForSecurity loads the loan of the request and checks the user is its borrower or staff
+func loanForSecurity(c *gin.Context) (*models.Loan, int, bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return nil, 0, false
+	}
+	loanID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || loanID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid loan ID"})
+		return nil, 0, false
+	}
+
+	loan, err := storage.GetLoan(loanID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "loan not found"})
+		return nil, 0, false
+	}
+	if loan.UserID != int64(userID) && !hasRole(userID, "admin", "manager") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "you are not authorized to change this loan"})
+		return nil, 0, false
+	}
+	return loan, userID, true
+}
+
+// respondLoanSecurityError maps collateral and guarantor errors to responses
+func respondLoanSecurityError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, storage.ErrLoanSecurityClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrCollateralNotFound), errors.Is(err, storage.ErrGuaranteeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error trying to %s: %v", action, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// AddLoanCollateral pledges collateral for a loan that is not active yet.
+// A pledged deposit is blocked until the loan is repaid or rejected.
+func AddLoanCollateral(c *gin.Context) {
+	loan, userID, ok := loanForSecurity(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Type        string  `json:"type" binding:"required"`
+		Description string  `json:"description"`
+		Valuation   float64 `json:"valuation"`
+		DepositID   *int64  `json:"deposit_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	collateral := &models.LoanCollateral{
+		LoanID:      loan.ID,
+		Type:        request.Type,
+		Description: request.Description,
+		Valuation:   request.Valuation,
+		DepositID:   request.DepositID,
+	}
+	if err := storage.AddLoanCollateral(collateral, int64(userID)); err != nil {
+		respondLoanSecurityError(c, err, "add loan collateral")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "collateral added to the loan",
+		"collateral": collateral,
+	})
+}
+
+// SetCollateralLienStatus records the registration or release of a lien (manager or admin)
+func SetCollateralLienStatus(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin", "manager") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "manager privileges required"})
+		return
+	}
+	collateralID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || collateralID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid collateral ID"})
+		return
+	}
+	var request struct {
+		LienStatus string `json:"lien_status" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.SetCollateralLienStatus(collateralID, request.LienStatus, int64(userID)); err != nil {
+		respondLoanSecurityError(c, err, "update lien status")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "lien status updated"})
+}
+
+// AddLoanGuarantor asks another user to guarantee a loan that is not active yet
+func AddLoanGuarantor(c *gin.Context) {
+	loan, userID, ok := loanForSecurity(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Username string `json:"username" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	guarantor, err := storage.AddLoanGuarantor(loan.ID, request.Username, int64(userID))
+	if err != nil {
+		respondLoanSecurityError(c, err, "add loan guarantor")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   "guarantee requested; the loan is activated once every guarantor accepts",
+		"guarantor": guarantor,
+	})
+}
+
+// GetGuaranteeRequests lists the loans the authenticated user was asked to guarantee
+func GetGuaranteeRequests(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	guarantees, err := storage.GetGuaranteeRequests(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching guarantee requests of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve guarantee requests"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"guarantees": guarantees})
+}
+
+// AcceptGuarantee accepts a guarantee request of the authenticated user
+func AcceptGuarantee(c *gin.Context) {
+	respondToGuarantee(c, true)
+}
+
+// DeclineGuarantee declines a guarantee request of the authenticated user
+func DeclineGuarantee(c *gin.Context) {
+	respondToGuarantee(c, false)
+}
+
+func respondToGuarantee(c *gin.Context, accept bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	guaranteeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || guaranteeID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid guarantee ID"})
+		return
+	}
+
+	guarantor, err := storage.RespondToGuarantee(guaranteeID, int64(userID), accept)
+	if err != nil {
+		respondLoanSecurityError(c, err, "respond to guarantee")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "guarantee " + guarantor.Status,
+		"guarantor": guarantor,
+	})
+}
@@ -1,9 +1,11 @@
 package handlers
 
 import (
+	"errors"
 	"finance/internal/models"
 	db "finance/internal/storage"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	_ "time"
@@ -19,9 +21,13 @@ func RegisterManagerRoutes(router *gin.RouterGroup) {
 	router.GET("/users/:id/last-action", GetUserLastAction)  // Fix this reference to match the actual function name
 
 	// Loan related routes
+	router.GET("/loans", GetManagerLoans)
 	router.GET("/loans/pending", GetPendingLoans)
 	router.POST("/loans/approve", ApproveLoan)
 	router.POST("/loans/reject", RejectLoan)
+	router.POST("/loans/review", ManagerReviewLoan)
+	router.GET("/loans/:id/score", GetLoanScore)
+	router.POST("/collateral/:id/lien", SetCollateralLienStatus)
 
 	// ...existing routes...
 }
@@ -114,12 +120,22 @@ func ManagerReviewLoan(c *gin.Context) {
 		// First approve the loan (changes status to Approved)
 		err = db.ManagerApproveLoan(request.LoanID, managerIDInt64)
 		if err != nil {
+			if respondApprovalPolicyError(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve loan: " + err.Error()})
 			return
 		}
 
 		// Then explicitly activate it (changes status to Active)
-		err = db.ActivateLoan(request.LoanID)
+		err = db.ActivateLoan(request.LoanID, managerIDInt64)
+		if errors.Is(err, db.ErrGuarantorsPending) {
+			c.JSON(http.StatusAccepted, gin.H{
+				"message": "loan approved; it is activated once every guarantor accepts",
+				"loan_id": request.LoanID,
+			})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "loan approved but failed to activate: " + err.Error()})
 			return
@@ -219,6 +235,7 @@ func GetManagerLoans(c *gin.Context) {
 			"status":          loan.Status,
 			"created_at":      loan.CreatedAt,
 			"needs_review":    loan.Status == models.Pending,
+			"score":           loanScoreForReview(loan),
 		}
 	}
 
@@ -276,26 +293,38 @@ func ProcessLoanRequest(c *gin.Context) {
 			TermMonths: request.Duration,
 		}
 
-		// Set interest rate if provided
+		// A manager may agree an individual rate instead of the product rate
 		if request.InterestRate > 0 {
 			loanRequest.InterestRate = &request.InterestRate
+			loanRequest.RateOverrideBy = int64(managerID)
 		}
 
 		// Create the loan
 		loan, err := db.RequestLoan(loanRequest)
 		if err != nil {
+			if errors.Is(err, db.ErrLoanProductNotFound) || errors.Is(err, db.ErrLoanTermsNotOffered) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create " + request.Type})
 			return
 		}
+		if _, err := db.ScoreLoan(loan); err != nil {
+			log.Printf("Error scoring loan %d: %v", loan.ID, err)
+		}
 
 		// Two-step process: First approve the loan
 		if err := db.ManagerApproveLoan(loan.ID, int64(managerID)); err != nil {
+			// A large request stays pending until another staff member approves it
+			if respondApprovalPolicyError(c, err) {
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve " + request.Type})
 			return
 		}
 
 		// Then explicitly activate it
-		if err := db.ActivateLoan(loan.ID); err != nil {
+		if err := db.ActivateLoan(loan.ID, int64(managerID)); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": request.Type + " approved but failed to activate"})
 			return
 		}
@@ -325,3 +354,62 @@ func ProcessLoanRequest(c *gin.Context) {
 		})
 	}
 }
+
+// loanScoreForReview returns the stored score of a loan. Pending loans that were never
+// scored, such as loans requested before scoring existed, are scored on the spot.
+func loanScoreForReview(loan *models.Loan) *db.LoanScore {
+	score, err := db.GetLoanScore(loan.ID)
+	if errors.Is(err, db.ErrLoanScoreNotFound) && loan.Status == models.Pending {
+		score, err = db.ScoreLoan(loan)
+	}
+	if err != nil {
+		if !errors.Is(err, db.ErrLoanScoreNotFound) {
+			log.Printf("Error fetching score of loan %d: %v", loan.ID, err)
+		}
+		return nil
+	}
+	return score
+}
+
+// GetLoanScore shows the scoring of a loan on the review screen.
+// Pass refresh=true to score the loan again with the current rules.
+func GetLoanScore(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "manager", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "manager privileges required"})
+		return
+	}
+
+	loanID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid loan ID"})
+		return
+	}
+	loan, err := db.GetLoan(loanID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "loan not found"})
+		return
+	}
+
+	var score *db.LoanScore
+	if c.Query("refresh") == "true" {
+		score, err = db.ScoreLoan(loan)
+		if err != nil {
+			log.Printf("Error scoring loan %d: %v", loanID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to score loan"})
+			return
+		}
+	} else if score = loanScoreForReview(loan); score == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": db.ErrLoanScoreNotFound.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"loan":  loan,
+		"score": score,
+	})
+}
@@ -0,0 +1,464 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MerchantKeyHeader carries the API key of a merchant on /merchant requests
+const MerchantKeyHeader = "X-API-Key"
+
+// MerchantAuthMiddleware authenticates merchants by their API key
+func MerchantAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(MerchantKeyHeader)
+		if key == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": MerchantKeyHeader + " header is required"})
+			c.Abort()
+			return
+		}
+
+		merchant, err := storage.AuthenticateMerchant(key)
+		if err != nil {
+			if errors.Is(err, storage.ErrInvalidMerchantKey) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			} else {
+				log.Printf("Error authenticating merchant: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to authenticate merchant"})
+			}
+			c.Abort()
+			return
+		}
+
+		c.Set("merchantID", merchant.ID)
+		c.Next()
+	}
+}
+
+// getMerchantID returns the merchant set by MerchantAuthMiddleware
+func getMerchantID(c *gin.Context) int64 {
+	return c.GetInt64("merchantID")
+}
+
+// parsePurchaseID reads the purchase ID path parameter
+func parsePurchaseID(c *gin.Context) (int64, bool) {
+	purchaseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || purchaseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid purchase ID"})
+		return 0, false
+	}
+	return purchaseID, true
+}
+
+// respondPurchaseError maps installment purchase errors to responses
+func respondPurchaseError(c *gin.Context, err error, action string) {
+	switch {
+	case errors.Is(err, storage.ErrPurchaseNotFound), errors.Is(err, storage.ErrMerchantPlanNotFound),
+		errors.Is(err, storage.ErrMerchantNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrPurchaseNotAwaitingConsent), errors.Is(err, storage.ErrDuplicateOrderReference):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		log.Printf("Error trying to %s: %v", action, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+// GetMerchantOwnPlans lists the promotional plans the merchant can offer at checkout
+func GetMerchantOwnPlans(c *gin.Context) {
+	plans, err := storage.GetMerchantPlans(getMerchantID(c), true)
+	if err != nil {
+		log.Printf("Error fetching merchant plans: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve plans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// CreateCheckoutPurchase creates an installment plan for a customer at checkout.
+// The customer identifies with their bank username and confirms the plan in the bank.
+func CreateCheckoutPurchase(c *gin.Context) {
+	var request struct {
+		CustomerUsername string  `json:"customer_username" binding:"required"`
+		PlanID           int64   `json:"plan_id" binding:"required"`
+		Amount           float64 `json:"amount" binding:"required"`
+		Item             string  `json:"item" binding:"required"`
+		OrderReference   string  `json:"order_reference" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	customer, err := storage.GetUserByUsername(request.CustomerUsername)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "customer not found"})
+			return
+		}
+		log.Printf("Error looking up customer %s: %v", request.CustomerUsername, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to look up customer"})
+		return
+	}
+	if !customer.Approved {
+		c.JSON(http.StatusForbidden, gin.H{"error": "customer account is not approved"})
+		return
+	}
+
+	purchase := &storage.InstallmentPurchase{
+		MerchantID:     getMerchantID(c),
+		PlanID:         request.PlanID,
+		CustomerID:     int64(customer.ID),
+		Item:           request.Item,
+		OrderReference: request.OrderReference,
+		Amount:         request.Amount,
+	}
+	if err := storage.CreateInstallmentPurchase(purchase); err != nil {
+		respondPurchaseError(c, err, "create installment purchase")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "installment plan created, waiting for the customer to confirm it in the bank",
+		"purchase": purchase,
+	})
+}
+
+// GetMerchantPurchases lists the installment purchases of the merchant, optionally by status
+func GetMerchantPurchases(c *gin.Context) {
+	purchases, err := storage.GetInstallmentPurchases(getMerchantID(c), 0, c.Query("status"))
+	if err != nil {
+		log.Printf("Error fetching merchant purchases: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve purchases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purchases": purchases})
+}
+
+// GetMerchantPurchase returns the status of one purchase of the merchant
+func GetMerchantPurchase(c *gin.Context) {
+	purchaseID, ok := parsePurchaseID(c)
+	if !ok {
+		return
+	}
+	purchase, err := storage.GetInstallmentPurchase(purchaseID)
+	if err == nil && purchase.MerchantID != getMerchantID(c) {
+		err = storage.ErrPurchaseNotFound
+	}
+	if err != nil {
+		respondPurchaseError(c, err, "fetch installment purchase")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purchase": purchase})
+}
+
+// CancelMerchantPurchase withdraws a purchase the customer has not confirmed yet
+func CancelMerchantPurchase(c *gin.Context) {
+	purchaseID, ok := parsePurchaseID(c)
+	if !ok {
+		return
+	}
+	if err := storage.CancelInstallmentPurchase(purchaseID, getMerchantID(c)); err != nil {
+		respondPurchaseError(c, err, "cancel installment purchase")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "installment purchase cancelled"})
+}
+
+// GetMyInstallmentPurchases lists the checkout plans of the authenticated customer
+func GetMyInstallmentPurchases(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	purchases, err := storage.GetInstallmentPurchases(0, int64(userID), c.Query("status"))
+	if err != nil {
+		log.Printf("Error fetching installment purchases of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve installment purchases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purchases": purchases})
+}
+
+// ConfirmInstallmentPurchase records the consent of the customer and opens the installment loan
+func ConfirmInstallmentPurchase(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	purchaseID, ok := parsePurchaseID(c)
+	if !ok {
+		return
+	}
+
+	purchase, err := storage.ConfirmInstallmentPurchase(purchaseID, int64(userID))
+	if err != nil {
+		respondPurchaseError(c, err, "confirm installment purchase")
+		return
+	}
+
+	message := "installment plan confirmed and the merchant has been paid"
+	switch purchase.Status {
+	case storage.PurchaseConsented:
+		message = "installment plan confirmed and awaiting manager approval"
+	case storage.PurchaseRejected:
+		message = "installment plan confirmed but the loan was declined"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  message,
+		"purchase": purchase,
+	})
+}
+
+// DeclineInstallmentPurchase records that the customer refused a checkout plan
+func DeclineInstallmentPurchase(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	purchaseID, ok := parsePurchaseID(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.DeclineInstallmentPurchase(purchaseID, int64(userID)); err != nil {
+		respondPurchaseError(c, err, "decline installment purchase")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "installment plan declined"})
+}
+
+// parseMerchantID reads the merchant ID path parameter
+func parseMerchantID(c *gin.Context) (int64, bool) {
+	merchantID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || merchantID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merchant ID"})
+		return 0, false
+	}
+	return merchantID, true
+}
+
+// GetMerchants lists the merchant registry (admin only)
+func GetMerchants(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	merchants, err := storage.GetMerchants()
+	if err != nil {
+		log.Printf("Error fetching merchants: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve merchants"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"merchants": merchants})
+}
+
+// CreateMerchant registers a merchant (admin only). The API key is only returned here.
+func CreateMerchant(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Name              string `json:"name" binding:"required"`
+		SettlementAccount string `json:"settlement_account" binding:"required"`
+		SettlementBank    string `json:"settlement_bank" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	merchant := &storage.Merchant{
+		Name:              request.Name,
+		SettlementAccount: request.SettlementAccount,
+		SettlementBank:    request.SettlementBank,
+	}
+	key, err := storage.CreateMerchant(merchant, int64(adminID))
+	if err != nil {
+		log.Printf("Error creating merchant: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":  "merchant registered successfully; store the API key, it is not shown again",
+		"merchant": merchant,
+		"api_key":  key,
+	})
+}
+
+// RotateMerchantKey issues a new API key for a merchant (admin only)
+func RotateMerchantKey(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	merchantID, ok := parseMerchantID(c)
+	if !ok {
+		return
+	}
+
+	key, err := storage.RotateMerchantKey(merchantID, int64(adminID))
+	if err != nil {
+		respondPurchaseError(c, err, "rotate merchant key")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "merchant API key rotated; the previous key no longer works",
+		"api_key": key,
+	})
+}
+
+// SetMerchantActive suspends or reactivates a merchant (admin only)
+func SetMerchantActive(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	merchantID, ok := parseMerchantID(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Active *bool `json:"active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.SetMerchantActive(merchantID, *request.Active, int64(adminID)); err != nil {
+		respondPurchaseError(c, err, "change merchant status")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "merchant status updated"})
+}
+
+// GetMerchantPlans lists every plan of a merchant (admin only)
+func GetMerchantPlans(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	merchantID, ok := parseMerchantID(c)
+	if !ok {
+		return
+	}
+	plans, err := storage.GetMerchantPlans(merchantID, false)
+	if err != nil {
+		log.Printf("Error fetching plans of merchant %d: %v", merchantID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve plans"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"plans": plans})
+}
+
+// CreateMerchantPlan adds a 0% promotional plan funded by a merchant discount (admin only)
+func CreateMerchantPlan(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	merchantID, ok := parseMerchantID(c)
+	if !ok {
+		return
+	}
+	var request struct {
+		Name            string  `json:"name" binding:"required"`
+		TermMonths      int     `json:"term_months" binding:"required"`
+		DiscountPercent float64 `json:"discount_percent"`
+		MinAmount       float64 `json:"min_amount"`
+		MaxAmount       float64 `json:"max_amount"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	plan := &storage.MerchantPlan{
+		MerchantID:      merchantID,
+		Name:            request.Name,
+		TermMonths:      request.TermMonths,
+		DiscountPercent: request.DiscountPercent,
+		MinAmount:       request.MinAmount,
+		MaxAmount:       request.MaxAmount,
+	}
+	if err := storage.CreateMerchantPlan(plan, int64(adminID)); err != nil {
+		respondPurchaseError(c, err, "create merchant plan")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "merchant plan created successfully",
+		"plan":    plan,
+	})
+}
+
+// SetMerchantPlanActive withdraws or re-offers a merchant plan (admin only)
+func SetMerchantPlanActive(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	planID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || planID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid plan ID"})
+		return
+	}
+	var request struct {
+		Active *bool `json:"active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.SetMerchantPlanActive(planID, *request.Active, int64(adminID)); err != nil {
+		respondPurchaseError(c, err, "change merchant plan status")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "merchant plan status updated"})
+}
+
+// GetInstallmentPurchases lists the installment purchases of every merchant (admin only)
+func GetInstallmentPurchases(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	var merchantID int64
+	if value := c.Query("merchant_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid merchant_id"})
+			return
+		}
+		merchantID = id
+	}
+	purchases, err := storage.GetInstallmentPurchases(merchantID, 0, c.Query("status"))
+	if err != nil {
+		log.Printf("Error fetching installment purchases: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve installment purchases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"purchases": purchases})
+}
+
+// SettleInstallmentPurchases pays the merchants of purchases whose loan is now active (admin only)
+func SettleInstallmentPurchases(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+	if err := storage.SettleInstallmentPurchases(); err != nil {
+		log.Printf("Error settling installment purchases: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to settle installment purchases"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "installment purchases settled"})
+}
@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"finance/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxKYBDocumentSize limits the size of uploaded KYB documents
+const maxKYBDocumentSize = 10 << 20
+
+// allowedKYBExtensions lists the file types accepted as KYB documents
+var allowedKYBExtensions = map[string]bool{
+	".pdf":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+}
+
+// parseApplicationIDParam reads the onboarding application ID from the URL
+func parseApplicationIDParam(c *gin.Context) (int64, bool) {
+	applicationID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || applicationID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid application ID"})
+		return 0, false
+	}
+	return applicationID, true
+}
+
+// SubmitEnterpriseApplication handles an onboarding application from an external specialist
+func SubmitEnterpriseApplication(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+
+	var request struct {
+		Name               string `json:"name" binding:"required"`
+		Description        string `json:"description"`
+		RegistrationNumber string `json:"registration_number" binding:"required"`
+		TaxID              string `json:"tax_id" binding:"required"`
+		LegalAddress       string `json:"legal_address" binding:"required"`
+		Signatories        []struct {
+			FullName       string `json:"full_name"`
+			Position       string `json:"position"`
+			DocumentNumber string `json:"document_number"`
+			UserID         int    `json:"user_id"`
+		} `json:"signatories" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	application := &storage.EnterpriseApplication{
+		ApplicantID:        userID,
+		Name:               request.Name,
+		Description:        request.Description,
+		RegistrationNumber: request.RegistrationNumber,
+		TaxID:              request.TaxID,
+		LegalAddress:       request.LegalAddress,
+	}
+	for _, s := range request.Signatories {
+		application.Signatories = append(application.Signatories, storage.EnterpriseSignatory{
+			FullName:       s.FullName,
+			Position:       s.Position,
+			DocumentNumber: s.DocumentNumber,
+			UserID:         s.UserID,
+		})
+	}
+
+	applicationID, err := storage.SubmitEnterpriseApplication(application)
+	if err != nil {
+		if errors.Is(err, storage.ErrEnterpriseAlreadyRegistered) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error submitting enterprise application: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "onboarding application submitted, upload the KYB documents next",
+		"application_id": applicationID,
+		"status":         application.Status,
+	})
+}
+
+// UploadEnterpriseApplicationDocument stores a KYB document for a pending application
+func UploadEnterpriseApplicationDocument(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	applicationID, ok := parseApplicationIDParam(c)
+	if !ok {
+		return
+	}
+
+	application, err := storage.GetEnterpriseApplication(applicationID)
+	if err != nil {
+		if errors.Is(err, storage.ErrApplicationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve application"})
+		return
+	}
+	if application.ApplicantID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the applicant can upload documents"})
+		return
+	}
+	if application.Status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": storage.ErrApplicationNotPending.Error()})
+		return
+	}
+
+	docType := strings.TrimSpace(c.PostForm("doc_type"))
+	if docType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "doc_type is required"})
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	if fileHeader.Size > maxKYBDocumentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "document is too large"})
+		return
+	}
+	if !allowedKYBExtensions[strings.ToLower(filepath.Ext(fileHeader.Filename))] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "document must be a PDF, JPEG or PNG file"})
+		return
+	}
+
+	stored, err := utils.SaveUploadedFile(fileHeader, filepath.Join("kyb", strconv.FormatInt(applicationID, 10)))
+	if err != nil {
+		log.Printf("Error storing KYB document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store document"})
+		return
+	}
+
+	document := &storage.EnterpriseKYBDocument{
+		ApplicationID: applicationID,
+		DocType:       docType,
+		FileName:      stored.FileName,
+		StoredPath:    stored.Path,
+		Size:          stored.Size,
+		SHA256:        stored.SHA256,
+		UploadedBy:    userID,
+	}
+	if _, err := storage.AddEnterpriseApplicationDocument(document); err != nil {
+		os.Remove(stored.Path)
+		if errors.Is(err, storage.ErrApplicationNotPending) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error saving KYB document: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "document uploaded successfully",
+		"document": document,
+	})
+}
+
+// GetMyEnterpriseApplications lists the onboarding applications of the current user
+func GetMyEnterpriseApplications(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+
+	applications, err := storage.GetEnterpriseApplications(c.Query("status"), userID)
+	if err != nil {
+		log.Printf("Error fetching enterprise applications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve applications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applications": applications,
+		"count":        len(applications),
+	})
+}
+
+// GetEnterpriseApplication returns an application with its signatories and documents.
+// Admins can see every application, other users only their own.
+func GetEnterpriseApplication(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	applicationID, ok := parseApplicationIDParam(c)
+	if !ok {
+		return
+	}
+
+	application, err := storage.GetEnterpriseApplication(applicationID)
+	if err != nil {
+		if errors.Is(err, storage.ErrApplicationNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error fetching enterprise application %d: %v", applicationID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve application"})
+		return
+	}
+	if application.ApplicantID != userID && !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this application"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"application": application})
+}
+
+// GetEnterpriseApplications lists onboarding applications for admin review
+func GetEnterpriseApplications(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	status := c.DefaultQuery("status", "pending")
+	if status != "pending" && status != "approved" && status != "rejected" && status != "all" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, approved, rejected or all"})
+		return
+	}
+	if status == "all" {
+		status = ""
+	}
+
+	applications, err := storage.GetEnterpriseApplications(status, 0)
+	if err != nil {
+		log.Printf("Error fetching enterprise applications: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve applications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"applications": applications,
+		"count":        len(applications),
+	})
+}
+
+// ApproveEnterpriseApplication approves an application and creates the enterprise
+func ApproveEnterpriseApplication(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	applicationID, ok := parseApplicationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	enterpriseID, err := storage.ApproveEnterpriseApplication(applicationID, int64(userID), request.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrApplicationNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrApplicationNotPending), errors.Is(err, storage.ErrEnterpriseAlreadyRegistered):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error approving enterprise application %d: %v", applicationID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "application approved, enterprise created",
+		"application_id": applicationID,
+		"enterprise_id":  enterpriseID,
+	})
+}
+
+// RejectEnterpriseApplication rejects an onboarding application
+func RejectEnterpriseApplication(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	applicationID, ok := parseApplicationIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required when rejecting an application"})
+		return
+	}
+
+	if err := storage.RejectEnterpriseApplication(applicationID, int64(userID), request.Reason); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrApplicationNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrApplicationNotPending):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error rejecting enterprise application %d: %v", applicationID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reject application"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "application rejected",
+		"application_id": applicationID,
+	})
+}
+
+// GetEnterpriseAccess lists the specialists that have access to an enterprise
+func GetEnterpriseAccess(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+
+	access, err := storage.GetEnterpriseAccessList(enterpriseID)
+	if err != nil {
+		log.Printf("Error fetching enterprise access: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve enterprise access"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access": access,
+		"count":  len(access),
+	})
+}
+
+// GrantEnterpriseAccess links an external specialist to an enterprise with an enterprise level role
+func GrantEnterpriseAccess(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		UserID int    `json:"user_id" binding:"required"`
+		Role   string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	if !hasRole(request.UserID, "external") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "only external specialists can be given enterprise access"})
+		return
+	}
+
+	if err := storage.GrantEnterpriseAccess(enterpriseID, request.UserID, request.Role, int64(userID)); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrInvalidEnterpriseRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrEnterpriseNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error granting enterprise access: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant enterprise access"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("user granted the %s role", request.Role),
+		"enterprise_id": enterpriseID,
+		"user_id":       request.UserID,
+		"role":          request.Role,
+	})
+}
+
+// RevokeEnterpriseAccess removes a specialist's access to an enterprise
+func RevokeEnterpriseAccess(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	targetUserID, err := strconv.Atoi(c.Param("user_id"))
+	if err != nil || targetUserID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	if err := storage.RevokeEnterpriseAccess(enterpriseID, targetUserID, int64(userID)); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrEnterpriseAccessNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrLastEnterpriseAdmin):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error revoking enterprise access: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke enterprise access"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "enterprise access revoked",
+		"enterprise_id": enterpriseID,
+		"user_id":       targetUserID,
+	})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"finance/internal/storage"
 	"fmt"
 	"log"
@@ -321,6 +322,11 @@ func CancelLastOperation(c *gin.Context) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Операции удаления не могут быть отменены"})
 			return
 		}
+		if errors.Is(err, storage.ErrClosureNotCancellable) || errors.Is(err, storage.ErrMovementReversalFunds) ||
+			errors.Is(err, storage.ErrOutgoingPaymentSent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
 		log.Printf("Error cancelling transaction: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
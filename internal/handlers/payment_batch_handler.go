@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/iso20022"
+	"finance/internal/storage"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPaymentFileSize limits the size of uploaded pain.001 files
+const maxPaymentFileSize = 10 << 20
+
+// UploadPaymentBatch imports a pain.001.001.03 or .09 credit transfer file exported from an
+// enterprise's ERP. The file is sent as the "file" field of a multipart form or as the raw XML
+// body. Accepted instructions become pending transfer requests grouped under a batch, and the
+// response is a pain.002 status report listing every instruction as accepted or rejected.
+func UploadPaymentBatch(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+	enterpriseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || enterpriseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enterprise ID"})
+		return
+	}
+	if !storage.CheckUserEnterpriseAuthorization(userID, enterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+	if !storage.CheckUserEnterpriseRole(userID, enterpriseID, storage.EnterprisePaymentRoles()...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "your role in this enterprise does not allow payments"})
+		return
+	}
+
+	var reader io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "pain.001 file is required"})
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read pain.001 file"})
+			return
+		}
+		defer file.Close()
+		reader = file
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, maxPaymentFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read pain.001 file"})
+		return
+	}
+	if len(data) > maxPaymentFileSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "pain.001 file is too large"})
+		return
+	}
+
+	message, err := iso20022.ParsePain001(data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	batch, err := storage.ImportPaymentBatch(enterpriseID, userID, message)
+	if err != nil {
+		if errors.Is(err, storage.ErrDuplicatePaymentBatch) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "message_id": message.MessageID})
+			return
+		}
+		log.Printf("Error importing payment batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import payment batch"})
+		return
+	}
+	writePaymentStatusReport(c, batch)
+}
+
+// GetPaymentBatches lists the payment batches uploaded for an enterprise
+func GetPaymentBatches(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+	enterpriseID, err := strconv.Atoi(c.Param("id"))
+	if err != nil || enterpriseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid enterprise ID"})
+		return
+	}
+	if !storage.CheckUserEnterpriseAuthorization(userID, enterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+	batches, err := storage.GetPaymentBatches(enterpriseID)
+	if err != nil {
+		log.Printf("Error fetching payment batches: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch payment batches"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"batches": batches})
+}
+
+// GetPaymentBatch returns a payment batch with its instructions. With format=pain002 the
+// status report sent when the batch was uploaded is returned again.
+func GetPaymentBatch(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return
+	}
+	batchID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || batchID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid batch ID"})
+		return
+	}
+	batch, err := storage.GetPaymentBatch(batchID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPaymentBatchNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error fetching payment batch: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch payment batch"})
+		return
+	}
+	if !storage.CheckUserEnterpriseAuthorization(userID, batch.EnterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return
+	}
+	if c.Query("format") == "pain002" {
+		writePaymentStatusReport(c, batch)
+		return
+	}
+	c.JSON(http.StatusOK, batch)
+}
+
+// writePaymentStatusReport sends the pain.002 report of a batch
+func writePaymentStatusReport(c *gin.Context, batch *storage.PaymentBatch) {
+	report, err := iso20022.BuildPain002(batch.StatusReport())
+	if err != nil {
+		log.Printf("Error rendering pain.002 report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render status report"})
+		return
+	}
+	c.Header("X-Payment-Batch-ID", strconv.FormatInt(batch.ID, 10))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="pain002-batch-%d.xml"`, batch.ID))
+	c.Data(http.StatusOK, "application/xml", report)
+}
@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeExternalEnterprise checks that the current user is an external specialist
+// of the enterprise and writes the error response when not
+func authorizeExternalEnterprise(c *gin.Context, enterpriseID int) (int, bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return 0, false
+	}
+	if !hasRole(userID, "external") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "external specialist privileges required"})
+		return 0, false
+	}
+	if !storage.CheckUserEnterpriseAuthorization(userID, enterpriseID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "not authorized for this enterprise"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// authorizeEnterprisePayments checks that the current user is an external specialist of the
+// enterprise whose role may move its money, and writes the error response when not
+func authorizeEnterprisePayments(c *gin.Context, enterpriseID int) (int, bool) {
+	userID, ok := authorizeExternalEnterprise(c, enterpriseID)
+	if !ok {
+		return 0, false
+	}
+	if !storage.CheckUserEnterpriseRole(userID, enterpriseID, storage.EnterprisePaymentRoles()...) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "your role in this enterprise does not allow payments"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// authorizeDraftProject resolves the salary project in the URL and checks that the current user
+// may change payments of its enterprise
+func authorizeDraftProject(c *gin.Context) (int64, int, bool) {
+	projectID, ok := parseSalaryProjectIDParam(c)
+	if !ok {
+		return 0, 0, false
+	}
+	enterpriseID, err := storage.GetSalaryProjectEnterpriseID(projectID)
+	if err != nil {
+		if errors.Is(err, storage.ErrSalaryProjectNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return 0, 0, false
+		}
+		log.Printf("Error fetching salary project %d: %v", projectID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve salary project"})
+		return 0, 0, false
+	}
+	userID, ok := authorizeEnterprisePayments(c, enterpriseID)
+	if !ok {
+		return 0, 0, false
+	}
+	return projectID, userID, true
+}
+
+// CreatePayrollTemplate creates a recurring payroll template for an enterprise
+func CreatePayrollTemplate(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	userID, ok := authorizeEnterprisePayments(c, enterpriseID)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name             string `json:"name" binding:"required"`
+		Kind             string `json:"kind"`
+		PayDay           int    `json:"pay_day" binding:"required"`
+		SubmitDaysBefore int    `json:"submit_days_before"`
+		PaymentPurpose   string `json:"payment_purpose"`
+		SourceProjectID  int64  `json:"source_project_id"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	template := &storage.PayrollTemplate{
+		EnterpriseID:     enterpriseID,
+		Name:             request.Name,
+		Kind:             request.Kind,
+		PayDay:           request.PayDay,
+		SubmitDaysBefore: request.SubmitDaysBefore,
+		PaymentPurpose:   request.PaymentPurpose,
+		SourceProjectID:  request.SourceProjectID,
+		CreatedBy:        userID,
+	}
+	if _, err := storage.CreatePayrollTemplate(template); err != nil {
+		log.Printf("Error creating payroll template: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	payDate := template.NextPayDate(time.Now())
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "payroll template created successfully",
+		"template":        template,
+		"next_pay_date":   payDate.Format("2006-01-02"),
+		"submission_date": template.SubmissionDate(payDate).Format("2006-01-02"),
+	})
+}
+
+// GetPayrollTemplates lists the payroll templates of an enterprise
+func GetPayrollTemplates(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeExternalEnterprise(c, enterpriseID); !ok {
+		return
+	}
+
+	templates, err := storage.GetEnterprisePayrollTemplates(enterpriseID)
+	if err != nil {
+		log.Printf("Error fetching payroll templates: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payroll templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"templates": templates,
+		"count":     len(templates),
+	})
+}
+
+// SetPayrollTemplateActive pauses or resumes a payroll template
+func SetPayrollTemplateActive(c *gin.Context) {
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || templateID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template ID"})
+		return
+	}
+	template, err := storage.GetPayrollTemplate(templateID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPayrollTemplateNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payroll template"})
+		return
+	}
+	if _, ok := authorizeEnterprisePayments(c, template.EnterpriseID); !ok {
+		return
+	}
+
+	var request struct {
+		Active *bool `json:"active" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.SetPayrollTemplateActive(templateID, *request.Active); err != nil {
+		log.Printf("Error updating payroll template %d: %v", templateID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update payroll template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "payroll template updated successfully",
+		"template_id": templateID,
+		"active":      *request.Active,
+	})
+}
+
+// CopyForwardPayrollTemplate creates the draft project for the next pay date of a template
+func CopyForwardPayrollTemplate(c *gin.Context) {
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || templateID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid template ID"})
+		return
+	}
+	template, err := storage.GetPayrollTemplate(templateID)
+	if err != nil {
+		if errors.Is(err, storage.ErrPayrollTemplateNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payroll template"})
+		return
+	}
+	if _, ok := authorizeEnterprisePayments(c, template.EnterpriseID); !ok {
+		return
+	}
+
+	var request struct {
+		PayDate string `json:"pay_date"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+	payDate := template.NextPayDate(time.Now())
+	if request.PayDate != "" {
+		payDate, err = time.ParseInLocation("2006-01-02", request.PayDate, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid pay_date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+
+	project, err := storage.CopyForwardPayrollTemplate(templateID, payDate)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoPayrollSource) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error copying payroll template %d forward: %v", templateID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create draft salary project"})
+		return
+	}
+	payments, err := storage.GetSalaryProjectPayments(project.ID)
+	if err != nil {
+		log.Printf("Error fetching payments of salary project %d: %v", project.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve salary payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         "draft salary project ready for review",
+		"salary_project":  project,
+		"payments":        payments,
+		"submission_date": template.SubmissionDate(payDate).Format("2006-01-02"),
+	})
+}
+
+// UpdateDraftSalaryPayment changes the amount of a payment in a draft salary project
+func UpdateDraftSalaryPayment(c *gin.Context) {
+	projectID, _, ok := authorizeDraftProject(c)
+	if !ok {
+		return
+	}
+	paymentID, err := strconv.ParseInt(c.Param("payment_id"), 10, 64)
+	if err != nil || paymentID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid payment ID"})
+		return
+	}
+
+	var request struct {
+		Amount *float64 `json:"amount" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.UpdateDraftSalaryPayment(projectID, paymentID, *request.Amount); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrSalaryPaymentNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrSalaryProjectNotDraft):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error updating salary payment %d: %v", paymentID, err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "salary payment updated, the draft must be confirmed again",
+		"project_id": projectID,
+		"payment_id": paymentID,
+	})
+}
+
+// ConfirmDraftSalaryProject confirms a draft salary project for submission on its submission date
+func ConfirmDraftSalaryProject(c *gin.Context) {
+	projectID, userID, ok := authorizeDraftProject(c)
+	if !ok {
+		return
+	}
+
+	if err := storage.ConfirmDraftSalaryProject(projectID, userID); err != nil {
+		if errors.Is(err, storage.ErrSalaryProjectNotDraft) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error confirming salary project %d: %v", projectID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "draft salary project confirmed",
+		"project_id": projectID,
+	})
+}
+
+// GetPayrollReminders lists the reminders about unconfirmed drafts of an enterprise
+func GetPayrollReminders(c *gin.Context) {
+	enterpriseID, ok := parseEnterpriseIDParam(c)
+	if !ok {
+		return
+	}
+	if _, ok := authorizeExternalEnterprise(c, enterpriseID); !ok {
+		return
+	}
+
+	reminders, err := storage.GetPayrollReminders(enterpriseID)
+	if err != nil {
+		log.Printf("Error fetching payroll reminders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve payroll reminders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"reminders": reminders,
+		"count":     len(reminders),
+	})
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/scoring"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scoringRuleRequest is the body of the scoring rule create and update endpoints
+type scoringRuleRequest struct {
+	Name      string  `json:"name" binding:"required"`
+	Factor    string  `json:"factor" binding:"required"`
+	Operator  string  `json:"operator" binding:"required"`
+	Threshold float64 `json:"threshold"`
+	Points    int     `json:"points"`
+	Active    *bool   `json:"active"`
+}
+
+// toRule converts the request into a scoring rule; rules are active unless stated otherwise
+func (r *scoringRuleRequest) toRule() *scoring.Rule {
+	rule := &scoring.Rule{
+		Name:      r.Name,
+		Factor:    r.Factor,
+		Operator:  r.Operator,
+		Threshold: r.Threshold,
+		Points:    r.Points,
+		Active:    true,
+	}
+	if r.Active != nil {
+		rule.Active = *r.Active
+	}
+	return rule
+}
+
+// requireAdmin checks the authenticated user is an admin and returns their ID
+func requireAdmin(c *gin.Context) (int, bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return 0, false
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// GetScoringConfig returns the scoring rules and settings (admin only)
+func GetScoringConfig(c *gin.Context) {
+	if _, ok := requireAdmin(c); !ok {
+		return
+	}
+
+	rules, err := storage.GetScoringRules()
+	if err != nil {
+		log.Printf("Error fetching scoring rules: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve scoring rules"})
+		return
+	}
+	settings, err := storage.GetScoringSettings()
+	if err != nil {
+		log.Printf("Error fetching scoring settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve scoring settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rules":    rules,
+		"settings": settings,
+	})
+}
+
+// CreateScoringRule adds a scoring rule (admin only)
+func CreateScoringRule(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var request scoringRuleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	rule := request.toRule()
+	if err := storage.CreateScoringRule(rule, int64(adminID)); err != nil {
+		if errors.Is(err, scoring.ErrInvalidRule) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error creating scoring rule: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create scoring rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "scoring rule created successfully",
+		"rule":    rule,
+	})
+}
+
+// UpdateScoringRule replaces a scoring rule (admin only)
+func UpdateScoringRule(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || ruleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule ID"})
+		return
+	}
+
+	var request scoringRuleRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	rule := request.toRule()
+	rule.ID = ruleID
+	if err := storage.UpdateScoringRule(rule, int64(adminID)); err != nil {
+		switch {
+		case errors.Is(err, scoring.ErrInvalidRule):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, storage.ErrScoringRuleNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error updating scoring rule %d: %v", ruleID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update scoring rule"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "scoring rule updated successfully",
+		"rule":    rule,
+	})
+}
+
+// DeleteScoringRule removes a scoring rule (admin only)
+func DeleteScoringRule(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+	ruleID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || ruleID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule ID"})
+		return
+	}
+
+	if err := storage.DeleteScoringRule(ruleID, int64(adminID)); err != nil {
+		if errors.Is(err, storage.ErrScoringRuleNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error deleting scoring rule %d: %v", ruleID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete scoring rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "scoring rule removed successfully"})
+}
+
+// UpdateScoringSettings replaces the scoring thresholds and rate settings (admin only)
+func UpdateScoringSettings(c *gin.Context) {
+	adminID, ok := requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var settings scoring.Settings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	if err := storage.UpdateScoringSettings(settings, int64(adminID)); err != nil {
+		log.Printf("Error updating scoring settings: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "scoring settings updated successfully",
+		"settings": settings,
+	})
+}
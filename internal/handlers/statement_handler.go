@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"finance/internal/iso20022"
+	"finance/internal/models"
+	"finance/internal/statement"
+	"finance/internal/storage"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetDepositStatement returns the statement of a deposit of the authenticated user for a period.
+// from and to are dates in YYYY-MM-DD format (last 30 days by default); format selects
+// json (default), csv, pdf or camt053. Operators and admins may request any deposit.
+func GetDepositStatement(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	depositID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || depositID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid deposit ID"})
+		return
+	}
+	from, to, ok := parseStatementPeriod(c)
+	if !ok {
+		return
+	}
+
+	deposit, err := storage.GetDepositStatement(depositID, from, to)
+	if err != nil {
+		if errors.Is(err, storage.ErrDepositNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found"})
+			return
+		}
+		log.Printf("Error building statement of deposit %d: %v", depositID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build statement"})
+		return
+	}
+	if !ownsDeposit(userID, depositID) && !hasRole(userID, "operator", "admin") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found"})
+		return
+	}
+
+	format := c.DefaultQuery("format", models.StatementJSON)
+	if format == models.StatementJSON {
+		c.JSON(http.StatusOK, gin.H{"statement": deposit})
+		return
+	}
+	writeStatement(c, format, fmt.Sprintf("deposit-%d-statement", depositID), []models.Statement{*deposit})
+}
+
+// ownsDeposit reports whether the deposit belongs to the user
+func ownsDeposit(userID int, depositID int64) bool {
+	deposits, err := storage.GetDepositsByUserID(int64(userID))
+	if err != nil {
+		log.Printf("Error fetching deposits of user %d: %v", userID, err)
+		return false
+	}
+	for _, deposit := range deposits {
+		if deposit.DepositID == depositID {
+			return true
+		}
+	}
+	return false
+}
+
+// writeStatement sends statements as a CSV, PDF or camt.053 attachment named after name
+func writeStatement(c *gin.Context, format, name string, statements []models.Statement) {
+	var body bytes.Buffer
+	var contentType, extension string
+	var err error
+	switch format {
+	case models.StatementCSV:
+		contentType, extension = "text/csv; charset=utf-8", "csv"
+		err = statement.WriteCSV(&body, statements)
+	case models.StatementPDF:
+		contentType, extension = "application/pdf", "pdf"
+		err = statement.WritePDF(&body, statements)
+	case models.StatementCamt053:
+		contentType, extension = "application/xml", "xml"
+		var document []byte
+		now := time.Now()
+		document, err = iso20022.BuildCamt053(fmt.Sprintf("STMT%s", now.Format("20060102150405.000000")), now, statements)
+		body.Write(document)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json, csv, pdf or camt053"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error rendering %s statement: %v", format, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to render statement"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, extension))
+	c.Data(http.StatusOK, contentType, body.Bytes())
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLimitRaiseHours caps how long an operator can raise the limits of a client
+const maxLimitRaiseHours = 24 * 31
+
+// respondLimitError answers operations stopped by a transaction limit.
+// It reports whether a response was written.
+func respondLimitError(c *gin.Context, err error) bool {
+	var exceeded *storage.LimitExceededError
+	if !errors.As(err, &exceeded) {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":     exceeded.Error(),
+		"code":      exceeded.Code,
+		"operation": exceeded.Operation,
+		"limit":     exceeded.Limit,
+		"used":      exceeded.Used,
+		"requested": exceeded.Requested,
+	})
+	return true
+}
+
+// GetMyLimits shows the limits of the authenticated user for every operation with what has
+// been used so far. With deposit_id the product limits of that deposit are included.
+func GetMyLimits(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	var depositID int64
+	if value := c.Query("deposit_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid deposit ID"})
+			return
+		}
+		if !ownsDeposit(userID, id) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "deposit not found"})
+			return
+		}
+		depositID = id
+	}
+
+	limits, err := storage.GetClientLimits(int64(userID), depositID)
+	if err != nil {
+		log.Printf("Error fetching limits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch limits"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"limits": limits})
+}
+
+// GetTransactionLimits lists the configured limits, optionally of one ?level (admin only)
+func GetTransactionLimits(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	limits, err := storage.GetTransactionLimits(c.Query("level"))
+	if err != nil {
+		log.Printf("Error fetching transaction limits: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch transaction limits"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"limits": limits})
+}
+
+// SetTransactionLimit creates or replaces the limit of an operation at the global, product or
+// user level (admin only)
+func SetTransactionLimit(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+
+	var request struct {
+		Level       string   `json:"level" binding:"required"`
+		LevelID     int64    `json:"level_id"`
+		Operation   string   `json:"operation" binding:"required"`
+		SingleMax   *float64 `json:"single_max"`
+		DailyMax    *float64 `json:"daily_max"`
+		MonthlyMax  *float64 `json:"monthly_max"`
+		HourlyCount *int     `json:"hourly_count"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request format"})
+		return
+	}
+
+	limit := &storage.TransactionLimit{
+		Level:       request.Level,
+		LevelID:     request.LevelID,
+		Operation:   request.Operation,
+		SingleMax:   request.SingleMax,
+		DailyMax:    request.DailyMax,
+		MonthlyMax:  request.MonthlyMax,
+		HourlyCount: request.HourlyCount,
+		UpdatedBy:   int64(userID),
+	}
+	if err := storage.SetTransactionLimit(limit); err != nil {
+		log.Printf("Error saving transaction limit: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "transaction limit saved successfully", "limit": limit})
+}
+
+// DeleteTransactionLimit removes a configured limit (admin only)
+func DeleteTransactionLimit(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(userID, "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "admin privileges required"})
+		return
+	}
+	limitID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || limitID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit ID"})
+		return
+	}
+	if err := storage.DeleteTransactionLimit(limitID, int64(userID)); err != nil {
+		if errors.Is(err, storage.ErrTransactionLimitNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error deleting transaction limit: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete transaction limit"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "transaction limit deleted successfully"})
+}
+
+// GrantLimitRaise gives a client temporarily higher limits for an operation (operator only)
+func GrantLimitRaise(c *gin.Context) {
+	operatorID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(operatorID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+
+	var request struct {
+		UserID      int64    `json:"user_id" binding:"required"`
+		Operation   string   `json:"operation" binding:"required"`
+		SingleMax   *float64 `json:"single_max"`
+		DailyMax    *float64 `json:"daily_max"`
+		MonthlyMax  *float64 `json:"monthly_max"`
+		HourlyCount *int     `json:"hourly_count"`
+		Hours       int      `json:"hours" binding:"required"`
+		Reason      string   `json:"reason" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "user_id, operation, hours and reason are required"})
+		return
+	}
+	if request.Hours <= 0 || request.Hours > maxLimitRaiseHours {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hours must be between 1 and " + strconv.Itoa(maxLimitRaiseHours)})
+		return
+	}
+
+	raise := &storage.LimitRaise{
+		UserID:      request.UserID,
+		Operation:   request.Operation,
+		SingleMax:   request.SingleMax,
+		DailyMax:    request.DailyMax,
+		MonthlyMax:  request.MonthlyMax,
+		HourlyCount: request.HourlyCount,
+		Reason:      request.Reason,
+		GrantedBy:   int64(operatorID),
+		ExpiresAt:   time.Now().Add(time.Duration(request.Hours) * time.Hour).Unix(),
+	}
+	if err := storage.GrantLimitRaise(raise); err != nil {
+		log.Printf("Error granting limit raise: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "limit raise granted", "raise": raise})
+}
+
+// GetLimitRaises lists limit raises, optionally of one ?user_id; ?active=true leaves out
+// expired and revoked ones (operator only)
+func GetLimitRaises(c *gin.Context) {
+	operatorID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(operatorID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	var userID int64
+	if value := c.Query("user_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+			return
+		}
+		userID = id
+	}
+	raises, err := storage.GetLimitRaises(userID, c.Query("active") == "true")
+	if err != nil {
+		log.Printf("Error fetching limit raises: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch limit raises"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"raises": raises})
+}
+
+// RevokeLimitRaise ends a limit raise before it expires (operator only)
+func RevokeLimitRaise(c *gin.Context) {
+	operatorID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication required"})
+		return
+	}
+	if !hasRole(operatorID, "operator", "admin") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator privileges required"})
+		return
+	}
+	raiseID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || raiseID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid raise ID"})
+		return
+	}
+	if err := storage.RevokeLimitRaise(raiseID, int64(operatorID)); err != nil {
+		if errors.Is(err, storage.ErrLimitRaiseNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error revoking limit raise: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke limit raise"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "limit raise revoked"})
+}
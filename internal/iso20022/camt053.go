@@ -0,0 +1,242 @@
+// Package iso20022 reads and writes the ISO 20022 messages exchanged with enterprise
+// accounting software. Only the elements the bank fills in or reads are modelled.
+package iso20022
+
+import (
+	"encoding/xml"
+	"finance/internal/models"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Camt053Namespace is the namespace of the bank-to-customer statements produced by BuildCamt053
+const Camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+const (
+	isoDate     = "2006-01-02"
+	isoDateTime = "2006-01-02T15:04:05"
+)
+
+// Credit and debit indicators
+const (
+	credit = "CRDT"
+	debit  = "DBIT"
+)
+
+type camt053Document struct {
+	XMLName   xml.Name                `xml:"Document"`
+	Namespace string                  `xml:"xmlns,attr"`
+	Statement bankToCustomerStatement `xml:"BkToCstmrStmt"`
+}
+
+type bankToCustomerStatement struct {
+	GroupHeader groupHeader   `xml:"GrpHdr"`
+	Statements  []camtAccount `xml:"Stmt"`
+}
+
+type groupHeader struct {
+	MessageID string `xml:"MsgId"`
+	CreatedAt string `xml:"CreDtTm"`
+}
+
+type camtAccount struct {
+	ID          string        `xml:"Id"`
+	SequenceNo  int           `xml:"ElctrncSeqNb"`
+	CreatedAt   string        `xml:"CreDtTm"`
+	Period      camtPeriod    `xml:"FrToDt"`
+	Account     camtAcct      `xml:"Acct"`
+	Balances    []camtBalance `xml:"Bal"`
+	Summary     camtSummary   `xml:"TxsSummry"`
+	Entries     []camtEntry   `xml:"Ntry"`
+	Information string        `xml:"AddtlStmtInf,omitempty"`
+}
+
+type camtPeriod struct {
+	From string `xml:"FrDtTm"`
+	To   string `xml:"ToDtTm"`
+}
+
+type camtAcct struct {
+	ID       string     `xml:"Id>Othr>Id"`
+	Currency string     `xml:"Ccy"`
+	Name     string     `xml:"Nm,omitempty"`
+	Owner    *partyName `xml:"Ownr,omitempty"`
+	Servicer *servicer  `xml:"Svcr,omitempty"`
+}
+
+type partyName struct {
+	Name string `xml:"Nm"`
+}
+
+type servicer struct {
+	Name string `xml:"FinInstnId>Nm"`
+}
+
+type camtAmount struct {
+	Currency string `xml:"Ccy,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type camtBalance struct {
+	Code      string     `xml:"Tp>CdOrPrtry>Cd"`
+	Amount    camtAmount `xml:"Amt"`
+	Indicator string     `xml:"CdtDbtInd"`
+	Date      string     `xml:"Dt>Dt"`
+}
+
+type camtSummary struct {
+	Total   camtTotals `xml:"TtlNtries"`
+	Credits camtTotals `xml:"TtlCdtNtries"`
+	Debits  camtTotals `xml:"TtlDbtNtries"`
+}
+
+type camtTotals struct {
+	Count int    `xml:"NbOfNtries"`
+	Sum   string `xml:"Sum"`
+}
+
+type camtEntry struct {
+	Reference   string        `xml:"NtryRef"`
+	Amount      camtAmount    `xml:"Amt"`
+	Indicator   string        `xml:"CdtDbtInd"`
+	Status      string        `xml:"Sts"`
+	BookingDate string        `xml:"BookgDt>DtTm"`
+	ValueDate   string        `xml:"ValDt>Dt"`
+	ServicerRef string        `xml:"AcctSvcrRef"`
+	TxCode      string        `xml:"BkTxCd>Prtry>Cd"`
+	Details     camtEntryDtls `xml:"NtryDtls>TxDtls"`
+	Information string        `xml:"AddtlNtryInf,omitempty"`
+}
+
+type camtEntryDtls struct {
+	EndToEndID string          `xml:"Refs>EndToEndId"`
+	Parties    *relatedParties `xml:"RltdPties,omitempty"`
+	Remittance string          `xml:"RmtInf>Ustrd,omitempty"`
+}
+
+type relatedParties struct {
+	Debtor   *partyName `xml:"Dbtr,omitempty"`
+	Creditor *partyName `xml:"Cdtr,omitempty"`
+}
+
+// BuildCamt053 renders statements as a camt.053.001.02 bank-to-customer statement message with
+// one Stmt element per account. messageID must be unique per message sent to the customer.
+func BuildCamt053(messageID string, createdAt time.Time, statements []models.Statement) ([]byte, error) {
+	document := camt053Document{
+		Namespace: Camt053Namespace,
+		Statement: bankToCustomerStatement{
+			GroupHeader: groupHeader{MessageID: messageID, CreatedAt: createdAt.Format(isoDateTime)},
+		},
+	}
+
+	for i, statement := range statements {
+		account := camtAccount{
+			ID:         fmt.Sprintf("%s-%d", messageID, i+1),
+			SequenceNo: i + 1,
+			CreatedAt:  createdAt.Format(isoDateTime),
+			Period: camtPeriod{
+				From: statement.From.Format(isoDateTime),
+				To:   statement.To.Format(isoDateTime),
+			},
+			Account: camtAcct{
+				ID:       statement.AccountID,
+				Currency: statement.Currency,
+				Name:     statement.AccountName,
+			},
+			Balances: []camtBalance{
+				balance("OPBD", statement.OpeningBalance, statement.Currency, statement.From),
+				balance("CLBD", statement.ClosingBalance, statement.Currency, statement.To),
+			},
+		}
+		if statement.Owner != "" {
+			account.Account.Owner = &partyName{Name: statement.Owner}
+		}
+		if statement.Servicer != "" {
+			account.Account.Servicer = &servicer{Name: statement.Servicer}
+		}
+		if statement.TotalInterest != 0 || statement.TotalFees != 0 {
+			account.Information = fmt.Sprintf("Interest %s, fees %s",
+				formatAmount(statement.TotalInterest), formatAmount(statement.TotalFees))
+		}
+
+		var credits, debits int
+		for _, entry := range statement.Entries {
+			indicator := credit
+			if entry.Amount < 0 {
+				indicator = debit
+				debits++
+			} else {
+				credits++
+			}
+			reference := strconv.FormatInt(entry.ID, 10)
+			ntry := camtEntry{
+				Reference:   reference,
+				Amount:      camtAmount{Currency: statement.Currency, Value: formatAmount(math.Abs(entry.Amount))},
+				Indicator:   indicator,
+				Status:      "BOOK",
+				BookingDate: entry.BookedAt.Format(isoDateTime),
+				ValueDate:   entry.BookedAt.Format(isoDate),
+				ServicerRef: reference,
+				TxCode:      entry.Type,
+				Details: camtEntryDtls{
+					EndToEndID: endToEndID(entry.Reference),
+					Remittance: entry.Description,
+				},
+				Information: entry.Description,
+			}
+			if entry.Counterparty != "" {
+				// The counterparty paid a credit and received a debit
+				party := &partyName{Name: entry.Counterparty}
+				if indicator == credit {
+					ntry.Details.Parties = &relatedParties{Debtor: party}
+				} else {
+					ntry.Details.Parties = &relatedParties{Creditor: party}
+				}
+			}
+			account.Entries = append(account.Entries, ntry)
+		}
+		account.Summary = camtSummary{
+			Total:   camtTotals{Count: credits + debits, Sum: formatAmount(statement.TotalCredits + statement.TotalDebits)},
+			Credits: camtTotals{Count: credits, Sum: formatAmount(statement.TotalCredits)},
+			Debits:  camtTotals{Count: debits, Sum: formatAmount(statement.TotalDebits)},
+		}
+		document.Statement.Statements = append(document.Statement.Statements, account)
+	}
+
+	out, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// balance is a camt balance; negative balances are reported as debit balances
+func balance(code string, amount float64, currency string, at time.Time) camtBalance {
+	indicator := credit
+	if amount < 0 {
+		indicator = debit
+	}
+	return camtBalance{
+		Code:      code,
+		Amount:    camtAmount{Currency: currency, Value: formatAmount(math.Abs(amount))},
+		Indicator: indicator,
+		Date:      at.Format(isoDate),
+	}
+}
+
+// endToEndID returns the reference of an entry, or NOTPROVIDED as the standard requires
+func endToEndID(reference string) string {
+	if reference == "" {
+		return "NOTPROVIDED"
+	}
+	if len(reference) > 35 {
+		return reference[:35]
+	}
+	return reference
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
@@ -0,0 +1,213 @@
+package iso20022
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Namespaces of the customer credit transfer initiations accepted by ParsePain001
+const (
+	Pain001V03Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+	Pain001V09Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.09"
+)
+
+var (
+	// ErrUnsupportedMessage is returned for XML that is not a pain.001.001.03 or .09 document
+	ErrUnsupportedMessage = errors.New("unsupported message, expected pain.001.001.03 or pain.001.001.09")
+	// ErrInvalidMessage is returned for documents missing mandatory elements or holding invalid values
+	ErrInvalidMessage = errors.New("invalid pain.001 message")
+)
+
+// PaymentInitiation is a customer credit transfer initiation (pain.001) sent by an enterprise
+type PaymentInitiation struct {
+	Version         string // Message name, e.g. pain.001.001.03
+	MessageID       string
+	CreatedAt       string
+	NumberOfTxs     int
+	ControlSum      *float64 // Nil when the group header has no control sum
+	InitiatingParty string
+	PaymentInfos    []PaymentInformation
+}
+
+// PaymentInformation is a block of credit transfers debited from the same account
+type PaymentInformation struct {
+	ID            string
+	ExecutionDate string
+	DebtorName    string
+	DebtorAccount string
+	Transfers     []CreditTransfer
+}
+
+// CreditTransfer is a single credit transfer instruction
+type CreditTransfer struct {
+	InstructionID   string
+	EndToEndID      string
+	Amount          float64
+	Currency        string
+	CreditorName    string
+	CreditorAccount string
+	Remittance      string
+}
+
+// Transactions returns the number of credit transfers in the message
+func (p *PaymentInitiation) Transactions() int {
+	var count int
+	for _, info := range p.PaymentInfos {
+		count += len(info.Transfers)
+	}
+	return count
+}
+
+// Sum returns the total amount of the credit transfers in the message
+func (p *PaymentInitiation) Sum() float64 {
+	var sum float64
+	for _, info := range p.PaymentInfos {
+		for _, transfer := range info.Transfers {
+			sum += transfer.Amount
+		}
+	}
+	return math.Round(sum*100) / 100
+}
+
+type pain001Document struct {
+	XMLName    xml.Name `xml:"Document"`
+	Initiation struct {
+		GroupHeader struct {
+			MessageID       string `xml:"MsgId"`
+			CreatedAt       string `xml:"CreDtTm"`
+			NumberOfTxs     string `xml:"NbOfTxs"`
+			ControlSum      string `xml:"CtrlSum"`
+			InitiatingParty string `xml:"InitgPty>Nm"`
+		} `xml:"GrpHdr"`
+		PaymentInfos []pain001PaymentInfo `xml:"PmtInf"`
+	} `xml:"CstmrCdtTrfInitn"`
+}
+
+type pain001PaymentInfo struct {
+	ID            string `xml:"PmtInfId"`
+	Method        string `xml:"PmtMtd"`
+	ExecutionDate struct {
+		Value string `xml:",chardata"` // pain.001.001.03
+		Date  string `xml:"Dt"`        // pain.001.001.09
+		Time  string `xml:"DtTm"`
+	} `xml:"ReqdExctnDt"`
+	DebtorName    string            `xml:"Dbtr>Nm"`
+	DebtorAccount painAccount       `xml:"DbtrAcct"`
+	Transfers     []pain001Transfer `xml:"CdtTrfTxInf"`
+}
+
+type pain001Transfer struct {
+	InstructionID   string      `xml:"PmtId>InstrId"`
+	EndToEndID      string      `xml:"PmtId>EndToEndId"`
+	Amount          camtAmount  `xml:"Amt>InstdAmt"`
+	CreditorName    string      `xml:"Cdtr>Nm"`
+	CreditorAccount painAccount `xml:"CdtrAcct"`
+	Remittance      []string    `xml:"RmtInf>Ustrd"`
+}
+
+type painAccount struct {
+	IBAN  string `xml:"Id>IBAN"`
+	Other string `xml:"Id>Othr>Id"`
+}
+
+func (a painAccount) number() string {
+	if a.IBAN != "" {
+		return strings.TrimSpace(a.IBAN)
+	}
+	return strings.TrimSpace(a.Other)
+}
+
+// ParsePain001 reads a pain.001.001.03 or pain.001.001.09 document. Only the structure is
+// checked here; whether the declared totals match and the accounts exist is left to the caller.
+func ParsePain001(data []byte) (*PaymentInitiation, error) {
+	var document pain001Document
+	if err := xml.NewDecoder(bytes.NewReader(data)).Decode(&document); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMessage, err)
+	}
+
+	initiation := &PaymentInitiation{}
+	switch document.XMLName.Space {
+	case Pain001V03Namespace:
+		initiation.Version = "pain.001.001.03"
+	case Pain001V09Namespace:
+		initiation.Version = "pain.001.001.09"
+	default:
+		return nil, ErrUnsupportedMessage
+	}
+
+	header := document.Initiation.GroupHeader
+	initiation.MessageID = strings.TrimSpace(header.MessageID)
+	initiation.CreatedAt = strings.TrimSpace(header.CreatedAt)
+	initiation.InitiatingParty = strings.TrimSpace(header.InitiatingParty)
+	if initiation.MessageID == "" {
+		return nil, fmt.Errorf("%w: group header has no MsgId", ErrInvalidMessage)
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(header.NumberOfTxs))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("%w: invalid NbOfTxs %q", ErrInvalidMessage, header.NumberOfTxs)
+	}
+	initiation.NumberOfTxs = count
+	if header.ControlSum != "" {
+		sum, err := strconv.ParseFloat(strings.TrimSpace(header.ControlSum), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CtrlSum %q", ErrInvalidMessage, header.ControlSum)
+		}
+		initiation.ControlSum = &sum
+	}
+	if len(document.Initiation.PaymentInfos) == 0 {
+		return nil, fmt.Errorf("%w: message has no PmtInf", ErrInvalidMessage)
+	}
+
+	for _, block := range document.Initiation.PaymentInfos {
+		if block.Method != "" && block.Method != "TRF" {
+			return nil, fmt.Errorf("%w: payment information %s uses method %s, only TRF is supported",
+				ErrInvalidMessage, block.ID, block.Method)
+		}
+		info := PaymentInformation{
+			ID:            strings.TrimSpace(block.ID),
+			ExecutionDate: strings.TrimSpace(firstNonEmpty(block.ExecutionDate.Date, block.ExecutionDate.Time, block.ExecutionDate.Value)),
+			DebtorName:    strings.TrimSpace(block.DebtorName),
+			DebtorAccount: block.DebtorAccount.number(),
+		}
+		if info.ID == "" {
+			return nil, fmt.Errorf("%w: payment information without PmtInfId", ErrInvalidMessage)
+		}
+		if len(block.Transfers) == 0 {
+			return nil, fmt.Errorf("%w: payment information %s has no CdtTrfTxInf", ErrInvalidMessage, info.ID)
+		}
+		for _, tx := range block.Transfers {
+			transfer := CreditTransfer{
+				InstructionID:   strings.TrimSpace(tx.InstructionID),
+				EndToEndID:      strings.TrimSpace(tx.EndToEndID),
+				Currency:        strings.TrimSpace(tx.Amount.Currency),
+				CreditorName:    strings.TrimSpace(tx.CreditorName),
+				CreditorAccount: tx.CreditorAccount.number(),
+				Remittance:      strings.TrimSpace(strings.Join(tx.Remittance, " ")),
+			}
+			if transfer.EndToEndID == "" {
+				return nil, fmt.Errorf("%w: credit transfer without EndToEndId in %s", ErrInvalidMessage, info.ID)
+			}
+			transfer.Amount, err = strconv.ParseFloat(strings.TrimSpace(tx.Amount.Value), 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: credit transfer %s has no valid InstdAmt", ErrInvalidMessage, transfer.EndToEndID)
+			}
+			info.Transfers = append(info.Transfers, transfer)
+		}
+		initiation.PaymentInfos = append(initiation.PaymentInfos, info)
+	}
+	return initiation, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+	return ""
+}
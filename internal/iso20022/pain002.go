@@ -0,0 +1,202 @@
+package iso20022
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+// Namespaces of the payment status reports built by BuildPain002. Reports on pain.001.001.09
+// messages use the pain.002 version of the same release.
+const (
+	Pain002V03Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.002.001.03"
+	Pain002V10Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.002.001.10"
+)
+
+// Payment status codes
+const (
+	StatusAccepted = "ACCP" // Accepted customer profile, the transfer awaits execution
+	StatusRejected = "RJCT"
+	StatusPartial  = "PART" // Some transfers of the group were rejected
+)
+
+// Status reason codes (ExternalStatusReason1Code) reported for rejected transfers
+const (
+	ReasonIncorrectAccount = "AC01" // Creditor account is not held at this bank
+	ReasonDebtorAccount    = "AC02" // Debtor account is not an account of the enterprise
+	ReasonCreditorAccount  = "AC03" // Creditor account cannot be credited by this debtor
+	ReasonClosedAccount    = "AC04" // Creditor is terminated or has no usable account
+	ReasonZeroAmount       = "AM01"
+	ReasonCurrency         = "AM03" // Currency differs from the debtor account currency
+	ReasonDuplication      = "AM05"
+	ReasonControlSum       = "AM10"
+	ReasonNumberOfTxs      = "AM18"
+	ReasonDuplicateMessage = "DU01"
+	ReasonNarrative        = "NARR"
+)
+
+// StatusReport is the outcome of a pain.001 message to be reported back to the enterprise
+type StatusReport struct {
+	MessageID           string
+	CreatedAt           time.Time
+	OriginalMessageID   string
+	OriginalVersion     string // Message name of the pain.001, e.g. pain.001.001.09
+	OriginalNumberOfTxs int
+	OriginalControlSum  *float64
+	GroupReasonCode     string // Set when the message was rejected as a whole
+	GroupReason         string
+	Transactions        []TransactionStatus
+}
+
+// TransactionStatus is the outcome of one credit transfer of a pain.001 message
+type TransactionStatus struct {
+	PaymentInfoID string
+	InstructionID string
+	EndToEndID    string
+	Status        string // StatusAccepted or StatusRejected
+	ReasonCode    string
+	Reason        string
+}
+
+type pain002Document struct {
+	XMLName   xml.Name      `xml:"Document"`
+	Namespace string        `xml:"xmlns,attr"`
+	Report    pain002Report `xml:"CstmrPmtStsRpt"`
+}
+
+type pain002Report struct {
+	GroupHeader   groupHeader            `xml:"GrpHdr"`
+	OriginalGroup pain002OriginalGroup   `xml:"OrgnlGrpInfAndSts"`
+	Payments      []pain002OriginalBlock `xml:"OrgnlPmtInfAndSts,omitempty"`
+}
+
+type pain002OriginalGroup struct {
+	MessageID   string               `xml:"OrgnlMsgId"`
+	MessageName string               `xml:"OrgnlMsgNmId"`
+	NumberOfTxs int                  `xml:"OrgnlNbOfTxs"`
+	ControlSum  string               `xml:"OrgnlCtrlSum,omitempty"`
+	Status      string               `xml:"GrpSts"`
+	Reason      *pain002StatusReason `xml:"StsRsnInf,omitempty"`
+}
+
+type pain002OriginalBlock struct {
+	PaymentInfoID string               `xml:"OrgnlPmtInfId"`
+	Status        string               `xml:"PmtInfSts"`
+	Transactions  []pain002Transaction `xml:"TxInfAndSts"`
+}
+
+type pain002Transaction struct {
+	StatusID      string               `xml:"StsId"`
+	InstructionID string               `xml:"OrgnlInstrId,omitempty"`
+	EndToEndID    string               `xml:"OrgnlEndToEndId"`
+	Status        string               `xml:"TxSts"`
+	Reason        *pain002StatusReason `xml:"StsRsnInf,omitempty"`
+}
+
+type pain002StatusReason struct {
+	Code        string `xml:"Rsn>Cd"`
+	Information string `xml:"AddtlInf,omitempty"`
+}
+
+// BuildPain002 renders a status report as a pain.002 customer payment status report. The group
+// and payment information statuses are derived from the statuses of the transfers they hold.
+func BuildPain002(report StatusReport) ([]byte, error) {
+	namespace := Pain002V03Namespace
+	if report.OriginalVersion == "pain.001.001.09" {
+		namespace = Pain002V10Namespace
+	}
+	document := pain002Document{
+		Namespace: namespace,
+		Report: pain002Report{
+			GroupHeader: groupHeader{MessageID: report.MessageID, CreatedAt: report.CreatedAt.Format(isoDateTime)},
+			OriginalGroup: pain002OriginalGroup{
+				MessageID:   report.OriginalMessageID,
+				MessageName: report.OriginalVersion,
+				NumberOfTxs: report.OriginalNumberOfTxs,
+			},
+		},
+	}
+	if report.OriginalControlSum != nil {
+		document.Report.OriginalGroup.ControlSum = formatAmount(*report.OriginalControlSum)
+	}
+
+	// Transfers are reported grouped by their payment information block in message order
+	blocks := map[string]int{}
+	var accepted, rejected int
+	for i, tx := range report.Transactions {
+		index, ok := blocks[tx.PaymentInfoID]
+		if !ok {
+			index = len(document.Report.Payments)
+			blocks[tx.PaymentInfoID] = index
+			document.Report.Payments = append(document.Report.Payments, pain002OriginalBlock{PaymentInfoID: tx.PaymentInfoID})
+		}
+		entry := pain002Transaction{
+			StatusID:      statusID(report.MessageID, i+1),
+			InstructionID: tx.InstructionID,
+			EndToEndID:    tx.EndToEndID,
+			Status:        tx.Status,
+			Reason:        statusReason(tx.ReasonCode, tx.Reason),
+		}
+		if tx.Status == StatusAccepted {
+			accepted++
+		} else {
+			rejected++
+		}
+		document.Report.Payments[index].Transactions = append(document.Report.Payments[index].Transactions, entry)
+	}
+	for i := range document.Report.Payments {
+		var blockAccepted, blockRejected int
+		for _, tx := range document.Report.Payments[i].Transactions {
+			if tx.Status == StatusAccepted {
+				blockAccepted++
+			} else {
+				blockRejected++
+			}
+		}
+		document.Report.Payments[i].Status = combinedStatus(blockAccepted, blockRejected)
+	}
+
+	if report.GroupReasonCode != "" {
+		document.Report.OriginalGroup.Status = StatusRejected
+		document.Report.OriginalGroup.Reason = statusReason(report.GroupReasonCode, report.GroupReason)
+	} else {
+		document.Report.OriginalGroup.Status = combinedStatus(accepted, rejected)
+	}
+
+	out, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// combinedStatus is the status of a group of transfers with the given outcomes
+func combinedStatus(accepted, rejected int) string {
+	switch {
+	case rejected == 0:
+		return StatusAccepted
+	case accepted == 0:
+		return StatusRejected
+	default:
+		return StatusPartial
+	}
+}
+
+func statusReason(code, information string) *pain002StatusReason {
+	if code == "" {
+		return nil
+	}
+	if len(information) > 105 {
+		information = information[:105]
+	}
+	return &pain002StatusReason{Code: code, Information: information}
+}
+
+// statusID identifies the status of a transfer within the report, at most 35 characters long
+func statusID(messageID string, n int) string {
+	suffix := "-" + strconv.Itoa(n)
+	if len(messageID)+len(suffix) > 35 {
+		messageID = messageID[:35-len(suffix)]
+	}
+	return messageID + suffix
+}
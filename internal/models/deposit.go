@@ -2,6 +2,27 @@ package models
 
 import "time"
 
+// DepositType is the kind of a deposit product
+type DepositType string
+
+const (
+	DemandDeposit DepositType = "demand" // Funds available at any time
+	TermDeposit   DepositType = "term"   // Funds placed until a maturity date
+)
+
+// Maturity instructions of term deposits
+const (
+	MaturityPayout   = "payout"   // Pay principal and interest out at maturity
+	MaturityRollover = "rollover" // Renew for the same term at the current rate
+)
+
+// Deposit statuses
+const (
+	DepositActive  = "active"
+	DepositMatured = "matured" // A term deposit paid out at maturity
+	DepositClosed  = "closed"  // Closed and paid out; kept for history and statements
+)
+
 // Deposit represents a bank deposit
 type Deposit struct {
 	DepositID      int64     `json:"deposit_id"`
@@ -13,6 +34,131 @@ type Deposit struct {
 	IsFrozen       bool      `json:"is_frozen"`
 	FreezeDuration int       `json:"freeze_duration"`
 	FreezeUntil    time.Time `json:"freeze_until,omitempty"`
+	// Deposits opened before the product catalog have no product and no rules
+	ProductID           *int64     `json:"product_id,omitempty"`
+	Status              string     `json:"status"`
+	TermMonths          int        `json:"term_months,omitempty"`
+	MaturityDate        *time.Time `json:"maturity_date,omitempty"`
+	MaturityInstruction string     `json:"maturity_instruction,omitempty"`
+	PayoutDepositID     *int64     `json:"payout_deposit_id,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	ClosedAt            *time.Time `json:"closed_at,omitempty"`
+}
+
+// DepositRequest opens a deposit under a product
+type DepositRequest struct {
+	ClientID            int64   `json:"client_id"`
+	BankName            string  `json:"bank_name"`
+	ProductCode         string  `json:"product_code"`
+	Amount              float64 `json:"amount"`
+	TermMonths          int     `json:"term_months,omitempty"`
+	MaturityInstruction string  `json:"maturity_instruction,omitempty"` // Defaults to payout
+	PayoutDepositID     *int64  `json:"payout_deposit_id,omitempty"`    // Receives the payout at maturity
+}
+
+// DepositClosure closes a deposit. The balance goes to PayoutDepositID, or is handed out in
+// cash when an operator closes the deposit at a branch.
+type DepositClosure struct {
+	DepositID       int64  `json:"deposit_id"`
+	ClientID        int64  `json:"-"`
+	PayoutDepositID *int64 `json:"payout_deposit_id,omitempty"`
+	CashOut         bool   `json:"cash_out,omitempty"`
+	TellerID        *int64 `json:"-"`
+	Branch          string `json:"branch,omitempty"`
+}
+
+// DepositClosureResult is the settlement of a closed deposit
+type DepositClosureResult struct {
+	DepositID       int64     `json:"deposit_id"`
+	Balance         float64   `json:"balance"`
+	AccruedInterest float64   `json:"accrued_interest"`
+	Penalty         float64   `json:"penalty"`
+	Payout          float64   `json:"payout"`
+	PayoutDepositID *int64    `json:"payout_deposit_id,omitempty"`
+	CashOut         bool      `json:"cash_out"`
+	ClosedAt        time.Time `json:"closed_at"`
+}
+
+// DepositProduct is a version of a deposit product. Deposits keep the version they were
+// opened under, so changing a product does not alter the rules of existing deposits.
+type DepositProduct struct {
+	ID           int64       `json:"id"`
+	Code         string      `json:"code"`
+	Version      int         `json:"version"`
+	Name         string      `json:"name"`
+	Type         DepositType `json:"type"`
+	AllowedTerms []int       `json:"allowed_terms,omitempty"` // Term products only; empty allows every term with a rate tier
+	MinBalance   float64     `json:"min_balance"`
+	AllowTopUp   bool        `json:"allow_top_up"`
+	// AllowWithdrawal permits partial withdrawals before maturity
+	AllowWithdrawal bool `json:"allow_withdrawal"`
+	// EarlyClosurePenalty is the percentage of the balance charged when a term deposit is closed before maturity
+	EarlyClosurePenalty float64           `json:"early_closure_penalty"`
+	AutoRollover        bool              `json:"auto_rollover"` // Whether clients can ask for a rollover at maturity
+	EffectiveFrom       time.Time         `json:"effective_from"`
+	Retired             bool              `json:"retired"`
+	CreatedBy           int64             `json:"created_by,omitempty"`
+	CreatedAt           time.Time         `json:"created_at"`
+	Rates               []DepositRateTier `json:"rates"`
+}
+
+// DepositRateTier is the annual rate of a deposit product for a range of terms and balances.
+// Demand products use tiers with a term range of 0 to 0. A MaxAmount of 0 means no upper limit.
+type DepositRateTier struct {
+	MinTerm   int     `json:"min_term"`
+	MaxTerm   int     `json:"max_term"`
+	MinAmount float64 `json:"min_amount"`
+	MaxAmount float64 `json:"max_amount,omitempty"`
+	Rate      float64 `json:"rate"`
+}
+
+// Matches reports whether the tier covers the term and amount
+func (t DepositRateTier) Matches(termMonths int, amount float64) bool {
+	return termMonths >= t.MinTerm && termMonths <= t.MaxTerm &&
+		amount >= t.MinAmount && (t.MaxAmount == 0 || amount <= t.MaxAmount)
+}
+
+// RateFor returns the rate of the most specific tier covering the term and amount:
+// the narrowest term range, then the highest amount threshold
+func (p *DepositProduct) RateFor(termMonths int, amount float64) (float64, bool) {
+	var best *DepositRateTier
+	for i := range p.Rates {
+		tier := &p.Rates[i]
+		if !tier.Matches(termMonths, amount) {
+			continue
+		}
+		if best == nil {
+			best = tier
+			continue
+		}
+		width, bestWidth := tier.MaxTerm-tier.MinTerm, best.MaxTerm-best.MinTerm
+		if width < bestWidth || (width == bestWidth && tier.MinAmount > best.MinAmount) {
+			best = tier
+		}
+	}
+	if best == nil {
+		return 0, false
+	}
+	return best.Rate, true
+}
+
+// AllowsTerm reports whether the product can be opened for the term
+func (p *DepositProduct) AllowsTerm(termMonths int) bool {
+	if p.Type == DemandDeposit {
+		return termMonths == 0
+	}
+	if termMonths <= 0 {
+		return false
+	}
+	if len(p.AllowedTerms) == 0 {
+		return true
+	}
+	for _, term := range p.AllowedTerms {
+		if term == termMonths {
+			return true
+		}
+	}
+	return false
 }
 
 // Transfer represents a transfer between accounts
@@ -24,3 +170,84 @@ type Transfer struct {
 	Amount        float64 `json:"amount"`
 	DepositID     int64   `json:"deposit_id"`
 }
+
+// InterbankTransfer is a transfer from a deposit to an account held at another bank
+type InterbankTransfer struct {
+	ClientID        int64   `json:"-"`
+	FromDepositID   int64   `json:"from_deposit_id"`
+	BeneficiaryName string  `json:"beneficiary_name"`
+	AccountNumber   string  `json:"account_number"`
+	BankName        string  `json:"bank_name"`
+	Amount          float64 `json:"amount"`
+	Purpose         string  `json:"purpose"`
+}
+
+// Deposit movement types. Amounts of movements are positive; the type tells whether
+// the movement is a credit or a debit of the deposit.
+const (
+	MovementOpening          = "opening"
+	MovementTopUp            = "top_up"
+	MovementWithdrawal       = "withdrawal"
+	MovementTransferIn       = "transfer_in"
+	MovementTransferOut      = "transfer_out"
+	MovementSalary           = "salary"
+	MovementInterest         = "interest"
+	MovementFee              = "fee" // Penalties and charges
+	MovementCancelTopUp      = "cancel_top_up"
+	MovementCancelWithdrawal = "cancel_withdrawal"
+	MovementPaymentReturn    = "payment_return" // An interbank transfer sent back by the beneficiary bank
+)
+
+// creditMovements lists the movement types that add money to a deposit
+var creditMovements = []string{
+	MovementOpening, MovementTopUp, MovementTransferIn, MovementSalary, MovementInterest, MovementCancelWithdrawal,
+	MovementPaymentReturn,
+}
+
+// CreditMovementTypes returns the movement types that add money to a deposit
+func CreditMovementTypes() []string {
+	return append([]string(nil), creditMovements...)
+}
+
+// IsCreditMovement reports whether a movement of this type adds money to a deposit
+func IsCreditMovement(movementType string) bool {
+	for _, credit := range creditMovements {
+		if credit == movementType {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels through which money enters or leaves a deposit
+const (
+	ChannelOnline   = "online"
+	ChannelCashDesk = "cash_desk" // Executed by a teller at a branch
+	ChannelSystem   = "system"    // Interest, maturity, salaries and settlements
+)
+
+// DepositMovement is an entry of the ledger of a deposit. Cash-desk movements record the teller
+// and the branch; TransactionID links the movement to its entry in the transaction history.
+type DepositMovement struct {
+	ID            int64      `json:"id"`
+	DepositID     int64      `json:"deposit_id"`
+	ClientID      int64      `json:"client_id"`
+	Type          string     `json:"type"`
+	Amount        float64    `json:"amount"`
+	Channel       string     `json:"channel"`
+	Counterparty  string     `json:"counterparty,omitempty"`
+	Reference     string     `json:"reference,omitempty"`
+	TellerID      *int64     `json:"teller_id,omitempty"`
+	Branch        string     `json:"branch,omitempty"`
+	TransactionID int64      `json:"transaction_id,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+}
+
+// Signed returns the amount of the movement as a change of the deposit balance
+func (m DepositMovement) Signed() float64 {
+	if IsCreditMovement(m.Type) {
+		return m.Amount
+	}
+	return -m.Amount
+}
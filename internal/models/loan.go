@@ -22,7 +22,6 @@ const (
 	Custom       LoanTerm = 0 // For custom duration
 )
 
-
 type LoanStatus string
 
 const (
@@ -34,29 +33,115 @@ const (
 	Default   LoanStatus = "default"
 )
 
+// loanTransitions lists the statuses a loan can move to from each status.
+// Rejected and completed loans are final.
+var loanTransitions = map[LoanStatus][]LoanStatus{
+	Pending:  {Approved, Rejected},
+	Approved: {Active, Rejected},
+	Active:   {Completed, Default},
+	Default:  {Active, Completed},
+}
+
+// NextStatuses returns the statuses a loan in status s can move to
+func (s LoanStatus) NextStatuses() []LoanStatus {
+	return loanTransitions[s]
+}
+
+// CanTransitionTo reports whether a loan in status s can move to next
+func (s LoanStatus) CanTransitionTo(next LoanStatus) bool {
+	for _, allowed := range loanTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
 // Loan represents a loan or installment plan
 type Loan struct {
-	ID             int64      `json:"id"`
-	UserID         int64      `json:"user_id"`
-	Username       string     `json:"username,omitempty"`
-	Type           LoanType   `json:"type"`
-	Amount         float64    `json:"amount"`
-	Term           int        `json:"term_months"` 
-	InterestRate   float64    `json:"interest_rate"`
-	TotalPayable   float64    `json:"total_payable"`
-	MonthlyPayment float64    `json:"monthly_payment"`
-	Status         LoanStatus `json:"status"`
-	StartDate      *time.Time `json:"start_date,omitempty"`
-	EndDate        *time.Time `json:"end_date,omitempty"`
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
-	ApprovedBy     *int64     `json:"approved_by,omitempty"`
-	RejectedBy     *int64     `json:"rejected_by,omitempty"`
-	ApprovedAt     *time.Time `json:"approved_at,omitempty"`
-	RejectedAt     *time.Time `json:"rejected_at,omitempty"`
+	ID              int64      `json:"id"`
+	UserID          int64      `json:"user_id"`
+	Username        string     `json:"username,omitempty"`
+	Type            LoanType   `json:"type"`
+	Amount          float64    `json:"amount"`
+	Term            int        `json:"term_months"`
+	InterestRate    float64    `json:"interest_rate"`
+	TotalPayable    float64    `json:"total_payable"`
+	MonthlyPayment  float64    `json:"monthly_payment"`
+	Status          LoanStatus `json:"status"`
+	StartDate       *time.Time `json:"start_date,omitempty"`
+	EndDate         *time.Time `json:"end_date,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ApprovedBy      *int64     `json:"approved_by,omitempty"`
+	RejectedBy      *int64     `json:"rejected_by,omitempty"`
+	ApprovedAt      *time.Time `json:"approved_at,omitempty"`
+	RejectedAt      *time.Time `json:"rejected_at,omitempty"`
+	RejectionReason string     `json:"rejection_reason,omitempty"`
+	ProductID       *int64     `json:"product_id,omitempty"`
+	FeeAmount       float64    `json:"fee_amount"`
+	RateOverrideBy  *int64     `json:"rate_override_by,omitempty"`
+}
+
+// LoanStatusChange is an entry of the status history of a loan
+type LoanStatusChange struct {
+	ID         int64      `json:"id"`
+	LoanID     int64      `json:"loan_id"`
+	FromStatus LoanStatus `json:"from_status,omitempty"`
+	ToStatus   LoanStatus `json:"to_status"`
+	ActorID    *int64     `json:"actor_id,omitempty"`
+	Reason     string     `json:"reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Collateral types
+const (
+	CollateralDeposit    = "deposit"
+	CollateralRealEstate = "real_estate"
+	CollateralVehicle    = "vehicle"
+	CollateralOther      = "other"
+)
+
+// Lien statuses of collateral. A pledged deposit is blocked while its lien is active.
+const (
+	LienPending  = "pending"
+	LienActive   = "active"
+	LienReleased = "released"
+)
 
+// LoanCollateral is an asset securing a loan
+type LoanCollateral struct {
+	ID          int64      `json:"id"`
+	LoanID      int64      `json:"loan_id"`
+	Type        string     `json:"type"`
+	Description string     `json:"description,omitempty"`
+	Valuation   float64    `json:"valuation"`
+	LienStatus  string     `json:"lien_status"`
+	DepositID   *int64     `json:"deposit_id,omitempty"` // The pledged deposit of deposit collateral
+	CreatedBy   int64      `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ReleasedAt  *time.Time `json:"released_at,omitempty"`
 }
 
+// Guarantee statuses
+const (
+	GuaranteePending  = "pending"
+	GuaranteeAccepted = "accepted"
+	GuaranteeDeclined = "declined"
+)
+
+// LoanGuarantor is a user who guarantees a loan. A guarantor accepts or declines
+// through their own login.
+type LoanGuarantor struct {
+	ID          int64      `json:"id"`
+	LoanID      int64      `json:"loan_id"`
+	UserID      int64      `json:"user_id"`
+	Username    string     `json:"username,omitempty"`
+	Status      string     `json:"status"`
+	AddedBy     int64      `json:"added_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RespondedAt *time.Time `json:"responded_at,omitempty"`
+}
 
 type Payment struct {
 	ID        int64     `json:"id"`
@@ -66,13 +151,97 @@ type Payment struct {
 	CreatedAt time.Time `json:"created_at"`
 }
 
-//sample of request
+// sample of request
 type LoanRequest struct {
-	UserID       int64    `json:"user_id"`
-	Type         LoanType `json:"type"`
-	Amount       float64  `json:"amount"`
-	TermMonths   int      `json:"term_months"`
-	InterestRate *float64 `json:"interest_rate,omitempty"` // Optional custom rate
+	UserID      int64    `json:"user_id"`
+	Type        LoanType `json:"type"`
+	Amount      float64  `json:"amount"`
+	TermMonths  int      `json:"term_months"`
+	ProductCode string   `json:"product_code,omitempty"` // Defaults to the current product of the loan type
+	// InterestRate overrides the product rate. It is only honoured together with RateOverrideBy,
+	// the manager who set it; rates sent by clients are ignored.
+	InterestRate   *float64 `json:"interest_rate,omitempty"`
+	RateOverrideBy int64    `json:"-"`
+	// Promotional loans are merchant plans at 0% without fees; the merchant discount pays for them
+	Promotional bool `json:"-"`
+}
+
+// LoanProduct is a version of a loan product. Changing a product creates a new version,
+// so loans keep the terms they were issued under.
+type LoanProduct struct {
+	ID            int64          `json:"id"`
+	Code          string         `json:"code"`
+	Version       int            `json:"version"`
+	Name          string         `json:"name"`
+	Type          LoanType       `json:"type"`
+	AllowedTerms  []int          `json:"allowed_terms,omitempty"` // Empty allows every term with a rate tier
+	MinAmount     float64        `json:"min_amount"`
+	MaxAmount     float64        `json:"max_amount,omitempty"` // 0 means no upper limit
+	FeePercent    float64        `json:"fee_percent"`
+	FeeFixed      float64        `json:"fee_fixed"`
+	EffectiveFrom time.Time      `json:"effective_from"`
+	Retired       bool           `json:"retired"`
+	CreatedBy     int64          `json:"created_by,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	Rates         []LoanRateTier `json:"rates"`
+}
+
+// LoanRateTier is the annual rate of a product for a range of terms and amounts.
+// A MaxAmount of 0 means no upper limit.
+type LoanRateTier struct {
+	MinTerm   int     `json:"min_term"`
+	MaxTerm   int     `json:"max_term"`
+	MinAmount float64 `json:"min_amount"`
+	MaxAmount float64 `json:"max_amount,omitempty"`
+	Rate      float64 `json:"rate"`
+}
+
+// Matches reports whether the tier covers the term and amount
+func (t LoanRateTier) Matches(termMonths int, amount float64) bool {
+	return termMonths >= t.MinTerm && termMonths <= t.MaxTerm &&
+		amount >= t.MinAmount && (t.MaxAmount == 0 || amount <= t.MaxAmount)
+}
+
+// Fee returns the one-off fee charged for a loan of the given amount
+func (p *LoanProduct) Fee(amount float64) float64 {
+	return p.FeeFixed + amount*p.FeePercent/100
+}
+
+// RateFor returns the rate of the most specific tier covering the term and amount:
+// the narrowest term range, then the highest amount threshold
+func (p *LoanProduct) RateFor(termMonths int, amount float64) (float64, bool) {
+	var best *LoanRateTier
+	for i := range p.Rates {
+		tier := &p.Rates[i]
+		if !tier.Matches(termMonths, amount) {
+			continue
+		}
+		if best == nil {
+			best = tier
+			continue
+		}
+		width, bestWidth := tier.MaxTerm-tier.MinTerm, best.MaxTerm-best.MinTerm
+		if width < bestWidth || (width == bestWidth && tier.MinAmount > best.MinAmount) {
+			best = tier
+		}
+	}
+	if best == nil {
+		return 0, false
+	}
+	return best.Rate, true
+}
+
+// AllowsTerm reports whether the product can be issued for the term
+func (p *LoanProduct) AllowsTerm(termMonths int) bool {
+	if len(p.AllowedTerms) == 0 {
+		return true
+	}
+	for _, term := range p.AllowedTerms {
+		if term == termMonths {
+			return true
+		}
+	}
+	return false
 }
 
 // LoanPaymentRequest represents a request to make a payment on a loan
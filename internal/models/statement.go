@@ -0,0 +1,62 @@
+package models
+
+import (
+	"math"
+	"time"
+)
+
+// Statement formats
+const (
+	StatementJSON    = "json"
+	StatementCSV     = "csv"
+	StatementPDF     = "pdf"
+	StatementCamt053 = "camt053" // ISO 20022 bank-to-customer statement
+)
+
+// Statement lists the entries booked on one account between From and To together with the
+// balances around them. Deposit and enterprise statements share this form so that every
+// format can render both.
+type Statement struct {
+	AccountID      string           `json:"account_id"`
+	AccountName    string           `json:"account_name,omitempty"`
+	Owner          string           `json:"owner,omitempty"`
+	Servicer       string           `json:"servicer,omitempty"` // Bank holding the account
+	Currency       string           `json:"currency"`
+	From           time.Time        `json:"from"`
+	To             time.Time        `json:"to"`
+	OpeningBalance float64          `json:"opening_balance"`
+	ClosingBalance float64          `json:"closing_balance"`
+	TotalCredits   float64          `json:"total_credits"`
+	TotalDebits    float64          `json:"total_debits"`
+	TotalInterest  float64          `json:"total_interest"`
+	TotalFees      float64          `json:"total_fees"`
+	Entries        []StatementEntry `json:"entries"`
+}
+
+// StatementEntry is a credit (positive amount) or a debit (negative amount) of a statement
+type StatementEntry struct {
+	ID           int64     `json:"id"`
+	BookedAt     time.Time `json:"booked_at"`
+	Type         string    `json:"type"`
+	Description  string    `json:"description,omitempty"`
+	Counterparty string    `json:"counterparty,omitempty"`
+	Reference    string    `json:"reference,omitempty"`
+	Amount       float64   `json:"amount"`
+	Balance      float64   `json:"balance"` // Balance after the entry
+}
+
+// Add appends an entry and updates the running balance and totals, rounded to cents
+func (s *Statement) Add(entry StatementEntry) {
+	s.ClosingBalance = roundCents(s.ClosingBalance + entry.Amount)
+	entry.Balance = s.ClosingBalance
+	if entry.Amount >= 0 {
+		s.TotalCredits = roundCents(s.TotalCredits + entry.Amount)
+	} else {
+		s.TotalDebits = roundCents(s.TotalDebits - entry.Amount)
+	}
+	s.Entries = append(s.Entries, entry)
+}
+
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,314 @@
+// Package payroll parses payroll files uploaded by enterprise specialists
+// into salary payment rows and validates them before a salary project is saved.
+package payroll
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Column names of a payroll file
+const (
+	ColumnEmployeeName  = "employee_name"
+	ColumnPosition      = "employee_position"
+	ColumnAccountNumber = "account_number"
+	ColumnBankName      = "bank_name"
+	ColumnAmount        = "amount"
+)
+
+// defaultColumnOrder is used when the file has no recognizable header row
+var defaultColumnOrder = []string{
+	ColumnEmployeeName,
+	ColumnPosition,
+	ColumnAccountNumber,
+	ColumnBankName,
+	ColumnAmount,
+}
+
+// columnAliases maps normalized header titles to column names
+var columnAliases = map[string]string{
+	"employee_name":     ColumnEmployeeName,
+	"employee":          ColumnEmployeeName,
+	"name":              ColumnEmployeeName,
+	"full_name":         ColumnEmployeeName,
+	"фио":               ColumnEmployeeName,
+	"сотрудник":         ColumnEmployeeName,
+	"employee_position": ColumnPosition,
+	"position":          ColumnPosition,
+	"должность":         ColumnPosition,
+	"account_number":    ColumnAccountNumber,
+	"account":           ColumnAccountNumber,
+	"счет":              ColumnAccountNumber,
+	"счёт":              ColumnAccountNumber,
+	"номер_счета":       ColumnAccountNumber,
+	"bank_name":         ColumnBankName,
+	"bank":              ColumnBankName,
+	"банк":              ColumnBankName,
+	"amount":            ColumnAmount,
+	"sum":               ColumnAmount,
+	"сумма":             ColumnAmount,
+}
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported payroll file format, expected .csv or .xlsx")
+	ErrEmptyFile         = errors.New("payroll file contains no rows")
+)
+
+// Row is a single payment line of a payroll file
+type Row struct {
+	Line          int     `json:"line"`
+	EmployeeID    int64   `json:"employee_id,omitempty"` // Registry entry the row was matched to
+	EmployeeName  string  `json:"employee_name"`
+	Position      string  `json:"employee_position"`
+	AccountNumber string  `json:"account_number"`
+	BankName      string  `json:"bank_name"`
+	Amount        float64 `json:"amount"`
+}
+
+// RowError describes a problem with one row of a payroll file.
+// Line is zero for problems that concern the whole file.
+type RowError struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Report is the result of parsing and validating a payroll file
+type Report struct {
+	Rows        []Row      `json:"rows"`
+	Errors      []RowError `json:"errors"`
+	Warnings    []RowError `json:"warnings,omitempty"`
+	RowCount    int        `json:"row_count"`
+	TotalAmount float64    `json:"total_amount"`
+}
+
+// Valid reports whether the payroll file has no errors
+func (r *Report) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+// AddError appends an error for the given line
+func (r *Report) AddError(line int, field, message string) {
+	r.Errors = append(r.Errors, RowError{Line: line, Field: field, Message: message})
+}
+
+// AddWarning appends a non-blocking warning for the given line
+func (r *Report) AddWarning(line int, field, message string) {
+	r.Warnings = append(r.Warnings, RowError{Line: line, Field: field, Message: message})
+}
+
+// Parse reads a payroll file. The format is chosen by the file extension.
+// Rows with invalid values are reported in the returned report rather than as an error;
+// an error is returned only when the file itself cannot be read.
+func Parse(fileName string, data []byte) (*Report, error) {
+	var records [][]string
+	var err error
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		records, err = readCSV(data)
+	case ".xlsx":
+		records, err = readXLSX(data)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buildReport(records)
+}
+
+// readCSV reads CSV data separated by commas or semicolons
+func readCSV(data []byte) ([][]string, error) {
+	// Strip the UTF-8 byte order mark added by spreadsheet exports
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	firstLine := data
+	if idx := bytes.IndexByte(data, '\n'); idx >= 0 {
+		firstLine = data[:idx]
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// headerColumns returns the column layout of a header row, or nil if the row is not a header
+func headerColumns(record []string) []string {
+	columns := make([]string, len(record))
+	recognized := 0
+	for i, title := range record {
+		key := strings.ToLower(strings.TrimSpace(title))
+		key = strings.Join(strings.Fields(key), "_")
+		if column, ok := columnAliases[key]; ok {
+			columns[i] = column
+			recognized++
+		}
+	}
+	if recognized == 0 {
+		return nil
+	}
+	return columns
+}
+
+// buildReport converts raw records into payment rows and validates each one
+func buildReport(records [][]string) (*Report, error) {
+	report := &Report{Rows: []Row{}, Errors: []RowError{}}
+
+	if len(records) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	columns := defaultColumnOrder
+	firstDataLine := 1
+	if header := headerColumns(records[0]); header != nil {
+		columns = header
+		firstDataLine = 2
+		for _, required := range defaultColumnOrder {
+			if !containsColumn(columns, required) {
+				report.AddError(0, required, "missing column "+required)
+			}
+		}
+		if !report.Valid() {
+			return report, nil
+		}
+	}
+
+	for i, record := range records[firstDataLine-1:] {
+		line := firstDataLine + i
+		if isBlankRecord(record) {
+			continue
+		}
+
+		values := map[string]string{}
+		for idx, column := range columns {
+			if column != "" && idx < len(record) {
+				values[column] = strings.TrimSpace(record[idx])
+			}
+		}
+
+		row := Row{
+			Line:          line,
+			EmployeeName:  values[ColumnEmployeeName],
+			Position:      values[ColumnPosition],
+			AccountNumber: values[ColumnAccountNumber],
+			BankName:      values[ColumnBankName],
+		}
+
+		valid := true
+		if row.EmployeeName == "" {
+			report.AddError(line, ColumnEmployeeName, "employee name is required")
+			valid = false
+		}
+		if row.Position == "" {
+			report.AddError(line, ColumnPosition, "employee position is required")
+			valid = false
+		}
+		if row.AccountNumber == "" {
+			report.AddError(line, ColumnAccountNumber, "account number is required")
+			valid = false
+		}
+		if row.BankName == "" {
+			report.AddError(line, ColumnBankName, "bank name is required")
+			valid = false
+		}
+
+		amount, err := parseAmount(values[ColumnAmount])
+		if err != nil {
+			report.AddError(line, ColumnAmount, err.Error())
+			valid = false
+		}
+		row.Amount = amount
+
+		if valid {
+			report.Rows = append(report.Rows, row)
+			report.TotalAmount += row.Amount
+		}
+		report.RowCount++
+	}
+
+	report.TotalAmount = math.Round(report.TotalAmount*100) / 100
+
+	if report.RowCount == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	return report, nil
+}
+
+// CheckTotals cross-checks the parsed file against the declared project totals
+func (r *Report) CheckTotals(expectedCount int, expectedTotal float64) {
+	if r.RowCount != expectedCount {
+		r.AddError(0, "employee_count",
+			fmt.Sprintf("file contains %d payments but employee_count is %d", r.RowCount, expectedCount))
+	}
+	if math.Abs(r.TotalAmount-expectedTotal) >= 0.005 {
+		r.AddError(0, "total_amount",
+			fmt.Sprintf("payments sum to %.2f but total_amount is %.2f", r.TotalAmount, expectedTotal))
+	}
+}
+
+// parseAmount parses a positive money amount with at most two decimals.
+// Both "1234.50" and "1 234,50" are accepted.
+func parseAmount(value string) (float64, error) {
+	cleaned := strings.ReplaceAll(value, " ", "")
+	cleaned = strings.ReplaceAll(cleaned, " ", "")
+	cleaned = strings.ReplaceAll(cleaned, ",", ".")
+	if cleaned == "" {
+		return 0, errors.New("amount is required")
+	}
+
+	amount, err := strconv.ParseFloat(cleaned, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if amount <= 0 {
+		return 0, errors.New("amount must be greater than 0")
+	}
+	if math.Abs(amount*100-math.Round(amount*100)) > 1e-6 {
+		return 0, errors.New("amount must have at most two decimal places")
+	}
+	return amount, nil
+}
+
+func containsColumn(columns []string, column string) bool {
+	for _, c := range columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+func isBlankRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
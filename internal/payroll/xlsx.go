@@ -0,0 +1,222 @@
+package payroll
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// The structures below cover the small subset of SpreadsheetML needed to read
+// cell values from the first worksheet of an .xlsx workbook.
+
+type xlsxSharedStrings struct {
+	Items []xlsxStringItem `xml:"si"`
+}
+
+type xlsxStringItem struct {
+	Text string        `xml:"t"`
+	Runs []xlsxTextRun `xml:"r"`
+}
+
+type xlsxTextRun struct {
+	Text string `xml:"t"`
+}
+
+func (si xlsxStringItem) String() string {
+	if len(si.Runs) == 0 {
+		return si.Text
+	}
+	var b strings.Builder
+	for _, run := range si.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+type xlsxWorksheet struct {
+	Rows []xlsxRow `xml:"sheetData>row"`
+}
+
+type xlsxRow struct {
+	Number int        `xml:"r,attr"`
+	Cells  []xlsxCell `xml:"c"`
+}
+
+type xlsxCell struct {
+	Ref       string         `xml:"r,attr"`
+	Type      string         `xml:"t,attr"`
+	Value     string         `xml:"v"`
+	InlineStr xlsxStringItem `xml:"is"`
+}
+
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Items []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// Limits on the cell positions of a worksheet. Rows and columns are padded up to the referenced
+// position, so without them a single cell such as ZZZZZZZ1 would allocate a huge record.
+const (
+	maxXLSXColumns = 64
+	maxXLSXRows    = 100000
+)
+
+// readXLSX returns the cell values of the first worksheet as records
+func readXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX: %w", err)
+	}
+
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	var sharedStrings xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeZipXML(f, &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+	sheetFile, ok := files[sheetPath]
+	if !ok {
+		return nil, errors.New("invalid XLSX: worksheet not found")
+	}
+
+	var sheet xlsxWorksheet
+	if err := decodeZipXML(sheetFile, &sheet); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for _, row := range sheet.Rows {
+		// Rows left out of the sheet are empty; keep them so line numbers match the spreadsheet
+		if row.Number > 0 {
+			if row.Number <= len(records) {
+				return nil, fmt.Errorf("invalid XLSX: row %d is out of order", row.Number)
+			}
+			if row.Number > maxXLSXRows {
+				return nil, fmt.Errorf("invalid XLSX: row %d is beyond the limit of %d rows", row.Number, maxXLSXRows)
+			}
+			for len(records) < row.Number-1 {
+				records = append(records, nil)
+			}
+		}
+
+		var record []string
+		for i, cell := range row.Cells {
+			col := i
+			if idx := columnIndex(cell.Ref); idx >= 0 {
+				col = idx
+			}
+			if col >= maxXLSXColumns {
+				return nil, fmt.Errorf("invalid XLSX: cell %s is beyond the limit of %d columns", cell.Ref, maxXLSXColumns)
+			}
+			for len(record) <= col {
+				record = append(record, "")
+			}
+			record[col] = cellValue(cell, sharedStrings)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// firstSheetPath resolves the path of the first worksheet listed in the workbook
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	workbookFile, ok := files["xl/workbook.xml"]
+	relsFile, relsOK := files["xl/_rels/workbook.xml.rels"]
+	if !ok || !relsOK {
+		return fallback, nil
+	}
+
+	var workbook xlsxWorkbook
+	if err := decodeZipXML(workbookFile, &workbook); err != nil {
+		return "", err
+	}
+	var rels xlsxRelationships
+	if err := decodeZipXML(relsFile, &rels); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", errors.New("invalid XLSX: workbook has no sheets")
+	}
+
+	for _, rel := range rels.Items {
+		if rel.ID == workbook.Sheets[0].RelID {
+			target := strings.TrimPrefix(rel.Target, "/")
+			if !strings.HasPrefix(target, "xl/") {
+				target = "xl/" + target
+			}
+			return target, nil
+		}
+	}
+	return fallback, nil
+}
+
+func decodeZipXML(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	// Guard against decompression bombs; payroll sheets are small
+	limited := io.LimitReader(rc, 50<<20)
+	if err := xml.NewDecoder(limited).Decode(v); err != nil {
+		return fmt.Errorf("invalid XLSX part %s: %w", f.Name, err)
+	}
+	return nil
+}
+
+func cellValue(cell xlsxCell, sharedStrings xlsxSharedStrings) string {
+	switch cell.Type {
+	case "s":
+		idx, err := strconv.Atoi(strings.TrimSpace(cell.Value))
+		if err != nil || idx < 0 || idx >= len(sharedStrings.Items) {
+			return ""
+		}
+		return sharedStrings.Items[idx].String()
+	case "inlineStr":
+		return cell.InlineStr.String()
+	default:
+		return cell.Value
+	}
+}
+
+// columnIndex converts a cell reference such as "C12" to a zero based column index.
+// Indexes past maxXLSXColumns are not computed exactly, so long references cannot overflow.
+func columnIndex(ref string) int {
+	index := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		if index > maxXLSXColumns {
+			continue
+		}
+		index = index*26 + int(r-'A'+1)
+	}
+	return index - 1
+}
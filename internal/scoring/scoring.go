@@ -0,0 +1,269 @@
+// Package scoring rates loan requests from the data the bank already holds about the client.
+// The rules and thresholds are data, so they can be changed without a release.
+package scoring
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Decisions recommended by the scoring
+const (
+	DecisionAutoApprove  = "auto_approve"
+	DecisionManualReview = "manual_review"
+	DecisionAutoDecline  = "auto_decline"
+)
+
+// Factors a rule can test
+const (
+	FactorAccountAgeDays      = "account_age_days"
+	FactorDepositBalance      = "deposit_balance"
+	FactorDepositCount        = "deposit_count"
+	FactorMonthlyIncome       = "monthly_income"
+	FactorDebtToIncome        = "debt_to_income"
+	FactorOutstandingDebt     = "outstanding_debt"
+	FactorCompletedLoans      = "completed_loans"
+	FactorDefaultedLoans      = "defaulted_loans"
+	FactorLoansBehindSchedule = "loans_behind_schedule"
+	FactorAmountToIncome      = "amount_to_income"
+)
+
+// Operators a rule can compare with
+const (
+	OperatorGreater      = "gt"
+	OperatorGreaterEqual = "gte"
+	OperatorLess         = "lt"
+	OperatorLessEqual    = "lte"
+	OperatorEqual        = "eq"
+)
+
+// maxRatio caps ratios whose income is zero or close to it
+const maxRatio = 99
+
+// ErrInvalidRule is returned for a rule with an unknown factor or operator
+var ErrInvalidRule = errors.New("invalid scoring rule")
+
+// Profile is what the bank knows about a client asking for a loan
+type Profile struct {
+	AccountAgeDays          int     `json:"account_age_days"`
+	DepositBalance          float64 `json:"deposit_balance"`
+	DepositCount            int     `json:"deposit_count"`
+	MonthlyIncome           float64 `json:"monthly_income"`
+	MonthlyDebtPayments     float64 `json:"monthly_debt_payments"`
+	OutstandingDebt         float64 `json:"outstanding_debt"`
+	CompletedLoans          int     `json:"completed_loans"`
+	DefaultedLoans          int     `json:"defaulted_loans"`
+	LoansBehindSchedule     int     `json:"loans_behind_schedule"`
+	RequestedAmount         float64 `json:"requested_amount"`
+	RequestedTermMonths     int     `json:"requested_term_months"`
+	RequestedMonthlyPayment float64 `json:"requested_monthly_payment"`
+}
+
+// DebtToIncome returns the monthly debt payments including the requested loan divided by income
+func (p Profile) DebtToIncome() float64 {
+	return ratio(p.MonthlyDebtPayments+p.RequestedMonthlyPayment, p.MonthlyIncome)
+}
+
+// Value returns the value of a factor
+func (p Profile) Value(factor string) (float64, bool) {
+	switch factor {
+	case FactorAccountAgeDays:
+		return float64(p.AccountAgeDays), true
+	case FactorDepositBalance:
+		return p.DepositBalance, true
+	case FactorDepositCount:
+		return float64(p.DepositCount), true
+	case FactorMonthlyIncome:
+		return p.MonthlyIncome, true
+	case FactorDebtToIncome:
+		return p.DebtToIncome(), true
+	case FactorOutstandingDebt:
+		return p.OutstandingDebt, true
+	case FactorCompletedLoans:
+		return float64(p.CompletedLoans), true
+	case FactorDefaultedLoans:
+		return float64(p.DefaultedLoans), true
+	case FactorLoansBehindSchedule:
+		return float64(p.LoansBehindSchedule), true
+	case FactorAmountToIncome:
+		return ratio(p.RequestedAmount, p.MonthlyIncome), true
+	}
+	return 0, false
+}
+
+// Rule adds Points to the score when the factor compares true against Threshold.
+// Points may be negative.
+type Rule struct {
+	ID        int64   `json:"id"`
+	Name      string  `json:"name"`
+	Factor    string  `json:"factor"`
+	Operator  string  `json:"operator"`
+	Threshold float64 `json:"threshold"`
+	Points    int     `json:"points"`
+	Active    bool    `json:"active"`
+}
+
+// Validate checks the factor and operator of the rule
+func (r Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidRule)
+	}
+	if _, ok := (Profile{}).Value(r.Factor); !ok {
+		return fmt.Errorf("%w: unknown factor %q", ErrInvalidRule, r.Factor)
+	}
+	switch r.Operator {
+	case OperatorGreater, OperatorGreaterEqual, OperatorLess, OperatorLessEqual, OperatorEqual:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown operator %q", ErrInvalidRule, r.Operator)
+}
+
+// matches reports whether the rule applies to the profile
+func (r Rule) matches(p Profile) bool {
+	value, ok := p.Value(r.Factor)
+	if !ok {
+		return false
+	}
+	switch r.Operator {
+	case OperatorGreater:
+		return value > r.Threshold
+	case OperatorGreaterEqual:
+		return value >= r.Threshold
+	case OperatorLess:
+		return value < r.Threshold
+	case OperatorLessEqual:
+		return value <= r.Threshold
+	case OperatorEqual:
+		return value == r.Threshold
+	}
+	return false
+}
+
+// Settings turn a score into a decision, a rate and a limit
+type Settings struct {
+	BaseScore        int     `json:"base_score"`
+	AutoApproveScore int     `json:"auto_approve_score"`
+	AutoDeclineScore int     `json:"auto_decline_score"`
+	MaxDebtToIncome  float64 `json:"max_debt_to_income"`
+	BaseRate         float64 `json:"base_rate"`
+	RatePerPoint     float64 `json:"rate_per_point"`
+	MinRate          float64 `json:"min_rate"`
+	MaxRate          float64 `json:"max_rate"`
+}
+
+// DefaultSettings are used until an admin changes them
+func DefaultSettings() Settings {
+	return Settings{
+		BaseScore:        500,
+		AutoApproveScore: 700,
+		AutoDeclineScore: 400,
+		MaxDebtToIncome:  0.5,
+		BaseRate:         8,
+		RatePerPoint:     0.05,
+		MinRate:          5,
+		MaxRate:          36,
+	}
+}
+
+// DefaultRules are seeded when no rules are configured
+func DefaultRules() []Rule {
+	return []Rule{
+		{Name: "Established customer", Factor: FactorAccountAgeDays, Operator: OperatorGreaterEqual, Threshold: 365, Points: 60, Active: true},
+		{Name: "New customer", Factor: FactorAccountAgeDays, Operator: OperatorLess, Threshold: 90, Points: -60, Active: true},
+		{Name: "Savings cushion", Factor: FactorDepositBalance, Operator: OperatorGreaterEqual, Threshold: 5000, Points: 50, Active: true},
+		{Name: "No deposits", Factor: FactorDepositCount, Operator: OperatorEqual, Threshold: 0, Points: -50, Active: true},
+		{Name: "Regular income", Factor: FactorMonthlyIncome, Operator: OperatorGreater, Threshold: 0, Points: 80, Active: true},
+		{Name: "Low debt load", Factor: FactorDebtToIncome, Operator: OperatorLessEqual, Threshold: 0.3, Points: 60, Active: true},
+		{Name: "High debt load", Factor: FactorDebtToIncome, Operator: OperatorGreater, Threshold: 0.4, Points: -80, Active: true},
+		{Name: "Repaid loans", Factor: FactorCompletedLoans, Operator: OperatorGreaterEqual, Threshold: 1, Points: 70, Active: true},
+		{Name: "Defaulted loan", Factor: FactorDefaultedLoans, Operator: OperatorGreaterEqual, Threshold: 1, Points: -250, Active: true},
+		{Name: "Behind on payments", Factor: FactorLoansBehindSchedule, Operator: OperatorGreaterEqual, Threshold: 1, Points: -120, Active: true},
+		{Name: "Large request for income", Factor: FactorAmountToIncome, Operator: OperatorGreater, Threshold: 12, Points: -60, Active: true},
+	}
+}
+
+// Result is the outcome of scoring a loan request
+type Result struct {
+	Score           int      `json:"score"`
+	Decision        string   `json:"decision"`
+	RecommendedRate float64  `json:"recommended_rate"`
+	MaxAmount       float64  `json:"max_amount"`
+	DebtToIncome    float64  `json:"debt_to_income"`
+	Reasons         []string `json:"reasons"`
+}
+
+// Evaluate scores a profile with the active rules
+func Evaluate(profile Profile, rules []Rule, settings Settings) Result {
+	sorted := append([]Rule(nil), rules...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+
+	result := Result{
+		Score:        settings.BaseScore,
+		DebtToIncome: round(profile.DebtToIncome(), 4),
+		Reasons:      []string{},
+	}
+	for _, rule := range sorted {
+		if !rule.Active || !rule.matches(profile) {
+			continue
+		}
+		result.Score += rule.Points
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%s (%+d)", rule.Name, rule.Points))
+	}
+
+	// Every point below the auto approve score costs RatePerPoint
+	rate := settings.BaseRate
+	if shortfall := settings.AutoApproveScore - result.Score; shortfall > 0 {
+		rate += float64(shortfall) * settings.RatePerPoint
+	}
+	result.RecommendedRate = round(math.Min(math.Max(rate, settings.MinRate), settings.MaxRate), 2)
+	result.MaxAmount = maxAmount(profile, settings, result.RecommendedRate)
+
+	switch {
+	case result.Score < settings.AutoDeclineScore:
+		result.Decision = DecisionAutoDecline
+	case settings.MaxDebtToIncome > 0 && profile.MonthlyIncome > 0 && result.DebtToIncome > settings.MaxDebtToIncome:
+		result.Decision = DecisionAutoDecline
+		result.Reasons = append(result.Reasons, fmt.Sprintf("debt to income %.2f exceeds %.2f",
+			result.DebtToIncome, settings.MaxDebtToIncome))
+	case result.Score >= settings.AutoApproveScore && profile.RequestedAmount <= result.MaxAmount:
+		result.Decision = DecisionAutoApprove
+	default:
+		result.Decision = DecisionManualReview
+	}
+	if result.Decision == DecisionAutoDecline {
+		result.MaxAmount = 0
+	}
+	return result
+}
+
+// maxAmount is the principal whose monthly payment still fits the allowed debt to income
+func maxAmount(profile Profile, settings Settings, rate float64) float64 {
+	term := profile.RequestedTermMonths
+	if term <= 0 || profile.MonthlyIncome <= 0 {
+		return 0
+	}
+	capacity := profile.MonthlyIncome*settings.MaxDebtToIncome - profile.MonthlyDebtPayments
+	if capacity <= 0 {
+		return 0
+	}
+	// Loans are repaid in equal parts of the compounded total
+	growth := math.Pow(1+rate/100/12, float64(term))
+	return round(capacity*float64(term)/growth, 2)
+}
+
+func ratio(value, income float64) float64 {
+	if income <= 0 {
+		if value <= 0 {
+			return 0
+		}
+		return maxRatio
+	}
+	return math.Min(value/income, maxRatio)
+}
+
+func round(value float64, places int) float64 {
+	factor := math.Pow(10, float64(places))
+	return math.Round(value*factor) / factor
+}
@@ -0,0 +1,126 @@
+package statement
+
+import (
+	"bytes"
+	"finance/internal/models"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Page layout of PDF statements: A4 in points with a monospaced 9pt font
+const (
+	pageWidth    = 595
+	pageHeight   = 842
+	pageMargin   = 40
+	fontSize     = 9
+	lineLeading  = 11
+	linesPerPage = (pageHeight-2*pageMargin)/lineLeading - 2 // Two lines are kept for the footer
+)
+
+// WritePDF renders the statements as a PDF document, each account starting on a new page.
+// Text is set in the standard Courier font, so characters outside Latin-1 are replaced by '?'.
+func WritePDF(w io.Writer, statements []models.Statement) error {
+	var pages [][]string
+	for _, statement := range statements {
+		text := lines(statement)
+		for len(text) > 0 {
+			n := linesPerPage
+			if n > len(text) {
+				n = len(text)
+			}
+			pages = append(pages, text[:n])
+			text = text[n:]
+		}
+	}
+	if len(pages) == 0 {
+		pages = [][]string{{"ACCOUNT STATEMENT", "", "No accounts to report."}}
+	}
+
+	doc := &pdfDocument{}
+	doc.object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	doc.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	doc.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		doc.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i))
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(pages))
+		doc.stream(pageContent(page, footer))
+	}
+	_, err := w.Write(doc.bytes())
+	return err
+}
+
+// pageContent is the content stream drawing the lines of one page
+func pageContent(lines []string, footer string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "BT\n/F1 %d Tf\n%d TL\n%d %d Td\n", fontSize, lineLeading, pageMargin, pageHeight-pageMargin)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "(%s) Tj T*\n", pdfText(line))
+	}
+	b.WriteString("ET\n")
+	fmt.Fprintf(&b, "BT\n/F1 %d Tf\n%d %d Td\n(%s) Tj\nET\n", fontSize, pageMargin, pageMargin, pdfText(footer))
+	return b.Bytes()
+}
+
+// pdfText encodes a line as a PDF string literal body
+func pdfText(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32:
+			b.WriteByte(' ')
+		case r > 255:
+			b.WriteByte('?')
+		case r > 126:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// pdfDocument assembles numbered objects and the cross-reference table of a PDF file
+type pdfDocument struct {
+	body    bytes.Buffer
+	offsets []int
+}
+
+func (d *pdfDocument) start() {
+	if d.body.Len() == 0 {
+		d.body.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	}
+	d.offsets = append(d.offsets, d.body.Len())
+}
+
+func (d *pdfDocument) object(dictionary string) {
+	d.start()
+	fmt.Fprintf(&d.body, "%d 0 obj\n%s\nendobj\n", len(d.offsets), dictionary)
+}
+
+func (d *pdfDocument) stream(content []byte) {
+	d.start()
+	fmt.Fprintf(&d.body, "%d 0 obj\n<< /Length %d >>\nstream\n", len(d.offsets), len(content))
+	d.body.Write(content)
+	d.body.WriteString("\nendstream\nendobj\n")
+}
+
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	out.Write(d.body.Bytes())
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(d.offsets)+1)
+	for _, offset := range d.offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.offsets)+1, xref)
+	return out.Bytes()
+}
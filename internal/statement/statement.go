@@ -0,0 +1,128 @@
+// Package statement renders account statements as CSV and PDF documents.
+// The ISO 20022 camt.053 form lives in package iso20022.
+package statement
+
+import (
+	"encoding/csv"
+	"finance/internal/models"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02 15:04:05"
+)
+
+// csvHeader is the header row of CSV statements
+var csvHeader = []string{
+	"account", "currency", "booked_at", "type", "description", "counterparty", "reference",
+	"credit", "debit", "balance",
+}
+
+// WriteCSV writes the entries of the statements with one row per entry. Every account starts
+// with an opening balance row and ends with a closing balance row so that the file can be
+// reconciled without the JSON statement.
+func WriteCSV(w io.Writer, statements []models.Statement) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		opening := []string{statement.AccountID, statement.Currency, statement.From.Format(dateTimeLayout),
+			"opening_balance", "Opening balance", "", "", "", "", formatAmount(statement.OpeningBalance)}
+		if err := writer.Write(opening); err != nil {
+			return err
+		}
+		for _, entry := range statement.Entries {
+			credit, debit := "", ""
+			if entry.Amount >= 0 {
+				credit = formatAmount(entry.Amount)
+			} else {
+				debit = formatAmount(-entry.Amount)
+			}
+			row := []string{statement.AccountID, statement.Currency, entry.BookedAt.Format(dateTimeLayout),
+				entry.Type, entry.Description, entry.Counterparty, entry.Reference, credit, debit, formatAmount(entry.Balance)}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		closing := []string{statement.AccountID, statement.Currency, statement.To.Format(dateTimeLayout),
+			"closing_balance", "Closing balance", "", "", formatAmount(statement.TotalCredits),
+			formatAmount(statement.TotalDebits), formatAmount(statement.ClosingBalance)}
+		if err := writer.Write(closing); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// lines lays a statement out as fixed-width text lines for the PDF document
+func lines(statement models.Statement) []string {
+	out := []string{
+		"ACCOUNT STATEMENT",
+		"",
+		"Account:  " + statement.AccountID + "  " + statement.AccountName,
+	}
+	if statement.Owner != "" {
+		out = append(out, "Holder:   "+statement.Owner)
+	}
+	if statement.Servicer != "" {
+		out = append(out, "Bank:     "+statement.Servicer)
+	}
+	out = append(out,
+		"Currency: "+statement.Currency,
+		fmt.Sprintf("Period:   %s - %s", statement.From.Format(dateLayout), statement.To.Format(dateLayout)),
+		"",
+		fmt.Sprintf("%-16s %-32s %14s %14s", "Date", "Details", "Amount", "Balance"),
+		fmt.Sprintf("%-16s %-32s %14s %14s", "", "Opening balance", "", formatAmount(statement.OpeningBalance)),
+	)
+	for _, entry := range statement.Entries {
+		details := entry.Description
+		if details == "" {
+			details = entry.Type
+		}
+		out = append(out, fmt.Sprintf("%-16s %-32s %14s %14s", entry.BookedAt.Format("2006-01-02 15:04"),
+			truncate(details, 32), formatAmount(entry.Amount), formatAmount(entry.Balance)))
+		var extra string
+		if entry.Counterparty != "" {
+			extra = entry.Counterparty
+		}
+		if entry.Reference != "" {
+			if extra != "" {
+				extra += ", "
+			}
+			extra += "ref " + entry.Reference
+		}
+		if extra != "" {
+			out = append(out, fmt.Sprintf("%-16s %s", "", truncate(extra, 62)))
+		}
+	}
+	out = append(out,
+		fmt.Sprintf("%-16s %-32s %14s %14s", "", "Closing balance", "", formatAmount(statement.ClosingBalance)),
+		"",
+		fmt.Sprintf("Total credits:  %14s", formatAmount(statement.TotalCredits)),
+		fmt.Sprintf("Total debits:   %14s", formatAmount(statement.TotalDebits)),
+	)
+	if statement.TotalInterest != 0 || statement.TotalFees != 0 {
+		out = append(out,
+			fmt.Sprintf("Interest:       %14s", formatAmount(statement.TotalInterest)),
+			fmt.Sprintf("Fees:           %14s", formatAmount(statement.TotalFees)),
+		)
+	}
+	return out
+}
+
+func truncate(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "~"
+}
@@ -0,0 +1,648 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/aml"
+	"finance/internal/models"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of an AML alert
+const (
+	AMLAlertOpen      = "open"
+	AMLAlertReported  = "reported"  // Confirmed as suspicious and included in the report
+	AMLAlertDismissed = "dismissed" // A false positive
+)
+
+// amlFreezeDays is how long a deposit stays frozen after a high severity hit unless the
+// alert is dismissed earlier
+const amlFreezeDays = 30
+
+// amlScreeningBatch bounds the movements screened in one pass
+const amlScreeningBatch = 500
+
+var (
+	// ErrAMLRuleNotFound is returned when an AML rule does not exist
+	ErrAMLRuleNotFound = errors.New("AML rule not found")
+	// ErrAMLAlertNotFound is returned when an AML alert does not exist
+	ErrAMLAlertNotFound = errors.New("AML alert not found")
+	// ErrAMLAlertResolved is returned when an alert was already reported or dismissed
+	ErrAMLAlertResolved = errors.New("AML alert was already resolved")
+	// ErrInvalidAMLAlertStatus is returned when alerts are filtered by an unknown status
+	ErrInvalidAMLAlertStatus = errors.New("status must be open, reported or dismissed")
+)
+
+// AMLAlert is a rule hit waiting for or worked by an operator
+type AMLAlert struct {
+	ID            int64   `json:"id"`
+	Rule          string  `json:"rule"`
+	Severity      string  `json:"severity"`
+	Reason        string  `json:"reason"`
+	ClientID      int64   `json:"client_id"`
+	DepositID     int64   `json:"deposit_id"`
+	MovementID    int64   `json:"movement_id"`
+	TransactionID int64   `json:"transaction_id,omitempty"`
+	Amount        float64 `json:"amount"`
+	Status        string  `json:"status"`
+	DepositFrozen bool    `json:"deposit_frozen"`
+	ResolvedBy    *int64  `json:"resolved_by,omitempty"`
+	Comment       string  `json:"comment,omitempty"`
+	CreatedAt     int64   `json:"created_at"`
+	ResolvedAt    *int64  `json:"resolved_at,omitempty"`
+}
+
+// EnsureAMLTablesExist creates the AML tables. Rules are seeded by code, so a rule added in a
+// later release is configured on first use and rules an admin changed are kept. Screening
+// starts after the movements that exist when the tables are created.
+func EnsureAMLTablesExist() error {
+	if err := ensureDepositMovementsTableExists(); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS aml_rules (
+			code TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			threshold REAL NOT NULL,
+			ratio REAL NOT NULL DEFAULT 0,
+			window_hours INTEGER NOT NULL,
+			min_count INTEGER NOT NULL DEFAULT 0,
+			updated_by INTEGER,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS aml_alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			rule TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			reason TEXT NOT NULL,
+			client_id INTEGER NOT NULL,
+			deposit_id INTEGER NOT NULL,
+			movement_id INTEGER NOT NULL,
+			transaction_id INTEGER,
+			amount REAL NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			deposit_frozen INTEGER NOT NULL DEFAULT 0,
+			resolved_by INTEGER,
+			comment TEXT,
+			created_at INTEGER NOT NULL,
+			resolved_at INTEGER,
+			UNIQUE (rule, movement_id),
+			FOREIGN KEY (deposit_id) REFERENCES deposits(deposit_id),
+			FOREIGN KEY (movement_id) REFERENCES deposit_movements(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if _, err := DB.Exec(`CREATE INDEX IF NOT EXISTS idx_aml_alerts_status ON aml_alerts(status, created_at)`); err != nil {
+		return err
+	}
+	if _, err := DB.Exec(`CREATE INDEX IF NOT EXISTS idx_deposit_movements_client ON deposit_movements(client_id, created_at)`); err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS aml_screening (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			last_movement_id INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		INSERT OR IGNORE INTO aml_screening (id, last_movement_id, updated_at)
+		SELECT 1, COALESCE(MAX(id), 0), ? FROM deposit_movements
+	`, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	for _, rule := range aml.DefaultRules() {
+		_, err := DB.Exec(`
+			INSERT OR IGNORE INTO aml_rules (code, name, severity, active, threshold, ratio, window_hours, min_count, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, rule.Code, rule.Name, rule.Severity, boolToInt(rule.Active), rule.Threshold, rule.Ratio,
+			rule.WindowHours, rule.MinCount, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAMLRules returns all AML rules, active or not
+func GetAMLRules() ([]aml.Rule, error) {
+	if err := EnsureAMLTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT code, name, severity, active, threshold, ratio, window_hours, min_count
+		FROM aml_rules
+		ORDER BY code
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []aml.Rule{}
+	for rows.Next() {
+		var rule aml.Rule
+		var active int
+		if err := rows.Scan(&rule.Code, &rule.Name, &rule.Severity, &active, &rule.Threshold,
+			&rule.Ratio, &rule.WindowHours, &rule.MinCount); err != nil {
+			return nil, err
+		}
+		rule.Active = active == 1
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// UpdateAMLRule replaces the configuration of an AML rule
+func UpdateAMLRule(rule *aml.Rule, adminID int64) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if err := EnsureAMLTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE aml_rules
+		SET name = COALESCE(NULLIF(?, ''), name), severity = ?, active = ?, threshold = ?, ratio = ?,
+			window_hours = ?, min_count = ?, updated_by = ?, updated_at = ?
+		WHERE code = ?
+	`, rule.Name, rule.Severity, boolToInt(rule.Active), rule.Threshold, rule.Ratio, rule.WindowHours,
+		rule.MinCount, adminID, time.Now().Unix(), rule.Code)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrAMLRuleNotFound
+	}
+
+	LogTransaction(adminID, "aml_rule_update", nil, fmt.Sprintf("AML rule %s updated: severity %s, active %t, threshold %.2f, ratio %.2f, window %dh, min count %d",
+		rule.Code, rule.Severity, rule.Active, rule.Threshold, rule.Ratio, rule.WindowHours, rule.MinCount))
+	return nil
+}
+
+var (
+	// amlScreeningMu keeps screening passes from running at the same time
+	amlScreeningMu sync.Mutex
+	// amlScreeningRequests wakes RunAMLScreening; one pending request covers any number of
+	// transactions logged in the meantime
+	amlScreeningRequests = make(chan struct{}, 1)
+)
+
+// requestAMLScreening asks RunAMLScreening for a pass. It is called for every logged
+// transaction, so hits are raised right after the operation without slowing it down or
+// failing it.
+func requestAMLScreening() {
+	select {
+	case amlScreeningRequests <- struct{}{}:
+	default:
+	}
+}
+
+// RunAMLScreening screens new deposit movements whenever a transaction is logged and every
+// interval for postings made without one. It does not return.
+func RunAMLScreening(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := ScreenDepositMovements(); err != nil {
+			log.Printf("Warning: AML screening failed: %v", err)
+		}
+		select {
+		case <-amlScreeningRequests:
+		case <-ticker.C:
+		}
+	}
+}
+
+// isAMLTransactionType reports whether a logged transaction was written by the AML engine
+// itself and must not trigger screening again
+func isAMLTransactionType(txType string) bool {
+	return strings.HasPrefix(txType, "aml_")
+}
+
+// ScreenDepositMovements runs the AML rules against the deposit movements posted since the last
+// pass and returns how many alerts were raised. Movements are screened in the order they were
+// posted, so every rule sees the history as it was at the time.
+func ScreenDepositMovements() (int, error) {
+	amlScreeningMu.Lock()
+	defer amlScreeningMu.Unlock()
+
+	rules, err := GetAMLRules()
+	if err != nil {
+		return 0, err
+	}
+	var longest time.Duration
+	active := rules[:0]
+	for _, rule := range rules {
+		if rule.Active {
+			active = append(active, rule)
+			if rule.Window() > longest {
+				longest = rule.Window()
+			}
+		}
+	}
+
+	raised := 0
+	for {
+		var lastID int64
+		if err := DB.QueryRow("SELECT last_movement_id FROM aml_screening WHERE id = 1").Scan(&lastID); err != nil {
+			return raised, err
+		}
+		movements, err := movementsAfter(lastID, amlScreeningBatch)
+		if err != nil {
+			return raised, err
+		}
+		if len(movements) == 0 {
+			return raised, nil
+		}
+
+		for _, movement := range movements {
+			if len(active) > 0 && movement.CancelledAt == nil {
+				count, err := screenMovement(movement, active, longest)
+				if err != nil {
+					return raised, fmt.Errorf("screening movement %d: %w", movement.ID, err)
+				}
+				raised += count
+			}
+			_, err := DB.Exec("UPDATE aml_screening SET last_movement_id = ?, updated_at = ? WHERE id = 1",
+				movement.ID, time.Now().Unix())
+			if err != nil {
+				return raised, err
+			}
+		}
+		if len(movements) < amlScreeningBatch {
+			return raised, nil
+		}
+	}
+}
+
+// movementsAfter returns up to limit deposit movements with an ID above afterID
+func movementsAfter(afterID int64, limit int) ([]models.DepositMovement, error) {
+	rows, err := DB.Query("SELECT "+depositMovementColumns+" FROM deposit_movements WHERE id > ? ORDER BY id LIMIT ?",
+		afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []models.DepositMovement{}
+	for rows.Next() {
+		var movement models.DepositMovement
+		if err := scanDepositMovement(rows, &movement); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, rows.Err()
+}
+
+// screenMovement evaluates one movement and raises an alert for every hit. A high severity hit
+// freezes the deposit unless it is already blocked or frozen.
+func screenMovement(movement models.DepositMovement, rules []aml.Rule, history time.Duration) (int, error) {
+	activity, err := buildAMLActivity(movement, history)
+	if err != nil {
+		return 0, err
+	}
+
+	raised := 0
+	for _, hit := range aml.Evaluate(activity, rules) {
+		result, err := DB.Exec(`
+			INSERT OR IGNORE INTO aml_alerts (rule, severity, reason, client_id, deposit_id, movement_id, transaction_id, amount, status, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, hit.Rule, hit.Severity, hit.Reason, movement.ClientID, movement.DepositID, movement.ID,
+			nullableInt64(movement.TransactionID), movement.Amount, AMLAlertOpen, time.Now().Unix())
+		if err != nil {
+			return raised, err
+		}
+		if inserted, err := result.RowsAffected(); err != nil {
+			return raised, err
+		} else if inserted == 0 {
+			continue
+		}
+		alertID, err := result.LastInsertId()
+		if err != nil {
+			return raised, err
+		}
+		raised++
+
+		amount := movement.Amount
+		LogTransaction(movement.ClientID, "aml_alert", &amount, fmt.Sprintf("AML alert #%d (%s, %s) on deposit %d: %s",
+			alertID, hit.Rule, hit.Severity, movement.DepositID, hit.Reason))
+		if hit.Severity == aml.SeverityHigh {
+			if err := freezeForAMLAlert(alertID, movement.DepositID); err != nil {
+				log.Printf("Error freezing deposit %d for AML alert %d: %v", movement.DepositID, alertID, err)
+			}
+		}
+	}
+	return raised, nil
+}
+
+// buildAMLActivity gathers what the rules need to know around a movement
+func buildAMLActivity(movement models.DepositMovement, history time.Duration) (aml.Activity, error) {
+	activity := aml.Activity{Movement: amlMovement(movement)}
+
+	err := DB.QueryRow("SELECT created_at FROM deposits WHERE deposit_id = ?", movement.DepositID).Scan(&activity.DepositOpenedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return activity, err
+	}
+	err = DB.QueryRow("SELECT created_at FROM users WHERE id = ?", movement.ClientID).Scan(&activity.ClientSince)
+	if err != nil && err != sql.ErrNoRows {
+		return activity, err
+	}
+
+	// Transfers within the bank name the sending deposit as counterparty
+	var senderDepositID int64
+	if movement.Type == models.MovementTransferIn {
+		if _, err := fmt.Sscanf(movement.Counterparty, "deposit #%d", &senderDepositID); err == nil {
+			err := DB.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", senderDepositID).Scan(&activity.SenderClientID)
+			if err != nil && err != sql.ErrNoRows {
+				return activity, err
+			}
+		}
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+depositMovementColumns+`
+		FROM deposit_movements
+		WHERE client_id = ? AND id < ? AND created_at >= ? AND cancelled_at IS NULL
+		ORDER BY id
+	`, movement.ClientID, movement.ID, movement.CreatedAt.Add(-history))
+	if err != nil {
+		return activity, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var earlier models.DepositMovement
+		if err := scanDepositMovement(rows, &earlier); err != nil {
+			return activity, err
+		}
+		activity.History = append(activity.History, amlMovement(earlier))
+	}
+	return activity, rows.Err()
+}
+
+func amlMovement(movement models.DepositMovement) aml.Movement {
+	return aml.Movement{
+		ID:        movement.ID,
+		DepositID: movement.DepositID,
+		ClientID:  movement.ClientID,
+		Type:      movement.Type,
+		Amount:    movement.Amount,
+		CreatedAt: movement.CreatedAt,
+	}
+}
+
+// freezeForAMLAlert freezes the deposit of a high severity alert through FreezeDeposit and
+// records on the alert that it did, so dismissing the alert can lift the freeze again
+func freezeForAMLAlert(alertID, depositID int64) error {
+	deposit, err := getDepositByID(depositID)
+	if err != nil {
+		return err
+	}
+	if deposit.IsBlocked || deposit.IsFrozen || deposit.Status != models.DepositActive {
+		return nil
+	}
+	if err := FreezeDeposit(deposit.ClientID, deposit.BankName, depositID, amlFreezeDays); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("UPDATE aml_alerts SET deposit_frozen = 1 WHERE id = ?", alertID); err != nil {
+		return err
+	}
+
+	LogTransaction(deposit.ClientID, "aml_freeze", nil, fmt.Sprintf("Deposit %d frozen for %d days by AML alert #%d",
+		depositID, amlFreezeDays, alertID))
+	return nil
+}
+
+// amlAlertColumns lists the columns read by scanAMLAlert
+const amlAlertColumns = `id, rule, severity, reason, client_id, deposit_id, movement_id, COALESCE(transaction_id, 0),
+	amount, status, deposit_frozen, resolved_by, COALESCE(comment, ''), created_at, resolved_at`
+
+// scanAMLAlert reads an alert row selected with amlAlertColumns
+func scanAMLAlert(row interface{ Scan(...interface{}) error }, alert *AMLAlert) error {
+	var frozen int
+	var resolvedBy, resolvedAt sql.NullInt64
+	err := row.Scan(&alert.ID, &alert.Rule, &alert.Severity, &alert.Reason, &alert.ClientID, &alert.DepositID,
+		&alert.MovementID, &alert.TransactionID, &alert.Amount, &alert.Status, &frozen, &resolvedBy,
+		&alert.Comment, &alert.CreatedAt, &resolvedAt)
+	if err != nil {
+		return err
+	}
+	alert.DepositFrozen = frozen == 1
+	if resolvedBy.Valid {
+		alert.ResolvedBy = &resolvedBy.Int64
+	}
+	if resolvedAt.Valid {
+		alert.ResolvedAt = &resolvedAt.Int64
+	}
+	return nil
+}
+
+// GetAMLAlerts lists alerts, newest first. An empty status or a clientID of 0 does not filter.
+func GetAMLAlerts(status string, clientID int64) ([]AMLAlert, error) {
+	switch status {
+	case "", AMLAlertOpen, AMLAlertReported, AMLAlertDismissed:
+	default:
+		return nil, ErrInvalidAMLAlertStatus
+	}
+	if err := EnsureAMLTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+amlAlertColumns+`
+		FROM aml_alerts
+		WHERE (? = '' OR status = ?) AND (? = 0 OR client_id = ?)
+		ORDER BY created_at DESC, id DESC
+	`, status, status, clientID, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []AMLAlert{}
+	for rows.Next() {
+		var alert AMLAlert
+		if err := scanAMLAlert(rows, &alert); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// GetAMLAlert returns a single alert
+func GetAMLAlert(alertID int64) (*AMLAlert, error) {
+	if err := EnsureAMLTablesExist(); err != nil {
+		return nil, err
+	}
+	alert := &AMLAlert{}
+	row := DB.QueryRow("SELECT "+amlAlertColumns+" FROM aml_alerts WHERE id = ?", alertID)
+	if err := scanAMLAlert(row, alert); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAMLAlertNotFound
+		}
+		return nil, err
+	}
+	return alert, nil
+}
+
+// ReportAMLAlert confirms an alert as suspicious activity. A freeze it caused stays in place.
+func ReportAMLAlert(alertID, operatorID int64, comment string) error {
+	alert, err := resolveAMLAlert(alertID, operatorID, AMLAlertReported, comment, nil)
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(operatorID, "aml_alert_report", nil, fmt.Sprintf("AML alert #%d on deposit %d reported as suspicious: %s",
+		alert.ID, alert.DepositID, comment))
+	return nil
+}
+
+// DismissAMLAlert closes an alert as a false positive. If the alert froze the deposit the freeze
+// passes to the next open alert of the deposit, or is lifted when there is none.
+func DismissAMLAlert(alertID, operatorID int64, comment string) error {
+	alert, err := resolveAMLAlert(alertID, operatorID, AMLAlertDismissed, comment, func(tx *sql.Tx, alert *AMLAlert) error {
+		if !alert.DepositFrozen {
+			return nil
+		}
+		result, err := tx.Exec(`
+			UPDATE aml_alerts SET deposit_frozen = 1
+			WHERE id = (SELECT id FROM aml_alerts WHERE deposit_id = ? AND status = ? AND id != ? ORDER BY id LIMIT 1)
+		`, alert.DepositID, AMLAlertOpen, alert.ID)
+		if err != nil {
+			return err
+		}
+		if handedOver, err := result.RowsAffected(); err != nil || handedOver > 0 {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE deposits
+			SET is_frozen = 0, freeze_duration = 0, freeze_until = NULL, version = version + 1, updated_at = ?
+			WHERE deposit_id = ? AND is_frozen = 1
+		`, time.Now(), alert.DepositID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(operatorID, "aml_alert_dismiss", nil, fmt.Sprintf("AML alert #%d on deposit %d dismissed: %s",
+		alert.ID, alert.DepositID, comment))
+	return nil
+}
+
+// resolveAMLAlert moves an open alert to status and runs then inside the same transaction
+func resolveAMLAlert(alertID, operatorID int64, status, comment string, then func(*sql.Tx, *AMLAlert) error) (*AMLAlert, error) {
+	if err := EnsureAMLTablesExist(); err != nil {
+		return nil, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	alert := &AMLAlert{}
+	row := tx.QueryRow("SELECT "+amlAlertColumns+" FROM aml_alerts WHERE id = ?", alertID)
+	if err := scanAMLAlert(row, alert); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAMLAlertNotFound
+		}
+		return nil, err
+	}
+	if alert.Status != AMLAlertOpen {
+		return nil, ErrAMLAlertResolved
+	}
+
+	now := time.Now().Unix()
+	_, err = tx.Exec(`
+		UPDATE aml_alerts SET status = ?, resolved_by = ?, comment = ?, resolved_at = ? WHERE id = ?
+	`, status, operatorID, comment, now, alertID)
+	if err != nil {
+		return nil, err
+	}
+	if then != nil {
+		if err := then(tx, alert); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	alert.Status, alert.ResolvedBy, alert.Comment, alert.ResolvedAt = status, &operatorID, comment, &now
+	return alert, nil
+}
+
+// GetSuspiciousActivityReport returns the alerts reported as suspicious between from and to
+func GetSuspiciousActivityReport(from, to time.Time) ([]aml.SuspiciousActivity, error) {
+	if err := EnsureAMLTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT a.id, a.rule, a.severity, a.reason, a.client_id, COALESCE(u.username, ''), a.deposit_id,
+			COALESCE(d.bank_name, ''), a.movement_id, m.type, a.amount, COALESCE(m.counterparty, ''),
+			COALESCE(m.reference, ''), m.created_at, a.created_at, a.deposit_frozen, a.resolved_by,
+			COALESCE(a.comment, ''), a.resolved_at
+		FROM aml_alerts a
+		JOIN deposit_movements m ON m.id = a.movement_id
+		LEFT JOIN users u ON u.id = a.client_id
+		LEFT JOIN deposits d ON d.deposit_id = a.deposit_id
+		WHERE a.status = ? AND a.resolved_at >= ? AND a.resolved_at <= ?
+		ORDER BY a.resolved_at, a.id
+	`, AMLAlertReported, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	activities := []aml.SuspiciousActivity{}
+	for rows.Next() {
+		var activity aml.SuspiciousActivity
+		var detectedAt, reportedAt int64
+		var frozen int
+		err := rows.Scan(&activity.AlertID, &activity.Rule, &activity.Severity, &activity.Reason, &activity.ClientID,
+			&activity.Username, &activity.DepositID, &activity.BankName, &activity.MovementID, &activity.MovementType,
+			&activity.Amount, &activity.Counterparty, &activity.Reference, &activity.OccurredAt, &detectedAt, &frozen,
+			&activity.ReportedBy, &activity.Comment, &reportedAt)
+		if err != nil {
+			return nil, err
+		}
+		activity.DetectedAt = time.Unix(detectedAt, 0)
+		activity.ReportedAt = time.Unix(reportedAt, 0)
+		activity.DepositFrozen = frozen == 1
+		activities = append(activities, activity)
+	}
+	return activities, rows.Err()
+}
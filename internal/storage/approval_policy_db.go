@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operation types covered by approval policies
+const (
+	OperationLoan               = "loan"
+	OperationSalaryProject      = "salary_project"
+	OperationEnterpriseTransfer = "enterprise_transfer"
+)
+
+var (
+	// ErrAdditionalApprovalRequired is returned when an approval was recorded but the policy needs more approvers
+	ErrAdditionalApprovalRequired = errors.New("additional approval required")
+	// ErrSelfApproval is returned when the requester of an operation tries to approve it
+	ErrSelfApproval = errors.New("the requester of an operation cannot approve it")
+	// ErrApprovalPolicyNotFound is returned when no policy exists for an operation type
+	ErrApprovalPolicyNotFound = errors.New("approval policy not found")
+	// ErrInvalidOperationType is returned for an operation type that has no approval flow
+	ErrInvalidOperationType = errors.New("operation type must be one of loan, salary_project, enterprise_transfer")
+)
+
+// ApprovalPolicy defines how many distinct staff members must approve an operation
+// of the given type once its amount reaches MinAmount
+type ApprovalPolicy struct {
+	ID                int64   `json:"id"`
+	OperationType     string  `json:"operation_type"`
+	MinAmount         float64 `json:"min_amount"`
+	RequiredApprovers int     `json:"required_approvers"`
+	UpdatedBy         int64   `json:"updated_by"`
+	UpdatedAt         int64   `json:"updated_at"`
+}
+
+// OperationApproval is a single approval given by a staff member
+type OperationApproval struct {
+	ID            int64  `json:"id"`
+	OperationType string `json:"operation_type"`
+	OperationID   int64  `json:"operation_id"`
+	ApproverID    int64  `json:"approver_id"`
+	ApprovedAt    int64  `json:"approved_at"`
+}
+
+// ApprovalRequiredError tells how far an operation is from collecting its approvals.
+// It wraps ErrAdditionalApprovalRequired.
+type ApprovalRequiredError struct {
+	OperationType string
+	OperationID   int64
+	Approvals     int
+	Required      int
+}
+
+func (e *ApprovalRequiredError) Error() string {
+	return fmt.Sprintf("%s #%d has %d of %d required approvals", e.OperationType, e.OperationID, e.Approvals, e.Required)
+}
+
+func (e *ApprovalRequiredError) Unwrap() error {
+	return ErrAdditionalApprovalRequired
+}
+
+// IsValidOperationType reports whether operationType has an approval flow
+func IsValidOperationType(operationType string) bool {
+	switch operationType {
+	case OperationLoan, OperationSalaryProject, OperationEnterpriseTransfer:
+		return true
+	}
+	return false
+}
+
+// EnsureApprovalTablesExist creates the approval policy and approval tracking tables
+func EnsureApprovalTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS approval_policies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			operation_type TEXT NOT NULL UNIQUE,
+			min_amount REAL NOT NULL,
+			required_approvers INTEGER NOT NULL,
+			updated_by INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS operation_approvals (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			operation_type TEXT NOT NULL,
+			operation_id INTEGER NOT NULL,
+			approver_id INTEGER NOT NULL,
+			approved_at INTEGER NOT NULL,
+			UNIQUE(operation_type, operation_id, approver_id),
+			FOREIGN KEY (approver_id) REFERENCES users(id)
+		)
+	`)
+	return err
+}
+
+// SetApprovalPolicy creates or replaces the policy of an operation type
+func SetApprovalPolicy(policy *ApprovalPolicy) error {
+	if !IsValidOperationType(policy.OperationType) {
+		return ErrInvalidOperationType
+	}
+	if policy.MinAmount < 0 {
+		return errors.New("min_amount cannot be negative")
+	}
+	if policy.RequiredApprovers < 1 {
+		return errors.New("required_approvers must be at least 1")
+	}
+	if err := EnsureApprovalTablesExist(); err != nil {
+		return err
+	}
+
+	policy.UpdatedAt = time.Now().Unix()
+	_, err := DB.Exec(`
+		INSERT INTO approval_policies (operation_type, min_amount, required_approvers, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(operation_type) DO UPDATE SET
+			min_amount = excluded.min_amount,
+			required_approvers = excluded.required_approvers,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, policy.OperationType, policy.MinAmount, policy.RequiredApprovers, policy.UpdatedBy, policy.UpdatedAt)
+	if err != nil {
+		return err
+	}
+
+	if err := DB.QueryRow("SELECT id FROM approval_policies WHERE operation_type = ?", policy.OperationType).Scan(&policy.ID); err != nil {
+		return err
+	}
+
+	amount := policy.MinAmount
+	metadata := fmt.Sprintf("Approval policy for %s set to %d approvers from %.2f",
+		policy.OperationType, policy.RequiredApprovers, policy.MinAmount)
+	LogTransaction(policy.UpdatedBy, "approval_policy_update", &amount, metadata)
+
+	return nil
+}
+
+// GetApprovalPolicies returns the policies of all operation types
+func GetApprovalPolicies() ([]ApprovalPolicy, error) {
+	if err := EnsureApprovalTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, operation_type, min_amount, required_approvers, updated_by, updated_at
+		FROM approval_policies
+		ORDER BY operation_type
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []ApprovalPolicy{}
+	for rows.Next() {
+		var policy ApprovalPolicy
+		if err := rows.Scan(&policy.ID, &policy.OperationType, &policy.MinAmount,
+			&policy.RequiredApprovers, &policy.UpdatedBy, &policy.UpdatedAt); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
+
+// DeleteApprovalPolicy removes the policy of an operation type, so a single approval is enough again
+func DeleteApprovalPolicy(operationType string, adminID int64) error {
+	if err := EnsureApprovalTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec("DELETE FROM approval_policies WHERE operation_type = ?", operationType)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrApprovalPolicyNotFound
+	}
+
+	LogTransaction(adminID, "approval_policy_delete", nil, "Approval policy for "+operationType+" removed")
+	return nil
+}
+
+// GetOperationApprovals lists the approvals recorded for an operation
+func GetOperationApprovals(operationType string, operationID int64) ([]OperationApproval, error) {
+	if err := EnsureApprovalTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, operation_type, operation_id, approver_id, approved_at
+		FROM operation_approvals
+		WHERE operation_type = ? AND operation_id = ?
+		ORDER BY approved_at, id
+	`, operationType, operationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	approvals := []OperationApproval{}
+	for rows.Next() {
+		var approval OperationApproval
+		if err := rows.Scan(&approval.ID, &approval.OperationType, &approval.OperationID,
+			&approval.ApproverID, &approval.ApprovedAt); err != nil {
+			return nil, err
+		}
+		approvals = append(approvals, approval)
+	}
+	return approvals, rows.Err()
+}
+
+// requiredApprovers returns how many distinct approvers an operation of the given amount needs
+func requiredApprovers(operationType string, amount float64) (int, error) {
+	var minAmount float64
+	var required int
+	err := DB.QueryRow(`
+		SELECT min_amount, required_approvers FROM approval_policies WHERE operation_type = ?
+	`, operationType).Scan(&minAmount, &required)
+	if err == sql.ErrNoRows {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if amount < minAmount || required < 1 {
+		return 1, nil
+	}
+	return required, nil
+}
+
+// recordOperationApproval records the approval of approverID and checks it against the policy.
+// The requester can never approve their own operation. An approver approving twice is counted once.
+// It returns an *ApprovalRequiredError while the operation still lacks approvers.
+func recordOperationApproval(operationType string, operationID, requesterID, approverID int64, amount float64) error {
+	if requesterID == approverID {
+		return ErrSelfApproval
+	}
+	if err := EnsureApprovalTablesExist(); err != nil {
+		return err
+	}
+
+	required, err := requiredApprovers(operationType, amount)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		INSERT OR IGNORE INTO operation_approvals (operation_type, operation_id, approver_id, approved_at)
+		VALUES (?, ?, ?, ?)
+	`, operationType, operationID, approverID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	var approvals int
+	err = DB.QueryRow(`
+		SELECT COUNT(*) FROM operation_approvals
+		WHERE operation_type = ? AND operation_id = ? AND approver_id <> ?
+	`, operationType, operationID, requesterID).Scan(&approvals)
+	if err != nil {
+		return err
+	}
+
+	if approvals < required {
+		metadata := fmt.Sprintf("Approval %d of %d recorded for %s #%d", approvals, required, operationType, operationID)
+		LogTransaction(approverID, "operation_approval", &amount, metadata)
+		return &ApprovalRequiredError{
+			OperationType: operationType,
+			OperationID:   operationID,
+			Approvals:     approvals,
+			Required:      required,
+		}
+	}
+	return nil
+}
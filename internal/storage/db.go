@@ -2,9 +2,11 @@ package storage
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3" // SQLite3 driver
 )
@@ -29,8 +31,10 @@ func InitDB() {
 	}
 
 	var err error
-	// Open SQLite database
-	DB, err = sql.Open("sqlite3", dbPath)
+	// Open SQLite database. Transactions start with BEGIN IMMEDIATE so that a transaction moving
+	// money holds the write lock from its first read of a balance, and concurrent writers wait
+	// for the lock instead of failing with SQLITE_BUSY.
+	DB, err = sql.Open("sqlite3", dataSourceName(dbPath))
 	if err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
@@ -58,6 +62,54 @@ func InitDB() {
 	}
 }
 
+// busyTimeoutMillis is how long a connection waits for another connection's write lock
+const busyTimeoutMillis = 5000
+
+// dataSourceName adds the connection options the storage layer relies on to the database path
+func dataSourceName(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return fmt.Sprintf("%s%s_txlock=immediate&_busy_timeout=%d", dbPath, separator, busyTimeoutMillis)
+}
+
+// ensureColumnExists adds a column to an existing table when it is missing.
+// Tables are created lazily with CREATE TABLE IF NOT EXISTS, so databases created
+// by older versions need new columns added explicitly.
+func ensureColumnExists(table, column, definition string) error {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows.Close()
+
+	// Tables are ensured from request handlers and background jobs alike, so another goroutine
+	// may add the column between the check above and the ALTER
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil && strings.Contains(err.Error(), "duplicate column name") {
+		return nil
+	}
+	return err
+}
+
 // GetDB returns the database instance
 func GetDB() *sql.DB {
 	return DB
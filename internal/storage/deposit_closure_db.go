@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrClosurePayoutRequired is returned when a deposit with a balance is closed without saying where the money goes
+	ErrClosurePayoutRequired = errors.New("payout_deposit_id is required, or an operator must pay the balance out in cash")
+	// ErrDepositChanged is returned when the balance of a deposit changed while it was being closed
+	ErrDepositChanged = errors.New("deposit changed while it was being closed, try again")
+	// ErrClosureNotCancellable is returned when an operator tries to cancel a deposit closure
+	ErrClosureNotCancellable = errors.New("deposit closures cannot be cancelled")
+)
+
+// accruedInterest is the simple interest earned since interest was last credited: the start of
+// the current term for term deposits and the opening date for demand deposits
+func accruedInterest(deposit *models.Deposit, now time.Time) (float64, error) {
+	if deposit.Status != models.DepositActive {
+		return 0, nil
+	}
+	from := deposit.CreatedAt
+	if deposit.MaturityDate != nil {
+		from = deposit.MaturityDate.AddDate(0, -deposit.TermMonths, 0)
+	}
+	return interestOnBalances(deposit, from, now)
+}
+
+// interestOnBalances is the simple interest a deposit earned between from and to on the balances
+// it actually held. Every movement starts a new balance period, so money paid in late earns
+// interest only from the day it arrived. Balances are rebuilt backwards from the current one.
+func interestOnBalances(deposit *models.Deposit, from, to time.Time) (float64, error) {
+	if !to.After(from) {
+		return 0, nil
+	}
+	rows, err := DB.Query(`
+		SELECT `+depositMovementColumns+` FROM deposit_movements
+		WHERE deposit_id = ? AND created_at > ?
+		ORDER BY created_at DESC, id DESC
+	`, deposit.DepositID, from)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	balance := deposit.Amount
+	periodEnd := to
+	var balanceDays float64
+	for rows.Next() {
+		var movement models.DepositMovement
+		if err := scanDepositMovement(rows, &movement); err != nil {
+			return 0, err
+		}
+		if movement.CreatedAt.Before(periodEnd) {
+			balanceDays += math.Max(balance, 0) * periodEnd.Sub(movement.CreatedAt).Hours() / 24
+			periodEnd = movement.CreatedAt
+		}
+		balance -= movement.Signed()
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	balanceDays += math.Max(balance, 0) * periodEnd.Sub(from).Hours() / 24
+
+	interest := balanceDays * deposit.Interest / 100 / 365
+	return math.Round(interest*100) / 100, nil
+}
+
+// earlyClosurePenalty is the penalty of the product for closing a term deposit before maturity
+func earlyClosurePenalty(deposit *models.Deposit, product *models.DepositProduct, balance float64, now time.Time) float64 {
+	if product == nil || deposit.Status != models.DepositActive || deposit.MaturityDate == nil ||
+		!now.Before(*deposit.MaturityDate) {
+		return 0
+	}
+	return math.Round(balance*product.EarlyClosurePenalty) / 100
+}
+
+// CloseDeposit settles accrued interest and penalties of a deposit, pays the balance out and
+// marks the deposit closed. The row is kept for history and statements. Clients close their own
+// deposits into another deposit; tellers may also hand the balance out in cash.
+func CloseDeposit(closure models.DepositClosure) (*models.DepositClosureResult, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+
+	deposit, err := getDepositByID(closure.DepositID)
+	if err != nil {
+		return nil, err
+	}
+	if closure.TellerID == nil && deposit.ClientID != closure.ClientID {
+		return nil, ErrDepositNotFound
+	}
+	if deposit.Status == models.DepositClosed {
+		return nil, ErrDepositNotActive
+	}
+	// Pledged deposits are blocked, so they cannot be closed while they secure a loan
+	if deposit.IsBlocked || deposit.IsFrozen {
+		return nil, ErrDepositUnavailable
+	}
+	product, err := depositProductOf(deposit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	interest, err := accruedInterest(deposit, now)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.DepositClosureResult{
+		DepositID:       deposit.DepositID,
+		Balance:         deposit.Amount,
+		AccruedInterest: interest,
+		ClosedAt:        now,
+	}
+	result.Penalty = earlyClosurePenalty(deposit, product, deposit.Amount, now)
+	result.Payout = math.Max(0, math.Round((deposit.Amount+result.AccruedInterest-result.Penalty)*100)/100)
+
+	switch {
+	case closure.CashOut:
+		if closure.TellerID == nil || strings.TrimSpace(closure.Branch) == "" {
+			return nil, errors.New("cash-out is only available at the cash desk of a branch")
+		}
+		result.CashOut = true
+	case closure.PayoutDepositID != nil:
+		if *closure.PayoutDepositID == deposit.DepositID {
+			return nil, errors.New("payout deposit must be another deposit")
+		}
+		target, err := getDepositByID(*closure.PayoutDepositID)
+		if err != nil || target.ClientID != deposit.ClientID || target.Status != models.DepositActive {
+			return nil, errors.New("payout deposit not found among the client's active deposits")
+		}
+		if target.IsBlocked || target.IsFrozen {
+			return nil, ErrDepositUnavailable
+		}
+		targetProduct, err := depositProductOf(target)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkDepositTopUp(target, targetProduct); err != nil {
+			return nil, err
+		}
+		result.PayoutDepositID = closure.PayoutDepositID
+	case result.Payout > 0:
+		return nil, ErrClosurePayoutRequired
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The payout deposit is kept on the closed deposit, so that money returned to it later
+	// follows the balance; a cash payout clears it
+	var payoutDepositID interface{}
+	if result.PayoutDepositID != nil {
+		payoutDepositID = *result.PayoutDepositID
+	}
+	update, err := tx.Exec(`
+		UPDATE deposits SET amount = 0, status = ?, closed_at = ?, payout_deposit_id = ?, version = version + 1, updated_at = ?
+		WHERE deposit_id = ? AND status = ? AND amount = ? AND is_blocked = 0 AND is_frozen = 0
+	`, models.DepositClosed, now, payoutDepositID, now, deposit.DepositID, deposit.Status, deposit.Amount)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := update.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, ErrDepositChanged
+	}
+
+	// Interest and penalty are settled first, then the remaining balance is paid out
+	reference := fmt.Sprintf("closure of deposit #%d", deposit.DepositID)
+	movements := []*models.DepositMovement{
+		{Type: models.MovementInterest, Amount: result.AccruedInterest},
+		{Type: models.MovementFee, Amount: math.Round((deposit.Amount+result.AccruedInterest-result.Payout)*100) / 100},
+	}
+	var cashOut *models.DepositMovement
+	if result.PayoutDepositID != nil {
+		if err := creditDepositTx(tx, *result.PayoutDepositID, result.Payout, now); err != nil {
+			return nil, err
+		}
+		movements = append(movements, &models.DepositMovement{
+			Type: models.MovementTransferOut, Amount: result.Payout, Counterparty: fmt.Sprintf("deposit #%d", *result.PayoutDepositID),
+		})
+		if err := recordDepositMovementTx(tx, &models.DepositMovement{
+			DepositID: *result.PayoutDepositID, ClientID: deposit.ClientID, Type: models.MovementTransferIn, Amount: result.Payout,
+			Counterparty: fmt.Sprintf("deposit #%d", deposit.DepositID), Reference: reference, CreatedAt: now,
+		}); err != nil {
+			return nil, err
+		}
+	} else if result.CashOut {
+		cashOut = &models.DepositMovement{
+			Type: models.MovementWithdrawal, Amount: result.Payout, Channel: models.ChannelCashDesk,
+			TellerID: closure.TellerID, Branch: closure.Branch,
+		}
+		movements = append(movements, cashOut)
+	}
+	for _, movement := range movements {
+		if movement.Amount == 0 {
+			continue
+		}
+		movement.DepositID = deposit.DepositID
+		movement.ClientID = deposit.ClientID
+		movement.Reference = reference
+		movement.CreatedAt = now
+		if err := recordDepositMovementTx(tx, movement); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	metadata := fmt.Sprintf("Closed deposit %d in %s: balance %.2f, interest %.2f, penalty %.2f, paid out %.2f",
+		deposit.DepositID, deposit.BankName, deposit.Amount, result.AccruedInterest, result.Penalty, result.Payout)
+	switch {
+	case result.PayoutDepositID != nil:
+		metadata += fmt.Sprintf(" to deposit %d", *result.PayoutDepositID)
+	case result.CashOut:
+		metadata += fmt.Sprintf(" in cash by teller %d at branch %s", *closure.TellerID, closure.Branch)
+	}
+	payout := result.Payout
+	transactionID, err := LogTransaction(deposit.ClientID, "close", &payout, metadata)
+	if err != nil {
+		return result, fmt.Errorf("deposit %d closed but not logged: %w", deposit.DepositID, err)
+	}
+	if cashOut != nil && cashOut.ID != 0 {
+		if _, err := DB.Exec("UPDATE deposit_movements SET transaction_id = ? WHERE id = ?", transactionID, cashOut.ID); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
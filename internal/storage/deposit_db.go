@@ -25,8 +25,39 @@ func EnsureDepositsTableExists() error {
 			UNIQUE(client_id, bank_name, deposit_id)
 		)
 	`
-	_, err := DB.Exec(createTableQuery)
-	return err
+	if _, err := DB.Exec(createTableQuery); err != nil {
+		return err
+	}
+
+	// Product rules, term and maturity instruction of the deposit
+	if err := ensureColumnExists("deposits", "product_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "status", "TEXT NOT NULL DEFAULT 'active'"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "term_months", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "maturity_date", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "maturity_instruction", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "payout_deposit_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposits", "closed_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureDepositBalanceGuards(); err != nil {
+		return err
+	}
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return err
+	}
+	return ensureDepositMovementsTableExists()
 }
 
 // SaveDeposit stores a new deposit in the database
@@ -70,20 +101,18 @@ func SaveDeposit(deposit models.Deposit) error {
 	return nil
 }
 
-// GetDeposit retrieves a deposit by its ID, client ID, and bank name
-func GetDeposit(clientID int64, bankName string, depositID int64) (models.Deposit, error) {
-	var deposit models.Deposit
-
-	query := `
-		SELECT deposit_id, client_id, bank_name, amount, interest, 
-		       is_blocked, is_frozen, freeze_duration, freeze_until
-		FROM deposits
-		WHERE client_id = ? AND bank_name = ? AND deposit_id = ?
-	`
+// depositColumns lists the columns read by scanDeposit
+const depositColumns = `
+	deposit_id, client_id, bank_name, amount, interest, is_blocked, is_frozen, freeze_duration, freeze_until,
+	product_id, status, term_months, maturity_date, maturity_instruction, payout_deposit_id, created_at, closed_at`
 
+// scanDeposit reads a deposit row selected with depositColumns
+func scanDeposit(row interface{ Scan(...interface{}) error }, deposit *models.Deposit) error {
 	var isBlocked, isFrozen int
-	var freezeUntil sql.NullTime
-	err := DB.QueryRow(query, clientID, bankName, depositID).Scan(
+	var freezeUntil, maturityDate, closedAt sql.NullTime
+	var productID, payoutDepositID sql.NullInt64
+	var maturityInstruction sql.NullString
+	err := row.Scan(
 		&deposit.DepositID,
 		&deposit.ClientID,
 		&deposit.BankName,
@@ -93,21 +122,59 @@ func GetDeposit(clientID int64, bankName string, depositID int64) (models.Deposi
 		&isFrozen,
 		&deposit.FreezeDuration,
 		&freezeUntil,
+		&productID,
+		&deposit.Status,
+		&deposit.TermMonths,
+		&maturityDate,
+		&maturityInstruction,
+		&payoutDepositID,
+		&deposit.CreatedAt,
+		&closedAt,
 	)
-
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return deposit, errors.New("deposit not found")
-		}
-		return deposit, err
+		return err
 	}
 
 	deposit.IsBlocked = isBlocked == 1
 	deposit.IsFrozen = isFrozen == 1
-
 	if freezeUntil.Valid {
 		deposit.FreezeUntil = freezeUntil.Time
 	}
+	if productID.Valid {
+		deposit.ProductID = &productID.Int64
+	}
+	if maturityDate.Valid {
+		deposit.MaturityDate = &maturityDate.Time
+	}
+	deposit.MaturityInstruction = maturityInstruction.String
+	if payoutDepositID.Valid {
+		deposit.PayoutDepositID = &payoutDepositID.Int64
+	}
+	if closedAt.Valid {
+		deposit.ClosedAt = &closedAt.Time
+	}
+	return nil
+}
+
+// GetDeposit retrieves a deposit by its ID, client ID, and bank name
+func GetDeposit(clientID int64, bankName string, depositID int64) (models.Deposit, error) {
+	var deposit models.Deposit
+	if err := EnsureDepositsTableExists(); err != nil {
+		return deposit, err
+	}
+
+	query := `
+		SELECT ` + depositColumns + `
+		FROM deposits
+		WHERE client_id = ? AND bank_name = ? AND deposit_id = ?
+	`
+	err := scanDeposit(DB.QueryRow(query, clientID, bankName, depositID), &deposit)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return deposit, errors.New("deposit not found")
+		}
+		return deposit, err
+	}
 
 	return deposit, nil
 }
@@ -119,18 +186,7 @@ func GetDepositsByUserID(userID int64) ([]models.Deposit, error) {
 	}
 
 	query := `
-		SELECT 
-			deposit_id, 
-			client_id, 
-			bank_name, 
-			amount, 
-			interest, 
-			is_blocked, 
-			is_frozen, 
-			freeze_duration, 
-			freeze_until,
-			created_at,
-			updated_at
+		SELECT ` + depositColumns + `
 		FROM deposits 
 		WHERE client_id = ?
 		ORDER BY created_at DESC
@@ -145,34 +201,9 @@ func GetDepositsByUserID(userID int64) ([]models.Deposit, error) {
 	deposits := []models.Deposit{}
 	for rows.Next() {
 		var deposit models.Deposit
-		var isBlocked, isFrozen int
-		var freezeUntil sql.NullTime
-		var createdAt, updatedAt time.Time
-
-		err := rows.Scan(
-			&deposit.DepositID,
-			&deposit.ClientID,
-			&deposit.BankName,
-			&deposit.Amount,
-			&deposit.Interest,
-			&isBlocked,
-			&isFrozen,
-			&deposit.FreezeDuration,
-			&freezeUntil,
-			&createdAt,
-			&updatedAt,
-		)
-
-		if err != nil {
+		if err := scanDeposit(rows, &deposit); err != nil {
 			return nil, fmt.Errorf("error scanning deposit row: %v", err)
 		}
-
-		deposit.IsBlocked = isBlocked == 1
-		deposit.IsFrozen = isFrozen == 1
-		if freezeUntil.Valid {
-			deposit.FreezeUntil = freezeUntil.Time
-		}
-
 		deposits = append(deposits, deposit)
 	}
 
@@ -183,30 +214,6 @@ func GetDepositsByUserID(userID int64) ([]models.Deposit, error) {
 	return deposits, nil
 }
 
-// DeleteDeposit removes a deposit from the database
-func DeleteDeposit(clientID int64, bankName string) error {
-	if err := EnsureDepositsTableExists(); err != nil {
-		return err
-	}
-
-	query := `DELETE FROM deposits WHERE client_id = ? AND bank_name = ?`
-	result, err := DB.Exec(query, clientID, bankName)
-	if err != nil {
-		return err
-	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return err
-	}
-
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
-	}
-
-	return nil
-}
-
 // BlockDeposit marks a deposit as blocked
 func BlockDeposit(clientID int64, bankName string, depositID int64) error {
 	deposit, err := GetDeposit(clientID, bankName, depositID)
@@ -220,7 +227,7 @@ func BlockDeposit(clientID int64, bankName string, depositID int64) error {
 
 	query := `
 		UPDATE deposits 
-		SET is_blocked = 1, updated_at = ?
+		SET is_blocked = 1, version = version + 1, updated_at = ?
 		WHERE client_id = ? AND bank_name = ? AND deposit_id = ?
 	`
 
@@ -239,9 +246,18 @@ func UnblockDeposit(clientID int64, bankName string, depositID int64) error {
 		return sql.ErrNoRows
 	}
 
+	// A deposit securing a loan stays blocked until the loan releases it
+	pledged, err := isDepositPledged(depositID)
+	if err != nil {
+		return err
+	}
+	if pledged {
+		return ErrDepositPledged
+	}
+
 	query := `
 		UPDATE deposits 
-		SET is_blocked = 0, updated_at = ?
+		SET is_blocked = 0, version = version + 1, updated_at = ?
 		WHERE client_id = ? AND bank_name = ? AND deposit_id = ?
 	`
 
@@ -269,7 +285,7 @@ func FreezeDeposit(clientID int64, bankName string, depositID int64, freezeDurat
 
 	query := `
 		UPDATE deposits 
-		SET is_frozen = 1, freeze_duration = ?, freeze_until = ?, updated_at = ?
+		SET is_frozen = 1, freeze_duration = ?, freeze_until = ?, version = version + 1, updated_at = ?
 		WHERE client_id = ? AND bank_name = ? AND deposit_id = ?
 	`
 
@@ -286,11 +302,41 @@ func FreezeDeposit(clientID int64, bankName string, depositID int64, freezeDurat
 	return err
 }
 
-// TransferBetweenAccounts transfers funds between accounts
+// TransferBetweenAccounts transfers funds from a deposit of the client to another deposit.
+// Ownership, blocks, freezes and the balance are checked inside the transaction that moves the
+// money, and the debit only applies to the deposit state those checks were made on. So are the
+// transfer limits of the client, and the transfer is logged in the same transaction.
 func TransferBetweenAccounts(transfer models.Transfer) error {
 	if err := EnsureDepositsTableExists(); err != nil {
 		return err
 	}
+	if err := ensureLimitCheckTablesExist(); err != nil {
+		return err
+	}
+
+	// The products of both deposits decide whether money may leave or enter them
+	from, err := getDepositByID(transfer.FromDepositID)
+	if err != nil {
+		if err == ErrDepositNotFound {
+			return ErrTransferSourceNotFound
+		}
+		return err
+	}
+	to, err := getDepositByID(transfer.ToDepositID)
+	if err != nil {
+		if err == ErrDepositNotFound {
+			return ErrTransferDestinationNotFound
+		}
+		return err
+	}
+	fromProduct, err := depositProductOf(from)
+	if err != nil {
+		return err
+	}
+	toProduct, err := depositProductOf(to)
+	if err != nil {
+		return err
+	}
 
 	// Start a transaction
 	tx, err := DB.Begin()
@@ -299,60 +345,78 @@ func TransferBetweenAccounts(transfer models.Transfer) error {
 	}
 	defer tx.Rollback()
 
-	// Check if source deposit exists and has sufficient funds
-	var sourceAmount float64
-	sourceQuery := `
-		SELECT amount FROM deposits 
-		WHERE deposit_id = ?
-	`
-	err = tx.QueryRow(sourceQuery, transfer.FromDepositID).Scan(&sourceAmount)
+	source, err := getDepositBalanceTx(tx, transfer.FromDepositID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.New("source deposit not found")
+		if err == ErrDepositNotFound {
+			return ErrTransferSourceNotFound
 		}
 		return err
 	}
-
-	if sourceAmount < transfer.Amount {
-		return ErrInsufficientFunds
+	if source.ClientID != transfer.ClientID {
+		return ErrTransferSourceNotFound
 	}
-
-	// Check if destination deposit exists
-	var destAmount float64
-	destQuery := `
-		SELECT amount FROM deposits 
-		WHERE deposit_id = ?
-	`
-	err = tx.QueryRow(destQuery, transfer.ToDepositID).Scan(&destAmount)
+	destination, err := getDepositBalanceTx(tx, transfer.ToDepositID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.New("destination deposit not found")
+		if err == ErrDepositNotFound {
+			return ErrTransferDestinationNotFound
 		}
 		return err
 	}
+	if !source.Available() || !destination.Available() {
+		return ErrDepositUnavailable
+	}
+
+	from.Status = source.Status
+	to.Status = destination.Status
+	if err := checkDepositTopUp(to, toProduct); err != nil {
+		return err
+	}
+	if source.Amount < transfer.Amount {
+		return ErrInsufficientFunds
+	}
+	if err := checkDepositWithdrawal(from, fromProduct, source.Amount, transfer.Amount); err != nil {
+		return err
+	}
+	if err := checkTransactionLimitTx(tx, source.ClientID, LimitOperationTransfer, from.ProductID, transfer.Amount); err != nil {
+		return err
+	}
 
 	now := time.Now()
+	if err := debitDepositTx(tx, source, transfer.Amount, now); err != nil {
+		return err
+	}
+	if err := creditDepositTx(tx, destination.DepositID, transfer.Amount, now); err != nil {
+		return err
+	}
 
-	// Update source deposit
-	_, err = tx.Exec(`
-		UPDATE deposits SET amount = amount - ?, updated_at = ?
-		WHERE deposit_id = ?
-	`, transfer.Amount, now, transfer.FromDepositID)
-	if err != nil {
+	amount := transfer.Amount
+	metadata := fmt.Sprintf("Transfer from deposit %d to deposit %d", transfer.FromDepositID, transfer.ToDepositID)
+	if _, err := logTransactionTx(tx, source.ClientID, LimitOperationTransfer, &amount, metadata); err != nil {
 		return err
 	}
 
-	// Update destination deposit
-	_, err = tx.Exec(`
-		UPDATE deposits SET amount = amount + ?, updated_at = ?
-		WHERE deposit_id = ?
-	`, transfer.Amount, now, transfer.ToDepositID)
+	// Both sides of the transfer go to the deposit ledger
+	err = recordDepositMovementTx(tx, &models.DepositMovement{
+		DepositID: transfer.FromDepositID, ClientID: source.ClientID, Type: models.MovementTransferOut, Amount: transfer.Amount,
+		Channel: models.ChannelOnline, Counterparty: fmt.Sprintf("deposit #%d", transfer.ToDepositID), CreatedAt: now,
+	})
+	if err != nil {
+		return err
+	}
+	err = recordDepositMovementTx(tx, &models.DepositMovement{
+		DepositID: transfer.ToDepositID, ClientID: destination.ClientID, Type: models.MovementTransferIn, Amount: transfer.Amount,
+		Channel: models.ChannelOnline, Counterparty: fmt.Sprintf("deposit #%d", transfer.FromDepositID), CreatedAt: now,
+	})
 	if err != nil {
 		return err
 	}
 
 	// Commit transaction
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	transactionLogged(source.ClientID, LimitOperationTransfer, &amount, metadata)
+	return nil
 }
 
 // boolToInt converts a boolean to an integer (1 for true, 0 for false)
@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDepositNotFound is returned when a deposit does not exist or belongs to another client
+	ErrDepositNotFound = errors.New("deposit not found")
+	// ErrDepositWithdrawalNotAllowed is returned when the product does not allow taking money out before maturity
+	ErrDepositWithdrawalNotAllowed = errors.New("deposit product does not allow withdrawals before maturity")
+	// ErrDepositTopUpNotAllowed is returned when the product does not accept additional funds
+	ErrDepositTopUpNotAllowed = errors.New("deposit product does not allow top-ups")
+	// ErrDepositMinBalance is returned when a withdrawal would leave less than the product minimum balance
+	ErrDepositMinBalance = errors.New("withdrawal would leave less than the minimum balance of the deposit product")
+)
+
+// OpenDeposit opens a deposit under the current version of a product. The rate comes from
+// the product tiers; term deposits get a maturity date and an instruction for maturity.
+func OpenDeposit(request models.DepositRequest) (*models.Deposit, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(request.BankName) == "" {
+		return nil, errors.New("bank_name is required")
+	}
+	if request.Amount <= 0 {
+		return nil, errors.New("amount must be greater than 0")
+	}
+
+	now := time.Now()
+	product, rate, err := selectDepositProduct(request.ProductCode, request.TermMonths, request.Amount, now)
+	if err != nil {
+		return nil, err
+	}
+
+	deposit := &models.Deposit{
+		ClientID:   request.ClientID,
+		BankName:   request.BankName,
+		Amount:     request.Amount,
+		Interest:   rate,
+		ProductID:  &product.ID,
+		Status:     models.DepositActive,
+		TermMonths: request.TermMonths,
+	}
+	if product.Type == models.TermDeposit {
+		maturityDate := now.AddDate(0, request.TermMonths, 0)
+		deposit.MaturityDate = &maturityDate
+		deposit.MaturityInstruction = request.MaturityInstruction
+		if deposit.MaturityInstruction == "" {
+			deposit.MaturityInstruction = models.MaturityPayout
+		}
+		if err := validateMaturityInstruction(product, request.ClientID, 0, deposit.MaturityInstruction, request.PayoutDepositID); err != nil {
+			return nil, err
+		}
+		deposit.PayoutDepositID = request.PayoutDepositID
+	}
+
+	var maturityInstruction interface{}
+	if deposit.MaturityInstruction != "" {
+		maturityInstruction = deposit.MaturityInstruction
+	}
+	var payoutDepositID interface{}
+	if deposit.PayoutDepositID != nil {
+		payoutDepositID = *deposit.PayoutDepositID
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO deposits (
+			client_id, bank_name, amount, interest, is_blocked, is_frozen, freeze_duration,
+			product_id, status, term_months, maturity_date, maturity_instruction, payout_deposit_id,
+			created_at, updated_at
+		) VALUES (?, ?, ?, ?, 0, 0, 0, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, deposit.ClientID, deposit.BankName, deposit.Amount, deposit.Interest,
+		product.ID, deposit.Status, deposit.TermMonths, deposit.MaturityDate, maturityInstruction, payoutDepositID,
+		now, now)
+	if err != nil {
+		return nil, err
+	}
+	if deposit.DepositID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	deposit.CreatedAt = now
+	err = recordDepositMovementTx(tx, &models.DepositMovement{
+		DepositID: deposit.DepositID,
+		ClientID:  deposit.ClientID,
+		Type:      models.MovementOpening,
+		Amount:    deposit.Amount,
+		Channel:   models.ChannelOnline,
+		Reference: product.Code,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return deposit, nil
+}
+
+// validateMaturityInstruction checks a maturity instruction against the product. A payout deposit
+// must be another active deposit of the client; without one the funds stay in the matured deposit.
+func validateMaturityInstruction(product *models.DepositProduct, clientID, depositID int64, instruction string, payoutDepositID *int64) error {
+	switch instruction {
+	case models.MaturityPayout:
+	case models.MaturityRollover:
+		if !product.AutoRollover {
+			return errors.New("deposit product does not offer rollover")
+		}
+	default:
+		return errors.New("maturity_instruction must be payout or rollover")
+	}
+
+	if payoutDepositID == nil {
+		return nil
+	}
+	if *payoutDepositID == depositID {
+		return errors.New("payout deposit must be another deposit")
+	}
+	payout, err := getDepositByID(*payoutDepositID)
+	if err != nil || payout.ClientID != clientID || payout.Status != models.DepositActive {
+		return errors.New("payout deposit not found among your active deposits")
+	}
+	return nil
+}
+
+// SetMaturityInstruction changes what happens to a term deposit of the client at maturity
+func SetMaturityInstruction(clientID, depositID int64, instruction string, payoutDepositID *int64) error {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return err
+	}
+	deposit, err := getDepositByID(depositID)
+	if err != nil || deposit.ClientID != clientID {
+		return ErrDepositNotFound
+	}
+	product, err := depositProductOf(deposit)
+	if err != nil {
+		return err
+	}
+	if product == nil || product.Type != models.TermDeposit || deposit.Status != models.DepositActive {
+		return errors.New("only active term deposits have a maturity instruction")
+	}
+	if err := validateMaturityInstruction(product, clientID, depositID, instruction, payoutDepositID); err != nil {
+		return err
+	}
+
+	var payout interface{}
+	if payoutDepositID != nil {
+		payout = *payoutDepositID
+	}
+	_, err = DB.Exec(`
+		UPDATE deposits SET maturity_instruction = ?, payout_deposit_id = ?, updated_at = ? WHERE deposit_id = ?
+	`, instruction, payout, time.Now(), depositID)
+	return err
+}
+
+// getDepositByID returns a deposit regardless of its owner
+func getDepositByID(depositID int64) (*models.Deposit, error) {
+	deposit := &models.Deposit{}
+	row := DB.QueryRow("SELECT "+depositColumns+" FROM deposits WHERE deposit_id = ?", depositID)
+	if err := scanDeposit(row, deposit); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDepositNotFound
+		}
+		return nil, err
+	}
+	return deposit, nil
+}
+
+// depositProductOf returns the product version of a deposit, or nil for deposits opened
+// before the product catalog
+func depositProductOf(deposit *models.Deposit) (*models.DepositProduct, error) {
+	if deposit.ProductID == nil {
+		return nil, nil
+	}
+	return GetDepositProduct(*deposit.ProductID)
+}
+
+// checkDepositWithdrawal applies the product rules to taking amount out of a deposit
+// whose balance is balance. Matured deposits and deposits without a product have no rules;
+// closed deposits refuse every movement.
+func checkDepositWithdrawal(deposit *models.Deposit, product *models.DepositProduct, balance, amount float64) error {
+	if deposit.Status == models.DepositClosed {
+		return ErrDepositNotActive
+	}
+	if product == nil || deposit.Status == models.DepositMatured {
+		return nil
+	}
+	if !product.AllowWithdrawal {
+		return ErrDepositWithdrawalNotAllowed
+	}
+	if balance-amount < product.MinBalance {
+		return ErrDepositMinBalance
+	}
+	return nil
+}
+
+// checkDepositTopUp applies the product rules to paying money into a deposit
+func checkDepositTopUp(deposit *models.Deposit, product *models.DepositProduct) error {
+	if deposit.Status == models.DepositClosed {
+		return ErrDepositNotActive
+	}
+	if deposit.Status == models.DepositMatured {
+		return ErrDepositTopUpNotAllowed
+	}
+	if product != nil && !product.AllowTopUp {
+		return ErrDepositTopUpNotAllowed
+	}
+	return nil
+}
+
+// termInterest is the simple interest earned by a term deposit over its term, on the balances it
+// held during the term
+func termInterest(deposit *models.Deposit) (float64, error) {
+	return interestOnBalances(deposit, deposit.MaturityDate.AddDate(0, -deposit.TermMonths, 0), *deposit.MaturityDate)
+}
+
+// ProcessMaturedDeposits settles the term deposits that reached maturity. Each deposit is
+// rolled over or paid out as the client instructed.
+func ProcessMaturedDeposits(now time.Time) error {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+depositColumns+` FROM deposits
+		WHERE status = ? AND maturity_date IS NOT NULL AND maturity_date <= ?
+		ORDER BY maturity_date
+	`, models.DepositActive, now)
+	if err != nil {
+		return err
+	}
+	var matured []*models.Deposit
+	for rows.Next() {
+		deposit := &models.Deposit{}
+		if err := scanDeposit(rows, deposit); err != nil {
+			rows.Close()
+			return err
+		}
+		matured = append(matured, deposit)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, deposit := range matured {
+		if err := settleMaturedDeposit(deposit, now); err != nil {
+			return fmt.Errorf("deposit %d: %w", deposit.DepositID, err)
+		}
+	}
+	return nil
+}
+
+// settleMaturedDeposit credits the interest of the finished term and then renews the deposit
+// at the current rate of its product, or pays it out. A rollover falls back to a payout when the
+// product no longer offers the term. Blocked or frozen funds are never moved to another deposit.
+func settleMaturedDeposit(deposit *models.Deposit, now time.Time) error {
+	interest, err := termInterest(deposit)
+	if err != nil {
+		return err
+	}
+	balance := deposit.Amount + interest
+
+	var renewal *models.DepositProduct
+	var renewalRate float64
+	if deposit.MaturityInstruction == models.MaturityRollover {
+		product, err := depositProductOf(deposit)
+		if err != nil {
+			return err
+		}
+		if product != nil {
+			renewal, renewalRate, err = selectDepositProduct(product.Code, deposit.TermMonths, balance, now)
+			if err != nil && !errors.Is(err, ErrDepositProductNotFound) && !errors.Is(err, ErrDepositTermsNotOffered) {
+				return err
+			}
+			if renewal != nil && !renewal.AutoRollover {
+				renewal = nil
+			}
+		}
+	}
+
+	var payout *models.Deposit
+	if renewal == nil && deposit.PayoutDepositID != nil && !deposit.IsBlocked && !deposit.IsFrozen {
+		target, err := getDepositByID(*deposit.PayoutDepositID)
+		if err != nil && !errors.Is(err, ErrDepositNotFound) {
+			return err
+		}
+		if target != nil && target.ClientID == deposit.ClientID && target.Status == models.DepositActive &&
+			!target.IsBlocked && !target.IsFrozen {
+			payout = target
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	var metadata string
+	switch {
+	case renewal != nil:
+		nextMaturity := deposit.MaturityDate.AddDate(0, deposit.TermMonths, 0)
+		result, err = tx.Exec(`
+			UPDATE deposits SET amount = ?, interest = ?, product_id = ?, maturity_date = ?, version = version + 1, updated_at = ?
+			WHERE deposit_id = ? AND status = ? AND maturity_date = ?
+		`, balance, renewalRate, renewal.ID, nextMaturity, now, deposit.DepositID, models.DepositActive, deposit.MaturityDate)
+		metadata = fmt.Sprintf("Deposit %d rolled over for %d months at %.2f%% until %s, interest %.2f",
+			deposit.DepositID, deposit.TermMonths, renewalRate, nextMaturity.Format("2006-01-02"), interest)
+	case payout != nil:
+		result, err = tx.Exec(`
+			UPDATE deposits SET amount = 0, status = ?, version = version + 1, updated_at = ?
+			WHERE deposit_id = ? AND status = ? AND maturity_date = ?
+		`, models.DepositMatured, now, deposit.DepositID, models.DepositActive, deposit.MaturityDate)
+		if err == nil {
+			err = creditDepositTx(tx, payout.DepositID, balance, now)
+		}
+		metadata = fmt.Sprintf("Deposit %d matured, %.2f including interest %.2f paid to deposit %d",
+			deposit.DepositID, balance, interest, payout.DepositID)
+	default:
+		result, err = tx.Exec(`
+			UPDATE deposits SET amount = ?, status = ?, version = version + 1, updated_at = ?
+			WHERE deposit_id = ? AND status = ? AND maturity_date = ?
+		`, balance, models.DepositMatured, now, deposit.DepositID, models.DepositActive, deposit.MaturityDate)
+		metadata = fmt.Sprintf("Deposit %d matured with %.2f including interest %.2f", deposit.DepositID, balance, interest)
+	}
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		// Settled by a concurrent run
+		return nil
+	}
+
+	reference := "maturity " + deposit.MaturityDate.Format("2006-01-02")
+	movements := []*models.DepositMovement{{
+		DepositID: deposit.DepositID, ClientID: deposit.ClientID, Type: models.MovementInterest,
+		Amount: interest, Reference: reference, CreatedAt: now,
+	}}
+	if payout != nil {
+		movements = append(movements,
+			&models.DepositMovement{
+				DepositID: deposit.DepositID, ClientID: deposit.ClientID, Type: models.MovementTransferOut, Amount: balance,
+				Counterparty: fmt.Sprintf("deposit #%d", payout.DepositID), Reference: reference, CreatedAt: now,
+			},
+			&models.DepositMovement{
+				DepositID: payout.DepositID, ClientID: payout.ClientID, Type: models.MovementTransferIn, Amount: balance,
+				Counterparty: fmt.Sprintf("deposit #%d", deposit.DepositID), Reference: reference, CreatedAt: now,
+			})
+	}
+	for _, movement := range movements {
+		if movement.Amount == 0 {
+			continue
+		}
+		if err := recordDepositMovementTx(tx, movement); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	LogTransaction(deposit.ClientID, "deposit_maturity", &interest, metadata)
+	return nil
+}
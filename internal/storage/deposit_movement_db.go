@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDepositUnavailable is returned when money cannot be moved because the deposit is blocked or frozen
+	ErrDepositUnavailable = errors.New("deposit is blocked or frozen")
+	// ErrDepositNotActive is returned for operations on a deposit that is no longer active
+	ErrDepositNotActive = errors.New("deposit is not active")
+	// ErrMovementReversalFunds is returned when a top-up cannot be cancelled because the money was already spent
+	ErrMovementReversalFunds = errors.New("deposit balance is lower than the top-up being cancelled")
+	// ErrInvalidMovementAmount is returned for top-ups and withdrawals that do not move a positive amount
+	ErrInvalidMovementAmount = errors.New("amount must be greater than 0")
+)
+
+// signedMovementAmount is the SQL expression of a deposit movement as a change of the balance
+var signedMovementAmount = "CASE WHEN type IN ('" + strings.Join(models.CreditMovementTypes(), "', '") + "') THEN amount ELSE -amount END"
+
+// ensureDepositMovementsTableExists creates the ledger of every change of deposit balances
+func ensureDepositMovementsTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS deposit_movements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			deposit_id INTEGER NOT NULL,
+			client_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			amount REAL NOT NULL,
+			channel TEXT NOT NULL,
+			teller_id INTEGER,
+			branch TEXT,
+			transaction_id INTEGER,
+			created_at TIMESTAMP NOT NULL,
+			cancelled_at TIMESTAMP,
+			FOREIGN KEY (deposit_id) REFERENCES deposits(deposit_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposit_movements", "counterparty", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("deposit_movements", "reference", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec(`CREATE INDEX IF NOT EXISTS idx_deposit_movements_transaction ON deposit_movements(transaction_id)`); err != nil {
+		return err
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_deposit_movements_deposit ON deposit_movements(deposit_id, created_at)`)
+	return err
+}
+
+// recordDepositMovementTx adds an entry to the ledger of a deposit. It does not change the
+// balance; callers update deposits.amount in the same transaction.
+func recordDepositMovementTx(tx *sql.Tx, movement *models.DepositMovement) error {
+	if movement.ClientID == 0 {
+		if err := tx.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", movement.DepositID).Scan(&movement.ClientID); err != nil {
+			return err
+		}
+	}
+	if movement.Channel == "" {
+		movement.Channel = models.ChannelSystem
+	}
+	if movement.CreatedAt.IsZero() {
+		movement.CreatedAt = time.Now()
+	}
+	var tellerID interface{}
+	if movement.TellerID != nil {
+		tellerID = *movement.TellerID
+	}
+	result, err := tx.Exec(`
+		INSERT INTO deposit_movements (
+			deposit_id, client_id, type, amount, channel, counterparty, reference, teller_id, branch, transaction_id, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, movement.DepositID, movement.ClientID, movement.Type, movement.Amount, movement.Channel,
+		movement.Counterparty, movement.Reference, tellerID, movement.Branch, nullableInt64(movement.TransactionID),
+		movement.CreatedAt)
+	if err != nil {
+		return err
+	}
+	movement.ID, err = result.LastInsertId()
+	return err
+}
+
+// TopUpDeposit pays money into a deposit. The movement is filled in with its ID and the
+// transaction history entry that can be used to cancel it.
+func TopUpDeposit(movement *models.DepositMovement) error {
+	movement.Type = models.MovementTopUp
+	return moveDepositFunds(movement)
+}
+
+// WithdrawFromDeposit takes money out of a deposit. Online withdrawals are checked against the
+// withdrawal limits of the client and return a *LimitExceededError when over one.
+func WithdrawFromDeposit(movement *models.DepositMovement) error {
+	movement.Type = models.MovementWithdrawal
+	return moveDepositFunds(movement)
+}
+
+// moveDepositFunds checks the deposit and its product, changes the balance and records
+// the movement together with its transaction log entry, so that operators can cancel it
+func moveDepositFunds(movement *models.DepositMovement) error {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return err
+	}
+	if movement.Amount <= 0 {
+		return ErrInvalidMovementAmount
+	}
+	if movement.Channel == models.ChannelCashDesk {
+		if movement.TellerID == nil || strings.TrimSpace(movement.Branch) == "" {
+			return errors.New("cash-desk operations require a teller and a branch")
+		}
+	} else {
+		movement.Channel = models.ChannelOnline
+		movement.TellerID = nil
+		movement.Branch = ""
+	}
+
+	deposit, err := getDepositByID(movement.DepositID)
+	if err != nil {
+		return err
+	}
+	// Tellers may serve any client; online clients only reach their own deposits
+	if movement.Channel == models.ChannelOnline && deposit.ClientID != movement.ClientID {
+		return ErrDepositNotFound
+	}
+	movement.ClientID = deposit.ClientID
+	if deposit.IsBlocked || deposit.IsFrozen {
+		return ErrDepositUnavailable
+	}
+	product, err := depositProductOf(deposit)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureLimitCheckTablesExist(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := getDepositBalanceTx(tx, deposit.DepositID)
+	if err != nil {
+		return err
+	}
+	if !balance.Available() {
+		return ErrDepositUnavailable
+	}
+	if balance.Status != models.DepositActive && balance.Status != models.DepositMatured {
+		return ErrDepositNotActive
+	}
+	deposit.Status = balance.Status
+
+	if movement.Type == models.MovementTopUp {
+		if err := checkDepositTopUp(deposit, product); err != nil {
+			return err
+		}
+		err = creditDepositTx(tx, deposit.DepositID, movement.Amount, now)
+	} else {
+		if balance.Amount < movement.Amount {
+			return ErrInsufficientFunds
+		}
+		if err := checkDepositWithdrawal(deposit, product, balance.Amount, movement.Amount); err != nil {
+			return err
+		}
+		// Withdrawals at a cash desk are not subject to the online limits
+		if movement.Channel == models.ChannelOnline {
+			err := checkTransactionLimitTx(tx, deposit.ClientID, LimitOperationWithdrawal, deposit.ProductID, movement.Amount)
+			if err != nil {
+				return err
+			}
+		}
+		err = debitDepositTx(tx, balance, movement.Amount, now)
+	}
+	if err != nil {
+		return err
+	}
+	metadata := fmt.Sprintf("%s of %.2f on deposit %d (%s)", movementLabel(movement.Type), movement.Amount,
+		deposit.DepositID, movement.Channel)
+	if movement.TellerID != nil {
+		metadata += fmt.Sprintf(", teller %d at branch %s", *movement.TellerID, movement.Branch)
+	}
+	amount := movement.Amount
+	transactionID, err := logTransactionTx(tx, deposit.ClientID, movement.Type, &amount, metadata)
+	if err != nil {
+		return err
+	}
+	movement.TransactionID = transactionID
+	movement.CreatedAt = now
+	if err := recordDepositMovementTx(tx, movement); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	transactionLogged(deposit.ClientID, movement.Type, &amount, metadata)
+	return nil
+}
+
+func movementLabel(movementType string) string {
+	if movementType == models.MovementTopUp {
+		return "Top-up"
+	}
+	return "Withdrawal"
+}
+
+// reverseDepositMovementTx undoes the top-up or withdrawal logged as transactionID and
+// returns the deposit it belonged to
+func reverseDepositMovementTx(tx *sql.Tx, transactionID int64) (int64, error) {
+	var movementID, depositID, clientID int64
+	var movementType string
+	var amount float64
+	err := tx.QueryRow(`
+		SELECT id, deposit_id, client_id, type, amount FROM deposit_movements
+		WHERE transaction_id = ? AND type IN (?, ?) AND cancelled_at IS NULL
+	`, transactionID, models.MovementTopUp, models.MovementWithdrawal).Scan(&movementID, &depositID, &clientID, &movementType, &amount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, errors.New("deposit movement not found or already cancelled")
+		}
+		return 0, err
+	}
+
+	now := time.Now()
+	if movementType == models.MovementTopUp {
+		result, err := tx.Exec(`
+			UPDATE deposits SET amount = amount - ?, version = version + 1, updated_at = ?
+			WHERE deposit_id = ? AND amount >= ?
+		`, amount, now, depositID, amount)
+		if err != nil {
+			return 0, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return 0, err
+		} else if affected == 0 {
+			return 0, ErrMovementReversalFunds
+		}
+	} else {
+		if err := creditDepositTx(tx, depositID, amount, now); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE deposit_movements SET cancelled_at = ? WHERE id = ?", now, movementID); err != nil {
+		return 0, err
+	}
+	// The reversal is a movement of its own so that statements show both
+	reversal := &models.DepositMovement{
+		DepositID: depositID,
+		ClientID:  clientID,
+		Type:      "cancel_" + movementType,
+		Amount:    amount,
+		Reference: fmt.Sprintf("transaction #%d", transactionID),
+		CreatedAt: now,
+	}
+	return depositID, recordDepositMovementTx(tx, reversal)
+}
+
+// GetDepositMovements lists the top-ups and withdrawals of a deposit, newest first
+func GetDepositMovements(depositID int64) ([]models.DepositMovement, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query(`
+		SELECT `+depositMovementColumns+` FROM deposit_movements WHERE deposit_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, depositID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movements := []models.DepositMovement{}
+	for rows.Next() {
+		var movement models.DepositMovement
+		if err := scanDepositMovement(rows, &movement); err != nil {
+			return nil, err
+		}
+		movements = append(movements, movement)
+	}
+	return movements, rows.Err()
+}
+
+// depositMovementColumns lists the columns read by scanDepositMovement
+const depositMovementColumns = `id, deposit_id, client_id, type, amount, channel, COALESCE(counterparty, ''),
+	COALESCE(reference, ''), teller_id, COALESCE(branch, ''), COALESCE(transaction_id, 0), created_at, cancelled_at`
+
+// scanDepositMovement reads a movement row selected with depositMovementColumns
+func scanDepositMovement(row interface{ Scan(...interface{}) error }, movement *models.DepositMovement) error {
+	var tellerID sql.NullInt64
+	var cancelledAt sql.NullTime
+	err := row.Scan(&movement.ID, &movement.DepositID, &movement.ClientID, &movement.Type, &movement.Amount,
+		&movement.Channel, &movement.Counterparty, &movement.Reference, &tellerID, &movement.Branch,
+		&movement.TransactionID, &movement.CreatedAt, &cancelledAt)
+	if err != nil {
+		return err
+	}
+	if tellerID.Valid {
+		movement.TellerID = &tellerID.Int64
+	}
+	if cancelledAt.Valid {
+		movement.CancelledAt = &cancelledAt.Time
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrDepositProductNotFound is returned when no deposit product matches the request
+	ErrDepositProductNotFound = errors.New("deposit product not found")
+	// ErrDepositTermsNotOffered is returned when the product does not offer the requested amount or term
+	ErrDepositTermsNotOffered = errors.New("deposit product does not offer the requested amount and term")
+)
+
+// defaultDepositProducts are seeded when the catalog is empty
+var defaultDepositProducts = []models.DepositProduct{
+	{
+		Code: "savings", Name: "Savings account", Type: models.DemandDeposit,
+		AllowTopUp: true, AllowWithdrawal: true,
+		Rates: []models.DepositRateTier{
+			{Rate: 1},
+			{MinAmount: 10000, Rate: 1.5},
+		},
+	},
+	{
+		Code: "term", Name: "Term deposit", Type: models.TermDeposit,
+		AllowedTerms: []int{3, 6, 12, 24}, MinBalance: 100,
+		EarlyClosurePenalty: 2, AutoRollover: true,
+		Rates: []models.DepositRateTier{
+			{MinTerm: 3, MaxTerm: 5, Rate: 5},
+			{MinTerm: 6, MaxTerm: 11, Rate: 6},
+			{MinTerm: 12, MaxTerm: 23, Rate: 7},
+			{MinTerm: 24, MaxTerm: 24, Rate: 7.5},
+		},
+	},
+}
+
+// EnsureDepositProductTablesExist creates the deposit product tables and seeds the default products
+func EnsureDepositProductTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS deposit_products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			deposit_type TEXT NOT NULL,
+			allowed_terms TEXT NOT NULL DEFAULT '[]',
+			min_balance REAL NOT NULL DEFAULT 0,
+			allow_top_up INTEGER NOT NULL DEFAULT 0,
+			allow_withdrawal INTEGER NOT NULL DEFAULT 0,
+			early_closure_penalty REAL NOT NULL DEFAULT 0,
+			auto_rollover INTEGER NOT NULL DEFAULT 0,
+			effective_from TIMESTAMP NOT NULL,
+			retired INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE(code, version)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS deposit_product_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			product_id INTEGER NOT NULL,
+			min_term INTEGER NOT NULL,
+			max_term INTEGER NOT NULL,
+			min_amount REAL NOT NULL DEFAULT 0,
+			max_amount REAL NOT NULL DEFAULT 0,
+			rate REAL NOT NULL,
+			FOREIGN KEY (product_id) REFERENCES deposit_products(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM deposit_products").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, product := range defaultDepositProducts {
+		product := product
+		product.EffectiveFrom = time.Unix(0, 0)
+		if err := insertDepositProduct(&product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDepositProduct checks a product before it is stored
+func validateDepositProduct(product *models.DepositProduct) error {
+	if !productCodePattern.MatchString(product.Code) {
+		return errors.New("code must be 2-32 lowercase letters, digits, '-' or '_'")
+	}
+	if product.Name == "" {
+		return errors.New("name is required")
+	}
+	if product.Type != models.DemandDeposit && product.Type != models.TermDeposit {
+		return errors.New("type must be either 'demand' or 'term'")
+	}
+	if product.MinBalance < 0 {
+		return errors.New("min_balance cannot be negative")
+	}
+	if product.EarlyClosurePenalty < 0 || product.EarlyClosurePenalty > 100 {
+		return errors.New("early_closure_penalty must be between 0 and 100")
+	}
+	if product.Type == models.DemandDeposit {
+		if len(product.AllowedTerms) > 0 || product.AutoRollover || product.EarlyClosurePenalty > 0 {
+			return errors.New("demand products have no terms, rollover or early closure penalty")
+		}
+	}
+	for _, term := range product.AllowedTerms {
+		if term <= 0 {
+			return errors.New("allowed terms must be positive")
+		}
+	}
+	if len(product.Rates) == 0 {
+		return errors.New("at least one rate tier is required")
+	}
+	for _, tier := range product.Rates {
+		if product.Type == models.DemandDeposit && (tier.MinTerm != 0 || tier.MaxTerm != 0) {
+			return errors.New("rate tiers of demand products must not set terms")
+		}
+		if product.Type == models.TermDeposit && (tier.MinTerm <= 0 || tier.MaxTerm < tier.MinTerm) {
+			return errors.New("rate tier terms must satisfy 0 < min_term <= max_term")
+		}
+		if tier.MinAmount < 0 || (tier.MaxAmount != 0 && tier.MaxAmount < tier.MinAmount) {
+			return errors.New("rate tier amounts must satisfy 0 <= min_amount <= max_amount")
+		}
+		if tier.Rate < 0 || tier.Rate > 100 {
+			return errors.New("rates must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+// insertDepositProduct stores the next version of a product code together with its rate tiers
+func insertDepositProduct(product *models.DepositProduct) error {
+	terms, err := json.Marshal(product.AllowedTerms)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+		SELECT COALESCE(MAX(version), 0) + 1 FROM deposit_products WHERE code = ?
+	`, product.Code).Scan(&product.Version); err != nil {
+		return err
+	}
+
+	product.CreatedAt = time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO deposit_products (
+			code, version, name, deposit_type, allowed_terms, min_balance, allow_top_up, allow_withdrawal,
+			early_closure_penalty, auto_rollover, effective_from, created_by, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, product.Code, product.Version, product.Name, product.Type, string(terms), product.MinBalance,
+		product.AllowTopUp, product.AllowWithdrawal, product.EarlyClosurePenalty, product.AutoRollover,
+		product.EffectiveFrom, nullableInt64(product.CreatedBy), product.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if product.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	for _, tier := range product.Rates {
+		_, err := tx.Exec(`
+			INSERT INTO deposit_product_rates (product_id, min_term, max_term, min_amount, max_amount, rate)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, product.ID, tier.MinTerm, tier.MaxTerm, tier.MinAmount, tier.MaxAmount, tier.Rate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateDepositProduct publishes a deposit product. Using the code of an existing product
+// publishes its next version, which applies to deposits opened from EffectiveFrom on.
+func CreateDepositProduct(product *models.DepositProduct, adminID int64) error {
+	if err := validateDepositProduct(product); err != nil {
+		return err
+	}
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return err
+	}
+	if product.EffectiveFrom.IsZero() {
+		product.EffectiveFrom = time.Now()
+	}
+	product.CreatedBy = adminID
+
+	if err := insertDepositProduct(product); err != nil {
+		return err
+	}
+
+	metadata := fmt.Sprintf("Deposit product %s version %d published, effective from %s",
+		product.Code, product.Version, product.EffectiveFrom.Format("2006-01-02"))
+	LogTransaction(adminID, "deposit_product_publish", nil, metadata)
+	return nil
+}
+
+// RetireDepositProduct stops a product version from being offered. Open deposits are not affected.
+func RetireDepositProduct(productID, adminID int64) error {
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec("UPDATE deposit_products SET retired = 1 WHERE id = ?", productID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrDepositProductNotFound
+	}
+
+	LogTransaction(adminID, "deposit_product_retire", nil, fmt.Sprintf("Deposit product #%d retired", productID))
+	return nil
+}
+
+// depositProductColumns lists the columns read by scanDepositProduct
+const depositProductColumns = `
+	id, code, version, name, deposit_type, allowed_terms, min_balance, allow_top_up, allow_withdrawal,
+	early_closure_penalty, auto_rollover, effective_from, retired, created_by, created_at`
+
+// scanDepositProduct reads a product row selected with depositProductColumns
+func scanDepositProduct(row interface{ Scan(...interface{}) error }, product *models.DepositProduct) error {
+	var terms string
+	var retired int
+	var createdBy sql.NullInt64
+	err := row.Scan(&product.ID, &product.Code, &product.Version, &product.Name, &product.Type, &terms,
+		&product.MinBalance, &product.AllowTopUp, &product.AllowWithdrawal, &product.EarlyClosurePenalty,
+		&product.AutoRollover, &product.EffectiveFrom, &retired, &createdBy, &product.CreatedAt)
+	if err != nil {
+		return err
+	}
+	product.Retired = retired == 1
+	product.CreatedBy = createdBy.Int64
+	return json.Unmarshal([]byte(terms), &product.AllowedTerms)
+}
+
+// queryDepositProducts runs a product query and loads the rate tiers of every result
+func queryDepositProducts(query string, args ...interface{}) ([]*models.DepositProduct, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*models.DepositProduct{}
+	for rows.Next() {
+		product := &models.DepositProduct{}
+		if err := scanDepositProduct(rows, product); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, product := range products {
+		rateRows, err := DB.Query(`
+			SELECT min_term, max_term, min_amount, max_amount, rate
+			FROM deposit_product_rates
+			WHERE product_id = ?
+			ORDER BY min_term, min_amount, id
+		`, product.ID)
+		if err != nil {
+			return nil, err
+		}
+		product.Rates = []models.DepositRateTier{}
+		for rateRows.Next() {
+			var tier models.DepositRateTier
+			if err := rateRows.Scan(&tier.MinTerm, &tier.MaxTerm, &tier.MinAmount, &tier.MaxAmount, &tier.Rate); err != nil {
+				rateRows.Close()
+				return nil, err
+			}
+			product.Rates = append(product.Rates, tier)
+		}
+		rateRows.Close()
+		if err := rateRows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+// GetDepositProduct returns a product version by ID
+func GetDepositProduct(productID int64) (*models.DepositProduct, error) {
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	products, err := queryDepositProducts("SELECT "+depositProductColumns+" FROM deposit_products WHERE id = ?", productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrDepositProductNotFound
+	}
+	return products[0], nil
+}
+
+// GetDepositProducts returns every version of every product, optionally for a single code
+func GetDepositProducts(code string) ([]*models.DepositProduct, error) {
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + depositProductColumns + " FROM deposit_products"
+	var args []interface{}
+	if code != "" {
+		query += " WHERE code = ?"
+		args = append(args, code)
+	}
+	query += " ORDER BY code, version DESC"
+	return queryDepositProducts(query, args...)
+}
+
+// GetCurrentDepositProducts returns the version of each product that is in effect at the given time
+func GetCurrentDepositProducts(at time.Time) ([]*models.DepositProduct, error) {
+	if err := EnsureDepositProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	return queryDepositProducts(`
+		SELECT `+depositProductColumns+`
+		FROM deposit_products p
+		WHERE retired = 0 AND effective_from <= ?
+		  AND version = (
+			SELECT MAX(version) FROM deposit_products latest
+			WHERE latest.code = p.code AND latest.retired = 0 AND latest.effective_from <= ?
+		  )
+		ORDER BY code
+	`, at, at)
+}
+
+// selectDepositProduct returns the current version of a product code with the rate it offers
+// for the term and amount
+func selectDepositProduct(code string, termMonths int, amount float64, at time.Time) (*models.DepositProduct, float64, error) {
+	products, err := GetCurrentDepositProducts(at)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, product := range products {
+		if product.Code != code {
+			continue
+		}
+		if amount < product.MinBalance || !product.AllowsTerm(termMonths) {
+			return nil, 0, ErrDepositTermsNotOffered
+		}
+		rate, ok := product.RateFor(termMonths, amount)
+		if !ok {
+			return nil, 0, ErrDepositTermsNotOffered
+		}
+		return product, rate, nil
+	}
+	return nil, 0, ErrDepositProductNotFound
+}
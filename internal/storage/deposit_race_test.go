@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"finance/internal/models"
+	"math"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newRaceTestDB opens a fresh file database shared by every goroutine of a test
+func newRaceTestDB(t *testing.T) {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOG_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key))
+	t.Setenv("DATABASE_PATH", filepath.Join(t.TempDir(), "finance.db"))
+	InitDB()
+	t.Cleanup(func() { CloseDB() })
+}
+
+func openTestDeposit(t *testing.T, clientID int64, amount float64) int64 {
+	t.Helper()
+	deposit, err := OpenDeposit(models.DepositRequest{ClientID: clientID, BankName: "Test Bank", ProductCode: "savings", Amount: amount})
+	if err != nil {
+		t.Fatalf("opening deposit: %v", err)
+	}
+	return deposit.DepositID
+}
+
+func depositAmount(t *testing.T, depositID int64) float64 {
+	t.Helper()
+	var amount float64
+	if err := DB.QueryRow("SELECT amount FROM deposits WHERE deposit_id = ?", depositID).Scan(&amount); err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func assertAmount(t *testing.T, depositID int64, want float64) {
+	t.Helper()
+	if got := depositAmount(t, depositID); math.Abs(got-want) > 0.000001 {
+		t.Errorf("deposit %d balance = %.2f, want %.2f", depositID, got, want)
+	}
+}
+
+// runParallel starts n goroutines at once and waits for them
+func runParallel(n int, fn func(i int)) {
+	var start, done sync.WaitGroup
+	start.Add(1)
+	done.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer done.Done()
+			start.Wait()
+			fn(i)
+		}(i)
+	}
+	start.Done()
+	done.Wait()
+}
+
+func TestParallelTransfersDoNotOverdraw(t *testing.T) {
+	newRaceTestDB(t)
+	source := openTestDeposit(t, 1, 100)
+	destination := openTestDeposit(t, 2, 10)
+
+	const attempts = 40
+	errs := make([]error, attempts)
+	runParallel(attempts, func(i int) {
+		errs[i] = TransferBetweenAccounts(models.Transfer{
+			ClientID: 1, FromDepositID: source, ToDepositID: destination, Amount: 10, BankName: "Test Bank",
+		})
+	})
+
+	succeeded := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case errors.Is(err, ErrInsufficientFunds):
+		default:
+			t.Errorf("unexpected transfer error: %v", err)
+		}
+	}
+	if succeeded != 10 {
+		t.Errorf("%d transfers succeeded, want 10", succeeded)
+	}
+	assertAmount(t, source, 0)
+	assertAmount(t, destination, 110)
+
+	var movements int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM deposit_movements WHERE deposit_id = ? AND type = ?",
+		source, models.MovementTransferOut).Scan(&movements); err != nil {
+		t.Fatal(err)
+	}
+	if movements != succeeded {
+		t.Errorf("%d outgoing movements recorded for %d transfers", movements, succeeded)
+	}
+}
+
+func TestParallelTransfersAndWithdrawalsKeepTotal(t *testing.T) {
+	newRaceTestDB(t)
+	first := openTestDeposit(t, 1, 500)
+	second := openTestDeposit(t, 1, 500)
+
+	const workers = 30
+	var mu sync.Mutex
+	withdrawn := 0.0
+	runParallel(workers, func(i int) {
+		var err error
+		switch i % 3 {
+		case 0:
+			err = TransferBetweenAccounts(models.Transfer{ClientID: 1, FromDepositID: first, ToDepositID: second, Amount: 70, BankName: "Test Bank"})
+		case 1:
+			err = TransferBetweenAccounts(models.Transfer{ClientID: 1, FromDepositID: second, ToDepositID: first, Amount: 45, BankName: "Test Bank"})
+		default:
+			err = WithdrawFromDeposit(&models.DepositMovement{DepositID: first, ClientID: 1, Amount: 60})
+			if err == nil {
+				mu.Lock()
+				withdrawn += 60
+				mu.Unlock()
+			}
+		}
+		if err != nil && !errors.Is(err, ErrInsufficientFunds) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	firstAmount, secondAmount := depositAmount(t, first), depositAmount(t, second)
+	if firstAmount < 0 || secondAmount < 0 {
+		t.Errorf("negative balance: %.2f and %.2f", firstAmount, secondAmount)
+	}
+	if total := firstAmount + secondAmount + withdrawn; math.Abs(total-1000) > 0.000001 {
+		t.Errorf("balances and withdrawals add up to %.2f, want 1000", total)
+	}
+}
+
+func TestTransferFromBlockedDepositIsRefused(t *testing.T) {
+	newRaceTestDB(t)
+	source := openTestDeposit(t, 1, 100)
+	destination := openTestDeposit(t, 2, 0.01)
+
+	const attempts = 20
+	errs := make([]error, attempts)
+	var blockErr error
+	runParallel(attempts+1, func(i int) {
+		if i == attempts {
+			blockErr = BlockDeposit(1, "Test Bank", source)
+			return
+		}
+		errs[i] = TransferBetweenAccounts(models.Transfer{
+			ClientID: 1, FromDepositID: source, ToDepositID: destination, Amount: 1, BankName: "Test Bank",
+		})
+	})
+	if blockErr != nil {
+		t.Fatalf("blocking deposit: %v", blockErr)
+	}
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if !errors.Is(err, ErrDepositUnavailable) {
+			t.Errorf("unexpected transfer error: %v", err)
+		}
+	}
+	// Whatever got through before the block, the ledger and balances agree
+	assertAmount(t, source, 100-float64(succeeded))
+	assertAmount(t, destination, 0.01+float64(succeeded))
+
+	err := TransferBetweenAccounts(models.Transfer{ClientID: 1, FromDepositID: source, ToDepositID: destination, Amount: 1, BankName: "Test Bank"})
+	if !errors.Is(err, ErrDepositUnavailable) {
+		t.Errorf("transfer from blocked deposit: got %v, want ErrDepositUnavailable", err)
+	}
+}
+
+func TestDebitRejectsStaleVersion(t *testing.T) {
+	newRaceTestDB(t)
+	depositID := openTestDeposit(t, 1, 100)
+
+	tx, err := DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale, err := getDepositBalanceTx(tx, depositID)
+	tx.Rollback()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TopUpDeposit(&models.DepositMovement{DepositID: depositID, ClientID: 1, Amount: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = DB.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if err := debitDepositTx(tx, stale, 10, time.Now()); !errors.Is(err, ErrDepositConcurrentUpdate) {
+		t.Errorf("debit with stale version: got %v, want ErrDepositConcurrentUpdate", err)
+	}
+}
+
+func TestBalanceCannotGoNegative(t *testing.T) {
+	newRaceTestDB(t)
+	depositID := openTestDeposit(t, 1, 100)
+
+	if _, err := DB.Exec("UPDATE deposits SET amount = amount - 150 WHERE deposit_id = ?", depositID); err == nil {
+		t.Error("balance was allowed to go negative")
+	}
+	assertAmount(t, depositID, 100)
+}
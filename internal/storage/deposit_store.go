@@ -1,82 +1,119 @@
-// Add these new functions to your deposit_store.go file
-
 package storage
 
 import (
 	"database/sql"
 	"errors"
-	"log"
+	"finance/internal/models"
+	"time"
+)
 
-	
+var (
+	// ErrInsufficientFunds is returned when a deposit does not cover the money taken from it
+	ErrInsufficientFunds = errors.New("insufficient funds for transfer")
+	// ErrTransferSourceNotFound is returned when the source deposit does not exist or belongs to another client
+	ErrTransferSourceNotFound = errors.New("source deposit not found or doesn't belong to you")
+	// ErrTransferDestinationNotFound is returned when the destination deposit does not exist
+	ErrTransferDestinationNotFound = errors.New("destination deposit not found")
+	// ErrDepositConcurrentUpdate is returned when a deposit changed between reading its balance
+	// and updating it, so the checks made on the old balance no longer hold
+	ErrDepositConcurrentUpdate = errors.New("deposit was changed by another operation, please retry")
 )
-// var ErrAccountNotFound = errors.New("account not found")
-// Add this error variable
-var ErrInsufficientFunds = errors.New("insufficient funds for transfer")
 
-// VerifyAccountsForTransfer checks if both accounts exist and belong to the right user
-func VerifyAccountsForTransfer(userID int64, fromAccount, toAccount int64) (fromExists bool, toExists bool, err error) {
-	// First check if the from account exists and belongs to the user
-	var fromOwnerID int64
-	err = DB.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", fromAccount).Scan(&fromOwnerID)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, false, nil // From account does not exist
-		}
-		return false, false, err
-	}
+// depositBalance is the state of a deposit read inside the transaction that moves its money
+type depositBalance struct {
+	DepositID int64
+	ClientID  int64
+	Amount    float64
+	Status    string
+	IsBlocked bool
+	IsFrozen  bool
+	Version   int64
+}
 
-	// Check if the from account belongs to the user
-	if fromOwnerID != userID {
-		return false, false, nil // User does not own the from account
+// Available reports whether money may be moved to or from the deposit
+func (b *depositBalance) Available() bool {
+	return !b.IsBlocked && !b.IsFrozen
+}
+
+// ensureDepositBalanceGuards adds the row version used for optimistic concurrency and a trigger
+// that keeps balances from going negative. SQLite cannot add a CHECK constraint to an existing
+// table, so the trigger stands in for CHECK (amount >= 0); the tolerance absorbs float rounding.
+func ensureDepositBalanceGuards() error {
+	if err := ensureColumnExists("deposits", "version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
 	}
+	_, err := DB.Exec(`
+		CREATE TRIGGER IF NOT EXISTS deposits_amount_not_negative
+		BEFORE UPDATE OF amount ON deposits
+		WHEN NEW.amount < -0.000001
+		BEGIN
+			SELECT RAISE(ABORT, 'deposit balance cannot be negative');
+		END
+	`)
+	return err
+}
 
-	// Now check if the to account exists
-	var toOwnerID int64
-	err = DB.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", toAccount).Scan(&toOwnerID)
+// getDepositBalanceTx reads the balance and state of a deposit
+func getDepositBalanceTx(tx *sql.Tx, depositID int64) (*depositBalance, error) {
+	balance := depositBalance{DepositID: depositID}
+	var isBlocked, isFrozen int
+	err := tx.QueryRow(`
+		SELECT client_id, amount, status, is_blocked, is_frozen, version FROM deposits WHERE deposit_id = ?
+	`, depositID).Scan(&balance.ClientID, &balance.Amount, &balance.Status, &isBlocked, &isFrozen, &balance.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return true, false, nil // From exists, to does not
+			return nil, ErrDepositNotFound
 		}
-		return true, false, err
+		return nil, err
 	}
-
-	// Both accounts exist
-	return true, true, nil
+	balance.IsBlocked = isBlocked == 1
+	balance.IsFrozen = isFrozen == 1
+	return &balance, nil
 }
 
-// CheckAccountsBlockedOrFrozen checks if either account is blocked or frozen
-func CheckAccountsBlockedOrFrozen(fromAccount, toAccount int64) (bool, error) {
-	var fromBlocked, fromFrozen, toBlocked, toFrozen int
-
-	// Check from account status
-	err := DB.QueryRow("SELECT is_blocked, is_frozen FROM deposits WHERE deposit_id = ?", fromAccount).Scan(&fromBlocked, &fromFrozen)
+// debitDepositTx takes amount from a deposit read with getDepositBalanceTx. The update only
+// applies while the deposit still has the version it was read with, is neither blocked nor
+// frozen and covers the amount, so no decision is made on a stale balance.
+func debitDepositTx(tx *sql.Tx, balance *depositBalance, amount float64, now time.Time) error {
+	result, err := tx.Exec(`
+		UPDATE deposits SET amount = amount - ?, version = version + 1, updated_at = ?
+		WHERE deposit_id = ? AND version = ? AND amount >= ? AND is_blocked = 0 AND is_frozen = 0
+	`, amount, now, balance.DepositID, balance.Version, amount)
 	if err != nil {
-		log.Printf("Error checking from account status: %v", err)
-		return false, err
+		return err
 	}
-
-	log.Printf("From account (ID: %d) status - blocked: %d, frozen: %d", fromAccount, fromBlocked, fromFrozen)
-
-	if fromBlocked == 1 || fromFrozen == 1 {
-		log.Printf("From account is blocked or frozen")
-		return true, nil
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrDepositConcurrentUpdate
 	}
+	balance.Amount -= amount
+	balance.Version++
+	return nil
+}
 
-	// Check to account status
-	err = DB.QueryRow("SELECT is_blocked, is_frozen FROM deposits WHERE deposit_id = ?", toAccount).Scan(&toBlocked, &toFrozen)
+// creditDepositTx adds amount to a deposit. A closed deposit was paid out and takes no more money.
+func creditDepositTx(tx *sql.Tx, depositID int64, amount float64, now time.Time) error {
+	result, err := tx.Exec(`
+		UPDATE deposits SET amount = amount + ?, version = version + 1, updated_at = ?
+		WHERE deposit_id = ? AND status != ?
+	`, amount, now, depositID, models.DepositClosed)
 	if err != nil {
-		log.Printf("Error checking to account status: %v", err)
-		return false, err
+		return err
 	}
-
-	log.Printf("To account (ID: %d) status - blocked: %d, frozen: %d", toAccount, toBlocked, toFrozen)
-
-	if toBlocked == 1 || toFrozen == 1 {
-		log.Printf("To account is blocked or frozen")
-		return true, nil
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected > 0 {
+		return nil
 	}
 
-	// Neither account is blocked or frozen
-	log.Printf("Neither account is blocked or frozen")
-	return false, nil
+	var exists int
+	err = tx.QueryRow("SELECT 1 FROM deposits WHERE deposit_id = ?", depositID).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return ErrDepositNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrDepositNotActive
 }
@@ -0,0 +1,584 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"time"
+)
+
+// DefaultEnterpriseCurrency is used for settlement accounts opened without an explicit currency
+const DefaultEnterpriseCurrency = "BYN"
+
+var (
+	// ErrEnterpriseNotFound is returned when an enterprise referenced by an operation does not exist
+	ErrEnterpriseNotFound = errors.New("enterprise not found")
+	// ErrInsufficientEnterpriseBalance is returned when a settlement account cannot cover a debit
+	ErrInsufficientEnterpriseBalance = errors.New("insufficient enterprise account balance")
+	// ErrEnterpriseAccountNotFound is returned when a settlement account does not exist
+	ErrEnterpriseAccountNotFound = errors.New("enterprise account not found")
+	// ErrEnterpriseCurrencyMismatch is returned when a transfer joins accounts in different currencies
+	ErrEnterpriseCurrencyMismatch = errors.New("currency mismatch")
+)
+
+// EnterpriseAccount represents a settlement account of an enterprise
+type EnterpriseAccount struct {
+	ID             int64   `json:"id"`
+	EnterpriseID   int     `json:"enterprise_id"`
+	AccountNumber  string  `json:"account_number"`
+	Currency       string  `json:"currency"`
+	Balance        float64 `json:"balance"`
+	OverdraftLimit float64 `json:"overdraft_limit"`
+	CreatedAt      int64   `json:"created_at"`
+	UpdatedAt      int64   `json:"updated_at"`
+}
+
+// Available returns the amount that can be debited including the overdraft
+func (a *EnterpriseAccount) Available() float64 {
+	return a.Balance + a.OverdraftLimit
+}
+
+// EnterpriseAccountMovement represents a single credit or debit on a settlement account
+type EnterpriseAccountMovement struct {
+	ID           int64   `json:"id"`
+	AccountID    int64   `json:"account_id"`
+	Amount       float64 `json:"amount"`
+	BalanceAfter float64 `json:"balance_after"`
+	Kind         string  `json:"kind"`
+	Reference    string  `json:"reference,omitempty"`
+	Description  string  `json:"description,omitempty"`
+	CreatedBy    int64   `json:"created_by,omitempty"`
+	CreatedAt    int64   `json:"created_at"`
+}
+
+// EnterpriseStatement is a statement of the settlement accounts of an enterprise for a period
+type EnterpriseStatement struct {
+	EnterpriseID int                          `json:"enterprise_id"`
+	From         int64                        `json:"from"`
+	To           int64                        `json:"to"`
+	Accounts     []EnterpriseAccountStatement `json:"accounts"`
+}
+
+// EnterpriseAccountStatement holds the movements of one settlement account for a statement period
+type EnterpriseAccountStatement struct {
+	Account        EnterpriseAccount           `json:"account"`
+	OpeningBalance float64                     `json:"opening_balance"`
+	ClosingBalance float64                     `json:"closing_balance"`
+	TotalCredits   float64                     `json:"total_credits"`
+	TotalDebits    float64                     `json:"total_debits"`
+	Movements      []EnterpriseAccountMovement `json:"movements"`
+}
+
+// EnsureEnterpriseAccountsTableExists creates the enterprise settlement account tables
+func EnsureEnterpriseAccountsTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_accounts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			enterprise_id INTEGER NOT NULL,
+			account_number TEXT,
+			currency TEXT NOT NULL DEFAULT 'BYN',
+			balance REAL NOT NULL DEFAULT 0,
+			overdraft_limit REAL NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			FOREIGN KEY (enterprise_id) REFERENCES enterprises(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Databases created before account numbers were introduced lack these columns
+	if err := ensureColumnExists("enterprise_accounts", "account_number", "TEXT"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("enterprise_accounts", "currency", "TEXT NOT NULL DEFAULT 'BYN'"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("enterprise_accounts", "overdraft_limit", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_enterprise_accounts_number
+		ON enterprise_accounts(account_number)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_account_movements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account_id INTEGER NOT NULL,
+			amount REAL NOT NULL,
+			balance_after REAL NOT NULL,
+			kind TEXT NOT NULL,
+			reference TEXT,
+			description TEXT,
+			created_by INTEGER,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY (account_id) REFERENCES enterprise_accounts(id)
+		)
+	`)
+	return err
+}
+
+// generateEnterpriseAccountNumber builds the account number assigned to a new settlement account
+func generateEnterpriseAccountNumber(enterpriseID int, accountID int64) string {
+	return fmt.Sprintf("ENT%06d%08d", enterpriseID, accountID)
+}
+
+// openEnterpriseAccountTx opens a settlement account for an enterprise inside tx
+func openEnterpriseAccountTx(tx *sql.Tx, enterpriseID int, accountNumber, currency string, overdraftLimit float64) (*EnterpriseAccount, error) {
+	var count int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM enterprises WHERE id = ?", enterpriseID).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("%w: ID %d", ErrEnterpriseNotFound, enterpriseID)
+	}
+
+	if currency == "" {
+		currency = DefaultEnterpriseCurrency
+	}
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		INSERT INTO enterprise_accounts (enterprise_id, account_number, currency, balance, overdraft_limit, created_at, updated_at)
+		VALUES (?, NULLIF(?, ''), ?, 0, ?, ?, ?)
+	`, enterpriseID, accountNumber, currency, overdraftLimit, now, now)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if accountNumber == "" {
+		accountNumber = generateEnterpriseAccountNumber(enterpriseID, id)
+		if _, err := tx.Exec("UPDATE enterprise_accounts SET account_number = ? WHERE id = ?", accountNumber, id); err != nil {
+			return nil, err
+		}
+	}
+
+	return &EnterpriseAccount{
+		ID:             id,
+		EnterpriseID:   enterpriseID,
+		AccountNumber:  accountNumber,
+		Currency:       currency,
+		OverdraftLimit: overdraftLimit,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
+
+// OpenEnterpriseAccount opens a new settlement account for an enterprise.
+// An account number is generated when none is given.
+func OpenEnterpriseAccount(enterpriseID int, accountNumber, currency string, overdraftLimit float64) (*EnterpriseAccount, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	if overdraftLimit < 0 {
+		return nil, errors.New("overdraft limit cannot be negative")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	account, err := openEnterpriseAccountTx(tx, enterpriseID, accountNumber, currency, overdraftLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+const enterpriseAccountColumns = `
+	id, enterprise_id, COALESCE(account_number, ''), currency, balance, overdraft_limit, created_at, updated_at
+`
+
+// scanEnterpriseAccount scans a row selected with enterpriseAccountColumns
+func scanEnterpriseAccount(row interface{ Scan(...interface{}) error }, account *EnterpriseAccount) error {
+	return row.Scan(
+		&account.ID,
+		&account.EnterpriseID,
+		&account.AccountNumber,
+		&account.Currency,
+		&account.Balance,
+		&account.OverdraftLimit,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+}
+
+// GetEnterpriseAccounts retrieves all settlement accounts of an enterprise
+func GetEnterpriseAccounts(enterpriseID int) ([]EnterpriseAccount, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+enterpriseAccountColumns+`
+		FROM enterprise_accounts
+		WHERE enterprise_id = ?
+		ORDER BY id
+	`, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []EnterpriseAccount{}
+	for rows.Next() {
+		var account EnterpriseAccount
+		if err := scanEnterpriseAccount(rows, &account); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// GetEnterpriseAccountByNumber retrieves a settlement account by its account number
+func GetEnterpriseAccountByNumber(accountNumber string) (*EnterpriseAccount, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var account EnterpriseAccount
+	row := DB.QueryRow(`SELECT `+enterpriseAccountColumns+` FROM enterprise_accounts WHERE account_number = ?`, accountNumber)
+	if err := scanEnterpriseAccount(row, &account); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEnterpriseAccountNotFound
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+// getEnterpriseAccountTx returns the primary (oldest) settlement account of an
+// enterprise, opening one with a zero balance if the enterprise has none yet
+func getEnterpriseAccountTx(tx *sql.Tx, enterpriseID int) (*EnterpriseAccount, error) {
+	var account EnterpriseAccount
+	row := tx.QueryRow(`
+		SELECT `+enterpriseAccountColumns+`
+		FROM enterprise_accounts
+		WHERE enterprise_id = ?
+		ORDER BY id
+		LIMIT 1
+	`, enterpriseID)
+	err := scanEnterpriseAccount(row, &account)
+	if err == nil {
+		return &account, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	return openEnterpriseAccountTx(tx, enterpriseID, "", DefaultEnterpriseCurrency, 0)
+}
+
+// getEnterpriseAccountByIDTx loads a settlement account by ID inside tx
+func getEnterpriseAccountByIDTx(tx *sql.Tx, accountID int64) (*EnterpriseAccount, error) {
+	var account EnterpriseAccount
+	row := tx.QueryRow(`SELECT `+enterpriseAccountColumns+` FROM enterprise_accounts WHERE id = ?`, accountID)
+	if err := scanEnterpriseAccount(row, &account); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEnterpriseAccountNotFound
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+// enterpriseNameTx returns the name of an enterprise for ledger counterparties, or an empty
+// string when it cannot be read
+func enterpriseNameTx(tx *sql.Tx, enterpriseID int) string {
+	var name string
+	if err := tx.QueryRow("SELECT name FROM enterprises WHERE id = ?", enterpriseID).Scan(&name); err != nil {
+		return ""
+	}
+	return name
+}
+
+// postEnterpriseMovementTx changes the balance of a settlement account by amount
+// and records the movement. Debits beyond the overdraft limit are rejected.
+func postEnterpriseMovementTx(tx *sql.Tx, accountID int64, amount float64, kind, reference, description string, actorID int64) error {
+	account, err := getEnterpriseAccountByIDTx(tx, accountID)
+	if err != nil {
+		return err
+	}
+
+	if amount < 0 && account.Available() < -amount {
+		return fmt.Errorf("%w: account %s has %.2f available, %.2f required",
+			ErrInsufficientEnterpriseBalance, account.AccountNumber, account.Available(), -amount)
+	}
+
+	now := time.Now().Unix()
+	balanceAfter := account.Balance + amount
+	_, err = tx.Exec(`
+		UPDATE enterprise_accounts SET balance = ?, updated_at = ?
+		WHERE id = ?
+	`, balanceAfter, now, accountID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO enterprise_account_movements (
+			account_id, amount, balance_after, kind, reference, description, created_by, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, accountID, amount, balanceAfter, kind, reference, description, actorID, now)
+	return err
+}
+
+// CreditEnterpriseAccount funds an enterprise settlement account on behalf of an admin.
+// When accountID is zero the primary account of the enterprise is credited.
+func CreditEnterpriseAccount(enterpriseID int, accountID int64, amount float64, adminID int64, reference string) (*EnterpriseAccount, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	if amount <= 0 {
+		return nil, errors.New("amount must be greater than 0")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var account *EnterpriseAccount
+	if accountID > 0 {
+		account, err = getEnterpriseAccountByIDTx(tx, accountID)
+		if err == nil && account.EnterpriseID != enterpriseID {
+			err = ErrEnterpriseAccountNotFound
+		}
+	} else {
+		account, err = getEnterpriseAccountTx(tx, enterpriseID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	description := fmt.Sprintf("Account funded by admin #%d", adminID)
+	if err := postEnterpriseMovementTx(tx, account.ID, amount, "admin_credit", reference, description, adminID); err != nil {
+		return nil, err
+	}
+
+	account, err = getEnterpriseAccountByIDTx(tx, account.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	metadata := fmt.Sprintf("Credited enterprise ID %d account %s", enterpriseID, account.AccountNumber)
+	if reference != "" {
+		metadata += ". Reference: " + reference
+	}
+	LogTransaction(adminID, "enterprise_credit", &amount, metadata)
+
+	return account, nil
+}
+
+// SetEnterpriseOverdraftLimit changes the overdraft limit of a settlement account
+func SetEnterpriseOverdraftLimit(accountID int64, limit float64) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	if limit < 0 {
+		return errors.New("overdraft limit cannot be negative")
+	}
+
+	result, err := DB.Exec(`
+		UPDATE enterprise_accounts SET overdraft_limit = ?, updated_at = ?
+		WHERE id = ?
+	`, limit, time.Now().Unix(), accountID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEnterpriseAccountNotFound
+	}
+
+	return nil
+}
+
+// CheckEnterpriseFunds verifies the primary settlement account of an enterprise can cover amount
+func CheckEnterpriseFunds(enterpriseID int, amount float64) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	account, err := getEnterpriseAccountTx(tx, enterpriseID)
+	if err != nil {
+		return err
+	}
+
+	if account.Available() < amount {
+		return fmt.Errorf("%w: account %s has %.2f available, %.2f required",
+			ErrInsufficientEnterpriseBalance, account.AccountNumber, account.Available(), amount)
+	}
+
+	// Keep the account opened by getEnterpriseAccountTx, if any
+	return tx.Commit()
+}
+
+// GetEnterpriseStatement builds a statement of all settlement accounts of an enterprise
+// for movements created between from and to (unix seconds, inclusive)
+func GetEnterpriseStatement(enterpriseID int, from, to int64) (*EnterpriseStatement, error) {
+	accounts, err := GetEnterpriseAccounts(enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &EnterpriseStatement{
+		EnterpriseID: enterpriseID,
+		From:         from,
+		To:           to,
+		Accounts:     []EnterpriseAccountStatement{},
+	}
+
+	for _, account := range accounts {
+		accountStatement := EnterpriseAccountStatement{
+			Account:   account,
+			Movements: []EnterpriseAccountMovement{},
+		}
+
+		// Opening balance is the current balance minus everything posted since the period start
+		var postedSince float64
+		err := DB.QueryRow(`
+			SELECT COALESCE(SUM(amount), 0) FROM enterprise_account_movements
+			WHERE account_id = ? AND created_at >= ?
+		`, account.ID, from).Scan(&postedSince)
+		if err != nil {
+			return nil, err
+		}
+		accountStatement.OpeningBalance = account.Balance - postedSince
+		accountStatement.ClosingBalance = accountStatement.OpeningBalance
+
+		rows, err := DB.Query(`
+			SELECT id, account_id, amount, balance_after, kind,
+			       COALESCE(reference, ''), COALESCE(description, ''), COALESCE(created_by, 0), created_at
+			FROM enterprise_account_movements
+			WHERE account_id = ? AND created_at >= ? AND created_at <= ?
+			ORDER BY created_at, id
+		`, account.ID, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var movement EnterpriseAccountMovement
+			if err := rows.Scan(
+				&movement.ID,
+				&movement.AccountID,
+				&movement.Amount,
+				&movement.BalanceAfter,
+				&movement.Kind,
+				&movement.Reference,
+				&movement.Description,
+				&movement.CreatedBy,
+				&movement.CreatedAt,
+			); err != nil {
+				rows.Close()
+				return nil, err
+			}
+
+			if movement.Amount >= 0 {
+				accountStatement.TotalCredits += movement.Amount
+			} else {
+				accountStatement.TotalDebits += -movement.Amount
+			}
+			accountStatement.ClosingBalance += movement.Amount
+			accountStatement.Movements = append(accountStatement.Movements, movement)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		statement.Accounts = append(statement.Accounts, accountStatement)
+	}
+
+	return statement, nil
+}
+
+// executeEnterpriseTransferTx moves the funds of an enterprise transfer inside tx
+func executeEnterpriseTransferTx(tx *sql.Tx, transfer *EnterpriseTransfer, actorID int64) error {
+	fromAccount, err := getEnterpriseAccountTx(tx, transfer.FromEnterpriseID)
+	if err != nil {
+		return err
+	}
+
+	reference := fmt.Sprintf("transfer #%d", transfer.ID)
+
+	// Transfers to an employee are credited to the employee's deposit
+	if transfer.ToEmployeeID > 0 {
+		depositID, err := getEmployeeDepositTx(tx, transfer.ToEnterpriseID, int64(transfer.ToEmployeeID))
+		if err != nil {
+			return err
+		}
+		description := fmt.Sprintf("Transfer to employee ID %d: %s", transfer.ToEmployeeID, transfer.Purpose)
+		if err := postEnterpriseMovementTx(tx, fromAccount.ID, -transfer.Amount, "transfer_out", reference, description, actorID); err != nil {
+			return err
+		}
+		if err := creditDepositTx(tx, depositID, transfer.Amount, time.Now()); err != nil {
+			return err
+		}
+		return recordDepositMovementTx(tx, &models.DepositMovement{
+			DepositID: depositID, Type: models.MovementTransferIn, Amount: transfer.Amount,
+			Counterparty: enterpriseNameTx(tx, transfer.FromEnterpriseID), Reference: reference,
+		})
+	}
+
+	toAccount, err := getEnterpriseAccountTx(tx, transfer.ToEnterpriseID)
+	if err != nil {
+		return err
+	}
+	if toAccount.Currency != fromAccount.Currency {
+		return fmt.Errorf("%w: %s account cannot pay into %s account",
+			ErrEnterpriseCurrencyMismatch, fromAccount.Currency, toAccount.Currency)
+	}
+
+	description := fmt.Sprintf("Transfer to enterprise ID %d: %s", transfer.ToEnterpriseID, transfer.Purpose)
+	if err := postEnterpriseMovementTx(tx, fromAccount.ID, -transfer.Amount, "transfer_out", reference, description, actorID); err != nil {
+		return err
+	}
+
+	description = fmt.Sprintf("Transfer from enterprise ID %d: %s", transfer.FromEnterpriseID, transfer.Purpose)
+	return postEnterpriseMovementTx(tx, toAccount.ID, transfer.Amount, "transfer_in", reference, description, actorID)
+}
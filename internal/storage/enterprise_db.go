@@ -8,12 +8,39 @@ import (
 	"time"
 )
 
+var (
+	// ErrTransferFailed is returned when an approved enterprise transfer could not be executed
+	ErrTransferFailed = errors.New("transfer execution failed")
+	// ErrEnterpriseTransferNotPending is returned when another request approved or rejected the transfer first
+	ErrEnterpriseTransferNotPending = errors.New("transfer was processed by another request")
+)
+
+// TransferFailedError is returned when an approved enterprise transfer could not be executed
+// and was moved to the failed status. It matches ErrTransferFailed and unwraps to the cause,
+// such as ErrInsufficientEnterpriseBalance.
+type TransferFailedError struct {
+	TransferID int64
+	Err        error
+}
+
+func (e *TransferFailedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrTransferFailed, e.Err)
+}
+
+func (e *TransferFailedError) Unwrap() error {
+	return e.Err
+}
+
+func (e *TransferFailedError) Is(target error) bool {
+	return target == ErrTransferFailed
+}
+
 // EnterpriseTransfer represents a transfer between enterprises or to an employee
 type EnterpriseTransfer struct {
 	ID               int64   `json:"id"`
 	FromEnterpriseID int     `json:"from_enterprise_id"`
 	ToEnterpriseID   int     `json:"to_enterprise_id"`
-	ToEmployeeID     int     `json:"to_employee_id,omitempty"`
+	ToEmployeeID     int     `json:"to_employee_id,omitempty"` // Registry ID in enterprise_employees of the receiving enterprise
 	Amount           float64 `json:"amount"`
 	Status           string  `json:"status"`
 	Purpose          string  `json:"purpose"`
@@ -22,6 +49,9 @@ type EnterpriseTransfer struct {
 	RequestedAt      int64   `json:"requested_at"`
 	ProcessedBy      int     `json:"processed_by,omitempty"`
 	ProcessedAt      int64   `json:"processed_at,omitempty"`
+	BatchID          int64   `json:"batch_id,omitempty"`       // Payment batch the transfer was imported with
+	EndToEndID       string  `json:"end_to_end_id,omitempty"`  // Reference assigned by the enterprise's software
+	FailureReason    string  `json:"failure_reason,omitempty"` // Why an approved transfer could not be executed
 }
 
 // SalaryProject represents a salary project submission
@@ -33,11 +63,14 @@ type SalaryProject struct {
 	TotalAmount    float64 `json:"total_amount"`
 	DocumentURL    string  `json:"document_url"`
 	Comment        string  `json:"comment,omitempty"`
-	Status         string  `json:"status"` // Status can be: "pending", "approved", "rejected"
+	Status         string  `json:"status"` // Status can be: "draft", "pending", "approved", "rejected", "disbursing", "completed"
 	SubmittedBy    int     `json:"submitted_by"`
 	SubmittedAt    int64   `json:"submitted_at"`
 	ProcessedBy    int     `json:"processed_by,omitempty"`
 	ProcessedAt    int64   `json:"processed_at,omitempty"`
+	TemplateID     int64   `json:"template_id,omitempty"`
+	PayDate        int64   `json:"pay_date,omitempty"`
+	ConfirmedAt    int64   `json:"confirmed_at,omitempty"`
 }
 
 // Enterprise represents a company or organization
@@ -58,17 +91,22 @@ type EnterpriseUser struct {
 
 // SalaryPayment represents a payment to an employee in a salary project
 type SalaryPayment struct {
-	ID               int64   `json:"id"`
-	ProjectID        int64   `json:"project_id"`
-	EmployeeName     string  `json:"employee_name"`
-	EmployeePosition string  `json:"employee_position"`
-	Amount           float64 `json:"amount"`
-	AccountNumber    string  `json:"account_number"`
-	BankName         string  `json:"bank_name"`
-	PaymentPurpose   string  `json:"payment_purpose"`
-	DocumentURL      string  `json:"document_url"`
-	Status           string  `json:"status"` // Status can be: "pending", "approved", "rejected"
-	CreatedAt        int64   `json:"created_at"`
+	ID                int64   `json:"id"`
+	ProjectID         int64   `json:"project_id"`
+	EmployeeID        int64   `json:"employee_id,omitempty"`
+	EmployeeName      string  `json:"employee_name"`
+	EmployeePosition  string  `json:"employee_position"`
+	Amount            float64 `json:"amount"`
+	AccountNumber     string  `json:"account_number"`
+	BankName          string  `json:"bank_name"`
+	PaymentPurpose    string  `json:"payment_purpose"`
+	DocumentURL       string  `json:"document_url"`
+	Status            string  `json:"status"` // Status can be: "pending", "paid", "failed"
+	FailureReason     string  `json:"failure_reason,omitempty"`
+	DepositID         int64   `json:"deposit_id,omitempty"`
+	OutgoingPaymentID int64   `json:"outgoing_payment_id,omitempty"`
+	ProcessedAt       int64   `json:"processed_at,omitempty"`
+	CreatedAt         int64   `json:"created_at"`
 }
 
 // EnsureEnterpriseTablesExist creates required tables for enterprise functionality
@@ -126,6 +164,9 @@ func EnsureEnterpriseTablesExist() error {
 	if err != nil {
 		return err
 	}
+	if err := ensureColumnExists("enterprise_transfers", "failure_reason", "TEXT"); err != nil {
+		return err
+	}
 
 	// Create salary_projects table
 	_, err = DB.Exec(`
@@ -151,6 +192,10 @@ func EnsureEnterpriseTablesExist() error {
 		return err
 	}
 
+	if err := EnsureEnterpriseAccountsTableExists(); err != nil {
+		return err
+	}
+
 	// Create salary_payments table
 	_, err = DB.Exec(`
 		CREATE TABLE IF NOT EXISTS salary_payments (
@@ -172,7 +217,19 @@ func EnsureEnterpriseTablesExist() error {
 		return err
 	}
 
-	return nil
+	if err := EnsureEnterpriseEmployeesTableExists(); err != nil {
+		return err
+	}
+
+	if err := ensureSalaryDisbursementColumnsExist(); err != nil {
+		return err
+	}
+
+	if err := EnsurePayrollTemplateTablesExist(); err != nil {
+		return err
+	}
+
+	return EnsurePaymentBatchTablesExist()
 }
 
 // CheckUserEnterpriseAuthorization checks if a user is authorized for a specific enterprise
@@ -196,6 +253,33 @@ func CheckUserEnterpriseAuthorization(userID, enterpriseID int) bool {
 	return count > 0
 }
 
+// CheckUserEnterpriseRole checks if a user has one of the given roles in a specific enterprise
+func CheckUserEnterpriseRole(userID, enterpriseID int, roles ...string) bool {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		log.Printf("Error ensuring enterprise tables exist: %v", err)
+		return false
+	}
+
+	var role string
+	err := DB.QueryRow(`
+		SELECT role FROM enterprise_users
+		WHERE user_id = ? AND enterprise_id = ?
+	`, userID, enterpriseID).Scan(&role)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error checking enterprise role: %v", err)
+		}
+		return false
+	}
+
+	for _, allowed := range roles {
+		if role == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 // GetEnterpriseTransfers retrieves transfers for a specific enterprise
 func GetEnterpriseTransfers(enterpriseID int, status string) ([]EnterpriseTransfer, error) {
 	if err := EnsureEnterpriseTablesExist(); err != nil {
@@ -206,7 +290,8 @@ func GetEnterpriseTransfers(enterpriseID int, status string) ([]EnterpriseTransf
 		SELECT 
 			id, from_enterprise_id, to_enterprise_id, to_employee_id,
 			amount, status, purpose, comment,
-			requested_by, requested_at, processed_by, processed_at
+			requested_by, requested_at, processed_by, processed_at,
+			batch_id, end_to_end_id, failure_reason
 		FROM enterprise_transfers
 		WHERE from_enterprise_id = ?
 	`
@@ -232,11 +317,15 @@ func GetEnterpriseTransfers(enterpriseID int, status string) ([]EnterpriseTransf
 		var comment sql.NullString
 		var processedBy sql.NullInt64
 		var processedAt sql.NullInt64
+		var batchID sql.NullInt64
+		var endToEndID sql.NullString
+		var failureReason sql.NullString
 
 		err := rows.Scan(
 			&transfer.ID, &transfer.FromEnterpriseID, &transfer.ToEnterpriseID, &toEmployeeID,
 			&transfer.Amount, &transfer.Status, &transfer.Purpose, &comment,
 			&transfer.RequestedBy, &transfer.RequestedAt, &processedBy, &processedAt,
+			&batchID, &endToEndID, &failureReason,
 		)
 		if err != nil {
 			return nil, err
@@ -255,6 +344,15 @@ func GetEnterpriseTransfers(enterpriseID int, status string) ([]EnterpriseTransf
 		if processedAt.Valid {
 			transfer.ProcessedAt = processedAt.Int64
 		}
+		if batchID.Valid {
+			transfer.BatchID = batchID.Int64
+		}
+		if endToEndID.Valid {
+			transfer.EndToEndID = endToEndID.String
+		}
+		if failureReason.Valid {
+			transfer.FailureReason = failureReason.String
+		}
 
 		transfers = append(transfers, transfer)
 	}
@@ -276,7 +374,8 @@ func GetEnterpriseSalaryProjects(enterpriseID int) ([]SalaryProject, error) {
 		SELECT 
 			id, enterprise_id, enterprise_name, employee_count,
 			total_amount, document_url, comment, status,
-			submitted_by, submitted_at, processed_by, processed_at
+			submitted_by, submitted_at, processed_by, processed_at,
+			COALESCE(template_id, 0), COALESCE(pay_date, 0), COALESCE(confirmed_at, 0)
 		FROM salary_projects
 		WHERE enterprise_id = ?
 		ORDER BY submitted_at DESC
@@ -299,6 +398,7 @@ func GetEnterpriseSalaryProjects(enterpriseID int) ([]SalaryProject, error) {
 			&project.ID, &project.EnterpriseID, &project.EnterpriseName, &project.EmployeeCount,
 			&project.TotalAmount, &project.DocumentURL, &comment, &project.Status,
 			&project.SubmittedBy, &project.SubmittedAt, &processedBy, &processedAt,
+			&project.TemplateID, &project.PayDate, &project.ConfirmedAt,
 		)
 		if err != nil {
 			return nil, err
@@ -398,45 +498,6 @@ func SaveEnterpriseTransfer(transfer *EnterpriseTransfer) (int64, error) {
 	return result.LastInsertId()
 }
 
-// SaveSalaryProject saves a new salary project to the database
-func SaveSalaryProject(project *SalaryProject) (int64, error) {
-	if err := EnsureEnterpriseTablesExist(); err != nil {
-		return 0, err
-	}
-
-	// Set default status to pending
-	project.Status = "pending"
-	project.SubmittedAt = time.Now().Unix()
-
-	result, err := DB.Exec(`
-		INSERT INTO salary_projects (
-			enterprise_id, enterprise_name, employee_count,
-			total_amount, document_url, comment, status,
-			submitted_by, submitted_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`,
-		project.EnterpriseID,
-		project.EnterpriseName,
-		project.EmployeeCount,
-		project.TotalAmount,
-		project.DocumentURL,
-		project.Comment,
-		project.Status,
-		project.SubmittedBy,
-		project.SubmittedAt,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
-	return id, nil
-}
-
 // GetPendingSalaryProjects retrieves all pending salary projects
 func GetPendingSalaryProjects() ([]SalaryProject, error) {
 	if err := EnsureEnterpriseTablesExist(); err != nil {
@@ -514,7 +575,8 @@ func GetPendingTransfers() ([]EnterpriseTransfer, error) {
 		SELECT 
 			id, from_enterprise_id, to_enterprise_id, to_employee_id,
 			amount, status, purpose, comment,
-			requested_by, requested_at, processed_by, processed_at
+			requested_by, requested_at, processed_by, processed_at,
+			batch_id, end_to_end_id, failure_reason
 		FROM enterprise_transfers
 		WHERE status = 'pending'
 		ORDER BY requested_at DESC
@@ -533,11 +595,15 @@ func GetPendingTransfers() ([]EnterpriseTransfer, error) {
 		var comment sql.NullString
 		var processedBy sql.NullInt64
 		var processedAt sql.NullInt64
+		var batchID sql.NullInt64
+		var endToEndID sql.NullString
+		var failureReason sql.NullString
 
 		err := rows.Scan(
 			&transfer.ID, &transfer.FromEnterpriseID, &transfer.ToEnterpriseID, &toEmployeeID,
 			&transfer.Amount, &transfer.Status, &transfer.Purpose, &comment,
 			&transfer.RequestedBy, &transfer.RequestedAt, &processedBy, &processedAt,
+			&batchID, &endToEndID, &failureReason,
 		)
 		if err != nil {
 			return nil, err
@@ -556,6 +622,15 @@ func GetPendingTransfers() ([]EnterpriseTransfer, error) {
 		if processedAt.Valid {
 			transfer.ProcessedAt = processedAt.Int64
 		}
+		if batchID.Valid {
+			transfer.BatchID = batchID.Int64
+		}
+		if endToEndID.Valid {
+			transfer.EndToEndID = endToEndID.String
+		}
+		if failureReason.Valid {
+			transfer.FailureReason = failureReason.String
+		}
 
 		transfers = append(transfers, transfer)
 	}
@@ -586,6 +661,26 @@ func ApproveSalaryProject(projectID, adminID int64, comment string) error {
 	if status != "pending" {
 		return errors.New("only pending salary projects can be approved")
 	}
+	if err := checkSalaryProjectHasPayments(projectID); err != nil {
+		return err
+	}
+
+	// The enterprise must be able to fund the whole payroll
+	var enterpriseID int
+	var totalAmount float64
+	var submittedBy int64
+	err = DB.QueryRow("SELECT enterprise_id, total_amount, submitted_by FROM salary_projects WHERE id = ?", projectID).Scan(&enterpriseID, &totalAmount, &submittedBy)
+	if err != nil {
+		return err
+	}
+	if err := CheckEnterpriseFunds(enterpriseID, totalAmount); err != nil {
+		return err
+	}
+
+	// Large payrolls may need more than one approver
+	if err := recordOperationApproval(OperationSalaryProject, projectID, submittedBy, adminID, totalAmount); err != nil {
+		return err
+	}
 
 	// Update the salary project status
 	_, err = DB.Exec(`
@@ -674,76 +769,184 @@ func RejectSalaryProject(projectID, adminID int64, reason string) error {
 	return nil
 }
 
-// ApproveEnterpriseTransfer approves an enterprise transfer request
-func ApproveEnterpriseTransfer(transferID, adminID int64, comment string) error {
+// GetEnterpriseTransfer retrieves a single enterprise transfer request by ID
+func GetEnterpriseTransfer(transferID int64) (*EnterpriseTransfer, error) {
 	if err := EnsureEnterpriseTablesExist(); err != nil {
-		return err
+		return nil, err
 	}
 
-	// Check if transfer exists and is in pending status
-	var status string
-	err := DB.QueryRow("SELECT status FROM enterprise_transfers WHERE id = ?", transferID).Scan(&status)
+	var transfer EnterpriseTransfer
+	var toEmployeeID sql.NullInt64
+	var comment sql.NullString
+	var processedBy sql.NullInt64
+	var processedAt sql.NullInt64
+	var batchID sql.NullInt64
+	var endToEndID sql.NullString
+	var failureReason sql.NullString
+
+	err := DB.QueryRow(`
+		SELECT 
+			id, from_enterprise_id, to_enterprise_id, to_employee_id,
+			amount, status, purpose, comment,
+			requested_by, requested_at, processed_by, processed_at,
+			batch_id, end_to_end_id, failure_reason
+		FROM enterprise_transfers
+		WHERE id = ?
+	`, transferID).Scan(
+		&transfer.ID, &transfer.FromEnterpriseID, &transfer.ToEnterpriseID, &toEmployeeID,
+		&transfer.Amount, &transfer.Status, &transfer.Purpose, &comment,
+		&transfer.RequestedBy, &transfer.RequestedAt, &processedBy, &processedAt,
+		&batchID, &endToEndID, &failureReason,
+	)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return errors.New("transfer request not found")
+			return nil, errors.New("transfer request not found")
 		}
+		return nil, err
+	}
+
+	if toEmployeeID.Valid {
+		transfer.ToEmployeeID = int(toEmployeeID.Int64)
+	}
+	if comment.Valid {
+		transfer.Comment = comment.String
+	}
+	if processedBy.Valid {
+		transfer.ProcessedBy = int(processedBy.Int64)
+	}
+	if processedAt.Valid {
+		transfer.ProcessedAt = processedAt.Int64
+	}
+	if batchID.Valid {
+		transfer.BatchID = batchID.Int64
+	}
+	if endToEndID.Valid {
+		transfer.EndToEndID = endToEndID.String
+	}
+	if failureReason.Valid {
+		transfer.FailureReason = failureReason.String
+	}
+
+	return &transfer, nil
+}
+
+// ApproveEnterpriseTransfer approves an enterprise transfer request and executes it.
+// If the funds cannot be moved the request is left in the failed status.
+func ApproveEnterpriseTransfer(transferID, adminID int64, comment string) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
 		return err
 	}
 
-	if status != "pending" {
+	transfer, err := GetEnterpriseTransfer(transferID)
+	if err != nil {
+		return err
+	}
+
+	if transfer.Status != "pending" {
 		return errors.New("only pending transfers can be approved")
 	}
 
-	// Update the transfer status
-	_, err = DB.Exec(`
-		UPDATE enterprise_transfers
-		SET status = 'approved', processed_by = ?, processed_at = ?, comment = CASE WHEN ? <> '' THEN ? ELSE comment END
-		WHERE id = ?
-	`, adminID, time.Now().Unix(), comment, comment, transferID)
-	if err != nil {
+	// Large transfers may need more than one approver
+	if err := recordOperationApproval(OperationEnterpriseTransfer, transferID, int64(transfer.RequestedBy), adminID, transfer.Amount); err != nil {
 		return err
 	}
 
+	recipientText := fmt.Sprintf("enterprise ID %d", transfer.ToEnterpriseID)
+	if transfer.ToEmployeeID > 0 {
+		recipientText = fmt.Sprintf("employee ID %d at enterprise ID %d",
+			transfer.ToEmployeeID, transfer.ToEnterpriseID)
+	}
+
+	if execErr := executeApprovedTransfer(transfer, adminID, comment); execErr != nil {
+		if !isEnterpriseTransferFailure(execErr) {
+			// Busy database, a failed commit or a concurrent approval: the transfer stays pending
+			return execErr
+		}
+		// Record the failure so the request does not stay pending forever
+		if err := markEnterpriseTransferFailed(transferID, adminID, execErr.Error()); err != nil {
+			if errors.Is(err, ErrEnterpriseTransferNotPending) {
+				return err
+			}
+			log.Printf("Error marking transfer #%d as failed: %v", transferID, err)
+		}
+		metadata := fmt.Sprintf("Failed transfer #%d from enterprise ID %d to %s: %v",
+			transferID, transfer.FromEnterpriseID, recipientText, execErr)
+		LogTransaction(adminID, "transfer_failed", &transfer.Amount, metadata)
+		return &TransferFailedError{TransferID: transferID, Err: execErr}
+	}
+
 	// Log the approval
-	var transferInfo struct {
-		FromEnterpriseID int
-		ToEnterpriseID   int
-		ToEmployeeID     sql.NullInt64
-		Amount           float64
-		Purpose          string
-		RequestedBy      int64
+	metadata := fmt.Sprintf("Approved transfer #%d from enterprise ID %d to %s, requested by user ID %d. Purpose: %s",
+		transferID, transfer.FromEnterpriseID, recipientText, transfer.RequestedBy, transfer.Purpose)
+	if comment != "" {
+		metadata += ". Comment: " + comment
 	}
+	LogTransaction(adminID, "transfer_approval", &transfer.Amount, metadata)
 
-	err = DB.QueryRow(`
-		SELECT from_enterprise_id, to_enterprise_id, to_employee_id, amount, purpose, requested_by
-		FROM enterprise_transfers WHERE id = ?
-	`, transferID).Scan(
-		&transferInfo.FromEnterpriseID,
-		&transferInfo.ToEnterpriseID,
-		&transferInfo.ToEmployeeID,
-		&transferInfo.Amount,
-		&transferInfo.Purpose,
-		&transferInfo.RequestedBy,
-	)
+	return nil
+}
 
-	if err == nil {
-		recipientText := fmt.Sprintf("enterprise ID %d", transferInfo.ToEnterpriseID)
-		if transferInfo.ToEmployeeID.Valid {
-			recipientText = fmt.Sprintf("employee ID %d at enterprise ID %d",
-				transferInfo.ToEmployeeID.Int64, transferInfo.ToEnterpriseID)
-		}
+// executeApprovedTransfer moves the funds and marks the transfer approved in one transaction
+func executeApprovedTransfer(transfer *EnterpriseTransfer, adminID int64, comment string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-		metadata := fmt.Sprintf("Approved transfer #%d from enterprise ID %d to %s, requested by user ID %d. Purpose: %s",
-			transferID, transferInfo.FromEnterpriseID, recipientText, transferInfo.RequestedBy, transferInfo.Purpose)
-		if comment != "" {
-			metadata += ". Comment: " + comment
-		}
-		LogTransaction(adminID, "transfer_approval", &transferInfo.Amount, metadata)
+	if err := executeEnterpriseTransferTx(tx, transfer, adminID); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE enterprise_transfers
+		SET status = 'approved', processed_by = ?, processed_at = ?, comment = CASE WHEN ? <> '' THEN ? ELSE comment END
+		WHERE id = ? AND status = 'pending'
+	`, adminID, time.Now().Unix(), comment, comment, transfer.ID)
+	if err != nil {
+		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEnterpriseTransferNotPending
+	}
+
+	return tx.Commit()
+}
+
+// markEnterpriseTransferFailed moves a pending transfer to the failed status with the failure reason
+func markEnterpriseTransferFailed(transferID, adminID int64, reason string) error {
+	result, err := DB.Exec(`
+		UPDATE enterprise_transfers
+		SET status = 'failed', processed_by = ?, processed_at = ?, failure_reason = ?
+		WHERE id = ? AND status = 'pending'
+	`, adminID, time.Now().Unix(), reason, transferID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEnterpriseTransferNotPending
+	}
 	return nil
 }
 
+// isEnterpriseTransferFailure reports whether err means an approved transfer can never be
+// executed, rather than a transient error after which it stays pending
+func isEnterpriseTransferFailure(err error) bool {
+	return errors.Is(err, ErrInsufficientEnterpriseBalance) || errors.Is(err, ErrEnterpriseCurrencyMismatch) ||
+		errors.Is(err, ErrEnterpriseEmployeeNotFound) || errors.Is(err, ErrEmployeeTerminated) ||
+		errors.Is(err, ErrEmployeeNoPayoutDeposit) || errors.Is(err, ErrPayeeDepositBlocked) ||
+		errors.Is(err, ErrPayeeDepositClosed) || errors.Is(err, ErrDepositNotFound) || errors.Is(err, ErrDepositNotActive)
+}
+
 // RejectEnterpriseTransfer rejects an enterprise transfer request
 func RejectEnterpriseTransfer(transferID, adminID int64, reason string) error {
 	if err := EnsureEnterpriseTablesExist(); err != nil {
@@ -947,7 +1150,9 @@ func GetSalaryProjectPayments(projectID int64) ([]SalaryPayment, error) {
 			id, project_id, employee_name, employee_position,
 			amount, account_number, bank_name,
 			payment_purpose, document_url, status,
-			created_at
+			COALESCE(failure_reason, ''), COALESCE(deposit_id, 0),
+			COALESCE(outgoing_payment_id, 0), COALESCE(processed_at, 0),
+			COALESCE(employee_id, 0), created_at
 		FROM salary_payments
 		WHERE project_id = ?
 		ORDER BY created_at DESC
@@ -973,6 +1178,11 @@ func GetSalaryProjectPayments(projectID int64) ([]SalaryPayment, error) {
 			&payment.PaymentPurpose,
 			&payment.DocumentURL,
 			&payment.Status,
+			&payment.FailureReason,
+			&payment.DepositID,
+			&payment.OutgoingPaymentID,
+			&payment.ProcessedAt,
+			&payment.EmployeeID,
 			&payment.CreatedAt,
 		)
 		if err != nil {
@@ -1001,6 +1211,81 @@ func SaveMultipleSalaryPayments(payments []*SalaryPayment) ([]int64, error) {
 	}
 	defer tx.Rollback()
 
+	paymentIDs, err := insertSalaryPaymentsTx(tx, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Commit the transaction
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return paymentIDs, nil
+}
+
+// SaveSalaryProjectWithPayments saves a salary project together with its payments
+// in a single transaction, so a project never exists without its payroll rows
+func SaveSalaryProjectWithPayments(project *SalaryProject, payments []*SalaryPayment) (int64, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	project.Status = "pending"
+	project.SubmittedAt = time.Now().Unix()
+
+	result, err := tx.Exec(`
+		INSERT INTO salary_projects (
+			enterprise_id, enterprise_name, employee_count,
+			total_amount, document_url, comment, status,
+			submitted_by, submitted_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		project.EnterpriseID,
+		project.EnterpriseName,
+		project.EmployeeCount,
+		project.TotalAmount,
+		project.DocumentURL,
+		project.Comment,
+		project.Status,
+		project.SubmittedBy,
+		project.SubmittedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	projectID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	project.ID = projectID
+
+	for _, payment := range payments {
+		payment.ProjectID = projectID
+		if payment.DocumentURL == "" {
+			payment.DocumentURL = project.DocumentURL
+		}
+	}
+	if _, err := insertSalaryPaymentsTx(tx, payments); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return projectID, nil
+}
+
+// insertSalaryPaymentsTx inserts salary payments with pending status within a transaction
+func insertSalaryPaymentsTx(tx *sql.Tx, payments []*SalaryPayment) ([]int64, error) {
 	var paymentIDs []int64
 	for _, payment := range payments {
 		// Set default status to pending
@@ -1012,8 +1297,8 @@ func SaveMultipleSalaryPayments(payments []*SalaryPayment) ([]int64, error) {
 				project_id, employee_name, employee_position,
 				amount, account_number, bank_name,
 				payment_purpose, document_url, status,
-				created_at
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				created_at, employee_id
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		`,
 			payment.ProjectID,
 			payment.EmployeeName,
@@ -1025,6 +1310,7 @@ func SaveMultipleSalaryPayments(payments []*SalaryPayment) ([]int64, error) {
 			payment.DocumentURL,
 			payment.Status,
 			payment.CreatedAt,
+			nullableInt64(payment.EmployeeID),
 		)
 		if err != nil {
 			return nil, err
@@ -1034,13 +1320,9 @@ func SaveMultipleSalaryPayments(payments []*SalaryPayment) ([]int64, error) {
 		if err != nil {
 			return nil, err
 		}
+		payment.ID = id
 		paymentIDs = append(paymentIDs, id)
 	}
 
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return nil, err
-	}
-
 	return paymentIDs, nil
 }
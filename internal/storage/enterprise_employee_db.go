@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrEnterpriseEmployeeNotFound is returned when an employee is not in the enterprise registry
+	ErrEnterpriseEmployeeNotFound = errors.New("employee not found in enterprise registry")
+	// ErrEmployeeTerminated is returned when paying an employee whose employment has ended
+	ErrEmployeeTerminated = errors.New("employee is terminated")
+	// ErrEmployeeNumberTaken is returned when an employee number is already used in the enterprise
+	ErrEmployeeNumberTaken = errors.New("employee number already exists in this enterprise")
+	// ErrEmployeeNoPayoutDeposit is returned when an employee has no deposit that can receive a transfer
+	ErrEmployeeNoPayoutDeposit = errors.New("employee has no deposit to receive the transfer")
+)
+
+// EnterpriseEmployee is an entry of an enterprise's employee registry
+type EnterpriseEmployee struct {
+	ID              int64  `json:"id"`
+	EnterpriseID    int    `json:"enterprise_id"`
+	EmployeeNumber  string `json:"employee_number"`
+	FullName        string `json:"full_name"`
+	Position        string `json:"position"`
+	UserID          int    `json:"user_id,omitempty"`
+	PayoutDepositID int64  `json:"payout_deposit_id,omitempty"`
+	HiredAt         int64  `json:"hired_at"`
+	TerminatedAt    int64  `json:"terminated_at,omitempty"`
+	CreatedAt       int64  `json:"created_at"`
+	UpdatedAt       int64  `json:"updated_at"`
+}
+
+// IsTerminated reports whether the employment has ended at the given time
+func (e *EnterpriseEmployee) IsTerminated(at time.Time) bool {
+	return e.TerminatedAt > 0 && e.TerminatedAt <= at.Unix()
+}
+
+// IsPayoutAccount reports whether accountNumber is the payout deposit of the employee
+func (e *EnterpriseEmployee) IsPayoutAccount(accountNumber string) bool {
+	return e.PayoutDepositID != 0 && strconv.FormatInt(e.PayoutDepositID, 10) == strings.TrimSpace(accountNumber)
+}
+
+// EnsureEnterpriseEmployeesTableExists creates the employee registry table
+func EnsureEnterpriseEmployeesTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_employees (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			enterprise_id INTEGER NOT NULL,
+			employee_number TEXT NOT NULL,
+			full_name TEXT NOT NULL,
+			position TEXT NOT NULL,
+			user_id INTEGER,
+			payout_deposit_id INTEGER,
+			hired_at INTEGER NOT NULL,
+			terminated_at INTEGER,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			UNIQUE(enterprise_id, employee_number),
+			FOREIGN KEY (enterprise_id) REFERENCES enterprises(id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			FOREIGN KEY (payout_deposit_id) REFERENCES deposits(deposit_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Salary payments keep a link to the registry entry they were matched to
+	return ensureColumnExists("salary_payments", "employee_id", "INTEGER")
+}
+
+// validateEmployeeLinks checks the linked user and payout deposit of an employee
+func validateEmployeeLinks(employee *EnterpriseEmployee) error {
+	if employee.UserID > 0 {
+		var exists int
+		err := DB.QueryRow("SELECT COUNT(*) FROM users WHERE id = ?", employee.UserID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("user ID %d does not exist", employee.UserID)
+		}
+	}
+
+	if employee.PayoutDepositID > 0 {
+		var clientID int
+		err := DB.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", employee.PayoutDepositID).Scan(&clientID)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("deposit %d does not exist", employee.PayoutDepositID)
+		}
+		if err != nil {
+			return err
+		}
+		if employee.UserID > 0 && clientID != employee.UserID {
+			return fmt.Errorf("deposit %d does not belong to user ID %d", employee.PayoutDepositID, employee.UserID)
+		}
+	}
+
+	if employee.TerminatedAt > 0 && employee.TerminatedAt < employee.HiredAt {
+		return errors.New("termination date cannot be before the hire date")
+	}
+	return nil
+}
+
+// nullableInt64 stores zero as NULL
+func nullableInt64(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != 0}
+}
+
+// CreateEnterpriseEmployee adds an employee to the registry of an enterprise
+func CreateEnterpriseEmployee(employee *EnterpriseEmployee) (int64, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+	if err := validateEmployeeLinks(employee); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	employee.CreatedAt = now
+	employee.UpdatedAt = now
+	if employee.HiredAt == 0 {
+		employee.HiredAt = now
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO enterprise_employees (
+			enterprise_id, employee_number, full_name, position, user_id,
+			payout_deposit_id, hired_at, terminated_at, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		employee.EnterpriseID,
+		employee.EmployeeNumber,
+		employee.FullName,
+		employee.Position,
+		nullableInt64(int64(employee.UserID)),
+		nullableInt64(employee.PayoutDepositID),
+		employee.HiredAt,
+		nullableInt64(employee.TerminatedAt),
+		employee.CreatedAt,
+		employee.UpdatedAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrEmployeeNumberTaken
+		}
+		if strings.Contains(err.Error(), "FOREIGN KEY constraint failed") {
+			return 0, ErrEnterpriseNotFound
+		}
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	employee.ID = id
+	return id, nil
+}
+
+const enterpriseEmployeeColumns = `
+	id, enterprise_id, employee_number, full_name, position,
+	COALESCE(user_id, 0), COALESCE(payout_deposit_id, 0), hired_at,
+	COALESCE(terminated_at, 0), created_at, updated_at
+`
+
+func scanEnterpriseEmployee(row interface{ Scan(...interface{}) error }, employee *EnterpriseEmployee) error {
+	return row.Scan(
+		&employee.ID, &employee.EnterpriseID, &employee.EmployeeNumber, &employee.FullName,
+		&employee.Position, &employee.UserID, &employee.PayoutDepositID, &employee.HiredAt,
+		&employee.TerminatedAt, &employee.CreatedAt, &employee.UpdatedAt,
+	)
+}
+
+// GetEnterpriseEmployees lists the registry of an enterprise.
+// Terminated employees are only included when includeTerminated is set.
+func GetEnterpriseEmployees(enterpriseID int, includeTerminated bool) ([]EnterpriseEmployee, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + enterpriseEmployeeColumns + " FROM enterprise_employees WHERE enterprise_id = ?"
+	args := []interface{}{enterpriseID}
+	if !includeTerminated {
+		query += " AND (terminated_at IS NULL OR terminated_at > ?)"
+		args = append(args, time.Now().Unix())
+	}
+	query += " ORDER BY full_name, id"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	employees := []EnterpriseEmployee{}
+	for rows.Next() {
+		var employee EnterpriseEmployee
+		if err := scanEnterpriseEmployee(rows, &employee); err != nil {
+			return nil, err
+		}
+		employees = append(employees, employee)
+	}
+	return employees, rows.Err()
+}
+
+// GetEnterpriseEmployee retrieves an employee of an enterprise by registry ID
+func GetEnterpriseEmployee(enterpriseID int, employeeID int64) (*EnterpriseEmployee, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var employee EnterpriseEmployee
+	err := scanEnterpriseEmployee(DB.QueryRow(
+		"SELECT "+enterpriseEmployeeColumns+" FROM enterprise_employees WHERE id = ? AND enterprise_id = ?",
+		employeeID, enterpriseID,
+	), &employee)
+	if err == sql.ErrNoRows {
+		return nil, ErrEnterpriseEmployeeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &employee, nil
+}
+
+// UpdateEnterpriseEmployee saves changes to a registry entry
+func UpdateEnterpriseEmployee(employee *EnterpriseEmployee) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+	if err := validateEmployeeLinks(employee); err != nil {
+		return err
+	}
+
+	employee.UpdatedAt = time.Now().Unix()
+	result, err := DB.Exec(`
+		UPDATE enterprise_employees SET
+			employee_number = ?, full_name = ?, position = ?, user_id = ?,
+			payout_deposit_id = ?, hired_at = ?, terminated_at = ?, updated_at = ?
+		WHERE id = ? AND enterprise_id = ?
+	`,
+		employee.EmployeeNumber,
+		employee.FullName,
+		employee.Position,
+		nullableInt64(int64(employee.UserID)),
+		nullableInt64(employee.PayoutDepositID),
+		employee.HiredAt,
+		nullableInt64(employee.TerminatedAt),
+		employee.UpdatedAt,
+		employee.ID,
+		employee.EnterpriseID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrEmployeeNumberTaken
+		}
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrEnterpriseEmployeeNotFound
+	}
+	return nil
+}
+
+// DeleteEnterpriseEmployee removes an employee from the registry.
+// Employees that have payments are kept for history and must be terminated instead.
+func DeleteEnterpriseEmployee(enterpriseID int, employeeID int64) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	var payments int
+	err := DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM salary_payments WHERE employee_id = ?)
+			+ (SELECT COUNT(*) FROM enterprise_transfers WHERE to_enterprise_id = ? AND to_employee_id = ?)
+	`, employeeID, enterpriseID, employeeID).Scan(&payments)
+	if err != nil {
+		return err
+	}
+	if payments > 0 {
+		return errors.New("employee has payments, set a termination date instead")
+	}
+
+	result, err := DB.Exec("DELETE FROM enterprise_employees WHERE id = ? AND enterprise_id = ?", employeeID, enterpriseID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrEnterpriseEmployeeNotFound
+	}
+	return nil
+}
+
+// FindEnterpriseEmployee matches a payroll line to the registry. The payout deposit is
+// compared with the account number first, then the full name is compared case-insensitively.
+func FindEnterpriseEmployee(employees []EnterpriseEmployee, fullName, accountNumber string) *EnterpriseEmployee {
+	if depositID, err := strconv.ParseInt(strings.TrimSpace(accountNumber), 10, 64); err == nil {
+		for i := range employees {
+			if employees[i].PayoutDepositID == depositID {
+				return &employees[i]
+			}
+		}
+	}
+
+	name := strings.Join(strings.Fields(strings.ToLower(fullName)), " ")
+	for i := range employees {
+		if strings.Join(strings.Fields(strings.ToLower(employees[i].FullName)), " ") == name {
+			return &employees[i]
+		}
+	}
+	return nil
+}
+
+// getEmployeeDepositTx finds the deposit a transfer to a registry employee is credited to:
+// the employee's payout deposit, or else the oldest usable deposit of the linked user
+func getEmployeeDepositTx(tx *sql.Tx, enterpriseID int, employeeID int64) (int64, error) {
+	var payoutDepositID, userID, terminatedAt sql.NullInt64
+	err := tx.QueryRow(`
+		SELECT payout_deposit_id, user_id, terminated_at FROM enterprise_employees
+		WHERE id = ? AND enterprise_id = ?
+	`, employeeID, enterpriseID).Scan(&payoutDepositID, &userID, &terminatedAt)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: employee ID %d", ErrEnterpriseEmployeeNotFound, employeeID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if terminatedAt.Valid && terminatedAt.Int64 <= time.Now().Unix() {
+		return 0, fmt.Errorf("%w: employee ID %d", ErrEmployeeTerminated, employeeID)
+	}
+
+	if payoutDepositID.Valid {
+		var status string
+		var isBlocked, isFrozen bool
+		err := tx.QueryRow("SELECT status, is_blocked, is_frozen FROM deposits WHERE deposit_id = ?",
+			payoutDepositID.Int64).Scan(&status, &isBlocked, &isFrozen)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, fmt.Errorf("%w: payout deposit %d of employee ID %d no longer exists",
+					ErrEmployeeNoPayoutDeposit, payoutDepositID.Int64, employeeID)
+			}
+			return 0, err
+		}
+		if err := checkPayeeDeposit(payoutDepositID.Int64, status, isBlocked, isFrozen); err != nil {
+			return 0, err
+		}
+		return payoutDepositID.Int64, nil
+	}
+
+	if !userID.Valid {
+		return 0, fmt.Errorf("%w: employee ID %d has no payout deposit or linked user", ErrEmployeeNoPayoutDeposit, employeeID)
+	}
+
+	var depositID int64
+	err = tx.QueryRow(`
+		SELECT deposit_id FROM deposits
+		WHERE client_id = ? AND status != ? AND is_blocked = 0 AND is_frozen = 0
+		ORDER BY created_at
+		LIMIT 1
+	`, userID.Int64, models.DepositClosed).Scan(&depositID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: employee ID %d has no active deposit", ErrEmployeeNoPayoutDeposit, employeeID)
+		}
+		return 0, err
+	}
+	return depositID, nil
+}
@@ -0,0 +1,660 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Enterprise level roles of specialists linked to an enterprise
+const (
+	EnterpriseRoleAdmin      = "admin"
+	EnterpriseRoleAccountant = "accountant"
+	EnterpriseRoleSpecialist = "specialist"
+	EnterpriseRoleViewer     = "viewer"
+)
+
+// EnterprisePaymentRoles returns the enterprise level roles that may submit payrolls, transfers
+// and payment batches and maintain the employee registry. Viewers can only read.
+func EnterprisePaymentRoles() []string {
+	return []string{EnterpriseRoleAdmin, EnterpriseRoleAccountant, EnterpriseRoleSpecialist}
+}
+
+var (
+	// ErrApplicationNotFound is returned when an onboarding application does not exist
+	ErrApplicationNotFound = errors.New("onboarding application not found")
+	// ErrApplicationNotPending is returned when reviewing or changing an application that was already reviewed
+	ErrApplicationNotPending = errors.New("only pending applications can be changed")
+	// ErrEnterpriseAlreadyRegistered is returned when an enterprise with the same registration number exists
+	ErrEnterpriseAlreadyRegistered = errors.New("an enterprise with this registration number already exists")
+	// ErrInvalidEnterpriseRole is returned for an unknown enterprise level role
+	ErrInvalidEnterpriseRole = errors.New("role must be one of admin, accountant, specialist, viewer")
+	// ErrEnterpriseAccessNotFound is returned when revoking access a user does not have
+	ErrEnterpriseAccessNotFound = errors.New("user has no access to this enterprise")
+	// ErrLastEnterpriseAdmin is returned when revoking the only admin of an enterprise
+	ErrLastEnterpriseAdmin = errors.New("cannot remove the last admin of an enterprise")
+)
+
+// registrationNumberPattern matches registration numbers and tax IDs
+var registrationNumberPattern = regexp.MustCompile(`^[0-9A-Za-z-]{5,20}$`)
+
+// IsValidEnterpriseRole reports whether role is a known enterprise level role
+func IsValidEnterpriseRole(role string) bool {
+	switch role {
+	case EnterpriseRoleAdmin, EnterpriseRoleAccountant, EnterpriseRoleSpecialist, EnterpriseRoleViewer:
+		return true
+	}
+	return false
+}
+
+// EnterpriseApplication is a request to onboard a new enterprise
+type EnterpriseApplication struct {
+	ID                 int64                   `json:"id"`
+	ApplicantID        int                     `json:"applicant_id"`
+	Name               string                  `json:"name"`
+	Description        string                  `json:"description,omitempty"`
+	RegistrationNumber string                  `json:"registration_number"`
+	TaxID              string                  `json:"tax_id"`
+	LegalAddress       string                  `json:"legal_address"`
+	Status             string                  `json:"status"` // Status can be: "pending", "approved", "rejected"
+	EnterpriseID       int                     `json:"enterprise_id,omitempty"`
+	ReviewComment      string                  `json:"review_comment,omitempty"`
+	ReviewedBy         int                     `json:"reviewed_by,omitempty"`
+	ReviewedAt         int64                   `json:"reviewed_at,omitempty"`
+	SubmittedAt        int64                   `json:"submitted_at"`
+	Signatories        []EnterpriseSignatory   `json:"signatories"`
+	Documents          []EnterpriseKYBDocument `json:"documents"`
+}
+
+// EnterpriseSignatory is a person authorized to sign on behalf of an enterprise
+type EnterpriseSignatory struct {
+	ID             int64  `json:"id"`
+	ApplicationID  int64  `json:"application_id"`
+	FullName       string `json:"full_name"`
+	Position       string `json:"position"`
+	DocumentNumber string `json:"document_number"`
+	UserID         int    `json:"user_id,omitempty"`
+}
+
+// EnterpriseKYBDocument is a document uploaded with an onboarding application
+type EnterpriseKYBDocument struct {
+	ID            int64  `json:"id"`
+	ApplicationID int64  `json:"application_id"`
+	DocType       string `json:"doc_type"`
+	FileName      string `json:"file_name"`
+	StoredPath    string `json:"-"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	UploadedBy    int    `json:"uploaded_by"`
+	UploadedAt    int64  `json:"uploaded_at"`
+}
+
+// EnterpriseAccess is the access of a specialist to an enterprise
+type EnterpriseAccess struct {
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	Role       string `json:"role"`
+	AssignedAt int64  `json:"assigned_at"`
+}
+
+// EnsureEnterpriseOnboardingTablesExist creates the onboarding application tables
+func EnsureEnterpriseOnboardingTablesExist() error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_applications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			applicant_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT,
+			registration_number TEXT NOT NULL,
+			tax_id TEXT NOT NULL,
+			legal_address TEXT NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			enterprise_id INTEGER,
+			review_comment TEXT,
+			reviewed_by INTEGER,
+			reviewed_at INTEGER,
+			submitted_at INTEGER NOT NULL,
+			FOREIGN KEY (applicant_id) REFERENCES users(id),
+			FOREIGN KEY (enterprise_id) REFERENCES enterprises(id),
+			FOREIGN KEY (reviewed_by) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_signatories (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			application_id INTEGER NOT NULL,
+			full_name TEXT NOT NULL,
+			position TEXT NOT NULL,
+			document_number TEXT NOT NULL,
+			user_id INTEGER,
+			FOREIGN KEY (application_id) REFERENCES enterprise_applications(id),
+			FOREIGN KEY (user_id) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS enterprise_documents (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			application_id INTEGER NOT NULL,
+			doc_type TEXT NOT NULL,
+			file_name TEXT NOT NULL,
+			stored_path TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			uploaded_by INTEGER NOT NULL,
+			uploaded_at INTEGER NOT NULL,
+			FOREIGN KEY (application_id) REFERENCES enterprise_applications(id),
+			FOREIGN KEY (uploaded_by) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct{ column, definition string }{
+		{"registration_number", "TEXT"},
+		{"tax_id", "TEXT"},
+		{"legal_address", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := ensureColumnExists("enterprises", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	_, err = DB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_enterprises_registration_number
+		ON enterprises(registration_number) WHERE registration_number IS NOT NULL
+	`)
+	return err
+}
+
+// validateEnterpriseApplication checks the fields of an onboarding application
+func validateEnterpriseApplication(application *EnterpriseApplication) error {
+	application.Name = strings.TrimSpace(application.Name)
+	application.RegistrationNumber = strings.TrimSpace(application.RegistrationNumber)
+	application.TaxID = strings.TrimSpace(application.TaxID)
+	application.LegalAddress = strings.TrimSpace(application.LegalAddress)
+
+	if application.Name == "" {
+		return errors.New("name is required")
+	}
+	if !registrationNumberPattern.MatchString(application.RegistrationNumber) {
+		return errors.New("registration_number must be 5 to 20 letters, digits or dashes")
+	}
+	if !registrationNumberPattern.MatchString(application.TaxID) {
+		return errors.New("tax_id must be 5 to 20 letters, digits or dashes")
+	}
+	if application.LegalAddress == "" {
+		return errors.New("legal_address is required")
+	}
+	if len(application.Signatories) == 0 {
+		return errors.New("at least one authorized signatory is required")
+	}
+	for i, signatory := range application.Signatories {
+		if strings.TrimSpace(signatory.FullName) == "" || strings.TrimSpace(signatory.Position) == "" ||
+			strings.TrimSpace(signatory.DocumentNumber) == "" {
+			return fmt.Errorf("signatory %d needs full_name, position and document_number", i+1)
+		}
+	}
+	return nil
+}
+
+// SubmitEnterpriseApplication saves a new onboarding application with its signatories
+func SubmitEnterpriseApplication(application *EnterpriseApplication) (int64, error) {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return 0, err
+	}
+	if err := validateEnterpriseApplication(application); err != nil {
+		return 0, err
+	}
+
+	var registered int
+	err := DB.QueryRow("SELECT COUNT(*) FROM enterprises WHERE registration_number = ?", application.RegistrationNumber).Scan(&registered)
+	if err != nil {
+		return 0, err
+	}
+	if registered > 0 {
+		return 0, ErrEnterpriseAlreadyRegistered
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	application.Status = "pending"
+	application.SubmittedAt = time.Now().Unix()
+	result, err := tx.Exec(`
+		INSERT INTO enterprise_applications (
+			applicant_id, name, description, registration_number, tax_id,
+			legal_address, status, submitted_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		application.ApplicantID,
+		application.Name,
+		application.Description,
+		application.RegistrationNumber,
+		application.TaxID,
+		application.LegalAddress,
+		application.Status,
+		application.SubmittedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+	application.ID, err = result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range application.Signatories {
+		signatory := &application.Signatories[i]
+		signatory.ApplicationID = application.ID
+		result, err := tx.Exec(`
+			INSERT INTO enterprise_signatories (application_id, full_name, position, document_number, user_id)
+			VALUES (?, ?, ?, ?, ?)
+		`, signatory.ApplicationID, signatory.FullName, signatory.Position, signatory.DocumentNumber,
+			nullableInt64(int64(signatory.UserID)))
+		if err != nil {
+			return 0, err
+		}
+		signatory.ID, err = result.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	LogTransaction(int64(application.ApplicantID), "enterprise_application", nil,
+		fmt.Sprintf("Onboarding application #%d for %s (registration number %s)",
+			application.ID, application.Name, application.RegistrationNumber))
+
+	return application.ID, nil
+}
+
+// AddEnterpriseApplicationDocument records a KYB document stored for a pending application
+func AddEnterpriseApplicationDocument(document *EnterpriseKYBDocument) (int64, error) {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return 0, err
+	}
+
+	var status string
+	err := DB.QueryRow("SELECT status FROM enterprise_applications WHERE id = ?", document.ApplicationID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return 0, ErrApplicationNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status != "pending" {
+		return 0, ErrApplicationNotPending
+	}
+
+	document.UploadedAt = time.Now().Unix()
+	result, err := DB.Exec(`
+		INSERT INTO enterprise_documents (
+			application_id, doc_type, file_name, stored_path, size, sha256, uploaded_by, uploaded_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		document.ApplicationID,
+		document.DocType,
+		document.FileName,
+		document.StoredPath,
+		document.Size,
+		document.SHA256,
+		document.UploadedBy,
+		document.UploadedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	document.ID = id
+	return id, nil
+}
+
+const enterpriseApplicationColumns = `
+	id, applicant_id, name, COALESCE(description, ''), registration_number, tax_id,
+	legal_address, status, COALESCE(enterprise_id, 0), COALESCE(review_comment, ''),
+	COALESCE(reviewed_by, 0), COALESCE(reviewed_at, 0), submitted_at
+`
+
+func scanEnterpriseApplication(row interface{ Scan(...interface{}) error }, application *EnterpriseApplication) error {
+	return row.Scan(
+		&application.ID, &application.ApplicantID, &application.Name, &application.Description,
+		&application.RegistrationNumber, &application.TaxID, &application.LegalAddress,
+		&application.Status, &application.EnterpriseID, &application.ReviewComment,
+		&application.ReviewedBy, &application.ReviewedAt, &application.SubmittedAt,
+	)
+}
+
+// GetEnterpriseApplication retrieves an application with its signatories and documents
+func GetEnterpriseApplication(applicationID int64) (*EnterpriseApplication, error) {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var application EnterpriseApplication
+	err := scanEnterpriseApplication(DB.QueryRow(
+		"SELECT "+enterpriseApplicationColumns+" FROM enterprise_applications WHERE id = ?", applicationID,
+	), &application)
+	if err == sql.ErrNoRows {
+		return nil, ErrApplicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	application.Signatories = []EnterpriseSignatory{}
+	rows, err := DB.Query(`
+		SELECT id, application_id, full_name, position, document_number, COALESCE(user_id, 0)
+		FROM enterprise_signatories WHERE application_id = ? ORDER BY id
+	`, applicationID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var signatory EnterpriseSignatory
+		if err := rows.Scan(&signatory.ID, &signatory.ApplicationID, &signatory.FullName,
+			&signatory.Position, &signatory.DocumentNumber, &signatory.UserID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		application.Signatories = append(application.Signatories, signatory)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	application.Documents = []EnterpriseKYBDocument{}
+	rows, err = DB.Query(`
+		SELECT id, application_id, doc_type, file_name, stored_path, size, sha256, uploaded_by, uploaded_at
+		FROM enterprise_documents WHERE application_id = ? ORDER BY id
+	`, applicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var document EnterpriseKYBDocument
+		if err := rows.Scan(&document.ID, &document.ApplicationID, &document.DocType, &document.FileName,
+			&document.StoredPath, &document.Size, &document.SHA256, &document.UploadedBy,
+			&document.UploadedAt); err != nil {
+			return nil, err
+		}
+		application.Documents = append(application.Documents, document)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &application, nil
+}
+
+// GetEnterpriseApplications lists applications, optionally filtered by status and applicant.
+// Signatories and documents are not loaded.
+func GetEnterpriseApplications(status string, applicantID int) ([]EnterpriseApplication, error) {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + enterpriseApplicationColumns + " FROM enterprise_applications WHERE 1 = 1"
+	var args []interface{}
+	if status != "" {
+		query += " AND status = ?"
+		args = append(args, status)
+	}
+	if applicantID > 0 {
+		query += " AND applicant_id = ?"
+		args = append(args, applicantID)
+	}
+	query += " ORDER BY submitted_at DESC, id DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applications := []EnterpriseApplication{}
+	for rows.Next() {
+		var application EnterpriseApplication
+		if err := scanEnterpriseApplication(rows, &application); err != nil {
+			return nil, err
+		}
+		applications = append(applications, application)
+	}
+	return applications, rows.Err()
+}
+
+// ApproveEnterpriseApplication creates the enterprise of a pending application, opens its
+// settlement account and gives the applicant the admin role in the new enterprise
+func ApproveEnterpriseApplication(applicationID, adminID int64, comment string) (int64, error) {
+	application, err := GetEnterpriseApplication(applicationID)
+	if err != nil {
+		return 0, err
+	}
+	if application.Status != "pending" {
+		return 0, ErrApplicationNotPending
+	}
+	if len(application.Documents) == 0 {
+		return 0, errors.New("application has no KYB documents")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		INSERT INTO enterprises (name, description, registration_number, tax_id, legal_address, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, application.Name, application.Description, application.RegistrationNumber,
+		application.TaxID, application.LegalAddress, now)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrEnterpriseAlreadyRegistered
+		}
+		return 0, err
+	}
+	enterpriseID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := openEnterpriseAccountTx(tx, int(enterpriseID), "", "", 0); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO enterprise_users (user_id, enterprise_id, role, assigned_at)
+		VALUES (?, ?, ?, ?)
+	`, application.ApplicantID, enterpriseID, EnterpriseRoleAdmin, now)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = tx.Exec(`
+		UPDATE enterprise_applications
+		SET status = 'approved', enterprise_id = ?, review_comment = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, enterpriseID, comment, adminID, now, applicationID)
+	if err != nil {
+		return 0, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return 0, ErrApplicationNotPending
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	LogTransaction(adminID, "enterprise_application_approval", nil,
+		fmt.Sprintf("Approved onboarding application #%d, created enterprise %s (ID: %d)",
+			applicationID, application.Name, enterpriseID))
+
+	return enterpriseID, nil
+}
+
+// RejectEnterpriseApplication rejects a pending application
+func RejectEnterpriseApplication(applicationID, adminID int64, reason string) error {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE enterprise_applications
+		SET status = 'rejected', review_comment = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, reason, adminID, time.Now().Unix(), applicationID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		var exists int
+		if err := DB.QueryRow("SELECT COUNT(*) FROM enterprise_applications WHERE id = ?", applicationID).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrApplicationNotFound
+		}
+		return ErrApplicationNotPending
+	}
+
+	LogTransaction(adminID, "enterprise_application_rejection", nil,
+		fmt.Sprintf("Rejected onboarding application #%d. Reason: %s", applicationID, reason))
+	return nil
+}
+
+// GrantEnterpriseAccess gives a user access to an enterprise with an enterprise level role,
+// or changes the role when the user already has access
+func GrantEnterpriseAccess(enterpriseID, userID int, role string, adminID int64) error {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return err
+	}
+	if !IsValidEnterpriseRole(role) {
+		return ErrInvalidEnterpriseRole
+	}
+
+	var exists int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM enterprises WHERE id = ?", enterpriseID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrEnterpriseNotFound
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO enterprise_users (user_id, enterprise_id, role, assigned_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, enterprise_id) DO UPDATE SET role = excluded.role, assigned_at = excluded.assigned_at
+	`, userID, enterpriseID, role, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(adminID, "enterprise_access_grant", nil,
+		fmt.Sprintf("Granted user ID %d the %s role in enterprise ID %d", userID, role, enterpriseID))
+	return nil
+}
+
+// RevokeEnterpriseAccess removes the access of a user to an enterprise
+func RevokeEnterpriseAccess(enterpriseID, userID int, adminID int64) error {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRow("SELECT role FROM enterprise_users WHERE user_id = ? AND enterprise_id = ?", userID, enterpriseID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return ErrEnterpriseAccessNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if role == EnterpriseRoleAdmin {
+		var admins int
+		err := tx.QueryRow("SELECT COUNT(*) FROM enterprise_users WHERE enterprise_id = ? AND role = ?",
+			enterpriseID, EnterpriseRoleAdmin).Scan(&admins)
+		if err != nil {
+			return err
+		}
+		if admins <= 1 {
+			return ErrLastEnterpriseAdmin
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM enterprise_users WHERE user_id = ? AND enterprise_id = ?", userID, enterpriseID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	LogTransaction(adminID, "enterprise_access_revoke", nil,
+		fmt.Sprintf("Revoked access of user ID %d to enterprise ID %d", userID, enterpriseID))
+	return nil
+}
+
+// GetEnterpriseAccessList lists the users that have access to an enterprise
+func GetEnterpriseAccessList(enterpriseID int) ([]EnterpriseAccess, error) {
+	if err := EnsureEnterpriseOnboardingTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT eu.user_id, u.username, eu.role, eu.assigned_at
+		FROM enterprise_users eu
+		JOIN users u ON u.id = eu.user_id
+		WHERE eu.enterprise_id = ?
+		ORDER BY eu.assigned_at
+	`, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	access := []EnterpriseAccess{}
+	for rows.Next() {
+		var entry EnterpriseAccess
+		if err := rows.Scan(&entry.UserID, &entry.Username, &entry.Role, &entry.AssignedAt); err != nil {
+			return nil, err
+		}
+		access = append(access, entry)
+	}
+	return access, rows.Err()
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when an idempotency key comes back with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key has already been used for a different request")
+	// ErrIdempotentRequestInProgress is returned while the first request with a key is still running
+	ErrIdempotentRequestInProgress = errors.New("a request with this idempotency key is still being processed")
+	// ErrIdempotentRequestInterrupted is returned when the server handling the first request with a
+	// key stopped before answering. The request may have completed, so it is never run again.
+	ErrIdempotentRequestInterrupted = errors.New("the request with this idempotency key was interrupted and may have completed; check its outcome before retrying with a new key")
+)
+
+// IdempotencyLease is how long a reserved key stays in progress without being renewed. The
+// request holding the key renews it while it runs, so an expired lease means the server died.
+const IdempotencyLease = time.Minute
+
+// IdempotentResponse is the response stored for an idempotency key
+type IdempotentResponse struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// EnsureIdempotencyKeysTableExists creates the table holding idempotency keys and the responses
+// sent for them. A key is scoped to the caller that used it, so clients cannot collide.
+func EnsureIdempotencyKeysTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			scope TEXT NOT NULL,
+			idempotency_key TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			status_code INTEGER,
+			content_type TEXT,
+			body BLOB,
+			created_at INTEGER NOT NULL,
+			completed_at INTEGER,
+			PRIMARY KEY (scope, idempotency_key)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	return ensureColumnExists("idempotency_keys", "started_at", "INTEGER")
+}
+
+// BeginIdempotentRequest reserves an idempotency key for a request with the given fingerprint.
+// It returns nil when the request should run, and the stored response when the same request was
+// already completed under the key. Keys older than retention are purged first and can be reused.
+// A key whose request has not answered is never handed to a retry, since its work may have been
+// committed.
+func BeginIdempotentRequest(scope, key, fingerprint string, retention time.Duration) (*IdempotentResponse, error) {
+	if err := EnsureIdempotencyKeysTableExists(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if _, err := DB.Exec(`DELETE FROM idempotency_keys WHERE created_at < ?`, now.Add(-retention).Unix()); err != nil {
+		return nil, err
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO idempotency_keys (scope, idempotency_key, fingerprint, created_at, started_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, scope, key, fingerprint, now.Unix(), now.Unix())
+	if err == nil {
+		return nil, nil
+	}
+	if !strings.Contains(err.Error(), "UNIQUE constraint") {
+		return nil, err
+	}
+
+	var storedFingerprint string
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	var startedAt int64
+	err = DB.QueryRow(`
+		SELECT fingerprint, status_code, content_type, body, COALESCE(started_at, created_at) FROM idempotency_keys
+		WHERE scope = ? AND idempotency_key = ?
+	`, scope, key).Scan(&storedFingerprint, &statusCode, &contentType, &body, &startedAt)
+	if err != nil {
+		return nil, err
+	}
+	if storedFingerprint != fingerprint {
+		return nil, ErrIdempotencyKeyReused
+	}
+	if !statusCode.Valid {
+		if time.Unix(startedAt, 0).Before(now.Add(-IdempotencyLease)) {
+			return nil, ErrIdempotentRequestInterrupted
+		}
+		return nil, ErrIdempotentRequestInProgress
+	}
+	return &IdempotentResponse{StatusCode: int(statusCode.Int64), ContentType: contentType.String, Body: body}, nil
+}
+
+// CompleteIdempotentRequest stores the response sent for a key reserved by BeginIdempotentRequest
+func CompleteIdempotentRequest(scope, key string, response IdempotentResponse) error {
+	_, err := DB.Exec(`
+		UPDATE idempotency_keys SET status_code = ?, content_type = ?, body = ?, completed_at = ?
+		WHERE scope = ? AND idempotency_key = ?
+	`, response.StatusCode, response.ContentType, response.Body, time.Now().Unix(), scope, key)
+	return err
+}
+
+// RenewIdempotencyKey extends the lease of a key whose request is still running
+func RenewIdempotencyKey(scope, key string) error {
+	_, err := DB.Exec(`
+		UPDATE idempotency_keys SET started_at = ?
+		WHERE scope = ? AND idempotency_key = ? AND status_code IS NULL
+	`, time.Now().Unix(), scope, key)
+	return err
+}
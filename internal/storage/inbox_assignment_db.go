@@ -0,0 +1,83 @@
+package storage
+
+import "time"
+
+// InboxAssignment hands a pending inbox item to a specific reviewer
+type InboxAssignment struct {
+	Source     string `json:"source"`
+	ItemID     int64  `json:"item_id"`
+	AssigneeID int64  `json:"assignee_id"`
+	AssignedBy int64  `json:"assigned_by"`
+	AssignedAt int64  `json:"assigned_at"`
+}
+
+// EnsureInboxAssignmentsTableExists creates the inbox assignments table
+func EnsureInboxAssignmentsTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS inbox_assignments (
+			source TEXT NOT NULL,
+			item_id INTEGER NOT NULL,
+			assignee_id INTEGER NOT NULL,
+			assigned_by INTEGER NOT NULL,
+			assigned_at INTEGER NOT NULL,
+			PRIMARY KEY (source, item_id),
+			FOREIGN KEY (assignee_id) REFERENCES users(id)
+		)
+	`)
+	return err
+}
+
+// AssignInboxItem assigns an inbox item to a reviewer, replacing any earlier assignment
+func AssignInboxItem(assignment *InboxAssignment) error {
+	if err := EnsureInboxAssignmentsTableExists(); err != nil {
+		return err
+	}
+
+	assignment.AssignedAt = time.Now().Unix()
+	_, err := DB.Exec(`
+		INSERT INTO inbox_assignments (source, item_id, assignee_id, assigned_by, assigned_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(source, item_id) DO UPDATE SET
+			assignee_id = excluded.assignee_id,
+			assigned_by = excluded.assigned_by,
+			assigned_at = excluded.assigned_at
+	`, assignment.Source, assignment.ItemID, assignment.AssigneeID, assignment.AssignedBy, assignment.AssignedAt)
+	return err
+}
+
+// DeleteInboxAssignment removes the assignment of an inbox item, if any
+func DeleteInboxAssignment(source string, itemID int64) error {
+	if err := EnsureInboxAssignmentsTableExists(); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec("DELETE FROM inbox_assignments WHERE source = ? AND item_id = ?", source, itemID)
+	return err
+}
+
+// GetInboxAssignments returns all current inbox assignments
+func GetInboxAssignments() ([]InboxAssignment, error) {
+	if err := EnsureInboxAssignmentsTableExists(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT source, item_id, assignee_id, assigned_by, assigned_at
+		FROM inbox_assignments
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []InboxAssignment{}
+	for rows.Next() {
+		var assignment InboxAssignment
+		if err := rows.Scan(&assignment.Source, &assignment.ItemID, &assignment.AssigneeID,
+			&assignment.AssignedBy, &assignment.AssignedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
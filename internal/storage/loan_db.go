@@ -46,27 +46,42 @@ func EnsureLoansTableExists() error {
 			FOREIGN KEY (loan_id) REFERENCES loans(id)
 		)
 	`
-	_, err := DB.Exec(paymentsTableQuery)
-	return err
-}
+	if _, err := DB.Exec(paymentsTableQuery); err != nil {
+		return err
+	}
 
-// Calculate fixed interest rate for specific loan terms
-func getFixedInterestRate(termMonths int) float64 {
-	switch termMonths {
-	case 3:
-		return 5.0 // 5% for 3-month loans
-	case 6:
-		return 7.5 // 7.5% for 6-month loans
-	case 12:
-		return 10.0 // 10% for 12-month loans
-	case 24:
-		return 15.0 // 15% for 24-month loans
-	default:
-		if termMonths > 24 {
-			return 20.0 // 20% for loans over 24 months
-		}
-		return 12.5 // Default rate for other terms
+	// Approval and rejection details written by the loan state machine
+	if err := ensureColumnExists("loans", "approved_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("loans", "rejected_by", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("loans", "rejected_at", "TIMESTAMP"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("loans", "rejection_reason", "TEXT"); err != nil {
+		return err
+	}
+
+	// Product version, fee and rate override of the loan
+	if err := ensureColumnExists("loans", "product_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("loans", "fee_amount", "REAL NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("loans", "rate_override_by", "INTEGER"); err != nil {
+		return err
 	}
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return err
+	}
+	if err := EnsureLoanSecurityTablesExist(); err != nil {
+		return err
+	}
+
+	return EnsureLoanStatusHistoryTableExists()
 }
 
 // Calculate loan parameters
@@ -98,22 +113,38 @@ func RequestLoan(request models.LoanRequest) (*models.Loan, error) {
 		return nil, errors.New("loan term must be at least one month")
 	}
 
-	// Determine interest rate - use provided rate or get fixed rate based on term
-	var interestRate float64
-	if request.InterestRate != nil {
+	// The product in effect today sets the rate and fees; the loan keeps this version
+	now := time.Now()
+	product, err := selectLoanProduct(request, now)
+	if err != nil {
+		return nil, err
+	}
+	interestRate, _ := product.RateFor(request.TermMonths, request.Amount)
+
+	// Only a manager can replace the product rate
+	var rateOverrideBy *int64
+	if request.InterestRate != nil && request.RateOverrideBy > 0 {
+		if *request.InterestRate < 0 {
+			return nil, errors.New("interest rate cannot be negative")
+		}
 		interestRate = *request.InterestRate
-	} else {
-		interestRate = getFixedInterestRate(request.TermMonths)
+		rateOverrideBy = &request.RateOverrideBy
 	}
 
-	// Calculate total payable amount and monthly payment
-	totalPayable, monthlyPayment := calculateLoanParameters(request.Amount, request.TermMonths, interestRate)
+	// Calculate total payable amount and monthly payment; the fee is repaid with the loan
+	fee := math.Round(product.Fee(request.Amount)*100) / 100
+	if request.Promotional {
+		interestRate = 0
+		fee = 0
+	}
+	totalPayable, _ := calculateLoanParameters(request.Amount, request.TermMonths, interestRate)
+	totalPayable += fee
+	monthlyPayment := totalPayable / float64(request.TermMonths)
 
 	// Create loan record
-	now := time.Now()
 	loan := &models.Loan{
 		UserID:         request.UserID,
-		Type:           request.Type,
+		Type:           product.Type,
 		Amount:         request.Amount,
 		Term:           request.TermMonths,
 		InterestRate:   interestRate,
@@ -122,16 +153,26 @@ func RequestLoan(request models.LoanRequest) (*models.Loan, error) {
 		Status:         models.Pending, // All loans start as pending
 		CreatedAt:      now,
 		UpdatedAt:      now,
+		ProductID:      &product.ID,
+		FeeAmount:      fee,
+		RateOverrideBy: rateOverrideBy,
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
 	}
+	defer tx.Rollback()
 
 	// Insert into database
 	query := `
 		INSERT INTO loans (
 			user_id, loan_type, amount, term_months, interest_rate, 
-			total_payable, monthly_payment, status, created_at, updated_at
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			total_payable, monthly_payment, status, created_at, updated_at,
+			product_id, fee_amount, rate_override_by
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	result, err := DB.Exec(
+	result, err := tx.Exec(
 		query,
 		loan.UserID,
 		loan.Type,
@@ -143,6 +184,9 @@ func RequestLoan(request models.LoanRequest) (*models.Loan, error) {
 		loan.Status,
 		loan.CreatedAt,
 		loan.UpdatedAt,
+		product.ID,
+		loan.FeeAmount,
+		rateOverrideBy,
 	)
 	if err != nil {
 		return nil, err
@@ -154,9 +198,23 @@ func RequestLoan(request models.LoanRequest) (*models.Loan, error) {
 	}
 	loan.ID = id
 
+	// The history starts with the creation of the loan
+	if err := insertLoanStatusHistoryTx(tx, loan.ID, "", loan.Status, loan.UserID, "loan requested", now); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	// Log the transaction
-	metadata := fmt.Sprintf("%s loan requested for %d months with %.2f%% interest",
-		loan.Type, loan.Term, loan.InterestRate)
+	metadata := fmt.Sprintf("%s loan requested for %d months with %.2f%% interest under product %s v%d",
+		loan.Type, loan.Term, loan.InterestRate, product.Code, product.Version)
+	if rateOverrideBy != nil {
+		metadata += fmt.Sprintf(", rate set by manager #%d", *rateOverrideBy)
+	}
+	if request.Promotional {
+		metadata += ", promotional merchant plan"
+	}
 	LogTransaction(loan.UserID, "loan_request", &loan.Amount, metadata)
 
 	return loan, nil
@@ -178,26 +236,12 @@ func ApproveLoan(loanID int64, approverID int64) error {
 		return fmt.Errorf("loan is not pending approval (current status: %s)", loan.Status)
 	}
 
-	// Calculate start and end dates
-	now := time.Now()
-	startDate := now
-	endDate := now.AddDate(0, loan.Term, 0)
+	// Large loans may need more than one approver
+	if err := recordOperationApproval(OperationLoan, loanID, loan.UserID, approverID, loan.Amount); err != nil {
+		return err
+	}
 
-	// Update the loan status
-	query := `
-		UPDATE loans 
-		SET status = ?, start_date = ?, end_date = ?, updated_at = ? 
-		WHERE id = ?
-	`
-	_, err = DB.Exec(
-		query,
-		models.Approved,
-		startDate,
-		endDate,
-		now,
-		loanID,
-	)
-	if err != nil {
+	if err := transitionLoan(loan, models.Approved, approverID, ""); err != nil {
 		return err
 	}
 
@@ -224,11 +268,7 @@ func RejectLoan(loanID int64, approverID int64) error {
 		return fmt.Errorf("loan is not pending approval (current status: %s)", loan.Status)
 	}
 
-	// Update the loan status
-	now := time.Now()
-	query := `UPDATE loans SET status = ?, updated_at = ? WHERE id = ?`
-	_, err = DB.Exec(query, models.Rejected, now, loanID)
-	if err != nil {
+	if err := transitionLoan(loan, models.Rejected, approverID, ""); err != nil {
 		return err
 	}
 
@@ -240,7 +280,7 @@ func RejectLoan(loanID int64, approverID int64) error {
 }
 
 // ActivateLoan changes a loan from approved to active
-func ActivateLoan(loanID int64) error {
+func ActivateLoan(loanID int64, actorID int64) error {
 	if err := EnsureLoansTableExists(); err != nil {
 		return err
 	}
@@ -252,30 +292,26 @@ func ActivateLoan(loanID int64) error {
 	}
 
 	// Only managers can activate loans, so it must be in approved status
-	if loan.Status != models.Approved {
-		return fmt.Errorf("loan is not approved (current status: %s)", loan.Status)
-	}
-
-	// Update the loan status
-	now := time.Now()
-	query := `UPDATE loans SET status = ?, updated_at = ? WHERE id = ?`
-	_, err = DB.Exec(query, models.Active, now, loanID)
-	if err != nil {
+	if err := transitionLoan(loan, models.Active, actorID, ""); err != nil {
 		return err
 	}
 
 	// Log the transaction
-	metadata := fmt.Sprintf("Loan #%d activated", loanID)
+	metadata := fmt.Sprintf("Loan #%d activated by user #%d", loanID, actorID)
 	LogTransaction(loan.UserID, "loan_activated", &loan.Amount, metadata)
 
 	return nil
 }
 
-// MakePayment records a payment against a loan
+// MakePayment records a payment against a loan. It counts against the loan payment limits of
+// the borrower and returns a *LimitExceededError when over one.
 func MakePayment(payment models.LoanPaymentRequest) (*models.Payment, error) {
 	if err := EnsureLoansTableExists(); err != nil {
 		return nil, err
 	}
+	if err := ensureLimitCheckTablesExist(); err != nil {
+		return nil, err
+	}
 
 	// Get the loan to make sure it exists
 	loan, err := GetLoan(payment.LoanID)
@@ -300,6 +336,10 @@ func MakePayment(payment models.LoanPaymentRequest) (*models.Payment, error) {
 	}
 	defer tx.Rollback()
 
+	if err := checkTransactionLimitTx(tx, loan.UserID, LimitOperationLoanPayment, loan.ProductID, payment.Amount); err != nil {
+		return nil, err
+	}
+
 	// Calculate total payments made so far
 	var totalPayments float64
 	err = tx.QueryRow(`
@@ -332,18 +372,22 @@ func MakePayment(payment models.LoanPaymentRequest) (*models.Payment, error) {
 	// Check if loan is fully paid
 	if totalPayments >= loan.TotalPayable {
 		// Mark loan as completed
-		_, err = tx.Exec(`
-			UPDATE loans SET status = ?, updated_at = ? WHERE id = ?
-		`, models.Completed, now, payment.LoanID)
-		if err != nil {
+		if err := transitionLoanTx(tx, loan, models.Completed, loan.UserID, "loan fully repaid", now); err != nil {
 			return nil, err
 		}
 	}
 
+	// Log the transaction
+	metadata := fmt.Sprintf("Payment made on loan #%d", payment.LoanID)
+	if _, err := logTransactionTx(tx, loan.UserID, LimitOperationLoanPayment, &payment.Amount, metadata); err != nil {
+		return nil, err
+	}
+
 	// Commit the transaction
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
+	transactionLogged(loan.UserID, LimitOperationLoanPayment, &payment.Amount, metadata)
 
 	// Create the payment object to return
 	newPayment := &models.Payment{
@@ -354,10 +398,6 @@ func MakePayment(payment models.LoanPaymentRequest) (*models.Payment, error) {
 		CreatedAt: now,
 	}
 
-	// Log the transaction
-	metadata := fmt.Sprintf("Payment made on loan #%d", payment.LoanID)
-	LogTransaction(loan.UserID, "loan_payment", &payment.Amount, metadata)
-
 	return newPayment, nil
 }
 
@@ -370,13 +410,17 @@ func GetLoan(loanID int64) (*models.Loan, error) {
 	query := `
 		SELECT id, user_id, loan_type, amount, term_months, interest_rate, 
 		       total_payable, monthly_payment, status, start_date, end_date,
-		       created_at, updated_at
+		       created_at, updated_at, approved_by, approved_at,
+		       rejected_by, rejected_at, rejection_reason,
+		       product_id, fee_amount, rate_override_by
 		FROM loans
 		WHERE id = ?
 	`
 
 	loan := &models.Loan{}
-	var startDate, endDate sql.NullTime
+	var startDate, endDate, approvedAt, rejectedAt sql.NullTime
+	var approvedBy, rejectedBy, productID, rateOverrideBy sql.NullInt64
+	var rejectionReason sql.NullString
 	var status string
 
 	err := DB.QueryRow(query, loanID).Scan(
@@ -393,6 +437,14 @@ func GetLoan(loanID int64) (*models.Loan, error) {
 		&endDate,
 		&loan.CreatedAt,
 		&loan.UpdatedAt,
+		&approvedBy,
+		&approvedAt,
+		&rejectedBy,
+		&rejectedAt,
+		&rejectionReason,
+		&productID,
+		&loan.FeeAmount,
+		&rateOverrideBy,
 	)
 
 	if err != nil {
@@ -403,6 +455,7 @@ func GetLoan(loanID int64) (*models.Loan, error) {
 	}
 
 	loan.Status = models.LoanStatus(status)
+	loan.RejectionReason = rejectionReason.String
 
 	if startDate.Valid {
 		loan.StartDate = &startDate.Time
@@ -412,6 +465,25 @@ func GetLoan(loanID int64) (*models.Loan, error) {
 		loan.EndDate = &endDate.Time
 	}
 
+	if approvedBy.Valid {
+		loan.ApprovedBy = &approvedBy.Int64
+	}
+	if approvedAt.Valid {
+		loan.ApprovedAt = &approvedAt.Time
+	}
+	if rejectedBy.Valid {
+		loan.RejectedBy = &rejectedBy.Int64
+	}
+	if rejectedAt.Valid {
+		loan.RejectedAt = &rejectedAt.Time
+	}
+	if productID.Valid {
+		loan.ProductID = &productID.Int64
+	}
+	if rateOverrideBy.Valid {
+		loan.RateOverrideBy = &rateOverrideBy.Int64
+	}
+
 	return loan, nil
 }
 
@@ -581,6 +653,7 @@ func GetPendingLoans() ([]*models.Loan, error) {
 		}
 
 		loan.Status = models.LoanStatus(status)
+		loan.Username = username
 
 		if startDate.Valid {
 			loan.StartDate = &startDate.Time
@@ -616,27 +689,12 @@ func ManagerApproveLoan(loanID int64, managerID int64) error {
 		return fmt.Errorf("loan is not pending approval (current status: %s)", loan.Status)
 	}
 
-	// Calculate start and end dates
-	now := time.Now()
-	startDate := now
-	endDate := now.AddDate(0, loan.Term, 0)
+	// Large loans may need more than one approver
+	if err := recordOperationApproval(OperationLoan, loanID, loan.UserID, managerID, loan.Amount); err != nil {
+		return err
+	}
 
-	// Update the loan status to approved but not yet active
-	query := `
-        UPDATE loans 
-        SET status = ?, start_date = ?, end_date = ?, updated_at = ?, approved_by = ? 
-        WHERE id = ?
-    `
-	_, err = DB.Exec(
-		query,
-		models.Approved,
-		startDate,
-		endDate,
-		now,
-		managerID,
-		loanID,
-	)
-	if err != nil {
+	if err := transitionLoan(loan, models.Approved, managerID, ""); err != nil {
 		return err
 	}
 
@@ -649,6 +707,10 @@ func ManagerApproveLoan(loanID int64, managerID int64) error {
 
 // Function to let managers reject loans
 func ManagerRejectLoan(loanID int64, managerID int64, reason string) error {
+	if err := EnsureLoansTableExists(); err != nil {
+		return err
+	}
+
 	// Get the loan to make sure it exists and is in pending status
 	loan, err := GetLoan(loanID)
 	if err != nil {
@@ -659,11 +721,7 @@ func ManagerRejectLoan(loanID int64, managerID int64, reason string) error {
 		return fmt.Errorf("loan is not pending approval (current status: %s)", loan.Status)
 	}
 
-	// Update the loan status
-	now := time.Now()
-	query := `UPDATE loans SET status = ?, updated_at = ?, rejection_reason = ?, rejected_by = ? WHERE id = ?`
-	_, err = DB.Exec(query, models.Rejected, now, reason, managerID, loanID)
-	if err != nil {
+	if err := transitionLoan(loan, models.Rejected, managerID, reason); err != nil {
 		return err
 	}
 
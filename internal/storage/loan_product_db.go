@@ -0,0 +1,389 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	// ErrLoanProductNotFound is returned when no product matches the request
+	ErrLoanProductNotFound = errors.New("loan product not found")
+	// ErrLoanTermsNotOffered is returned when the product does not offer the requested amount or term
+	ErrLoanTermsNotOffered = errors.New("loan product does not offer the requested amount and term")
+)
+
+// productCodePattern matches loan product codes
+var productCodePattern = regexp.MustCompile(`^[a-z0-9_-]{2,32}$`)
+
+// defaultLoanRateTiers reproduce the rates offered before the product catalog existed
+var defaultLoanRateTiers = []models.LoanRateTier{
+	{MinTerm: 1, MaxTerm: 24, Rate: 12.5},
+	{MinTerm: 3, MaxTerm: 3, Rate: 5},
+	{MinTerm: 6, MaxTerm: 6, Rate: 7.5},
+	{MinTerm: 12, MaxTerm: 12, Rate: 10},
+	{MinTerm: 24, MaxTerm: 24, Rate: 15},
+	{MinTerm: 25, MaxTerm: 360, Rate: 20},
+}
+
+// EnsureLoanProductTablesExist creates the loan product tables and seeds a product per loan type
+func EnsureLoanProductTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_products (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			code TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			loan_type TEXT NOT NULL,
+			allowed_terms TEXT NOT NULL DEFAULT '[]',
+			min_amount REAL NOT NULL DEFAULT 0,
+			max_amount REAL NOT NULL DEFAULT 0,
+			fee_percent REAL NOT NULL DEFAULT 0,
+			fee_fixed REAL NOT NULL DEFAULT 0,
+			effective_from TIMESTAMP NOT NULL,
+			retired INTEGER NOT NULL DEFAULT 0,
+			created_by INTEGER,
+			created_at TIMESTAMP NOT NULL,
+			UNIQUE(code, version)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_product_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			product_id INTEGER NOT NULL,
+			min_term INTEGER NOT NULL,
+			max_term INTEGER NOT NULL,
+			min_amount REAL NOT NULL DEFAULT 0,
+			max_amount REAL NOT NULL DEFAULT 0,
+			rate REAL NOT NULL,
+			FOREIGN KEY (product_id) REFERENCES loan_products(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM loan_products").Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	epoch := time.Unix(0, 0)
+	for _, product := range []*models.LoanProduct{
+		{Code: string(models.StandardLoan), Name: "Standard loan", Type: models.StandardLoan},
+		{Code: string(models.InstallmentPlan), Name: "Installment plan", Type: models.InstallmentPlan},
+	} {
+		product.EffectiveFrom = epoch
+		product.Rates = defaultLoanRateTiers
+		if err := insertLoanProduct(product); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateLoanProduct checks a product before it is stored
+func validateLoanProduct(product *models.LoanProduct) error {
+	if !productCodePattern.MatchString(product.Code) {
+		return errors.New("code must be 2-32 lowercase letters, digits, '-' or '_'")
+	}
+	if product.Name == "" {
+		return errors.New("name is required")
+	}
+	if product.Type != models.StandardLoan && product.Type != models.InstallmentPlan {
+		return errors.New("type must be either 'standard' or 'installment'")
+	}
+	if product.MinAmount < 0 || (product.MaxAmount != 0 && product.MaxAmount < product.MinAmount) {
+		return errors.New("amount limits must satisfy 0 <= min_amount <= max_amount")
+	}
+	if product.FeePercent < 0 || product.FeeFixed < 0 {
+		return errors.New("fees cannot be negative")
+	}
+	for _, term := range product.AllowedTerms {
+		if term <= 0 {
+			return errors.New("allowed terms must be positive")
+		}
+	}
+	if len(product.Rates) == 0 {
+		return errors.New("at least one rate tier is required")
+	}
+	for _, tier := range product.Rates {
+		if tier.MinTerm <= 0 || tier.MaxTerm < tier.MinTerm {
+			return errors.New("rate tier terms must satisfy 0 < min_term <= max_term")
+		}
+		if tier.MinAmount < 0 || (tier.MaxAmount != 0 && tier.MaxAmount < tier.MinAmount) {
+			return errors.New("rate tier amounts must satisfy 0 <= min_amount <= max_amount")
+		}
+		if tier.Rate < 0 {
+			return errors.New("rates cannot be negative")
+		}
+	}
+	return nil
+}
+
+// insertLoanProduct stores the next version of a product code together with its rate tiers
+func insertLoanProduct(product *models.LoanProduct) error {
+	terms, err := json.Marshal(product.AllowedTerms)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.QueryRow(`
+		SELECT COALESCE(MAX(version), 0) + 1 FROM loan_products WHERE code = ?
+	`, product.Code).Scan(&product.Version); err != nil {
+		return err
+	}
+
+	product.CreatedAt = time.Now()
+	result, err := tx.Exec(`
+		INSERT INTO loan_products (
+			code, version, name, loan_type, allowed_terms, min_amount, max_amount,
+			fee_percent, fee_fixed, effective_from, created_by, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, product.Code, product.Version, product.Name, product.Type, string(terms), product.MinAmount, product.MaxAmount,
+		product.FeePercent, product.FeeFixed, product.EffectiveFrom, nullableInt64(product.CreatedBy), product.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if product.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	for _, tier := range product.Rates {
+		_, err := tx.Exec(`
+			INSERT INTO loan_product_rates (product_id, min_term, max_term, min_amount, max_amount, rate)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, product.ID, tier.MinTerm, tier.MaxTerm, tier.MinAmount, tier.MaxAmount, tier.Rate)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CreateLoanProduct publishes a product. Using the code of an existing product publishes its
+// next version, which applies to loans requested from EffectiveFrom on.
+func CreateLoanProduct(product *models.LoanProduct, adminID int64) error {
+	if err := validateLoanProduct(product); err != nil {
+		return err
+	}
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return err
+	}
+	if product.EffectiveFrom.IsZero() {
+		product.EffectiveFrom = time.Now()
+	}
+	product.CreatedBy = adminID
+
+	if err := insertLoanProduct(product); err != nil {
+		return err
+	}
+
+	metadata := fmt.Sprintf("Loan product %s version %d published, effective from %s",
+		product.Code, product.Version, product.EffectiveFrom.Format("2006-01-02"))
+	LogTransaction(adminID, "loan_product_publish", nil, metadata)
+	return nil
+}
+
+// RetireLoanProduct stops a product version from being offered. Issued loans are not affected.
+func RetireLoanProduct(productID, adminID int64) error {
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec("UPDATE loan_products SET retired = 1 WHERE id = ?", productID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrLoanProductNotFound
+	}
+
+	LogTransaction(adminID, "loan_product_retire", nil, fmt.Sprintf("Loan product #%d retired", productID))
+	return nil
+}
+
+// loanProductColumns lists the columns read by scanLoanProduct
+const loanProductColumns = `
+	id, code, version, name, loan_type, allowed_terms, min_amount, max_amount,
+	fee_percent, fee_fixed, effective_from, retired, created_by, created_at`
+
+// scanLoanProduct reads a product row selected with loanProductColumns
+func scanLoanProduct(row interface{ Scan(...interface{}) error }, product *models.LoanProduct) error {
+	var terms string
+	var retired int
+	var createdBy sql.NullInt64
+	err := row.Scan(&product.ID, &product.Code, &product.Version, &product.Name, &product.Type, &terms,
+		&product.MinAmount, &product.MaxAmount, &product.FeePercent, &product.FeeFixed,
+		&product.EffectiveFrom, &retired, &createdBy, &product.CreatedAt)
+	if err != nil {
+		return err
+	}
+	product.Retired = retired == 1
+	product.CreatedBy = createdBy.Int64
+	return json.Unmarshal([]byte(terms), &product.AllowedTerms)
+}
+
+// loadLoanProductRates fills in the rate tiers of a product
+func loadLoanProductRates(product *models.LoanProduct) error {
+	rows, err := DB.Query(`
+		SELECT min_term, max_term, min_amount, max_amount, rate
+		FROM loan_product_rates
+		WHERE product_id = ?
+		ORDER BY min_term, min_amount, id
+	`, product.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	product.Rates = []models.LoanRateTier{}
+	for rows.Next() {
+		var tier models.LoanRateTier
+		if err := rows.Scan(&tier.MinTerm, &tier.MaxTerm, &tier.MinAmount, &tier.MaxAmount, &tier.Rate); err != nil {
+			return err
+		}
+		product.Rates = append(product.Rates, tier)
+	}
+	return rows.Err()
+}
+
+// queryLoanProducts runs a product query and loads the rate tiers of every result
+func queryLoanProducts(query string, args ...interface{}) ([]*models.LoanProduct, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	products := []*models.LoanProduct{}
+	for rows.Next() {
+		product := &models.LoanProduct{}
+		if err := scanLoanProduct(rows, product); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, product := range products {
+		if err := loadLoanProductRates(product); err != nil {
+			return nil, err
+		}
+	}
+	return products, nil
+}
+
+// GetLoanProduct returns a product version by ID
+func GetLoanProduct(productID int64) (*models.LoanProduct, error) {
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	products, err := queryLoanProducts("SELECT "+loanProductColumns+" FROM loan_products WHERE id = ?", productID)
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return nil, ErrLoanProductNotFound
+	}
+	return products[0], nil
+}
+
+// GetLoanProducts returns every version of every product, optionally for a single code
+func GetLoanProducts(code string) ([]*models.LoanProduct, error) {
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	query := "SELECT " + loanProductColumns + " FROM loan_products"
+	var args []interface{}
+	if code != "" {
+		query += " WHERE code = ?"
+		args = append(args, code)
+	}
+	query += " ORDER BY code, version DESC"
+	return queryLoanProducts(query, args...)
+}
+
+// GetCurrentLoanProducts returns the version of each product that is in effect at the given time
+func GetCurrentLoanProducts(at time.Time) ([]*models.LoanProduct, error) {
+	if err := EnsureLoanProductTablesExist(); err != nil {
+		return nil, err
+	}
+
+	return queryLoanProducts(`
+		SELECT `+loanProductColumns+`
+		FROM loan_products p
+		WHERE retired = 0 AND effective_from <= ?
+		  AND version = (
+			SELECT MAX(version) FROM loan_products latest
+			WHERE latest.code = p.code AND latest.retired = 0 AND latest.effective_from <= ?
+		  )
+		ORDER BY code
+	`, at, at)
+}
+
+// selectLoanProduct picks the product for a request: the requested code, or the first current
+// product of the loan type that offers the amount and term
+func selectLoanProduct(request models.LoanRequest, at time.Time) (*models.LoanProduct, error) {
+	products, err := GetCurrentLoanProducts(at)
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	for _, product := range products {
+		if request.ProductCode != "" && product.Code != request.ProductCode {
+			continue
+		}
+		if request.ProductCode == "" && product.Type != request.Type {
+			continue
+		}
+		found = true
+		if loanProductOffers(product, request.Amount, request.TermMonths) {
+			return product, nil
+		}
+	}
+	if !found {
+		return nil, ErrLoanProductNotFound
+	}
+	return nil, ErrLoanTermsNotOffered
+}
+
+// loanProductOffers reports whether a product covers the amount and term
+func loanProductOffers(product *models.LoanProduct, amount float64, termMonths int) bool {
+	if amount < product.MinAmount || (product.MaxAmount != 0 && amount > product.MaxAmount) {
+		return false
+	}
+	if !product.AllowsTerm(termMonths) {
+		return false
+	}
+	_, ok := product.RateFor(termMonths, amount)
+	return ok
+}
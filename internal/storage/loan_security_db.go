@@ -0,0 +1,416 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrGuarantorsPending is returned when activating a loan some guarantors have not accepted
+	ErrGuarantorsPending = errors.New("loan is waiting for its guarantors to accept")
+	// ErrDepositPledged is returned when unblocking a deposit that secures a loan
+	ErrDepositPledged = errors.New("deposit is pledged as loan collateral")
+	// ErrCollateralNotFound is returned when a collateral record does not exist
+	ErrCollateralNotFound = errors.New("collateral not found")
+	// ErrGuaranteeNotFound is returned when a guarantee request does not exist
+	ErrGuaranteeNotFound = errors.New("guarantee request not found")
+	// ErrLoanSecurityClosed is returned when securing a loan that is already active or final
+	ErrLoanSecurityClosed = errors.New("collateral and guarantors can only be added before the loan is active")
+)
+
+// EnsureLoanSecurityTablesExist creates the collateral and guarantor tables
+func EnsureLoanSecurityTablesExist() error {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_collateral (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			loan_id INTEGER NOT NULL,
+			collateral_type TEXT NOT NULL,
+			description TEXT,
+			valuation REAL NOT NULL,
+			lien_status TEXT NOT NULL,
+			deposit_id INTEGER,
+			created_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			released_at TIMESTAMP,
+			FOREIGN KEY (loan_id) REFERENCES loans(id),
+			FOREIGN KEY (deposit_id) REFERENCES deposits(deposit_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_guarantors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			loan_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			added_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			responded_at TIMESTAMP,
+			UNIQUE(loan_id, user_id),
+			FOREIGN KEY (loan_id) REFERENCES loans(id)
+		)
+	`)
+	return err
+}
+
+// securableLoan returns the loan when collateral or guarantors can still be added to it
+func securableLoan(loanID int64) (*models.Loan, error) {
+	loan, err := GetLoan(loanID)
+	if err != nil {
+		return nil, err
+	}
+	if loan.Status != models.Pending && loan.Status != models.Approved {
+		return nil, ErrLoanSecurityClosed
+	}
+	return loan, nil
+}
+
+// AddLoanCollateral records an asset securing a loan. A pledged deposit must belong to the
+// borrower; it is blocked at once and its lien is active until the loan is repaid or rejected.
+// Other collateral starts with a pending lien until staff confirm it is registered.
+func AddLoanCollateral(collateral *models.LoanCollateral, actorID int64) error {
+	if err := EnsureLoansTableExists(); err != nil {
+		return err
+	}
+	loan, err := securableLoan(collateral.LoanID)
+	if err != nil {
+		return err
+	}
+
+	collateral.LienStatus = models.LienPending
+	switch collateral.Type {
+	case models.CollateralDeposit:
+		if collateral.DepositID == nil {
+			return errors.New("deposit_id is required for deposit collateral")
+		}
+		var clientID int64
+		var bankName string
+		var amount float64
+		err := DB.QueryRow("SELECT client_id, bank_name, amount FROM deposits WHERE deposit_id = ?",
+			*collateral.DepositID).Scan(&clientID, &bankName, &amount)
+		if err == sql.ErrNoRows || (err == nil && clientID != loan.UserID) {
+			return errors.New("deposit not found among the borrower's deposits")
+		}
+		if err != nil {
+			return err
+		}
+		if err := BlockDeposit(clientID, bankName, *collateral.DepositID); err != nil {
+			return fmt.Errorf("pledging deposit: %w", err)
+		}
+		collateral.Valuation = amount
+		collateral.LienStatus = models.LienActive
+	case models.CollateralRealEstate, models.CollateralVehicle, models.CollateralOther:
+		collateral.DepositID = nil
+		if collateral.Valuation <= 0 {
+			return errors.New("valuation must be greater than zero")
+		}
+	default:
+		return errors.New("type must be one of deposit, real_estate, vehicle or other")
+	}
+
+	collateral.Description = strings.TrimSpace(collateral.Description)
+	collateral.CreatedBy = actorID
+	collateral.CreatedAt = time.Now()
+	var depositID interface{}
+	if collateral.DepositID != nil {
+		depositID = *collateral.DepositID
+	}
+	result, err := DB.Exec(`
+		INSERT INTO loan_collateral (
+			loan_id, collateral_type, description, valuation, lien_status, deposit_id, created_by, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, collateral.LoanID, collateral.Type, collateral.Description, collateral.Valuation,
+		collateral.LienStatus, depositID, actorID, collateral.CreatedAt)
+	if err != nil {
+		return err
+	}
+	collateral.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(actorID, "loan_collateral_added", &collateral.Valuation,
+		fmt.Sprintf("%s collateral #%d pledged for loan #%d", collateral.Type, collateral.ID, collateral.LoanID))
+	return nil
+}
+
+// SetCollateralLienStatus records that the lien on non-deposit collateral was registered or released
+func SetCollateralLienStatus(collateralID int64, status string, actorID int64) error {
+	if err := EnsureLoansTableExists(); err != nil {
+		return err
+	}
+	if status != models.LienActive && status != models.LienReleased {
+		return errors.New("lien status must be active or released")
+	}
+
+	var collateralType string
+	var loanID int64
+	err := DB.QueryRow("SELECT collateral_type, loan_id FROM loan_collateral WHERE id = ?", collateralID).
+		Scan(&collateralType, &loanID)
+	if err == sql.ErrNoRows {
+		return ErrCollateralNotFound
+	}
+	if err != nil {
+		return err
+	}
+	// Pledged deposits follow the loan, so their lien cannot be changed by hand
+	if collateralType == models.CollateralDeposit {
+		return errors.New("the lien on a pledged deposit is released with the loan")
+	}
+
+	var releasedAt interface{}
+	if status == models.LienReleased {
+		releasedAt = time.Now()
+	}
+	_, err = DB.Exec("UPDATE loan_collateral SET lien_status = ?, released_at = ? WHERE id = ?",
+		status, releasedAt, collateralID)
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(actorID, "loan_collateral_lien", nil,
+		fmt.Sprintf("Lien on collateral #%d of loan #%d set to %s", collateralID, loanID, status))
+	return nil
+}
+
+// releaseLoanCollateralTx releases every lien of a loan and unblocks its pledged deposits
+func releaseLoanCollateralTx(tx *sql.Tx, loanID int64, now time.Time) error {
+	_, err := tx.Exec(`
+		UPDATE deposits SET is_blocked = 0, updated_at = ?
+		WHERE deposit_id IN (
+			SELECT deposit_id FROM loan_collateral
+			WHERE loan_id = ? AND deposit_id IS NOT NULL AND lien_status = ?
+		)
+	`, now, loanID, models.LienActive)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		UPDATE loan_collateral SET lien_status = ?, released_at = ?
+		WHERE loan_id = ? AND lien_status != ?
+	`, models.LienReleased, now, loanID, models.LienReleased)
+	return err
+}
+
+// unpledgedDepositCondition keeps SQL that unblocks deposits away from deposits securing a loan
+const unpledgedDepositCondition = `deposit_id NOT IN (
+	SELECT deposit_id FROM loan_collateral WHERE deposit_id IS NOT NULL AND lien_status = 'active'
+)`
+
+// isDepositPledged reports whether a deposit secures a loan under an active lien
+func isDepositPledged(depositID int64) (bool, error) {
+	if err := EnsureLoanSecurityTablesExist(); err != nil {
+		return false, err
+	}
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM loan_collateral WHERE deposit_id = ? AND lien_status = ?
+	`, depositID, models.LienActive).Scan(&count)
+	return count > 0, err
+}
+
+// GetLoanCollateral returns the collateral of a loan
+func GetLoanCollateral(loanID int64) ([]models.LoanCollateral, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query(`
+		SELECT id, loan_id, collateral_type, description, valuation, lien_status, deposit_id,
+			created_by, created_at, released_at
+		FROM loan_collateral WHERE loan_id = ? ORDER BY id
+	`, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collateral := []models.LoanCollateral{}
+	for rows.Next() {
+		var item models.LoanCollateral
+		var description sql.NullString
+		var depositID sql.NullInt64
+		var releasedAt sql.NullTime
+		err := rows.Scan(&item.ID, &item.LoanID, &item.Type, &description, &item.Valuation, &item.LienStatus,
+			&depositID, &item.CreatedBy, &item.CreatedAt, &releasedAt)
+		if err != nil {
+			return nil, err
+		}
+		item.Description = description.String
+		if depositID.Valid {
+			item.DepositID = &depositID.Int64
+		}
+		if releasedAt.Valid {
+			item.ReleasedAt = &releasedAt.Time
+		}
+		collateral = append(collateral, item)
+	}
+	return collateral, rows.Err()
+}
+
+// AddLoanGuarantor asks a user to guarantee a loan. The borrower cannot guarantee their own loan.
+func AddLoanGuarantor(loanID int64, username string, actorID int64) (*models.LoanGuarantor, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+	loan, err := securableLoan(loanID)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := GetUserByUsername(strings.TrimSpace(username))
+	if err == sql.ErrNoRows {
+		return nil, errors.New("guarantor not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(user.ID) == loan.UserID {
+		return nil, errors.New("the borrower cannot guarantee their own loan")
+	}
+
+	guarantor := &models.LoanGuarantor{
+		LoanID:    loanID,
+		UserID:    int64(user.ID),
+		Username:  user.Username,
+		Status:    models.GuaranteePending,
+		AddedBy:   actorID,
+		CreatedAt: time.Now(),
+	}
+	result, err := DB.Exec(`
+		INSERT INTO loan_guarantors (loan_id, user_id, status, added_by, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, guarantor.LoanID, guarantor.UserID, guarantor.Status, actorID, guarantor.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, errors.New("user is already a guarantor of this loan")
+		}
+		return nil, err
+	}
+	guarantor.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	LogTransaction(actorID, "loan_guarantor_added", nil,
+		fmt.Sprintf("User %s asked to guarantee loan #%d", user.Username, loanID))
+	return guarantor, nil
+}
+
+// RespondToGuarantee records the answer of a guarantor. When the last guarantor accepts
+// a loan that is already approved, the loan is activated.
+func RespondToGuarantee(guarantorID, userID int64, accept bool) (*models.LoanGuarantor, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+
+	status := models.GuaranteeDeclined
+	if accept {
+		status = models.GuaranteeAccepted
+	}
+	now := time.Now()
+	result, err := DB.Exec(`
+		UPDATE loan_guarantors SET status = ?, responded_at = ?
+		WHERE id = ? AND user_id = ? AND status = ?
+	`, status, now, guarantorID, userID, models.GuaranteePending)
+	if err != nil {
+		return nil, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if affected == 0 {
+		return nil, ErrGuaranteeNotFound
+	}
+
+	guarantors, err := getGuarantors("g.id = ?", guarantorID)
+	if err != nil {
+		return nil, err
+	}
+	guarantor := &guarantors[0]
+	LogTransaction(userID, "loan_guarantee_"+status, nil,
+		fmt.Sprintf("Guarantee of loan #%d %s", guarantor.LoanID, status))
+
+	if accept {
+		loan, err := GetLoan(guarantor.LoanID)
+		if err != nil {
+			return nil, err
+		}
+		if loan.Status == models.Approved {
+			pending, err := countPendingGuarantors(guarantor.LoanID)
+			if err != nil {
+				return nil, err
+			}
+			if pending == 0 {
+				if err := transitionLoan(loan, models.Active, 0, "all guarantors accepted"); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return guarantor, nil
+}
+
+// countPendingGuarantors counts the guarantors of a loan who have not accepted
+func countPendingGuarantors(loanID int64) (int, error) {
+	var count int
+	err := DB.QueryRow(`
+		SELECT COUNT(*) FROM loan_guarantors WHERE loan_id = ? AND status != ?
+	`, loanID, models.GuaranteeAccepted).Scan(&count)
+	return count, err
+}
+
+// GetLoanGuarantors returns the guarantors of a loan
+func GetLoanGuarantors(loanID int64) ([]models.LoanGuarantor, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+	return getGuarantors("g.loan_id = ?", loanID)
+}
+
+// GetGuaranteeRequests returns the guarantees asked of a user, newest first
+func GetGuaranteeRequests(userID int64) ([]models.LoanGuarantor, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+	return getGuarantors("g.user_id = ?", userID)
+}
+
+func getGuarantors(condition string, arg interface{}) ([]models.LoanGuarantor, error) {
+	rows, err := DB.Query(`
+		SELECT g.id, g.loan_id, g.user_id, COALESCE(u.username, ''), g.status, g.added_by,
+			g.created_at, g.responded_at
+		FROM loan_guarantors g LEFT JOIN users u ON u.id = g.user_id
+		WHERE `+condition+`
+		ORDER BY g.created_at DESC, g.id DESC
+	`, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	guarantors := []models.LoanGuarantor{}
+	for rows.Next() {
+		var guarantor models.LoanGuarantor
+		var respondedAt sql.NullTime
+		err := rows.Scan(&guarantor.ID, &guarantor.LoanID, &guarantor.UserID, &guarantor.Username,
+			&guarantor.Status, &guarantor.AddedBy, &guarantor.CreatedAt, &respondedAt)
+		if err != nil {
+			return nil, err
+		}
+		if respondedAt.Valid {
+			guarantor.RespondedAt = &respondedAt.Time
+		}
+		guarantors = append(guarantors, guarantor)
+	}
+	return guarantors, rows.Err()
+}
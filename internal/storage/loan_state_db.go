@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidLoanTransition is returned when a loan cannot move from its status to the requested one
+	ErrInvalidLoanTransition = errors.New("loan status transition is not allowed")
+	// ErrLoanStatusChanged is returned when another request changed the loan status first
+	ErrLoanStatusChanged = errors.New("loan status was changed by another request")
+	// ErrLoanNotPaidOff is returned when completing a loan that still has an outstanding balance
+	ErrLoanNotPaidOff = errors.New("loan is not fully paid")
+)
+
+// loanTransitionRule holds the guard and side effects of moving a loan into a status
+type loanTransitionRule struct {
+	// guard can refuse the transition; it runs inside the transaction before the change
+	guard func(tx *sql.Tx, loan *models.Loan) error
+	// apply updates the columns that come with the new status
+	apply func(tx *sql.Tx, loan *models.Loan, actorID int64, reason string, now time.Time) error
+}
+
+// loanTransitionRules maps a target status to its rule. The allowed source statuses
+// are defined by models.LoanStatus.CanTransitionTo.
+var loanTransitionRules = map[models.LoanStatus]loanTransitionRule{
+	models.Approved: {
+		apply: func(tx *sql.Tx, loan *models.Loan, actorID int64, _ string, now time.Time) error {
+			startDate := now
+			endDate := now.AddDate(0, loan.Term, 0)
+			_, err := tx.Exec(`
+				UPDATE loans SET start_date = ?, end_date = ?, approved_by = ?, approved_at = ? WHERE id = ?
+			`, startDate, endDate, actorID, now, loan.ID)
+			if err == nil {
+				loan.StartDate = &startDate
+				loan.EndDate = &endDate
+				loan.ApprovedBy = &actorID
+				loan.ApprovedAt = &now
+			}
+			return err
+		},
+	},
+	models.Rejected: {
+		apply: func(tx *sql.Tx, loan *models.Loan, actorID int64, reason string, now time.Time) error {
+			_, err := tx.Exec(`
+				UPDATE loans SET rejected_by = ?, rejected_at = ?, rejection_reason = ? WHERE id = ?
+			`, actorID, now, reason, loan.ID)
+			if err != nil {
+				return err
+			}
+			loan.RejectedBy = &actorID
+			loan.RejectedAt = &now
+			loan.RejectionReason = reason
+			return releaseLoanCollateralTx(tx, loan.ID, now)
+		},
+	},
+	models.Active: {
+		guard: func(tx *sql.Tx, loan *models.Loan) error {
+			if loan.StartDate == nil {
+				return errors.New("loan has no start date")
+			}
+			// Funds are only released once every guarantor has accepted
+			var pending int
+			err := tx.QueryRow(`
+				SELECT COUNT(*) FROM loan_guarantors WHERE loan_id = ? AND status != ?
+			`, loan.ID, models.GuaranteeAccepted).Scan(&pending)
+			if err != nil {
+				return err
+			}
+			if pending > 0 && loan.Status == models.Approved {
+				return fmt.Errorf("%w: %d outstanding", ErrGuarantorsPending, pending)
+			}
+			return nil
+		},
+	},
+	models.Completed: {
+		guard: func(tx *sql.Tx, loan *models.Loan) error {
+			var paid float64
+			err := tx.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM loan_payments WHERE loan_id = ?", loan.ID).Scan(&paid)
+			if err != nil {
+				return err
+			}
+			if paid < loan.TotalPayable {
+				return fmt.Errorf("%w: %.2f of %.2f paid", ErrLoanNotPaidOff, paid, loan.TotalPayable)
+			}
+			return nil
+		},
+		apply: func(tx *sql.Tx, loan *models.Loan, _ int64, _ string, now time.Time) error {
+			return releaseLoanCollateralTx(tx, loan.ID, now)
+		},
+	},
+}
+
+// EnsureLoanStatusHistoryTableExists creates the loan status history table
+func EnsureLoanStatusHistoryTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_status_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			loan_id INTEGER NOT NULL,
+			from_status TEXT,
+			to_status TEXT NOT NULL,
+			actor_id INTEGER,
+			reason TEXT,
+			created_at TIMESTAMP NOT NULL,
+			FOREIGN KEY (loan_id) REFERENCES loans(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_loan_status_history_loan ON loan_status_history(loan_id)")
+	return err
+}
+
+// transitionLoan moves a loan to another status in its own transaction
+func transitionLoan(loan *models.Loan, to models.LoanStatus, actorID int64, reason string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := transitionLoanTx(tx, loan, to, actorID, reason, time.Now()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// transitionLoanTx moves a loan to another status within a transaction. It checks the transition
+// is allowed, runs its guard, applies its side effects and records it in the status history.
+// The update only succeeds while the loan is still in the status it was read with.
+func transitionLoanTx(tx *sql.Tx, loan *models.Loan, to models.LoanStatus, actorID int64, reason string, now time.Time) error {
+	from := loan.Status
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("%w: %s -> %s", ErrInvalidLoanTransition, from, to)
+	}
+
+	rule := loanTransitionRules[to]
+	if rule.guard != nil {
+		if err := rule.guard(tx, loan); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(`
+		UPDATE loans SET status = ?, updated_at = ? WHERE id = ? AND status = ?
+	`, to, now, loan.ID, from)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrLoanStatusChanged
+	}
+
+	if rule.apply != nil {
+		if err := rule.apply(tx, loan, actorID, reason, now); err != nil {
+			return err
+		}
+	}
+
+	if err := insertLoanStatusHistoryTx(tx, loan.ID, from, to, actorID, reason, now); err != nil {
+		return err
+	}
+
+	loan.Status = to
+	loan.UpdatedAt = now
+	return nil
+}
+
+// insertLoanStatusHistoryTx records a status change. An empty from status marks the creation
+// of the loan and an actorID of 0 a change made by the system.
+func insertLoanStatusHistoryTx(tx *sql.Tx, loanID int64, from, to models.LoanStatus, actorID int64, reason string, now time.Time) error {
+	var fromStatus interface{}
+	if from != "" {
+		fromStatus = string(from)
+	}
+	_, err := tx.Exec(`
+		INSERT INTO loan_status_history (loan_id, from_status, to_status, actor_id, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, loanID, fromStatus, string(to), nullableInt64(actorID), reason, now)
+	return err
+}
+
+// GetLoanStatusHistory returns the status changes of a loan, oldest first
+func GetLoanStatusHistory(loanID int64) ([]models.LoanStatusChange, error) {
+	if err := EnsureLoansTableExists(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, loan_id, from_status, to_status, actor_id, reason, created_at
+		FROM loan_status_history
+		WHERE loan_id = ?
+		ORDER BY created_at, id
+	`, loanID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []models.LoanStatusChange{}
+	for rows.Next() {
+		var change models.LoanStatusChange
+		var fromStatus, reason sql.NullString
+		var actorID sql.NullInt64
+		if err := rows.Scan(&change.ID, &change.LoanID, &fromStatus, &change.ToStatus,
+			&actorID, &reason, &change.CreatedAt); err != nil {
+			return nil, err
+		}
+		change.FromStatus = models.LoanStatus(fromStatus.String)
+		change.Reason = reason.String
+		if actorID.Valid {
+			actor := actorID.Int64
+			change.ActorID = &actor
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
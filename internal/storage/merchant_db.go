@@ -0,0 +1,888 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"finance/internal/models"
+	"finance/internal/scoring"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// Installment purchase statuses
+const (
+	PurchaseAwaitingConsent = "awaiting_consent"
+	PurchaseConsented       = "consented" // The customer accepted, the loan is being decided
+	PurchaseSettled         = "settled"   // The merchant has been paid
+	PurchaseDeclined        = "declined"  // The customer refused the plan
+	PurchaseRejected        = "rejected"  // The bank rejected the loan
+	PurchaseCancelled       = "cancelled"
+	PurchaseExpired         = "expired"
+)
+
+// purchaseConsentWindow is how long a customer has to confirm a plan created at checkout
+const purchaseConsentWindow = 30 * time.Minute
+
+var (
+	// ErrMerchantNotFound is returned when a merchant does not exist
+	ErrMerchantNotFound = errors.New("merchant not found")
+	// ErrInvalidMerchantKey is returned for an unknown API key or the key of a suspended merchant
+	ErrInvalidMerchantKey = errors.New("invalid merchant API key")
+	// ErrMerchantPlanNotFound is returned when a merchant does not offer the requested plan
+	ErrMerchantPlanNotFound = errors.New("merchant installment plan not found")
+	// ErrPurchaseNotFound is returned when an installment purchase does not exist
+	ErrPurchaseNotFound = errors.New("installment purchase not found")
+	// ErrPurchaseNotAwaitingConsent is returned when a purchase can no longer be confirmed or cancelled
+	ErrPurchaseNotAwaitingConsent = errors.New("installment purchase is not awaiting consent")
+	// ErrDuplicateOrderReference is returned when a merchant reuses an order reference
+	ErrDuplicateOrderReference = errors.New("order reference already used by this merchant")
+)
+
+// Merchant is a retailer offering installment plans at checkout.
+// Settlements are paid to its account, which may be a deposit held in this bank.
+type Merchant struct {
+	ID                int64  `json:"id"`
+	Name              string `json:"name"`
+	SettlementAccount string `json:"settlement_account"`
+	SettlementBank    string `json:"settlement_bank"`
+	APIKeyPrefix      string `json:"api_key_prefix"`
+	Active            bool   `json:"active"`
+	CreatedBy         int64  `json:"created_by"`
+	CreatedAt         int64  `json:"created_at"`
+	UpdatedAt         int64  `json:"updated_at"`
+}
+
+// MerchantPlan is a 0% promotional plan of a merchant. The interest is funded by
+// the merchant discount, which is withheld from the settlement.
+type MerchantPlan struct {
+	ID              int64   `json:"id"`
+	MerchantID      int64   `json:"merchant_id"`
+	Name            string  `json:"name"`
+	TermMonths      int     `json:"term_months"`
+	DiscountPercent float64 `json:"discount_percent"`
+	MinAmount       float64 `json:"min_amount"`
+	MaxAmount       float64 `json:"max_amount"` // 0 means no limit
+	Active          bool    `json:"active"`
+	CreatedAt       int64   `json:"created_at"`
+}
+
+// InstallmentPurchase is a purchase paid with an installment plan at checkout
+type InstallmentPurchase struct {
+	ID               int64   `json:"id"`
+	MerchantID       int64   `json:"merchant_id"`
+	MerchantName     string  `json:"merchant_name,omitempty"`
+	PlanID           int64   `json:"plan_id"`
+	CustomerID       int64   `json:"customer_id"`
+	Item             string  `json:"item"`
+	OrderReference   string  `json:"order_reference"`
+	Amount           float64 `json:"amount"`
+	TermMonths       int     `json:"term_months"`
+	DiscountAmount   float64 `json:"discount_amount"`
+	SettlementAmount float64 `json:"settlement_amount"`
+	Status           string  `json:"status"`
+	LoanID           *int64  `json:"loan_id,omitempty"`
+	ConsentExpiresAt int64   `json:"consent_expires_at"`
+	ConsentedAt      *int64  `json:"consented_at,omitempty"`
+	SettledAt        *int64  `json:"settled_at,omitempty"`
+	SettlementRef    string  `json:"settlement_reference,omitempty"`
+	CreatedAt        int64   `json:"created_at"`
+	UpdatedAt        int64   `json:"updated_at"`
+}
+
+// EnsureMerchantTablesExist creates the merchant registry, plan and purchase tables
+func EnsureMerchantTablesExist() error {
+	// Purchases reference the loans they are financed with
+	if err := EnsureLoansTableExists(); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS merchants (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			settlement_account TEXT NOT NULL,
+			settlement_bank TEXT NOT NULL,
+			api_key_hash TEXT NOT NULL UNIQUE,
+			api_key_prefix TEXT NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_by INTEGER,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS merchant_plans (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			merchant_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			term_months INTEGER NOT NULL,
+			discount_percent REAL NOT NULL,
+			min_amount REAL NOT NULL DEFAULT 0,
+			max_amount REAL NOT NULL DEFAULT 0,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER NOT NULL,
+			FOREIGN KEY (merchant_id) REFERENCES merchants(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS installment_purchases (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			merchant_id INTEGER NOT NULL,
+			plan_id INTEGER NOT NULL,
+			customer_id INTEGER NOT NULL,
+			item TEXT NOT NULL,
+			order_reference TEXT NOT NULL,
+			amount REAL NOT NULL,
+			term_months INTEGER NOT NULL,
+			discount_amount REAL NOT NULL,
+			settlement_amount REAL NOT NULL,
+			status TEXT NOT NULL,
+			loan_id INTEGER,
+			consent_expires_at INTEGER NOT NULL,
+			consented_at INTEGER,
+			settled_at INTEGER,
+			settlement_reference TEXT,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			UNIQUE(merchant_id, order_reference),
+			FOREIGN KEY (merchant_id) REFERENCES merchants(id),
+			FOREIGN KEY (plan_id) REFERENCES merchant_plans(id),
+			FOREIGN KEY (loan_id) REFERENCES loans(id)
+		)
+	`)
+	return err
+}
+
+// hashMerchantKey returns the stored form of a merchant API key
+func hashMerchantKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// newMerchantKey generates an API key; only its hash is stored
+func newMerchantKey() (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return "mk_" + hex.EncodeToString(raw), nil
+}
+
+// CreateMerchant registers a merchant and returns its API key, which is only shown once
+func CreateMerchant(merchant *Merchant, adminID int64) (string, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return "", err
+	}
+	merchant.Name = strings.TrimSpace(merchant.Name)
+	merchant.SettlementAccount = strings.TrimSpace(merchant.SettlementAccount)
+	merchant.SettlementBank = strings.TrimSpace(merchant.SettlementBank)
+	if merchant.Name == "" || merchant.SettlementAccount == "" || merchant.SettlementBank == "" {
+		return "", errors.New("name, settlement account and settlement bank are required")
+	}
+
+	key, err := newMerchantKey()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	result, err := DB.Exec(`
+		INSERT INTO merchants (
+			name, settlement_account, settlement_bank, api_key_hash, api_key_prefix,
+			active, created_by, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, 1, ?, ?, ?)
+	`, merchant.Name, merchant.SettlementAccount, merchant.SettlementBank,
+		hashMerchantKey(key), key[:10], adminID, now, now)
+	if err != nil {
+		return "", err
+	}
+	merchant.ID, err = result.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	merchant.APIKeyPrefix = key[:10]
+	merchant.Active = true
+	merchant.CreatedBy = adminID
+	merchant.CreatedAt = now
+	merchant.UpdatedAt = now
+
+	LogTransaction(adminID, "merchant_created", nil,
+		fmt.Sprintf("Merchant #%d %s registered", merchant.ID, merchant.Name))
+	return key, nil
+}
+
+// RotateMerchantKey replaces the API key of a merchant; the old key stops working at once
+func RotateMerchantKey(merchantID, adminID int64) (string, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return "", err
+	}
+	key, err := newMerchantKey()
+	if err != nil {
+		return "", err
+	}
+	result, err := DB.Exec(`
+		UPDATE merchants SET api_key_hash = ?, api_key_prefix = ?, updated_at = ? WHERE id = ?
+	`, hashMerchantKey(key), key[:10], time.Now().Unix(), merchantID)
+	if err != nil {
+		return "", err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if affected == 0 {
+		return "", ErrMerchantNotFound
+	}
+
+	LogTransaction(adminID, "merchant_key_rotated", nil, fmt.Sprintf("API key of merchant #%d rotated", merchantID))
+	return key, nil
+}
+
+// SetMerchantActive suspends or reactivates a merchant
+func SetMerchantActive(merchantID int64, active bool, adminID int64) error {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return err
+	}
+	result, err := DB.Exec("UPDATE merchants SET active = ?, updated_at = ? WHERE id = ?",
+		active, time.Now().Unix(), merchantID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrMerchantNotFound
+	}
+
+	LogTransaction(adminID, "merchant_status_changed", nil,
+		fmt.Sprintf("Merchant #%d active set to %t", merchantID, active))
+	return nil
+}
+
+const merchantColumns = `
+	id, name, settlement_account, settlement_bank, api_key_prefix, active, created_by, created_at, updated_at
+`
+
+func scanMerchant(row interface{ Scan(...interface{}) error }, merchant *Merchant) error {
+	var createdBy sql.NullInt64
+	err := row.Scan(
+		&merchant.ID,
+		&merchant.Name,
+		&merchant.SettlementAccount,
+		&merchant.SettlementBank,
+		&merchant.APIKeyPrefix,
+		&merchant.Active,
+		&createdBy,
+		&merchant.CreatedAt,
+		&merchant.UpdatedAt,
+	)
+	merchant.CreatedBy = createdBy.Int64
+	return err
+}
+
+// GetMerchants lists the registered merchants
+func GetMerchants() ([]*Merchant, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	rows, err := DB.Query("SELECT " + merchantColumns + " FROM merchants ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	merchants := []*Merchant{}
+	for rows.Next() {
+		merchant := &Merchant{}
+		if err := scanMerchant(rows, merchant); err != nil {
+			return nil, err
+		}
+		merchants = append(merchants, merchant)
+	}
+	return merchants, rows.Err()
+}
+
+// GetMerchant returns a merchant by ID
+func GetMerchant(merchantID int64) (*Merchant, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	merchant := &Merchant{}
+	row := DB.QueryRow("SELECT "+merchantColumns+" FROM merchants WHERE id = ?", merchantID)
+	if err := scanMerchant(row, merchant); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+	return merchant, nil
+}
+
+// AuthenticateMerchant returns the active merchant owning an API key
+func AuthenticateMerchant(key string) (*Merchant, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	merchant := &Merchant{}
+	row := DB.QueryRow("SELECT "+merchantColumns+" FROM merchants WHERE api_key_hash = ?", hashMerchantKey(key))
+	if err := scanMerchant(row, merchant); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidMerchantKey
+		}
+		return nil, err
+	}
+	if !merchant.Active {
+		return nil, ErrInvalidMerchantKey
+	}
+	return merchant, nil
+}
+
+// CreateMerchantPlan adds a promotional plan to a merchant
+func CreateMerchantPlan(plan *MerchantPlan, adminID int64) error {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return err
+	}
+	if _, err := GetMerchant(plan.MerchantID); err != nil {
+		return err
+	}
+	plan.Name = strings.TrimSpace(plan.Name)
+	if plan.Name == "" {
+		return errors.New("plan name is required")
+	}
+	if plan.TermMonths <= 0 {
+		return errors.New("term must be at least one month")
+	}
+	if plan.DiscountPercent < 0 || plan.DiscountPercent >= 100 {
+		return errors.New("discount percent must be between 0 and 100")
+	}
+	if plan.MinAmount < 0 || (plan.MaxAmount != 0 && plan.MaxAmount < plan.MinAmount) {
+		return errors.New("amount limits must satisfy 0 <= min_amount <= max_amount")
+	}
+
+	plan.Active = true
+	plan.CreatedAt = time.Now().Unix()
+	result, err := DB.Exec(`
+		INSERT INTO merchant_plans (
+			merchant_id, name, term_months, discount_percent, min_amount, max_amount, active, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, 1, ?)
+	`, plan.MerchantID, plan.Name, plan.TermMonths, plan.DiscountPercent, plan.MinAmount, plan.MaxAmount, plan.CreatedAt)
+	if err != nil {
+		return err
+	}
+	plan.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(adminID, "merchant_plan_created", nil,
+		fmt.Sprintf("Plan #%d of merchant #%d: %d months, %.2f%% merchant discount",
+			plan.ID, plan.MerchantID, plan.TermMonths, plan.DiscountPercent))
+	return nil
+}
+
+// SetMerchantPlanActive withdraws or re-offers a promotional plan
+func SetMerchantPlanActive(planID int64, active bool, adminID int64) error {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return err
+	}
+	result, err := DB.Exec("UPDATE merchant_plans SET active = ? WHERE id = ?", active, planID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrMerchantPlanNotFound
+	}
+
+	LogTransaction(adminID, "merchant_plan_status_changed", nil,
+		fmt.Sprintf("Merchant plan #%d active set to %t", planID, active))
+	return nil
+}
+
+// GetMerchantPlans lists the plans of a merchant; activeOnly hides withdrawn plans
+func GetMerchantPlans(merchantID int64, activeOnly bool) ([]*MerchantPlan, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	query := `
+		SELECT id, merchant_id, name, term_months, discount_percent, min_amount, max_amount, active, created_at
+		FROM merchant_plans WHERE merchant_id = ?
+	`
+	if activeOnly {
+		query += " AND active = 1"
+	}
+	rows, err := DB.Query(query+" ORDER BY term_months", merchantID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []*MerchantPlan{}
+	for rows.Next() {
+		plan := &MerchantPlan{}
+		err := rows.Scan(&plan.ID, &plan.MerchantID, &plan.Name, &plan.TermMonths, &plan.DiscountPercent,
+			&plan.MinAmount, &plan.MaxAmount, &plan.Active, &plan.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+// CreateInstallmentPurchase records a checkout paid with a promotional plan.
+// The purchase waits for the customer to confirm it in the bank before any loan is opened.
+func CreateInstallmentPurchase(purchase *InstallmentPurchase) error {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return err
+	}
+	purchase.Item = strings.TrimSpace(purchase.Item)
+	purchase.OrderReference = strings.TrimSpace(purchase.OrderReference)
+	if purchase.Item == "" || purchase.OrderReference == "" {
+		return errors.New("item and order reference are required")
+	}
+	if purchase.Amount <= 0 {
+		return errors.New("amount must be greater than zero")
+	}
+
+	plans, err := GetMerchantPlans(purchase.MerchantID, true)
+	if err != nil {
+		return err
+	}
+	var plan *MerchantPlan
+	for _, p := range plans {
+		if p.ID == purchase.PlanID {
+			plan = p
+		}
+	}
+	if plan == nil {
+		return ErrMerchantPlanNotFound
+	}
+	if purchase.Amount < plan.MinAmount || (plan.MaxAmount != 0 && purchase.Amount > plan.MaxAmount) {
+		return fmt.Errorf("amount is outside the limits of plan %s", plan.Name)
+	}
+
+	now := time.Now()
+	purchase.TermMonths = plan.TermMonths
+	purchase.DiscountAmount = math.Round(purchase.Amount*plan.DiscountPercent) / 100
+	purchase.SettlementAmount = math.Round((purchase.Amount-purchase.DiscountAmount)*100) / 100
+	purchase.Status = PurchaseAwaitingConsent
+	purchase.ConsentExpiresAt = now.Add(purchaseConsentWindow).Unix()
+	purchase.CreatedAt = now.Unix()
+	purchase.UpdatedAt = now.Unix()
+
+	result, err := DB.Exec(`
+		INSERT INTO installment_purchases (
+			merchant_id, plan_id, customer_id, item, order_reference, amount, term_months,
+			discount_amount, settlement_amount, status, consent_expires_at, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, purchase.MerchantID, purchase.PlanID, purchase.CustomerID, purchase.Item, purchase.OrderReference,
+		purchase.Amount, purchase.TermMonths, purchase.DiscountAmount, purchase.SettlementAmount,
+		purchase.Status, purchase.ConsentExpiresAt, purchase.CreatedAt, purchase.UpdatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return ErrDuplicateOrderReference
+		}
+		return err
+	}
+	purchase.ID, err = result.LastInsertId()
+	return err
+}
+
+const installmentPurchaseColumns = `
+	p.id, p.merchant_id, m.name, p.plan_id, p.customer_id, p.item, p.order_reference, p.amount,
+	p.term_months, p.discount_amount, p.settlement_amount, p.status, p.loan_id, p.consent_expires_at,
+	p.consented_at, p.settled_at, p.settlement_reference, p.created_at, p.updated_at
+`
+
+func scanInstallmentPurchase(row interface{ Scan(...interface{}) error }, purchase *InstallmentPurchase) error {
+	var loanID, consentedAt, settledAt sql.NullInt64
+	var settlementRef sql.NullString
+	err := row.Scan(
+		&purchase.ID,
+		&purchase.MerchantID,
+		&purchase.MerchantName,
+		&purchase.PlanID,
+		&purchase.CustomerID,
+		&purchase.Item,
+		&purchase.OrderReference,
+		&purchase.Amount,
+		&purchase.TermMonths,
+		&purchase.DiscountAmount,
+		&purchase.SettlementAmount,
+		&purchase.Status,
+		&loanID,
+		&purchase.ConsentExpiresAt,
+		&consentedAt,
+		&settledAt,
+		&settlementRef,
+		&purchase.CreatedAt,
+		&purchase.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if loanID.Valid {
+		purchase.LoanID = &loanID.Int64
+	}
+	if consentedAt.Valid {
+		purchase.ConsentedAt = &consentedAt.Int64
+	}
+	if settledAt.Valid {
+		purchase.SettledAt = &settledAt.Int64
+	}
+	purchase.SettlementRef = settlementRef.String
+	return nil
+}
+
+// GetInstallmentPurchase returns a purchase by ID
+func GetInstallmentPurchase(purchaseID int64) (*InstallmentPurchase, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	purchase := &InstallmentPurchase{}
+	row := DB.QueryRow(`
+		SELECT `+installmentPurchaseColumns+`
+		FROM installment_purchases p JOIN merchants m ON m.id = p.merchant_id
+		WHERE p.id = ?
+	`, purchaseID)
+	if err := scanInstallmentPurchase(row, purchase); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPurchaseNotFound
+		}
+		return nil, err
+	}
+	return purchase, nil
+}
+
+// GetInstallmentPurchases lists purchases, newest first. A zero merchantID or customerID
+// matches every merchant or customer and an empty status every status.
+func GetInstallmentPurchases(merchantID, customerID int64, status string) ([]*InstallmentPurchase, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	if err := expireInstallmentPurchases(time.Now()); err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + installmentPurchaseColumns + `
+		FROM installment_purchases p JOIN merchants m ON m.id = p.merchant_id
+		WHERE 1 = 1
+	`
+	var args []interface{}
+	if merchantID != 0 {
+		query += " AND p.merchant_id = ?"
+		args = append(args, merchantID)
+	}
+	if customerID != 0 {
+		query += " AND p.customer_id = ?"
+		args = append(args, customerID)
+	}
+	if status != "" {
+		query += " AND p.status = ?"
+		args = append(args, status)
+	}
+	rows, err := DB.Query(query+" ORDER BY p.created_at DESC, p.id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	purchases := []*InstallmentPurchase{}
+	for rows.Next() {
+		purchase := &InstallmentPurchase{}
+		if err := scanInstallmentPurchase(rows, purchase); err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+	return purchases, rows.Err()
+}
+
+// expireInstallmentPurchases closes the purchases whose consent window has passed
+func expireInstallmentPurchases(now time.Time) error {
+	_, err := DB.Exec(`
+		UPDATE installment_purchases SET status = ?, updated_at = ?
+		WHERE status = ? AND consent_expires_at < ?
+	`, PurchaseExpired, now.Unix(), PurchaseAwaitingConsent, now.Unix())
+	return err
+}
+
+// setPurchaseStatus moves a purchase out of a status; it fails when the purchase has already left it
+func setPurchaseStatus(purchaseID int64, from, to string) error {
+	result, err := DB.Exec(`
+		UPDATE installment_purchases SET status = ?, updated_at = ? WHERE id = ? AND status = ?
+	`, to, time.Now().Unix(), purchaseID, from)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrPurchaseNotAwaitingConsent
+	}
+	return nil
+}
+
+// CancelInstallmentPurchase lets the merchant withdraw a purchase the customer has not confirmed yet
+func CancelInstallmentPurchase(purchaseID, merchantID int64) error {
+	purchase, err := GetInstallmentPurchase(purchaseID)
+	if err != nil {
+		return err
+	}
+	if purchase.MerchantID != merchantID {
+		return ErrPurchaseNotFound
+	}
+	return setPurchaseStatus(purchaseID, PurchaseAwaitingConsent, PurchaseCancelled)
+}
+
+// DeclineInstallmentPurchase records that the customer refused the plan
+func DeclineInstallmentPurchase(purchaseID, customerID int64) error {
+	purchase, err := getCustomerPurchase(purchaseID, customerID)
+	if err != nil {
+		return err
+	}
+	if err := setPurchaseStatus(purchase.ID, PurchaseAwaitingConsent, PurchaseDeclined); err != nil {
+		return err
+	}
+	LogTransaction(customerID, "installment_declined", &purchase.Amount,
+		fmt.Sprintf("Installment plan for order %s at merchant #%d declined", purchase.OrderReference, purchase.MerchantID))
+	return nil
+}
+
+// getCustomerPurchase returns a purchase of the customer that is still awaiting consent
+func getCustomerPurchase(purchaseID, customerID int64) (*InstallmentPurchase, error) {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return nil, err
+	}
+	if err := expireInstallmentPurchases(time.Now()); err != nil {
+		return nil, err
+	}
+	purchase, err := GetInstallmentPurchase(purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	if purchase.CustomerID != customerID {
+		return nil, ErrPurchaseNotFound
+	}
+	if purchase.Status != PurchaseAwaitingConsent {
+		return nil, ErrPurchaseNotAwaitingConsent
+	}
+	return purchase, nil
+}
+
+// ConfirmInstallmentPurchase records the consent of the customer and opens the 0% installment loan.
+// The loan is scored like any other request: a clear approval opens it immediately, a decline
+// rejects it and anything else waits for a manager. The merchant is paid once the loan is active.
+func ConfirmInstallmentPurchase(purchaseID, customerID int64) (*InstallmentPurchase, error) {
+	purchase, err := getCustomerPurchase(purchaseID, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claiming the purchase first makes a double confirmation open a single loan
+	if err := setPurchaseStatus(purchase.ID, PurchaseAwaitingConsent, PurchaseConsented); err != nil {
+		return nil, err
+	}
+
+	loan, err := RequestLoan(models.LoanRequest{
+		UserID:      customerID,
+		Type:        models.InstallmentPlan,
+		Amount:      purchase.Amount,
+		TermMonths:  purchase.TermMonths,
+		Promotional: true,
+	})
+	if err != nil {
+		// Give the customer another chance while the consent window is open
+		if resetErr := setPurchaseStatus(purchase.ID, PurchaseConsented, PurchaseAwaitingConsent); resetErr != nil {
+			return nil, fmt.Errorf("%v (reopening purchase: %v)", err, resetErr)
+		}
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	_, err = DB.Exec(`
+		UPDATE installment_purchases SET loan_id = ?, consented_at = ?, updated_at = ? WHERE id = ?
+	`, loan.ID, now, now, purchase.ID)
+	if err != nil {
+		return nil, err
+	}
+	LogTransaction(customerID, "installment_consented", &purchase.Amount,
+		fmt.Sprintf("Installment plan for %s (order %s) at merchant #%d confirmed, loan #%d",
+			purchase.Item, purchase.OrderReference, purchase.MerchantID, loan.ID))
+
+	if err := decideInstallmentLoan(loan); err != nil {
+		return nil, err
+	}
+	if err := settleInstallmentPurchase(purchase.ID); err != nil {
+		return nil, err
+	}
+	return GetInstallmentPurchase(purchase.ID)
+}
+
+// decideInstallmentLoan applies the scoring decision to a checkout loan. Loans that need more
+// than one approver under the approval policy are always left to the reviewers.
+func decideInstallmentLoan(loan *models.Loan) error {
+	score, err := ScoreLoan(loan)
+	if err != nil {
+		return err
+	}
+
+	switch score.Decision {
+	case scoring.DecisionAutoDecline:
+		return transitionLoan(loan, models.Rejected, 0, "declined by credit scoring at checkout")
+	case scoring.DecisionAutoApprove:
+		required, err := requiredApprovers(OperationLoan, loan.Amount)
+		if err != nil || required > 1 {
+			return err
+		}
+		if err := transitionLoan(loan, models.Approved, 0, "approved by credit scoring at checkout"); err != nil {
+			return err
+		}
+		return transitionLoan(loan, models.Active, 0, "")
+	}
+	return nil
+}
+
+// SettleInstallmentPurchases pays the merchants of confirmed purchases whose loan is active
+// and closes the purchases whose loan was rejected. Purchases that fail are logged and retried
+// on the next run.
+func SettleInstallmentPurchases() error {
+	if err := EnsureMerchantTablesExist(); err != nil {
+		return err
+	}
+	rows, err := DB.Query("SELECT id FROM installment_purchases WHERE status = ? ORDER BY id", PurchaseConsented)
+	if err != nil {
+		return err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// A purchase that cannot be settled does not hold back the others
+	for _, id := range ids {
+		if err := settleInstallmentPurchase(id); err != nil {
+			log.Printf("Error settling installment purchase %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// settleInstallmentPurchase settles one confirmed purchase once its loan has been decided.
+// The merchant receives the price less the discount that funds the 0% plan.
+func settleInstallmentPurchase(purchaseID int64) error {
+	purchase, err := GetInstallmentPurchase(purchaseID)
+	if err != nil {
+		return err
+	}
+	if purchase.Status != PurchaseConsented || purchase.LoanID == nil {
+		return nil
+	}
+	loan, err := GetLoan(*purchase.LoanID)
+	if err != nil {
+		return err
+	}
+	switch loan.Status {
+	case models.Rejected:
+		return setPurchaseStatus(purchase.ID, PurchaseConsented, PurchaseRejected)
+	case models.Active, models.Completed, models.Default:
+	default:
+		return nil
+	}
+
+	merchant, err := GetMerchant(purchase.MerchantID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		UPDATE installment_purchases SET status = ?, settled_at = ?, updated_at = ? WHERE id = ? AND status = ?
+	`, PurchaseSettled, now, now, purchase.ID, PurchaseConsented)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		// Settled by a concurrent run
+		return nil
+	}
+
+	var reference string
+	depositID, found, err := findPayeeDepositTx(tx, merchant.SettlementAccount, merchant.SettlementBank)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := creditDepositTx(tx, depositID, purchase.SettlementAmount, time.Now()); err != nil {
+			return err
+		}
+		err = recordDepositMovementTx(tx, &models.DepositMovement{
+			DepositID: depositID, Type: models.MovementTransferIn, Amount: purchase.SettlementAmount,
+			Counterparty: "Installment settlement", Reference: fmt.Sprintf("purchase #%d %s", purchase.ID, purchase.OrderReference),
+		})
+		if err != nil {
+			return err
+		}
+		reference = fmt.Sprintf("deposit #%d", depositID)
+	} else {
+		// The merchant banks elsewhere, hand the payment over to interbank settlement
+		outgoingID, err := queueOutgoingPaymentTx(tx, &OutgoingPayment{
+			SourceType:      "merchant_settlement",
+			SourceID:        purchase.ID,
+			Amount:          purchase.SettlementAmount,
+			Currency:        DefaultEnterpriseCurrency,
+			BeneficiaryName: merchant.Name,
+			AccountNumber:   merchant.SettlementAccount,
+			BankName:        merchant.SettlementBank,
+			Purpose:         fmt.Sprintf("Installment purchase, order %s", purchase.OrderReference),
+		})
+		if err != nil {
+			return err
+		}
+		reference = fmt.Sprintf("outgoing payment #%d", outgoingID)
+	}
+
+	if _, err := tx.Exec("UPDATE installment_purchases SET settlement_reference = ? WHERE id = ?", reference, purchase.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	LogTransaction(purchase.CustomerID, "merchant_settlement", &purchase.SettlementAmount,
+		fmt.Sprintf("Merchant #%d paid %.2f for order %s (discount %.2f) via %s",
+			merchant.ID, purchase.SettlementAmount, purchase.OrderReference, purchase.DiscountAmount, reference))
+	return nil
+}
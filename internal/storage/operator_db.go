@@ -324,6 +324,9 @@ func CancelUserAction(userID, actionID, operatorID int) error {
 	if err := ensureUserActionsTableExists(); err != nil {
 		return err
 	}
+	if err := EnsureLoanSecurityTablesExist(); err != nil {
+		return err
+	}
 
 	// Start a transaction
 	tx, err := DB.Begin()
@@ -393,7 +396,7 @@ func CancelUserAction(userID, actionID, operatorID int) error {
 	case "block":
 		// Unblock the deposit
 		_, err = tx.Exec(
-			"UPDATE deposits SET is_blocked = 0 WHERE client_id = ?",
+			"UPDATE deposits SET is_blocked = 0 WHERE client_id = ? AND "+unpledgedDepositCondition,
 			userID,
 		)
 	case "unblock":
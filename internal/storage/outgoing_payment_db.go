@@ -0,0 +1,670 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/clearing"
+	"finance/internal/models"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Outgoing payment statuses
+const (
+	OutgoingPaymentQueued   = "queued"   // Waiting to be sent for clearing
+	OutgoingPaymentSent     = "sent"     // Submitted to the clearing system
+	OutgoingPaymentSettled  = "settled"  // Credited by the beneficiary bank
+	OutgoingPaymentReturned = "returned" // Sent back; the originator has been credited
+	// Sent back to a deposit that was closed meanwhile and paid out in cash; an admin credits
+	// the money to another deposit of the client
+	OutgoingPaymentReturnHeld = "return_held"
+)
+
+var (
+	// ErrOutgoingPaymentNotFound is returned when an outgoing payment does not exist
+	ErrOutgoingPaymentNotFound = errors.New("outgoing payment not found")
+	// ErrOutgoingPaymentSent is returned when cancelling a payment that has already left the queue
+	ErrOutgoingPaymentSent = errors.New("payment has already been sent for clearing")
+	// ErrOutgoingPaymentReturned is returned when returning a payment a second time
+	ErrOutgoingPaymentReturned = errors.New("payment has already been returned")
+	// ErrOutgoingPaymentNotHeld is returned when crediting a held return of a payment that is not held
+	ErrOutgoingPaymentNotHeld = errors.New("payment return is not held")
+	// ErrHeldReturnDeposit is returned when a held return is credited to a deposit of another client
+	ErrHeldReturnDeposit = errors.New("deposit must be an open deposit of the client who sent the payment")
+	// ErrBeneficiaryHeldLocally is returned for interbank transfers to an account held at this bank
+	ErrBeneficiaryHeldLocally = errors.New("beneficiary account is held at this bank, use an internal transfer")
+	// ErrBeneficiaryDetailsRequired is returned for interbank transfers without a full beneficiary
+	ErrBeneficiaryDetailsRequired = errors.New("beneficiary name, account number and bank name are required")
+)
+
+// OutgoingPayment is a payment to an account held at another bank.
+// It is queued when funds leave the bank and is picked up by interbank settlement.
+// The originator is credited again if the payment is returned.
+type OutgoingPayment struct {
+	ID                  int64   `json:"id"`
+	SourceType          string  `json:"source_type"`
+	SourceID            int64   `json:"source_id"`
+	EnterpriseAccountID int64   `json:"enterprise_account_id,omitempty"`
+	DepositID           int64   `json:"deposit_id,omitempty"` // Originating deposit of client transfers
+	Amount              float64 `json:"amount"`
+	Currency            string  `json:"currency"`
+	BeneficiaryName     string  `json:"beneficiary_name"`
+	AccountNumber       string  `json:"account_number"`
+	BankName            string  `json:"bank_name"`
+	Purpose             string  `json:"purpose,omitempty"`
+	Status              string  `json:"status"` // Status can be: "queued", "sent", "settled", "returned", "return_held"
+	Connector           string  `json:"connector,omitempty"`
+	ClearingReference   string  `json:"clearing_reference,omitempty"`
+	ReturnReason        string  `json:"return_reason,omitempty"`
+	CreatedAt           int64   `json:"created_at"`
+	UpdatedAt           int64   `json:"updated_at"`
+	SentAt              int64   `json:"sent_at,omitempty"`
+	SettledAt           int64   `json:"settled_at,omitempty"`
+	ReturnedAt          int64   `json:"returned_at,omitempty"`
+}
+
+// EnsureOutgoingPaymentsTableExists creates the outgoing interbank payments table
+func EnsureOutgoingPaymentsTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS outgoing_payments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_type TEXT NOT NULL,
+			source_id INTEGER NOT NULL,
+			enterprise_account_id INTEGER,
+			amount REAL NOT NULL,
+			currency TEXT NOT NULL,
+			beneficiary_name TEXT NOT NULL,
+			account_number TEXT NOT NULL,
+			bank_name TEXT NOT NULL,
+			purpose TEXT,
+			status TEXT NOT NULL DEFAULT 'queued',
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			UNIQUE(source_type, source_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct{ column, definition string }{
+		{"deposit_id", "INTEGER"},
+		{"connector", "TEXT"},
+		{"clearing_reference", "TEXT"},
+		{"return_reason", "TEXT"},
+		{"sent_at", "INTEGER"},
+		{"settled_at", "INTEGER"},
+		{"returned_at", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := ensureColumnExists("outgoing_payments", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_outgoing_payments_reference ON outgoing_payments(clearing_reference)")
+	return err
+}
+
+// queueOutgoingPaymentTx queues an outgoing payment within a transaction.
+// A source can only be queued once, which keeps retried runs from paying twice.
+func queueOutgoingPaymentTx(tx *sql.Tx, payment *OutgoingPayment) (int64, error) {
+	now := time.Now().Unix()
+	payment.Status = OutgoingPaymentQueued
+	payment.CreatedAt = now
+	payment.UpdatedAt = now
+
+	result, err := tx.Exec(`
+		INSERT INTO outgoing_payments (
+			source_type, source_id, enterprise_account_id, deposit_id, amount, currency,
+			beneficiary_name, account_number, bank_name, purpose,
+			status, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		payment.SourceType,
+		payment.SourceID,
+		payment.EnterpriseAccountID,
+		nullableInt64(payment.DepositID),
+		payment.Amount,
+		payment.Currency,
+		payment.BeneficiaryName,
+		payment.AccountNumber,
+		payment.BankName,
+		payment.Purpose,
+		payment.Status,
+		payment.CreatedAt,
+		payment.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	payment.ID = id
+	return id, nil
+}
+
+const outgoingPaymentColumns = `id, source_type, source_id, COALESCE(enterprise_account_id, 0), COALESCE(deposit_id, 0),
+	amount, currency, beneficiary_name, account_number, bank_name, COALESCE(purpose, ''), status,
+	COALESCE(connector, ''), COALESCE(clearing_reference, ''), COALESCE(return_reason, ''),
+	created_at, updated_at, COALESCE(sent_at, 0), COALESCE(settled_at, 0), COALESCE(returned_at, 0)`
+
+func scanOutgoingPayment(row interface{ Scan(...interface{}) error }, payment *OutgoingPayment) error {
+	return row.Scan(
+		&payment.ID, &payment.SourceType, &payment.SourceID, &payment.EnterpriseAccountID, &payment.DepositID,
+		&payment.Amount, &payment.Currency, &payment.BeneficiaryName, &payment.AccountNumber, &payment.BankName,
+		&payment.Purpose, &payment.Status,
+		&payment.Connector, &payment.ClearingReference, &payment.ReturnReason,
+		&payment.CreatedAt, &payment.UpdatedAt, &payment.SentAt, &payment.SettledAt, &payment.ReturnedAt,
+	)
+}
+
+func queryOutgoingPayments(query string, args ...interface{}) ([]OutgoingPayment, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []OutgoingPayment{}
+	for rows.Next() {
+		var payment OutgoingPayment
+		if err := scanOutgoingPayment(rows, &payment); err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// GetOutgoingPayments lists outgoing payments, newest first, optionally only those with a status
+func GetOutgoingPayments(status string) ([]OutgoingPayment, error) {
+	if err := EnsureOutgoingPaymentsTableExists(); err != nil {
+		return nil, err
+	}
+	query := "SELECT " + outgoingPaymentColumns + " FROM outgoing_payments"
+	var args []interface{}
+	if status != "" {
+		query += " WHERE status = ?"
+		args = append(args, status)
+	}
+	return queryOutgoingPayments(query+" ORDER BY created_at DESC, id DESC", args...)
+}
+
+// GetClientOutgoingPayments lists the interbank transfers sent from the deposits of a client
+func GetClientOutgoingPayments(clientID int64) ([]OutgoingPayment, error) {
+	if err := EnsureOutgoingPaymentsTableExists(); err != nil {
+		return nil, err
+	}
+	return queryOutgoingPayments(`
+		SELECT `+outgoingPaymentColumns+` FROM outgoing_payments
+		WHERE deposit_id IN (SELECT deposit_id FROM deposits WHERE client_id = ?)
+		ORDER BY created_at DESC, id DESC
+	`, clientID)
+}
+
+// SendInterbankTransfer debits a deposit of the client and queues the money for an account at
+// another bank. The deposit's product rules apply as for a withdrawal, and the interbank
+// transfer limits of the client are checked in the transaction that debits the deposit.
+func SendInterbankTransfer(transfer models.InterbankTransfer) (*OutgoingPayment, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+	if err := EnsureOutgoingPaymentsTableExists(); err != nil {
+		return nil, err
+	}
+	if err := ensureLimitCheckTablesExist(); err != nil {
+		return nil, err
+	}
+	transfer.BeneficiaryName = strings.TrimSpace(transfer.BeneficiaryName)
+	transfer.AccountNumber = strings.TrimSpace(transfer.AccountNumber)
+	transfer.BankName = strings.TrimSpace(transfer.BankName)
+	if transfer.Amount <= 0 {
+		return nil, ErrInvalidMovementAmount
+	}
+	if transfer.BeneficiaryName == "" || transfer.AccountNumber == "" || transfer.BankName == "" {
+		return nil, ErrBeneficiaryDetailsRequired
+	}
+
+	deposit, err := getDepositByID(transfer.FromDepositID)
+	if err != nil {
+		return nil, err
+	}
+	if deposit.ClientID != transfer.ClientID {
+		return nil, ErrDepositNotFound
+	}
+	if deposit.IsBlocked || deposit.IsFrozen {
+		return nil, ErrDepositUnavailable
+	}
+	product, err := depositProductOf(deposit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, local, err := findPayeeDepositTx(tx, transfer.AccountNumber, transfer.BankName)
+	if local || errors.Is(err, ErrPayeeDepositBlocked) || errors.Is(err, ErrPayeeDepositClosed) {
+		return nil, ErrBeneficiaryHeldLocally
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := getDepositBalanceTx(tx, deposit.DepositID)
+	if err != nil {
+		return nil, err
+	}
+	if !balance.Available() {
+		return nil, ErrDepositUnavailable
+	}
+	if balance.Status != models.DepositActive && balance.Status != models.DepositMatured {
+		return nil, ErrDepositNotActive
+	}
+	deposit.Status = balance.Status
+	if balance.Amount < transfer.Amount {
+		return nil, ErrInsufficientFunds
+	}
+	if err := checkDepositWithdrawal(deposit, product, balance.Amount, transfer.Amount); err != nil {
+		return nil, err
+	}
+	err = checkTransactionLimitTx(tx, deposit.ClientID, LimitOperationInterbankTransfer, deposit.ProductID, transfer.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := debitDepositTx(tx, balance, transfer.Amount, now); err != nil {
+		return nil, err
+	}
+	movement := &models.DepositMovement{
+		DepositID: deposit.DepositID, ClientID: deposit.ClientID, Type: models.MovementTransferOut,
+		Amount: transfer.Amount, Channel: models.ChannelOnline, CreatedAt: now,
+		Counterparty: fmt.Sprintf("%s, %s at %s", transfer.BeneficiaryName, transfer.AccountNumber, transfer.BankName),
+		Reference:    transfer.Purpose,
+	}
+	if err := recordDepositMovementTx(tx, movement); err != nil {
+		return nil, err
+	}
+	payment := &OutgoingPayment{
+		SourceType:      "deposit_transfer",
+		SourceID:        movement.ID,
+		DepositID:       deposit.DepositID,
+		Amount:          transfer.Amount,
+		Currency:        DefaultDepositCurrency,
+		BeneficiaryName: transfer.BeneficiaryName,
+		AccountNumber:   transfer.AccountNumber,
+		BankName:        transfer.BankName,
+		Purpose:         transfer.Purpose,
+	}
+	if _, err := queueOutgoingPaymentTx(tx, payment); err != nil {
+		return nil, err
+	}
+
+	// The transaction ID lets an operator cancel the transfer while it is still queued
+	metadata := fmt.Sprintf("Interbank transfer of %.2f from deposit %d to %s at %s, outgoing payment #%d",
+		transfer.Amount, deposit.DepositID, transfer.AccountNumber, transfer.BankName, payment.ID)
+	transactionID, err := logTransactionTx(tx, deposit.ClientID, LimitOperationInterbankTransfer, &transfer.Amount, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE deposit_movements SET transaction_id = ? WHERE id = ?", transactionID, movement.ID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	transactionLogged(deposit.ClientID, LimitOperationInterbankTransfer, &transfer.Amount, metadata)
+	return payment, nil
+}
+
+// DispatchOutgoingPayments sends the queued payments through connector. Payments are claimed
+// before they are handed over, so a payment cancelled in the meantime is never sent, and go
+// back to the queue if the connector fails.
+func DispatchOutgoingPayments(connector clearing.Connector) (int, error) {
+	if err := EnsureOutgoingPaymentsTableExists(); err != nil {
+		return 0, err
+	}
+	queued, err := queryOutgoingPayments(`
+		SELECT `+outgoingPaymentColumns+` FROM outgoing_payments
+		WHERE status = ? ORDER BY id LIMIT 500
+	`, OutgoingPaymentQueued)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().Unix()
+	var claimed []OutgoingPayment
+	var batch []clearing.Payment
+	for _, payment := range queued {
+		result, err := DB.Exec(`
+			UPDATE outgoing_payments SET status = ?, connector = ?, sent_at = ?, updated_at = ?
+			WHERE id = ? AND status = ?
+		`, OutgoingPaymentSent, connector.Name(), now, now, payment.ID, OutgoingPaymentQueued)
+		if err != nil {
+			return 0, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			continue
+		}
+		claimed = append(claimed, payment)
+		batch = append(batch, clearing.Payment{
+			ID:              payment.ID,
+			Amount:          payment.Amount,
+			Currency:        payment.Currency,
+			BeneficiaryName: payment.BeneficiaryName,
+			AccountNumber:   payment.AccountNumber,
+			BankName:        payment.BankName,
+			Purpose:         payment.Purpose,
+		})
+	}
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	references, err := connector.Send(batch)
+	if err == nil && len(references) != len(batch) {
+		err = fmt.Errorf("%s returned %d references for %d payments", connector.Name(), len(references), len(batch))
+	}
+	if err != nil {
+		for _, payment := range claimed {
+			DB.Exec(`
+				UPDATE outgoing_payments SET status = ?, connector = NULL, sent_at = NULL, updated_at = ?
+				WHERE id = ? AND status = ?
+			`, OutgoingPaymentQueued, now, payment.ID, OutgoingPaymentSent)
+		}
+		return 0, err
+	}
+
+	for i, payment := range claimed {
+		if _, err := DB.Exec("UPDATE outgoing_payments SET clearing_reference = ? WHERE id = ?", references[i], payment.ID); err != nil {
+			return i, err
+		}
+	}
+	return len(claimed), nil
+}
+
+// ProcessClearingResults applies the outcomes reported by connector: settled payments are
+// marked settled and returned payments are credited back to their originator
+func ProcessClearingResults(connector clearing.Connector) error {
+	if err := EnsureOutgoingPaymentsTableExists(); err != nil {
+		return err
+	}
+	return connector.Poll(func(result clearing.Result) error {
+		var payment OutgoingPayment
+		row := DB.QueryRow("SELECT "+outgoingPaymentColumns+" FROM outgoing_payments WHERE clearing_reference = ?", result.Reference)
+		if err := scanOutgoingPayment(row, &payment); err != nil {
+			if err == sql.ErrNoRows {
+				log.Printf("Warning: %s reported %s for unknown reference %s", connector.Name(), result.Status, result.Reference)
+				return nil
+			}
+			return err
+		}
+
+		switch result.Status {
+		case clearing.StatusSettled:
+			_, err := DB.Exec(`
+				UPDATE outgoing_payments SET status = ?, settled_at = ?, updated_at = ?
+				WHERE id = ? AND status = ?
+			`, OutgoingPaymentSettled, result.At.Unix(), time.Now().Unix(), payment.ID, OutgoingPaymentSent)
+			return err
+		case clearing.StatusReturned:
+			_, err := ReturnOutgoingPayment(payment.ID, result.Reason, 0)
+			if errors.Is(err, ErrOutgoingPaymentReturned) {
+				return nil
+			}
+			return err
+		}
+		return fmt.Errorf("unknown clearing status %q", result.Status)
+	})
+}
+
+// RunClearing sends the queued payments and then applies the outcomes reported so far
+func RunClearing(connector clearing.Connector) error {
+	if _, err := DispatchOutgoingPayments(connector); err != nil {
+		return err
+	}
+	return ProcessClearingResults(connector)
+}
+
+// ReturnOutgoingPayment records that a payment came back from the beneficiary bank and credits
+// the amount back to the originator. actorID is the admin registering the return, or zero when
+// the clearing system reported it. The returned payment has status return_held when the money
+// could not be credited because the originating deposit was closed.
+func ReturnOutgoingPayment(paymentID int64, reason string, actorID int64) (*OutgoingPayment, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := getOutgoingPaymentTx(tx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	userID, err := returnOutgoingPaymentTx(tx, payment, reason, actorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	metadata := fmt.Sprintf("Outgoing payment #%d to %s at %s returned: %s", payment.ID, payment.AccountNumber, payment.BankName, reason)
+	if payment.Status == OutgoingPaymentReturnHeld {
+		metadata += fmt.Sprintf("; deposit %d is closed, return held for an admin", payment.DepositID)
+	}
+	LogTransaction(userID, "payment_return", &payment.Amount, metadata)
+	return payment, nil
+}
+
+// CreditHeldPaymentReturn pays a held return into another open deposit of the client who sent
+// the payment
+func CreditHeldPaymentReturn(paymentID, depositID, actorID int64) (*OutgoingPayment, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := getOutgoingPaymentTx(tx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != OutgoingPaymentReturnHeld {
+		return nil, ErrOutgoingPaymentNotHeld
+	}
+	var senderID, clientID int64
+	if err := tx.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ?", payment.DepositID).Scan(&senderID); err != nil {
+		return nil, err
+	}
+	err = tx.QueryRow("SELECT client_id FROM deposits WHERE deposit_id = ? AND status != ?",
+		depositID, models.DepositClosed).Scan(&clientID)
+	if err == sql.ErrNoRows || err == nil && clientID != senderID {
+		return nil, ErrHeldReturnDeposit
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := creditPaymentReturnTx(tx, payment, depositID, clientID, now); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec("UPDATE outgoing_payments SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		OutgoingPaymentReturned, now.Unix(), payment.ID, OutgoingPaymentReturnHeld)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	payment.Status = OutgoingPaymentReturned
+
+	LogTransaction(actorID, "payment_return_credit", &payment.Amount,
+		fmt.Sprintf("Held return of outgoing payment #%d credited to deposit %d", payment.ID, depositID))
+	return payment, nil
+}
+
+func getOutgoingPaymentTx(tx *sql.Tx, paymentID int64) (*OutgoingPayment, error) {
+	var payment OutgoingPayment
+	row := tx.QueryRow("SELECT "+outgoingPaymentColumns+" FROM outgoing_payments WHERE id = ?", paymentID)
+	if err := scanOutgoingPayment(row, &payment); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOutgoingPaymentNotFound
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// returnOutgoingPaymentTx marks a payment returned and credits its originator inside tx: the
+// deposit of a client transfer or the settlement account of an enterprise. Merchant settlements
+// are paid from the bank's own funds, so nothing is credited for them. A closed deposit is never
+// credited: the return goes to the deposit it was paid out to, or is held for an admin. It
+// returns the user the return is logged for.
+func returnOutgoingPaymentTx(tx *sql.Tx, payment *OutgoingPayment, reason string, actorID int64) (int64, error) {
+	if payment.Status == OutgoingPaymentReturned || payment.Status == OutgoingPaymentReturnHeld {
+		return 0, ErrOutgoingPaymentReturned
+	}
+	now := time.Now()
+	result, err := tx.Exec(`
+		UPDATE outgoing_payments SET status = ?, return_reason = ?, returned_at = ?, updated_at = ?
+		WHERE id = ? AND status = ?
+	`, OutgoingPaymentReturned, reason, now.Unix(), now.Unix(), payment.ID, payment.Status)
+	if err != nil {
+		return 0, err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return 0, ErrOutgoingPaymentReturned
+	}
+	payment.Status = OutgoingPaymentReturned
+
+	reference := fmt.Sprintf("outgoing payment #%d", payment.ID)
+	switch {
+	case payment.DepositID > 0:
+		clientID, depositID, err := paymentReturnDepositTx(tx, payment.DepositID)
+		if err != nil {
+			return 0, err
+		}
+		if depositID == 0 {
+			_, err := tx.Exec("UPDATE outgoing_payments SET status = ? WHERE id = ?", OutgoingPaymentReturnHeld, payment.ID)
+			payment.Status = OutgoingPaymentReturnHeld
+			return clientID, err
+		}
+		return clientID, creditPaymentReturnTx(tx, payment, depositID, clientID, now)
+
+	case payment.EnterpriseAccountID > 0:
+		description := fmt.Sprintf("Payment to %s returned by %s: %s", payment.BeneficiaryName, payment.BankName, reason)
+		if err := postEnterpriseMovementTx(tx, payment.EnterpriseAccountID, payment.Amount, "payment_return", reference, description, actorID); err != nil {
+			return 0, err
+		}
+		if payment.SourceType == "salary_payment" {
+			_, err := tx.Exec(`
+				UPDATE salary_payments SET status = 'failed', failure_reason = ?
+				WHERE id = ? AND status = 'paid'
+			`, "returned by "+payment.BankName+": "+reason, payment.SourceID)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return actorID, nil
+
+	default:
+		if payment.SourceType == "merchant_settlement" {
+			_, err := tx.Exec("UPDATE installment_purchases SET settlement_reference = ? WHERE id = ?",
+				reference+" returned: "+reason, payment.SourceID)
+			if err != nil {
+				return 0, err
+			}
+		}
+		return actorID, nil
+	}
+}
+
+// paymentReturnDepositTx returns the client who sent a payment from depositID and the deposit
+// its return is credited to: the deposit itself, or when it was closed meanwhile the deposit its
+// balance was paid out to. The deposit is 0 when neither can take the money.
+func paymentReturnDepositTx(tx *sql.Tx, depositID int64) (int64, int64, error) {
+	var clientID int64
+	var status string
+	var payoutDepositID sql.NullInt64
+	err := tx.QueryRow("SELECT client_id, status, payout_deposit_id FROM deposits WHERE deposit_id = ?",
+		depositID).Scan(&clientID, &status, &payoutDepositID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if status != models.DepositClosed {
+		return clientID, depositID, nil
+	}
+	// Closure records the deposit the balance was paid out to; cash payouts leave it empty
+	if !payoutDepositID.Valid {
+		return clientID, 0, nil
+	}
+
+	err = tx.QueryRow("SELECT status FROM deposits WHERE deposit_id = ?", payoutDepositID.Int64).Scan(&status)
+	if err == sql.ErrNoRows || err == nil && status == models.DepositClosed {
+		return clientID, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return clientID, payoutDepositID.Int64, nil
+}
+
+// creditPaymentReturnTx credits a returned client payment to depositID
+func creditPaymentReturnTx(tx *sql.Tx, payment *OutgoingPayment, depositID, clientID int64, now time.Time) error {
+	if err := creditDepositTx(tx, depositID, payment.Amount, now); err != nil {
+		return err
+	}
+	reference := fmt.Sprintf("outgoing payment #%d", payment.ID)
+	if depositID != payment.DepositID {
+		reference += fmt.Sprintf(" from closed deposit #%d", payment.DepositID)
+	}
+	return recordDepositMovementTx(tx, &models.DepositMovement{
+		DepositID: depositID, ClientID: clientID, Type: models.MovementPaymentReturn, Amount: payment.Amount,
+		Counterparty: payment.BankName, Reference: reference, CreatedAt: now,
+	})
+}
+
+// cancelInterbankTransferTx takes back an interbank transfer logged as transactionID while it is
+// still queued and returns the deposit it was sent from
+func cancelInterbankTransferTx(tx *sql.Tx, transactionID int64, operatorID int64) (int64, error) {
+	var payment OutgoingPayment
+	row := tx.QueryRow(`
+		SELECT `+outgoingPaymentColumns+` FROM outgoing_payments
+		WHERE source_type = 'deposit_transfer' AND source_id = (
+			SELECT id FROM deposit_movements WHERE transaction_id = ? AND type = ?
+		)
+	`, transactionID, models.MovementTransferOut)
+	if err := scanOutgoingPayment(row, &payment); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrOutgoingPaymentNotFound
+		}
+		return 0, err
+	}
+	if payment.Status != OutgoingPaymentQueued {
+		return 0, ErrOutgoingPaymentSent
+	}
+	_, err := returnOutgoingPaymentTx(tx, &payment, fmt.Sprintf("cancelled by operator %d", operatorID), operatorID)
+	return payment.DepositID, err
+}
@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/iso20022"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDuplicatePaymentBatch is returned when an enterprise uploads a message ID it has already used
+	ErrDuplicatePaymentBatch = errors.New("payment batch with this message ID was already uploaded")
+	// ErrPaymentBatchNotFound is returned when a payment batch does not exist
+	ErrPaymentBatchNotFound = errors.New("payment batch not found")
+)
+
+// PaymentBatch is a pain.001 credit transfer message uploaded by an enterprise. Every accepted
+// instruction becomes a pending enterprise transfer that goes through the usual approval.
+type PaymentBatch struct {
+	ID             int64                     `json:"id"`
+	EnterpriseID   int                       `json:"enterprise_id"`
+	MessageID      string                    `json:"message_id"`
+	MessageName    string                    `json:"message_name"` // e.g. pain.001.001.03
+	NumberOfTxs    int                       `json:"number_of_txs"`
+	ControlSum     *float64                  `json:"control_sum,omitempty"`
+	AcceptedCount  int                       `json:"accepted_count"`
+	RejectedCount  int                       `json:"rejected_count"`
+	AcceptedAmount float64                   `json:"accepted_amount"`
+	Status         string                    `json:"status"` // Status can be: "ACCP", "PART", "RJCT"
+	ReasonCode     string                    `json:"reason_code,omitempty"`
+	Reason         string                    `json:"reason,omitempty"`
+	UploadedBy     int                       `json:"uploaded_by"`
+	UploadedAt     int64                     `json:"uploaded_at"`
+	Instructions   []PaymentBatchInstruction `json:"instructions,omitempty"`
+}
+
+// PaymentBatchInstruction is one credit transfer of a payment batch and its outcome
+type PaymentBatchInstruction struct {
+	ID              int64   `json:"id"`
+	BatchID         int64   `json:"batch_id"`
+	PaymentInfoID   string  `json:"payment_info_id"`
+	InstructionID   string  `json:"instruction_id,omitempty"`
+	EndToEndID      string  `json:"end_to_end_id"`
+	CreditorName    string  `json:"creditor_name"`
+	CreditorAccount string  `json:"creditor_account"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	Status          string  `json:"status"` // Status can be: "ACCP", "RJCT"
+	ReasonCode      string  `json:"reason_code,omitempty"`
+	Reason          string  `json:"reason,omitempty"`
+	TransferID      int64   `json:"transfer_id,omitempty"`
+}
+
+// EnsurePaymentBatchTablesExist creates the payment batch tables and links enterprise transfers to them
+func EnsurePaymentBatchTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS payment_batches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			enterprise_id INTEGER NOT NULL,
+			message_id TEXT NOT NULL,
+			message_name TEXT NOT NULL,
+			number_of_txs INTEGER NOT NULL,
+			control_sum REAL,
+			accepted_count INTEGER NOT NULL,
+			rejected_count INTEGER NOT NULL,
+			accepted_amount REAL NOT NULL,
+			status TEXT NOT NULL,
+			reason_code TEXT,
+			reason TEXT,
+			uploaded_by INTEGER NOT NULL,
+			uploaded_at INTEGER NOT NULL,
+			UNIQUE(enterprise_id, message_id),
+			FOREIGN KEY (enterprise_id) REFERENCES enterprises(id),
+			FOREIGN KEY (uploaded_by) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS payment_batch_instructions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			batch_id INTEGER NOT NULL,
+			payment_info_id TEXT NOT NULL,
+			instruction_id TEXT,
+			end_to_end_id TEXT NOT NULL,
+			creditor_name TEXT,
+			creditor_account TEXT,
+			amount REAL NOT NULL,
+			currency TEXT,
+			status TEXT NOT NULL,
+			reason_code TEXT,
+			reason TEXT,
+			transfer_id INTEGER,
+			FOREIGN KEY (batch_id) REFERENCES payment_batches(id),
+			FOREIGN KEY (transfer_id) REFERENCES enterprise_transfers(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if err := ensureColumnExists("enterprise_transfers", "batch_id", "INTEGER REFERENCES payment_batches(id)"); err != nil {
+		return err
+	}
+	if err := ensureColumnExists("enterprise_transfers", "end_to_end_id", "TEXT"); err != nil {
+		return err
+	}
+	_, err = DB.Exec("CREATE INDEX IF NOT EXISTS idx_enterprise_transfers_end_to_end ON enterprise_transfers(from_enterprise_id, end_to_end_id)")
+	return err
+}
+
+// paymentBatchResolver matches pain.001 credit transfers to recipients held at the bank.
+// Everything is read before the batch is saved so that the write transaction stays short.
+type paymentBatchResolver struct {
+	enterpriseID int
+	debtor       *EnterpriseAccount // Primary settlement account, nil if the enterprise has none yet
+	employees    []EnterpriseEmployee
+	primary      map[int]int64 // Primary settlement account ID by enterprise
+	endToEndIDs  map[string]bool
+}
+
+func newPaymentBatchResolver(enterpriseID int) (*paymentBatchResolver, error) {
+	accounts, err := GetEnterpriseAccounts(enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	employees, err := GetEnterpriseEmployees(enterpriseID, true)
+	if err != nil {
+		return nil, err
+	}
+	resolver := &paymentBatchResolver{
+		enterpriseID: enterpriseID,
+		employees:    employees,
+		primary:      map[int]int64{},
+		endToEndIDs:  map[string]bool{},
+	}
+	if len(accounts) > 0 {
+		resolver.debtor = &accounts[0]
+	}
+	return resolver, nil
+}
+
+// currency is the currency the enterprise's transfers are debited in
+func (r *paymentBatchResolver) currency() string {
+	if r.debtor != nil {
+		return r.debtor.Currency
+	}
+	return DefaultEnterpriseCurrency
+}
+
+// checkDebtor validates the debtor account of a payment information block. Transfers are always
+// debited from the primary settlement account, so other accounts cannot be named.
+func (r *paymentBatchResolver) checkDebtor(account string) (string, string) {
+	if account == "" {
+		return "", ""
+	}
+	if r.debtor == nil || !strings.EqualFold(account, r.debtor.AccountNumber) {
+		primary := "the enterprise has no settlement account yet"
+		if r.debtor != nil {
+			primary = "transfers are debited from " + r.debtor.AccountNumber
+		}
+		return iso20022.ReasonDebtorAccount, fmt.Sprintf("debtor account %s cannot be used, %s", account, primary)
+	}
+	return "", ""
+}
+
+// resolve validates a credit transfer and fills in the recipient of the enterprise transfer.
+// It returns a status reason code and text when the transfer has to be rejected.
+func (r *paymentBatchResolver) resolve(credit iso20022.CreditTransfer, transfer *EnterpriseTransfer) (string, string, error) {
+	if r.endToEndIDs[credit.EndToEndID] {
+		return iso20022.ReasonDuplication, "EndToEndId appears more than once in the message", nil
+	}
+	r.endToEndIDs[credit.EndToEndID] = true
+
+	var previous int64
+	err := DB.QueryRow(`
+		SELECT id FROM enterprise_transfers
+		WHERE from_enterprise_id = ? AND end_to_end_id = ? AND status != 'rejected'
+		LIMIT 1
+	`, r.enterpriseID, credit.EndToEndID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+	if err == nil {
+		return iso20022.ReasonDuplication, fmt.Sprintf("EndToEndId was already submitted as transfer #%d", previous), nil
+	}
+
+	if credit.Amount <= 0 || math.Abs(credit.Amount*100-math.Round(credit.Amount*100)) > 1e-6 {
+		return iso20022.ReasonZeroAmount, "amount must be positive with at most two decimals", nil
+	}
+	if credit.Currency != "" && !strings.EqualFold(credit.Currency, r.currency()) {
+		return iso20022.ReasonCurrency, fmt.Sprintf("transfers are debited in %s, not %s", r.currency(), credit.Currency), nil
+	}
+	if credit.CreditorAccount == "" {
+		return iso20022.ReasonIncorrectAccount, "creditor account is missing", nil
+	}
+
+	// Settlement accounts of other enterprises
+	account, err := GetEnterpriseAccountByNumber(credit.CreditorAccount)
+	if err != nil && !errors.Is(err, ErrEnterpriseAccountNotFound) {
+		return "", "", err
+	}
+	if err == nil {
+		if account.EnterpriseID == r.enterpriseID {
+			return iso20022.ReasonCreditorAccount, "creditor account belongs to the debtor enterprise", nil
+		}
+		if account.Currency != r.currency() {
+			return iso20022.ReasonCurrency, fmt.Sprintf("creditor account is held in %s", account.Currency), nil
+		}
+		primary, ok := r.primary[account.EnterpriseID]
+		if !ok {
+			accounts, err := GetEnterpriseAccounts(account.EnterpriseID)
+			if err != nil {
+				return "", "", err
+			}
+			primary = accounts[0].ID
+			r.primary[account.EnterpriseID] = primary
+		}
+		// Enterprise transfers are credited to the primary settlement account of the recipient
+		if primary != account.ID {
+			return iso20022.ReasonCreditorAccount, "creditor account is not the primary settlement account of its enterprise", nil
+		}
+		transfer.ToEnterpriseID = account.EnterpriseID
+		return "", "", nil
+	}
+
+	// Payout deposits of the enterprise's own employees
+	employee := FindEnterpriseEmployee(r.employees, credit.CreditorName, credit.CreditorAccount)
+	if employee == nil {
+		return iso20022.ReasonIncorrectAccount, "creditor account is not held at this bank", nil
+	}
+	if !employee.IsPayoutAccount(credit.CreditorAccount) {
+		return iso20022.ReasonCreditorAccount,
+			fmt.Sprintf("creditor account is not the payout deposit of employee %s", employee.EmployeeNumber), nil
+	}
+	if employee.IsTerminated(time.Now()) {
+		return iso20022.ReasonClosedAccount, fmt.Sprintf("employee %s is terminated", employee.EmployeeNumber), nil
+	}
+	transfer.ToEnterpriseID = r.enterpriseID
+	transfer.ToEmployeeID = int(employee.ID)
+	return "", "", nil
+}
+
+// ImportPaymentBatch validates a pain.001 message uploaded for an enterprise and saves every
+// accepted credit transfer as a pending enterprise transfer under a new batch. Rejected transfers
+// are kept with their reason so that the status report can be produced again later.
+// A message whose declared transaction count or control sum does not match is rejected as a whole.
+func ImportPaymentBatch(enterpriseID, userID int, message *iso20022.PaymentInitiation) (*PaymentBatch, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var existing int64
+	err := DB.QueryRow("SELECT id FROM payment_batches WHERE enterprise_id = ? AND message_id = ?",
+		enterpriseID, message.MessageID).Scan(&existing)
+	if err == nil {
+		return nil, ErrDuplicatePaymentBatch
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	resolver, err := newPaymentBatchResolver(enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := &PaymentBatch{
+		EnterpriseID: enterpriseID,
+		MessageID:    message.MessageID,
+		MessageName:  message.Version,
+		NumberOfTxs:  message.NumberOfTxs,
+		ControlSum:   message.ControlSum,
+		UploadedBy:   userID,
+		UploadedAt:   time.Now().Unix(),
+	}
+	if count := message.Transactions(); count != message.NumberOfTxs {
+		batch.ReasonCode = iso20022.ReasonNumberOfTxs
+		batch.Reason = fmt.Sprintf("NbOfTxs is %d but the message holds %d transfers", message.NumberOfTxs, count)
+	} else if message.ControlSum != nil && math.Abs(*message.ControlSum-message.Sum()) >= 0.005 {
+		batch.ReasonCode = iso20022.ReasonControlSum
+		batch.Reason = fmt.Sprintf("CtrlSum is %.2f but the transfers add up to %.2f", *message.ControlSum, message.Sum())
+	}
+
+	var transfers []*EnterpriseTransfer
+	for _, info := range message.PaymentInfos {
+		debtorCode, debtorReason := resolver.checkDebtor(info.DebtorAccount)
+		for _, credit := range info.Transfers {
+			instruction := PaymentBatchInstruction{
+				PaymentInfoID:   info.ID,
+				InstructionID:   credit.InstructionID,
+				EndToEndID:      credit.EndToEndID,
+				CreditorName:    credit.CreditorName,
+				CreditorAccount: credit.CreditorAccount,
+				Amount:          credit.Amount,
+				Currency:        credit.Currency,
+				Status:          iso20022.StatusRejected,
+			}
+			purpose := credit.Remittance
+			if purpose == "" {
+				purpose = "Payment " + credit.EndToEndID
+			}
+			transfer := &EnterpriseTransfer{
+				FromEnterpriseID: enterpriseID,
+				Amount:           credit.Amount,
+				Status:           "pending",
+				Purpose:          purpose,
+				Comment:          fmt.Sprintf("%s %s, payment %s", message.Version, message.MessageID, info.ID),
+				RequestedBy:      userID,
+				EndToEndID:       credit.EndToEndID,
+			}
+
+			switch {
+			case batch.ReasonCode != "":
+				instruction.ReasonCode, instruction.Reason = iso20022.ReasonNarrative, "message rejected: "+batch.Reason
+			case debtorCode != "":
+				instruction.ReasonCode, instruction.Reason = debtorCode, debtorReason
+			default:
+				instruction.ReasonCode, instruction.Reason, err = resolver.resolve(credit, transfer)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			if instruction.ReasonCode == "" {
+				instruction.Status = iso20022.StatusAccepted
+				batch.AcceptedCount++
+				batch.AcceptedAmount += credit.Amount
+				transfers = append(transfers, transfer)
+			} else {
+				batch.RejectedCount++
+				transfers = append(transfers, nil)
+			}
+			batch.Instructions = append(batch.Instructions, instruction)
+		}
+	}
+	batch.AcceptedAmount = math.Round(batch.AcceptedAmount*100) / 100
+	switch {
+	case batch.RejectedCount == 0:
+		batch.Status = iso20022.StatusAccepted
+	case batch.AcceptedCount == 0:
+		batch.Status = iso20022.StatusRejected
+	default:
+		batch.Status = iso20022.StatusPartial
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		INSERT INTO payment_batches (
+			enterprise_id, message_id, message_name, number_of_txs, control_sum,
+			accepted_count, rejected_count, accepted_amount, status, reason_code, reason,
+			uploaded_by, uploaded_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		batch.EnterpriseID, batch.MessageID, batch.MessageName, batch.NumberOfTxs, batch.ControlSum,
+		batch.AcceptedCount, batch.RejectedCount, batch.AcceptedAmount, batch.Status, batch.ReasonCode, batch.Reason,
+		batch.UploadedBy, batch.UploadedAt,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, ErrDuplicatePaymentBatch
+		}
+		return nil, err
+	}
+	if batch.ID, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	for i := range batch.Instructions {
+		instruction := &batch.Instructions[i]
+		instruction.BatchID = batch.ID
+		if transfer := transfers[i]; transfer != nil {
+			transfer.BatchID = batch.ID
+			transfer.RequestedAt = batch.UploadedAt
+			result, err := tx.Exec(`
+				INSERT INTO enterprise_transfers (
+					from_enterprise_id, to_enterprise_id, to_employee_id,
+					amount, status, purpose, comment,
+					requested_by, requested_at, batch_id, end_to_end_id
+				) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			`,
+				transfer.FromEnterpriseID, transfer.ToEnterpriseID, nullableInt64(int64(transfer.ToEmployeeID)),
+				transfer.Amount, transfer.Status, transfer.Purpose, transfer.Comment,
+				transfer.RequestedBy, transfer.RequestedAt, transfer.BatchID, transfer.EndToEndID,
+			)
+			if err != nil {
+				return nil, err
+			}
+			if transfer.ID, err = result.LastInsertId(); err != nil {
+				return nil, err
+			}
+			instruction.TransferID = transfer.ID
+		}
+
+		result, err := tx.Exec(`
+			INSERT INTO payment_batch_instructions (
+				batch_id, payment_info_id, instruction_id, end_to_end_id,
+				creditor_name, creditor_account, amount, currency,
+				status, reason_code, reason, transfer_id
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			instruction.BatchID, instruction.PaymentInfoID, instruction.InstructionID, instruction.EndToEndID,
+			instruction.CreditorName, instruction.CreditorAccount, instruction.Amount, instruction.Currency,
+			instruction.Status, instruction.ReasonCode, instruction.Reason, nullableInt64(instruction.TransferID),
+		)
+		if err != nil {
+			return nil, err
+		}
+		if instruction.ID, err = result.LastInsertId(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// Every accepted transfer is logged like a transfer request keyed in by hand
+	for _, transfer := range transfers {
+		if transfer == nil {
+			continue
+		}
+		transferType := "enterprise_transfer_request"
+		recipient := fmt.Sprintf("Enterprise ID: %d", transfer.ToEnterpriseID)
+		if transfer.ToEmployeeID > 0 {
+			transferType = "employee_transfer_request"
+			recipient = fmt.Sprintf("Employee ID: %d at Enterprise ID: %d", transfer.ToEmployeeID, transfer.ToEnterpriseID)
+		}
+		metadata := fmt.Sprintf("Transfer #%d to %s, Purpose: %s, Batch #%d (%s), EndToEndId: %s",
+			transfer.ID, recipient, transfer.Purpose, batch.ID, batch.MessageID, transfer.EndToEndID)
+		LogTransaction(int64(userID), transferType, &transfer.Amount, metadata)
+	}
+
+	return batch, nil
+}
+
+const paymentBatchColumns = `
+	id, enterprise_id, message_id, message_name, number_of_txs, control_sum,
+	accepted_count, rejected_count, accepted_amount, status, reason_code, reason,
+	uploaded_by, uploaded_at`
+
+func scanPaymentBatch(row interface{ Scan(...interface{}) error }, batch *PaymentBatch) error {
+	var controlSum sql.NullFloat64
+	var reasonCode, reason sql.NullString
+	err := row.Scan(
+		&batch.ID, &batch.EnterpriseID, &batch.MessageID, &batch.MessageName, &batch.NumberOfTxs, &controlSum,
+		&batch.AcceptedCount, &batch.RejectedCount, &batch.AcceptedAmount, &batch.Status, &reasonCode, &reason,
+		&batch.UploadedBy, &batch.UploadedAt,
+	)
+	if err != nil {
+		return err
+	}
+	if controlSum.Valid {
+		batch.ControlSum = &controlSum.Float64
+	}
+	batch.ReasonCode = reasonCode.String
+	batch.Reason = reason.String
+	return nil
+}
+
+// GetPaymentBatches lists the payment batches of an enterprise, newest first, without their instructions
+func GetPaymentBatches(enterpriseID int) ([]PaymentBatch, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT `+paymentBatchColumns+` FROM payment_batches
+		WHERE enterprise_id = ?
+		ORDER BY uploaded_at DESC, id DESC
+	`, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	batches := []PaymentBatch{}
+	for rows.Next() {
+		var batch PaymentBatch
+		if err := scanPaymentBatch(rows, &batch); err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	return batches, rows.Err()
+}
+
+// GetPaymentBatch retrieves a payment batch together with its instructions in message order
+func GetPaymentBatch(batchID int64) (*PaymentBatch, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var batch PaymentBatch
+	row := DB.QueryRow(`SELECT `+paymentBatchColumns+` FROM payment_batches WHERE id = ?`, batchID)
+	if err := scanPaymentBatch(row, &batch); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPaymentBatchNotFound
+		}
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, batch_id, payment_info_id, instruction_id, end_to_end_id,
+			creditor_name, creditor_account, amount, currency,
+			status, reason_code, reason, transfer_id
+		FROM payment_batch_instructions
+		WHERE batch_id = ?
+		ORDER BY id
+	`, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var instruction PaymentBatchInstruction
+		var instructionID, creditorName, creditorAccount, currency, reasonCode, reason sql.NullString
+		var transferID sql.NullInt64
+		err := rows.Scan(
+			&instruction.ID, &instruction.BatchID, &instruction.PaymentInfoID, &instructionID, &instruction.EndToEndID,
+			&creditorName, &creditorAccount, &instruction.Amount, &currency,
+			&instruction.Status, &reasonCode, &reason, &transferID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		instruction.InstructionID = instructionID.String
+		instruction.CreditorName = creditorName.String
+		instruction.CreditorAccount = creditorAccount.String
+		instruction.Currency = currency.String
+		instruction.ReasonCode = reasonCode.String
+		instruction.Reason = reason.String
+		instruction.TransferID = transferID.Int64
+		batch.Instructions = append(batch.Instructions, instruction)
+	}
+	return &batch, rows.Err()
+}
+
+// StatusReport is the pain.002 report on the batch, identified by the batch ID so that it
+// is the same document every time it is requested
+func (b *PaymentBatch) StatusReport() iso20022.StatusReport {
+	report := iso20022.StatusReport{
+		MessageID:           fmt.Sprintf("PSR-%d", b.ID),
+		CreatedAt:           time.Unix(b.UploadedAt, 0),
+		OriginalMessageID:   b.MessageID,
+		OriginalVersion:     b.MessageName,
+		OriginalNumberOfTxs: b.NumberOfTxs,
+		OriginalControlSum:  b.ControlSum,
+		GroupReasonCode:     b.ReasonCode,
+		GroupReason:         b.Reason,
+	}
+	for _, instruction := range b.Instructions {
+		report.Transactions = append(report.Transactions, iso20022.TransactionStatus{
+			PaymentInfoID: instruction.PaymentInfoID,
+			InstructionID: instruction.InstructionID,
+			EndToEndID:    instruction.EndToEndID,
+			Status:        instruction.Status,
+			ReasonCode:    instruction.ReasonCode,
+			Reason:        instruction.Reason,
+		})
+	}
+	return report
+}
@@ -0,0 +1,768 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// payrollDraftLeadDays is how many days before the submission date a draft project is created
+	payrollDraftLeadDays = 5
+	// payrollReminderLeadDays is how many days before the submission date reminders start
+	payrollReminderLeadDays = 2
+)
+
+var (
+	// ErrPayrollTemplateNotFound is returned when a payroll template does not exist
+	ErrPayrollTemplateNotFound = errors.New("payroll template not found")
+	// ErrNoPayrollSource is returned when there is no previous project to copy payments from
+	ErrNoPayrollSource = errors.New("no previous salary project to copy payments from")
+	// ErrSalaryProjectNotDraft is returned when changing a salary project that is no longer a draft
+	ErrSalaryProjectNotDraft = errors.New("only draft salary projects can be changed")
+	// ErrSalaryPaymentNotFound is returned when a salary payment does not exist
+	ErrSalaryPaymentNotFound = errors.New("salary payment not found")
+)
+
+// PayrollTemplate describes a recurring payroll of an enterprise
+type PayrollTemplate struct {
+	ID               int64  `json:"id"`
+	EnterpriseID     int    `json:"enterprise_id"`
+	Name             string `json:"name"`
+	Kind             string `json:"kind"`    // Kind can be: "salary", "advance"
+	PayDay           int    `json:"pay_day"` // Day of month, clamped to the last day of shorter months
+	SubmitDaysBefore int    `json:"submit_days_before"`
+	PaymentPurpose   string `json:"payment_purpose,omitempty"`
+	SourceProjectID  int64  `json:"source_project_id,omitempty"`
+	Active           bool   `json:"active"`
+	CreatedBy        int    `json:"created_by"`
+	CreatedAt        int64  `json:"created_at"`
+	UpdatedAt        int64  `json:"updated_at"`
+}
+
+// PayrollReminder is a notice that a draft salary project still waits for confirmation
+type PayrollReminder struct {
+	ID           int64  `json:"id"`
+	TemplateID   int64  `json:"template_id"`
+	ProjectID    int64  `json:"project_id"`
+	EnterpriseID int    `json:"enterprise_id"`
+	Message      string `json:"message"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// NextPayDate returns the first pay date of the template on or after the day of from
+func (t *PayrollTemplate) NextPayDate(from time.Time) time.Time {
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	payDate := payDateInMonth(day.Year(), day.Month(), t.PayDay, day.Location())
+	if payDate.Before(day) {
+		next := day.AddDate(0, 0, -day.Day()+1).AddDate(0, 1, 0)
+		payDate = payDateInMonth(next.Year(), next.Month(), t.PayDay, day.Location())
+	}
+	return payDate
+}
+
+// SubmissionDate returns the day a project paid on payDate is submitted for approval
+func (t *PayrollTemplate) SubmissionDate(payDate time.Time) time.Time {
+	return payDate.AddDate(0, 0, -t.SubmitDaysBefore)
+}
+
+// payDateInMonth returns the pay day of a month, using the last day of the month when it is shorter
+func payDateInMonth(year int, month time.Month, payDay int, loc *time.Location) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if payDay > lastDay {
+		payDay = lastDay
+	}
+	return time.Date(year, month, payDay, 0, 0, 0, 0, loc)
+}
+
+// EnsurePayrollTemplateTablesExist creates the payroll calendar tables
+func EnsurePayrollTemplateTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS payroll_templates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			enterprise_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			kind TEXT NOT NULL DEFAULT 'salary',
+			pay_day INTEGER NOT NULL,
+			submit_days_before INTEGER NOT NULL DEFAULT 0,
+			payment_purpose TEXT,
+			source_project_id INTEGER,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_by INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			FOREIGN KEY (enterprise_id) REFERENCES enterprises(id),
+			FOREIGN KEY (created_by) REFERENCES users(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS payroll_reminders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			template_id INTEGER NOT NULL,
+			project_id INTEGER NOT NULL,
+			enterprise_id INTEGER NOT NULL,
+			reminder_date TEXT NOT NULL,
+			message TEXT NOT NULL,
+			created_at INTEGER NOT NULL,
+			UNIQUE(project_id, reminder_date),
+			FOREIGN KEY (template_id) REFERENCES payroll_templates(id),
+			FOREIGN KEY (project_id) REFERENCES salary_projects(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct{ column, definition string }{
+		{"template_id", "INTEGER"},
+		{"pay_date", "INTEGER"},
+		{"confirmed_by", "INTEGER"},
+		{"confirmed_at", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := ensureColumnExists("salary_projects", c.column, c.definition); err != nil {
+			return err
+		}
+	}
+
+	// One project per template and pay date, so copy-forward can run repeatedly
+	_, err = DB.Exec(`
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_salary_projects_template_pay_date
+		ON salary_projects(template_id, pay_date)
+	`)
+	return err
+}
+
+// CreatePayrollTemplate saves a new recurring payroll template
+func CreatePayrollTemplate(template *PayrollTemplate) (int64, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+
+	if template.Kind == "" {
+		template.Kind = "salary"
+	}
+	if template.Kind != "salary" && template.Kind != "advance" {
+		return 0, errors.New("kind must be 'salary' or 'advance'")
+	}
+	if template.PayDay < 1 || template.PayDay > 31 {
+		return 0, errors.New("pay_day must be between 1 and 31")
+	}
+	if template.SubmitDaysBefore < 0 || template.SubmitDaysBefore > 27 {
+		return 0, errors.New("submit_days_before must be between 0 and 27")
+	}
+	if template.SourceProjectID > 0 {
+		var enterpriseID int
+		err := DB.QueryRow("SELECT enterprise_id FROM salary_projects WHERE id = ?", template.SourceProjectID).Scan(&enterpriseID)
+		if err == sql.ErrNoRows || (err == nil && enterpriseID != template.EnterpriseID) {
+			return 0, errors.New("source project does not belong to this enterprise")
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now().Unix()
+	template.Active = true
+	template.CreatedAt = now
+	template.UpdatedAt = now
+
+	result, err := DB.Exec(`
+		INSERT INTO payroll_templates (
+			enterprise_id, name, kind, pay_day, submit_days_before,
+			payment_purpose, source_project_id, active, created_by, created_at, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, 1, ?, ?, ?)
+	`,
+		template.EnterpriseID,
+		template.Name,
+		template.Kind,
+		template.PayDay,
+		template.SubmitDaysBefore,
+		template.PaymentPurpose,
+		sql.NullInt64{Int64: template.SourceProjectID, Valid: template.SourceProjectID > 0},
+		template.CreatedBy,
+		template.CreatedAt,
+		template.UpdatedAt,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	template.ID = id
+	return id, nil
+}
+
+const payrollTemplateColumns = `
+	id, enterprise_id, name, kind, pay_day, submit_days_before,
+	COALESCE(payment_purpose, ''), COALESCE(source_project_id, 0), active,
+	created_by, created_at, updated_at
+`
+
+func scanPayrollTemplate(row interface{ Scan(...interface{}) error }, template *PayrollTemplate) error {
+	return row.Scan(
+		&template.ID, &template.EnterpriseID, &template.Name, &template.Kind,
+		&template.PayDay, &template.SubmitDaysBefore, &template.PaymentPurpose,
+		&template.SourceProjectID, &template.Active, &template.CreatedBy,
+		&template.CreatedAt, &template.UpdatedAt,
+	)
+}
+
+// GetPayrollTemplate retrieves a payroll template by ID
+func GetPayrollTemplate(templateID int64) (*PayrollTemplate, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var template PayrollTemplate
+	err := scanPayrollTemplate(DB.QueryRow("SELECT "+payrollTemplateColumns+" FROM payroll_templates WHERE id = ?", templateID), &template)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrPayrollTemplateNotFound
+		}
+		return nil, err
+	}
+	return &template, nil
+}
+
+// GetEnterprisePayrollTemplates retrieves the payroll templates of an enterprise
+func GetEnterprisePayrollTemplates(enterpriseID int) ([]PayrollTemplate, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query("SELECT "+payrollTemplateColumns+" FROM payroll_templates WHERE enterprise_id = ? ORDER BY id", enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []PayrollTemplate{}
+	for rows.Next() {
+		var template PayrollTemplate
+		if err := scanPayrollTemplate(rows, &template); err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+// SetPayrollTemplateActive pauses or resumes a payroll template
+func SetPayrollTemplateActive(templateID int64, active bool) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE payroll_templates SET active = ?, updated_at = ? WHERE id = ?
+	`, boolToInt(active), time.Now().Unix(), templateID)
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrPayrollTemplateNotFound
+	}
+	return nil
+}
+
+// CopyForwardPayrollTemplate creates the draft salary project of a template for payDate.
+// The payments are copied from the latest project of the template, or from the template's
+// source project for the first period. If the draft already exists it is returned as is.
+func CopyForwardPayrollTemplate(templateID int64, payDate time.Time) (*SalaryProject, error) {
+	template, err := GetPayrollTemplate(templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing, err := getTemplateSalaryProject(templateID, payDate.Unix()); err != nil || existing != nil {
+		return existing, err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Copy from the most recent project of this template that was not rejected
+	var sourceID int64
+	err = tx.QueryRow(`
+		SELECT id FROM salary_projects
+		WHERE template_id = ? AND status <> 'rejected' AND pay_date < ?
+		ORDER BY pay_date DESC
+		LIMIT 1
+	`, templateID, payDate.Unix()).Scan(&sourceID)
+	if err == sql.ErrNoRows {
+		sourceID = template.SourceProjectID
+	} else if err != nil {
+		return nil, err
+	}
+	if sourceID == 0 {
+		return nil, ErrNoPayrollSource
+	}
+
+	var enterpriseName string
+	if err := tx.QueryRow("SELECT name FROM enterprises WHERE id = ?", template.EnterpriseID).Scan(&enterpriseName); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrEnterpriseNotFound
+		}
+		return nil, err
+	}
+
+	// Employees terminated by the pay date are not carried forward
+	rows, err := tx.Query(`
+		SELECT p.employee_name, p.employee_position, p.amount, p.account_number, p.bank_name,
+			p.payment_purpose, COALESCE(p.employee_id, 0)
+		FROM salary_payments p
+		LEFT JOIN enterprise_employees e ON e.id = p.employee_id
+		WHERE p.project_id = ? AND p.status <> 'failed'
+		AND (e.terminated_at IS NULL OR e.terminated_at > ?)
+		ORDER BY p.id
+	`, sourceID, payDate.Unix())
+	if err != nil {
+		return nil, err
+	}
+	var payments []*SalaryPayment
+	var total float64
+	for rows.Next() {
+		payment := &SalaryPayment{}
+		if err := rows.Scan(&payment.EmployeeName, &payment.EmployeePosition, &payment.Amount,
+			&payment.AccountNumber, &payment.BankName, &payment.PaymentPurpose, &payment.EmployeeID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if template.PaymentPurpose != "" {
+			payment.PaymentPurpose = template.PaymentPurpose
+		}
+		total += payment.Amount
+		payments = append(payments, payment)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(payments) == 0 {
+		return nil, ErrNoPayrollSource
+	}
+
+	project := &SalaryProject{
+		EnterpriseID:   template.EnterpriseID,
+		EnterpriseName: enterpriseName,
+		EmployeeCount:  len(payments),
+		TotalAmount:    total,
+		DocumentURL:    fmt.Sprintf("payroll-template:%d", template.ID),
+		Comment:        fmt.Sprintf("%s for %s", template.Name, payDate.Format("2006-01-02")),
+		Status:         "draft",
+		SubmittedBy:    template.CreatedBy,
+		SubmittedAt:    time.Now().Unix(),
+		TemplateID:     template.ID,
+		PayDate:        payDate.Unix(),
+	}
+	result, err := tx.Exec(`
+		INSERT INTO salary_projects (
+			enterprise_id, enterprise_name, employee_count,
+			total_amount, document_url, comment, status,
+			submitted_by, submitted_at, template_id, pay_date
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`,
+		project.EnterpriseID,
+		project.EnterpriseName,
+		project.EmployeeCount,
+		project.TotalAmount,
+		project.DocumentURL,
+		project.Comment,
+		project.Status,
+		project.SubmittedBy,
+		project.SubmittedAt,
+		project.TemplateID,
+		project.PayDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+	project.ID, err = result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, payment := range payments {
+		payment.ProjectID = project.ID
+		payment.DocumentURL = project.DocumentURL
+	}
+	if _, err := insertSalaryPaymentsTx(tx, payments); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	LogTransaction(int64(template.CreatedBy), "salary_project_draft", &project.TotalAmount,
+		fmt.Sprintf("Draft salary project #%d created from template #%d (%s) for pay date %s",
+			project.ID, template.ID, template.Name, payDate.Format("2006-01-02")))
+
+	return project, nil
+}
+
+// getTemplateSalaryProject returns the project of a template for a pay date, or nil if there is none
+func getTemplateSalaryProject(templateID, payDate int64) (*SalaryProject, error) {
+	var project SalaryProject
+	err := DB.QueryRow(`
+		SELECT id, enterprise_id, enterprise_name, employee_count, total_amount,
+			document_url, COALESCE(comment, ''), status, submitted_by, submitted_at,
+			template_id, pay_date, COALESCE(confirmed_at, 0)
+		FROM salary_projects
+		WHERE template_id = ? AND pay_date = ?
+	`, templateID, payDate).Scan(
+		&project.ID, &project.EnterpriseID, &project.EnterpriseName, &project.EmployeeCount,
+		&project.TotalAmount, &project.DocumentURL, &project.Comment, &project.Status,
+		&project.SubmittedBy, &project.SubmittedAt, &project.TemplateID, &project.PayDate,
+		&project.ConfirmedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
+// GetSalaryProjectEnterpriseID returns the enterprise a salary project belongs to
+func GetSalaryProjectEnterpriseID(projectID int64) (int, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+
+	var enterpriseID int
+	err := DB.QueryRow("SELECT enterprise_id FROM salary_projects WHERE id = ?", projectID).Scan(&enterpriseID)
+	if err == sql.ErrNoRows {
+		return 0, ErrSalaryProjectNotFound
+	}
+	return enterpriseID, err
+}
+
+// UpdateDraftSalaryPayment changes the amount of a payment in a draft project.
+// An amount of zero removes the payment. Any change requires the draft to be confirmed again.
+func UpdateDraftSalaryPayment(projectID, paymentID int64, amount float64) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+	if amount < 0 {
+		return errors.New("amount cannot be negative")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireDraftProjectTx(tx, projectID); err != nil {
+		return err
+	}
+
+	var result sql.Result
+	if amount == 0 {
+		result, err = tx.Exec("DELETE FROM salary_payments WHERE id = ? AND project_id = ?", paymentID, projectID)
+	} else {
+		result, err = tx.Exec("UPDATE salary_payments SET amount = ? WHERE id = ? AND project_id = ?", amount, paymentID, projectID)
+	}
+	if err != nil {
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return ErrSalaryPaymentNotFound
+	}
+
+	_, err = tx.Exec(`
+		UPDATE salary_projects SET
+			employee_count = (SELECT COUNT(*) FROM salary_payments WHERE project_id = ?),
+			total_amount = (SELECT COALESCE(SUM(amount), 0) FROM salary_payments WHERE project_id = ?),
+			confirmed_by = NULL, confirmed_at = NULL
+		WHERE id = ?
+	`, projectID, projectID, projectID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// requireDraftProjectTx checks that a salary project exists and is still a draft
+func requireDraftProjectTx(tx *sql.Tx, projectID int64) error {
+	var status string
+	err := tx.QueryRow("SELECT status FROM salary_projects WHERE id = ?", projectID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrSalaryProjectNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != "draft" {
+		return ErrSalaryProjectNotDraft
+	}
+	return nil
+}
+
+// ConfirmDraftSalaryProject marks a draft project as checked by the enterprise.
+// Confirmed drafts are submitted for approval on their submission date.
+func ConfirmDraftSalaryProject(projectID int64, userID int) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requireDraftProjectTx(tx, projectID); err != nil {
+		return err
+	}
+
+	var paymentCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM salary_payments WHERE project_id = ?", projectID).Scan(&paymentCount); err != nil {
+		return err
+	}
+	if paymentCount == 0 {
+		return errors.New("draft salary project has no payments")
+	}
+
+	_, err = tx.Exec(`
+		UPDATE salary_projects SET confirmed_by = ?, confirmed_at = ? WHERE id = ?
+	`, userID, time.Now().Unix(), projectID)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Drafts confirmed after their submission date go out right away
+	_, err = SubmitDuePayrollDrafts(time.Now())
+	return err
+}
+
+// SubmitDuePayrollDrafts submits confirmed drafts whose submission date has come
+// for admin approval and returns how many were submitted
+func SubmitDuePayrollDrafts(now time.Time) (int, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT p.id, p.pay_date, t.submit_days_before, p.confirmed_by, p.total_amount
+		FROM salary_projects p
+		JOIN payroll_templates t ON t.id = p.template_id
+		WHERE p.status = 'draft' AND p.confirmed_at IS NOT NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	type dueDraft struct {
+		id, payDate, confirmedBy int64
+		submitDaysBefore         int
+		totalAmount              float64
+	}
+	var due []dueDraft
+	for rows.Next() {
+		var d dueDraft
+		if err := rows.Scan(&d.id, &d.payDate, &d.submitDaysBefore, &d.confirmedBy, &d.totalAmount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		submissionDate := time.Unix(d.payDate, 0).AddDate(0, 0, -d.submitDaysBefore)
+		if !now.Before(submissionDate) {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	submitted := 0
+	for _, d := range due {
+		result, err := DB.Exec(`
+			UPDATE salary_projects SET status = 'pending', submitted_by = ?, submitted_at = ?
+			WHERE id = ? AND status = 'draft' AND confirmed_at IS NOT NULL
+		`, d.confirmedBy, now.Unix(), d.id)
+		if err != nil {
+			return submitted, err
+		}
+		if affected, _ := result.RowsAffected(); affected == 0 {
+			continue
+		}
+		submitted++
+		LogTransaction(d.confirmedBy, "salary_project_submission", &d.totalAmount,
+			fmt.Sprintf("Recurring salary project #%d submitted for approval, pay date %s",
+				d.id, time.Unix(d.payDate, 0).Format("2006-01-02")))
+	}
+	return submitted, nil
+}
+
+// CreatePayrollReminders records a reminder for every draft that is close to its
+// submission date but has not been confirmed. At most one reminder per draft is made per day.
+func CreatePayrollReminders(now time.Time) (int, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT p.id, p.enterprise_id, p.pay_date, t.id, t.name, t.submit_days_before, t.created_by
+		FROM salary_projects p
+		JOIN payroll_templates t ON t.id = p.template_id
+		WHERE p.status = 'draft' AND p.confirmed_at IS NULL
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var reminders []PayrollReminder
+	var recipients []int64
+	for rows.Next() {
+		var reminder PayrollReminder
+		var payDate, createdBy int64
+		var name string
+		var submitDaysBefore int
+		if err := rows.Scan(&reminder.ProjectID, &reminder.EnterpriseID, &payDate,
+			&reminder.TemplateID, &name, &submitDaysBefore, &createdBy); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		pay := time.Unix(payDate, 0)
+		submissionDate := pay.AddDate(0, 0, -submitDaysBefore)
+		if now.Before(submissionDate.AddDate(0, 0, -payrollReminderLeadDays)) {
+			continue
+		}
+
+		if now.Before(submissionDate) {
+			reminder.Message = fmt.Sprintf("%s for %s is not confirmed; it is due for submission on %s",
+				name, pay.Format("2006-01-02"), submissionDate.Format("2006-01-02"))
+		} else {
+			reminder.Message = fmt.Sprintf("%s for %s is overdue: confirm the draft so it can be submitted for approval",
+				name, pay.Format("2006-01-02"))
+		}
+		reminders = append(reminders, reminder)
+		recipients = append(recipients, createdBy)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	today := now.Format("2006-01-02")
+	for i, reminder := range reminders {
+		_, err := DB.Exec(`
+			INSERT INTO payroll_reminders (template_id, project_id, enterprise_id, reminder_date, message, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, reminder.TemplateID, reminder.ProjectID, reminder.EnterpriseID, today, reminder.Message, now.Unix())
+		if err != nil {
+			if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+				continue
+			}
+			return created, err
+		}
+		created++
+		LogTransaction(recipients[i], "payroll_reminder", nil,
+			fmt.Sprintf("Salary project #%d: %s", reminder.ProjectID, reminder.Message))
+	}
+	return created, nil
+}
+
+// GetPayrollReminders retrieves the reminders of an enterprise, newest first
+func GetPayrollReminders(enterpriseID int) ([]PayrollReminder, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, template_id, project_id, enterprise_id, message, created_at
+		FROM payroll_reminders
+		WHERE enterprise_id = ?
+		ORDER BY created_at DESC, id DESC
+	`, enterpriseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []PayrollReminder{}
+	for rows.Next() {
+		var reminder PayrollReminder
+		if err := rows.Scan(&reminder.ID, &reminder.TemplateID, &reminder.ProjectID,
+			&reminder.EnterpriseID, &reminder.Message, &reminder.CreatedAt); err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	return reminders, rows.Err()
+}
+
+// RunPayrollCalendar creates upcoming drafts for active templates, submits confirmed
+// drafts that are due and reminds enterprises about unconfirmed ones
+func RunPayrollCalendar(now time.Time) error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query("SELECT " + payrollTemplateColumns + " FROM payroll_templates WHERE active = 1 ORDER BY id")
+	if err != nil {
+		return err
+	}
+	var templates []PayrollTemplate
+	for rows.Next() {
+		var template PayrollTemplate
+		if err := scanPayrollTemplate(rows, &template); err != nil {
+			rows.Close()
+			return err
+		}
+		templates = append(templates, template)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, template := range templates {
+		payDate := template.NextPayDate(now)
+		if !now.Before(template.SubmissionDate(payDate)) {
+			// Too late to submit this period unless its draft already exists
+			existing, err := getTemplateSalaryProject(template.ID, payDate.Unix())
+			if err != nil {
+				return err
+			}
+			if existing == nil {
+				payDate = template.NextPayDate(payDate.AddDate(0, 0, 1))
+			}
+		}
+		draftDate := template.SubmissionDate(payDate).AddDate(0, 0, -payrollDraftLeadDays)
+		if now.Before(draftDate) {
+			continue
+		}
+		if _, err := CopyForwardPayrollTemplate(template.ID, payDate); err != nil {
+			log.Printf("Error creating draft for payroll template %d: %v", template.ID, err)
+		}
+	}
+
+	if _, err := SubmitDuePayrollDrafts(now); err != nil {
+		return err
+	}
+	_, err = CreatePayrollReminders(now)
+	return err
+}
@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrNonceReused is returned when a signed request nonce has already been used
+var ErrNonceReused = errors.New("request nonce has already been used")
+
+// EnsureRequestNoncesTableExists creates the table used for replay protection
+func EnsureRequestNoncesTableExists() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS request_nonces (
+			nonce TEXT PRIMARY KEY,
+			request_timestamp INTEGER NOT NULL,
+			created_at INTEGER NOT NULL
+		)
+	`)
+	return err
+}
+
+// RegisterRequestNonce stores a nonce from a signed request.
+// Nonces older than retention are purged first, since requests that old are
+// rejected by the timestamp check anyway.
+func RegisterRequestNonce(nonce string, requestTimestamp int64, retention time.Duration) error {
+	if err := EnsureRequestNoncesTableExists(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := DB.Exec(`DELETE FROM request_nonces WHERE created_at < ?`, now.Add(-retention).Unix()); err != nil {
+		return err
+	}
+
+	_, err := DB.Exec(`
+		INSERT INTO request_nonces (nonce, request_timestamp, created_at)
+		VALUES (?, ?, ?)
+	`, nonce, requestTimestamp, now.Unix())
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint") {
+			return ErrNonceReused
+		}
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,444 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"finance/internal/models"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSalaryProjectNotFound is returned when a salary project does not exist
+	ErrSalaryProjectNotFound = errors.New("salary project not found")
+	// ErrSalaryProjectNotApproved is returned when disbursing a project that has not been approved
+	ErrSalaryProjectNotApproved = errors.New("only approved salary projects can be disbursed")
+	// ErrSalaryProjectNotDue is returned when disbursing an approved project before its pay date;
+	// the project is paid out by the resume job once the date has arrived
+	ErrSalaryProjectNotDue = errors.New("salary project is not due yet")
+	// ErrSalaryProjectNoPayments is returned for projects submitted without payment rows, such as
+	// JSON submissions made before payroll files; they have to be submitted again as a file
+	ErrSalaryProjectNoPayments = errors.New("salary project has no payments, submit it again as a payroll file")
+	// ErrPayeeDepositBlocked is returned when the deposit a salary is paid to is blocked or frozen
+	ErrPayeeDepositBlocked = errors.New("payee deposit is blocked or frozen")
+	// ErrPayeeDepositClosed is returned when the deposit a salary is paid to was closed
+	ErrPayeeDepositClosed = errors.New("payee deposit is closed")
+)
+
+// SalaryPaymentFailure describes a salary payment that could not be paid
+type SalaryPaymentFailure struct {
+	PaymentID     int64   `json:"payment_id"`
+	EmployeeName  string  `json:"employee_name"`
+	AccountNumber string  `json:"account_number"`
+	BankName      string  `json:"bank_name"`
+	Amount        float64 `json:"amount"`
+	Reason        string  `json:"reason"`
+}
+
+// SalaryDisbursementReport summarizes the payout of a salary project
+type SalaryDisbursementReport struct {
+	ProjectID     int64                  `json:"project_id"`
+	EnterpriseID  int                    `json:"enterprise_id"`
+	Status        string                 `json:"status"`
+	TotalCount    int                    `json:"total_count"`
+	PaidCount     int                    `json:"paid_count"`
+	InternalCount int                    `json:"internal_count"`
+	OutgoingCount int                    `json:"outgoing_count"`
+	FailedCount   int                    `json:"failed_count"`
+	PendingCount  int                    `json:"pending_count"`
+	PaidAmount    float64                `json:"paid_amount"`
+	FailedAmount  float64                `json:"failed_amount"`
+	Failures      []SalaryPaymentFailure `json:"failures"`
+	CompletedAt   int64                  `json:"completed_at,omitempty"`
+}
+
+// ensureSalaryDisbursementColumnsExist adds the columns used by the payout executor
+func ensureSalaryDisbursementColumnsExist() error {
+	columns := []struct{ table, column, definition string }{
+		{"salary_payments", "failure_reason", "TEXT"},
+		{"salary_payments", "processed_at", "INTEGER"},
+		{"salary_payments", "deposit_id", "INTEGER"},
+		{"salary_payments", "outgoing_payment_id", "INTEGER"},
+		{"salary_projects", "disbursed_at", "INTEGER"},
+	}
+	for _, c := range columns {
+		if err := ensureColumnExists(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return EnsureOutgoingPaymentsTableExists()
+}
+
+// DisburseSalaryProject pays out every pending payment of an approved salary project.
+// Each payment is settled in its own transaction that only succeeds while the payment
+// is still pending, so the run can be repeated after a crash without paying anyone twice.
+// Projects with a pay date are not paid out before that day.
+func DisburseSalaryProject(projectID, actorID int64) (*SalaryDisbursementReport, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var status string
+	var enterpriseID int
+	var payDate int64
+	err := DB.QueryRow("SELECT status, enterprise_id, COALESCE(pay_date, 0) FROM salary_projects WHERE id = ?", projectID).Scan(&status, &enterpriseID, &payDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSalaryProjectNotFound
+		}
+		return nil, err
+	}
+
+	if status == "approved" || status == "disbursing" {
+		if err := checkSalaryProjectHasPayments(projectID); err != nil {
+			return nil, err
+		}
+	}
+
+	switch status {
+	case "approved":
+		if payDate > time.Now().Unix() {
+			return nil, fmt.Errorf("%w: it pays out on %s", ErrSalaryProjectNotDue, time.Unix(payDate, 0).Format("2006-01-02"))
+		}
+		_, err = DB.Exec(`
+			UPDATE salary_projects SET status = 'disbursing'
+			WHERE id = ? AND status = 'approved'
+		`, projectID)
+		if err != nil {
+			return nil, err
+		}
+	case "disbursing":
+		// Resume an interrupted run
+	case "completed":
+		return GetSalaryDisbursementReport(projectID)
+	default:
+		return nil, ErrSalaryProjectNotApproved
+	}
+
+	rows, err := DB.Query(`
+		SELECT id FROM salary_payments
+		WHERE project_id = ? AND status = 'pending'
+		ORDER BY id
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	var paymentIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		paymentIDs = append(paymentIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, paymentID := range paymentIDs {
+		if err := disburseSalaryPayment(enterpriseID, paymentID, actorID); err != nil {
+			// The project stays in the disbursing status and is picked up again later
+			return nil, fmt.Errorf("salary payment %d: %w", paymentID, err)
+		}
+	}
+
+	result, err := DB.Exec(`
+		UPDATE salary_projects SET status = 'completed', disbursed_at = ?
+		WHERE id = ? AND status = 'disbursing'
+		AND NOT EXISTS (SELECT 1 FROM salary_payments WHERE project_id = ? AND status = 'pending')
+	`, time.Now().Unix(), projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := GetSalaryDisbursementReport(projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if affected, _ := result.RowsAffected(); affected > 0 {
+		metadata := fmt.Sprintf("Disbursed salary project #%d for enterprise ID %d: %d paid (%d internal, %d outgoing), %d failed",
+			projectID, enterpriseID, report.PaidCount, report.InternalCount, report.OutgoingCount, report.FailedCount)
+		LogTransaction(actorID, "salary_project_disbursed", &report.PaidAmount, metadata)
+	}
+
+	return report, nil
+}
+
+// checkSalaryProjectHasPayments returns ErrSalaryProjectNoPayments when a project has nothing to
+// pay out. Without the check a project without rows would be completed without paying anyone.
+func checkSalaryProjectHasPayments(projectID int64) error {
+	var exists bool
+	err := DB.QueryRow("SELECT EXISTS (SELECT 1 FROM salary_payments WHERE project_id = ?)", projectID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrSalaryProjectNoPayments
+	}
+	return nil
+}
+
+// disburseSalaryPayment settles a single salary payment. Business failures such as an
+// underfunded enterprise account mark the payment as failed; other errors are returned
+// and leave the payment pending so it is retried.
+func disburseSalaryPayment(enterpriseID int, paymentID, actorID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payErr := paySalaryPaymentTx(tx, enterpriseID, paymentID, actorID)
+	if payErr == nil {
+		return tx.Commit()
+	}
+	if !isSalaryPaymentFailure(payErr) {
+		return payErr
+	}
+	tx.Rollback()
+
+	_, err = DB.Exec(`
+		UPDATE salary_payments SET status = 'failed', failure_reason = ?, processed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, payErr.Error(), time.Now().Unix(), paymentID)
+	return err
+}
+
+// isSalaryPaymentFailure reports whether err is a final failure of a payment rather than a
+// transient error that should be retried
+func isSalaryPaymentFailure(err error) bool {
+	return errors.Is(err, ErrInsufficientEnterpriseBalance) || errors.Is(err, ErrPayeeDepositBlocked) ||
+		errors.Is(err, ErrPayeeDepositClosed)
+}
+
+// paySalaryPaymentTx debits the enterprise and credits the payee within tx.
+// The payment is claimed first, so a payment that is no longer pending is skipped.
+func paySalaryPaymentTx(tx *sql.Tx, enterpriseID int, paymentID, actorID int64) error {
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		UPDATE salary_payments SET status = 'paid', processed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, now, paymentID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		// Already settled by an earlier or concurrent run
+		return nil
+	}
+
+	var payment SalaryPayment
+	err = tx.QueryRow(`
+		SELECT id, project_id, employee_name, amount, account_number, bank_name, payment_purpose
+		FROM salary_payments WHERE id = ?
+	`, paymentID).Scan(
+		&payment.ID,
+		&payment.ProjectID,
+		&payment.EmployeeName,
+		&payment.Amount,
+		&payment.AccountNumber,
+		&payment.BankName,
+		&payment.PaymentPurpose,
+	)
+	if err != nil {
+		return err
+	}
+
+	account, err := getEnterpriseAccountTx(tx, enterpriseID)
+	if err != nil {
+		return err
+	}
+
+	reference := fmt.Sprintf("salary project #%d payment #%d", payment.ProjectID, payment.ID)
+	description := fmt.Sprintf("Salary payment to %s", payment.EmployeeName)
+	if err := postEnterpriseMovementTx(tx, account.ID, -payment.Amount, "salary_payout", reference, description, actorID); err != nil {
+		return err
+	}
+
+	depositID, found, err := findPayeeDepositTx(tx, payment.AccountNumber, payment.BankName)
+	if err != nil {
+		return err
+	}
+	if found {
+		if err := creditDepositTx(tx, depositID, payment.Amount, time.Now()); err != nil {
+			return err
+		}
+		err = recordDepositMovementTx(tx, &models.DepositMovement{
+			DepositID: depositID, Type: models.MovementSalary, Amount: payment.Amount,
+			Counterparty: enterpriseNameTx(tx, enterpriseID), Reference: reference,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE salary_payments SET deposit_id = ? WHERE id = ?", depositID, payment.ID)
+		return err
+	}
+
+	// The account is held at another bank, hand the payment over to interbank settlement
+	outgoingID, err := queueOutgoingPaymentTx(tx, &OutgoingPayment{
+		SourceType:          "salary_payment",
+		SourceID:            payment.ID,
+		EnterpriseAccountID: account.ID,
+		Amount:              payment.Amount,
+		Currency:            account.Currency,
+		BeneficiaryName:     payment.EmployeeName,
+		AccountNumber:       payment.AccountNumber,
+		BankName:            payment.BankName,
+		Purpose:             payment.PaymentPurpose,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE salary_payments SET outgoing_payment_id = ? WHERE id = ?", outgoingID, payment.ID)
+	return err
+}
+
+// findPayeeDepositTx looks up the deposit held in this system that matches a payroll
+// account number and bank name. found is false when the account belongs to another bank.
+func findPayeeDepositTx(tx *sql.Tx, accountNumber, bankName string) (int64, bool, error) {
+	depositID, err := strconv.ParseInt(strings.TrimSpace(accountNumber), 10, 64)
+	if err != nil {
+		return 0, false, nil
+	}
+
+	var status string
+	var isBlocked, isFrozen bool
+	err = tx.QueryRow(`
+		SELECT status, is_blocked, is_frozen FROM deposits
+		WHERE deposit_id = ? AND LOWER(TRIM(bank_name)) = LOWER(TRIM(?))
+	`, depositID, bankName).Scan(&status, &isBlocked, &isFrozen)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	if err := checkPayeeDeposit(depositID, status, isBlocked, isFrozen); err != nil {
+		return 0, false, err
+	}
+	return depositID, true, nil
+}
+
+// checkPayeeDeposit refuses payments to a deposit that cannot receive them, so the payment
+// fails with the reason instead of being booked
+func checkPayeeDeposit(depositID int64, status string, isBlocked, isFrozen bool) error {
+	if status == models.DepositClosed {
+		return fmt.Errorf("%w: deposit %d", ErrPayeeDepositClosed, depositID)
+	}
+	if isBlocked || isFrozen {
+		return fmt.Errorf("%w: deposit %d", ErrPayeeDepositBlocked, depositID)
+	}
+	return nil
+}
+
+// GetSalaryDisbursementReport builds the payout report of a salary project from its payments
+func GetSalaryDisbursementReport(projectID int64) (*SalaryDisbursementReport, error) {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return nil, err
+	}
+
+	report := &SalaryDisbursementReport{ProjectID: projectID, Failures: []SalaryPaymentFailure{}}
+	var disbursedAt sql.NullInt64
+	err := DB.QueryRow(`
+		SELECT enterprise_id, status, disbursed_at FROM salary_projects WHERE id = ?
+	`, projectID).Scan(&report.EnterpriseID, &report.Status, &disbursedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSalaryProjectNotFound
+		}
+		return nil, err
+	}
+	report.CompletedAt = disbursedAt.Int64
+
+	rows, err := DB.Query(`
+		SELECT id, employee_name, account_number, bank_name, amount, status,
+			COALESCE(failure_reason, ''), outgoing_payment_id IS NOT NULL
+		FROM salary_payments
+		WHERE project_id = ?
+		ORDER BY id
+	`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var failure SalaryPaymentFailure
+		var status string
+		var outgoing bool
+		if err := rows.Scan(&failure.PaymentID, &failure.EmployeeName, &failure.AccountNumber,
+			&failure.BankName, &failure.Amount, &status, &failure.Reason, &outgoing); err != nil {
+			return nil, err
+		}
+
+		report.TotalCount++
+		switch status {
+		case "paid":
+			report.PaidCount++
+			report.PaidAmount += failure.Amount
+			if outgoing {
+				report.OutgoingCount++
+			} else {
+				report.InternalCount++
+			}
+		case "failed":
+			report.FailedCount++
+			report.FailedAmount += failure.Amount
+			report.Failures = append(report.Failures, failure)
+		default:
+			report.PendingCount++
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// ResumeSalaryDisbursements pays out approved salary projects whose pay date has arrived and
+// finishes disbursements that were interrupted, for example because the server stopped
+func ResumeSalaryDisbursements() error {
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, COALESCE(processed_by, submitted_by) FROM salary_projects
+		WHERE (status = 'disbursing' OR status = 'approved' AND COALESCE(pay_date, 0) <= ?)
+		AND EXISTS (SELECT 1 FROM salary_payments WHERE project_id = salary_projects.id)
+		ORDER BY id
+	`, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	type pendingProject struct{ id, actorID int64 }
+	var projects []pendingProject
+	for rows.Next() {
+		var p pendingProject
+		if err := rows.Scan(&p.id, &p.actorID); err != nil {
+			rows.Close()
+			return err
+		}
+		projects = append(projects, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range projects {
+		if _, err := DisburseSalaryProject(p.id, p.actorID); err != nil {
+			log.Printf("Error resuming disbursement of salary project %d: %v", p.id, err)
+		}
+	}
+	return nil
+}
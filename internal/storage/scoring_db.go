@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"finance/internal/models"
+	"finance/internal/scoring"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrScoringRuleNotFound is returned when a scoring rule does not exist
+	ErrScoringRuleNotFound = errors.New("scoring rule not found")
+	// ErrLoanScoreNotFound is returned when a loan has not been scored yet
+	ErrLoanScoreNotFound = errors.New("loan has not been scored")
+)
+
+// incomeWindowDays is how far back salary credits are averaged into the monthly income
+const incomeWindowDays = 90
+
+// LoanScore is the stored scoring of a loan request
+type LoanScore struct {
+	LoanID int64 `json:"loan_id"`
+	scoring.Result
+	Profile  scoring.Profile `json:"profile"`
+	ScoredAt int64           `json:"scored_at"`
+}
+
+// EnsureScoringTablesExist creates the scoring tables. The default rules are seeded
+// together with the settings, so rules an admin removes are not brought back.
+func EnsureScoringTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS scoring_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			factor TEXT NOT NULL,
+			operator TEXT NOT NULL,
+			threshold REAL NOT NULL,
+			points INTEGER NOT NULL,
+			active INTEGER NOT NULL DEFAULT 1,
+			updated_by INTEGER,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS scoring_settings (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			settings TEXT NOT NULL,
+			updated_by INTEGER,
+			updated_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS loan_scores (
+			loan_id INTEGER PRIMARY KEY,
+			score INTEGER NOT NULL,
+			decision TEXT NOT NULL,
+			recommended_rate REAL NOT NULL,
+			max_amount REAL NOT NULL,
+			debt_to_income REAL NOT NULL,
+			reasons TEXT NOT NULL,
+			profile TEXT NOT NULL,
+			scored_at INTEGER NOT NULL,
+			FOREIGN KEY (loan_id) REFERENCES loans(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	return seedScoringDefaults()
+}
+
+// seedScoringDefaults stores the default settings and rules the first time scoring is used
+func seedScoringDefaults() error {
+	settings, err := json.Marshal(scoring.DefaultSettings())
+	if err != nil {
+		return err
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO scoring_settings (id, settings, updated_at) VALUES (1, ?, ?)
+	`, string(settings), now)
+	if err != nil {
+		return err
+	}
+	seeded, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if seeded == 0 {
+		return nil
+	}
+
+	for _, rule := range scoring.DefaultRules() {
+		_, err := tx.Exec(`
+			INSERT INTO scoring_rules (name, factor, operator, threshold, points, active, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, rule.Name, rule.Factor, rule.Operator, rule.Threshold, rule.Points, boolToInt(rule.Active), now)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetScoringRules returns all scoring rules, active or not
+func GetScoringRules() ([]scoring.Rule, error) {
+	if err := EnsureScoringTablesExist(); err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT id, name, factor, operator, threshold, points, active
+		FROM scoring_rules
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []scoring.Rule{}
+	for rows.Next() {
+		var rule scoring.Rule
+		var active int
+		if err := rows.Scan(&rule.ID, &rule.Name, &rule.Factor, &rule.Operator,
+			&rule.Threshold, &rule.Points, &active); err != nil {
+			return nil, err
+		}
+		rule.Active = active == 1
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// CreateScoringRule adds a scoring rule
+func CreateScoringRule(rule *scoring.Rule, adminID int64) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if err := EnsureScoringTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO scoring_rules (name, factor, operator, threshold, points, active, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, rule.Name, rule.Factor, rule.Operator, rule.Threshold, rule.Points, boolToInt(rule.Active), adminID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	rule.ID, err = result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(adminID, "scoring_rule_create", nil, fmt.Sprintf("Scoring rule #%d %q added", rule.ID, rule.Name))
+	return nil
+}
+
+// UpdateScoringRule replaces a scoring rule
+func UpdateScoringRule(rule *scoring.Rule, adminID int64) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	if err := EnsureScoringTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		UPDATE scoring_rules
+		SET name = ?, factor = ?, operator = ?, threshold = ?, points = ?, active = ?, updated_by = ?, updated_at = ?
+		WHERE id = ?
+	`, rule.Name, rule.Factor, rule.Operator, rule.Threshold, rule.Points, boolToInt(rule.Active), adminID, time.Now().Unix(), rule.ID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrScoringRuleNotFound
+	}
+
+	LogTransaction(adminID, "scoring_rule_update", nil, fmt.Sprintf("Scoring rule #%d %q updated", rule.ID, rule.Name))
+	return nil
+}
+
+// DeleteScoringRule removes a scoring rule
+func DeleteScoringRule(ruleID, adminID int64) error {
+	if err := EnsureScoringTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec("DELETE FROM scoring_rules WHERE id = ?", ruleID)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrScoringRuleNotFound
+	}
+
+	LogTransaction(adminID, "scoring_rule_delete", nil, fmt.Sprintf("Scoring rule #%d removed", ruleID))
+	return nil
+}
+
+// GetScoringSettings returns the current scoring settings
+func GetScoringSettings() (scoring.Settings, error) {
+	settings := scoring.DefaultSettings()
+	if err := EnsureScoringTablesExist(); err != nil {
+		return settings, err
+	}
+
+	var raw string
+	if err := DB.QueryRow("SELECT settings FROM scoring_settings WHERE id = 1").Scan(&raw); err != nil {
+		return settings, err
+	}
+	err := json.Unmarshal([]byte(raw), &settings)
+	return settings, err
+}
+
+// UpdateScoringSettings replaces the scoring settings
+func UpdateScoringSettings(settings scoring.Settings, adminID int64) error {
+	if settings.AutoDeclineScore >= settings.AutoApproveScore {
+		return errors.New("auto_decline_score must be lower than auto_approve_score")
+	}
+	if settings.MinRate < 0 || settings.MaxRate < settings.MinRate {
+		return errors.New("rates must satisfy 0 <= min_rate <= max_rate")
+	}
+	if settings.MaxDebtToIncome <= 0 || settings.RatePerPoint < 0 {
+		return errors.New("max_debt_to_income must be positive and rate_per_point cannot be negative")
+	}
+	if err := EnsureScoringTablesExist(); err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`
+		UPDATE scoring_settings SET settings = ?, updated_by = ?, updated_at = ? WHERE id = 1
+	`, string(raw), adminID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+
+	LogTransaction(adminID, "scoring_settings_update", nil, "Scoring settings updated: "+string(raw))
+	return nil
+}
+
+// BuildCreditProfile collects what the bank knows about a client for scoring a loan request.
+// The loan being scored is left out of the existing debt.
+func BuildCreditProfile(loan *models.Loan) (scoring.Profile, error) {
+	profile := scoring.Profile{
+		RequestedAmount:         loan.Amount,
+		RequestedTermMonths:     loan.Term,
+		RequestedMonthlyPayment: loan.MonthlyPayment,
+	}
+	now := time.Now()
+
+	var registeredAt time.Time
+	if err := DB.QueryRow("SELECT created_at FROM users WHERE id = ?", loan.UserID).Scan(&registeredAt); err != nil {
+		return profile, err
+	}
+	profile.AccountAgeDays = int(now.Sub(registeredAt).Hours() / 24)
+
+	deposits, err := GetDepositsByUserID(loan.UserID)
+	if err != nil {
+		return profile, err
+	}
+	profile.DepositCount = len(deposits)
+	for _, deposit := range deposits {
+		profile.DepositBalance += deposit.Amount
+	}
+
+	// Salary credited to the client's deposits is the income we can see
+	if err := EnsureEnterpriseTablesExist(); err != nil {
+		return profile, err
+	}
+	var salary float64
+	err = DB.QueryRow(`
+		SELECT COALESCE(SUM(sp.amount), 0)
+		FROM salary_payments sp
+		JOIN deposits d ON d.deposit_id = sp.deposit_id
+		WHERE d.client_id = ? AND sp.status = 'paid' AND sp.processed_at >= ?
+	`, loan.UserID, now.AddDate(0, 0, -incomeWindowDays).Unix()).Scan(&salary)
+	if err != nil {
+		return profile, err
+	}
+	profile.MonthlyIncome = salary / (incomeWindowDays / 30)
+
+	loans, err := GetUserLoans(loan.UserID)
+	if err != nil {
+		return profile, err
+	}
+	for _, existing := range loans {
+		if existing.ID == loan.ID {
+			continue
+		}
+		switch existing.Status {
+		case models.Completed:
+			profile.CompletedLoans++
+		case models.Active, models.Default:
+			paid, err := loanPaidAmount(existing.ID)
+			if err != nil {
+				return profile, err
+			}
+			profile.MonthlyDebtPayments += existing.MonthlyPayment
+			if outstanding := existing.TotalPayable - paid; outstanding > 0 {
+				profile.OutstandingDebt += outstanding
+			}
+			if existing.Status == models.Default {
+				profile.DefaultedLoans++
+			} else if isBehindSchedule(existing, paid, now) {
+				profile.LoansBehindSchedule++
+			}
+		}
+	}
+	return profile, nil
+}
+
+// loanPaidAmount returns the sum of payments made on a loan
+func loanPaidAmount(loanID int64) (float64, error) {
+	var paid float64
+	err := DB.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM loan_payments WHERE loan_id = ?", loanID).Scan(&paid)
+	return paid, err
+}
+
+// isBehindSchedule reports whether less than the installments due by now were paid
+func isBehindSchedule(loan *models.Loan, paid float64, now time.Time) bool {
+	if loan.StartDate == nil {
+		return false
+	}
+	monthsDue := 0
+	for due := loan.StartDate.AddDate(0, 1, 0); !due.After(now) && monthsDue < loan.Term; due = due.AddDate(0, 1, 0) {
+		monthsDue++
+	}
+	return paid+0.01 < float64(monthsDue)*loan.MonthlyPayment
+}
+
+// ScoreLoan scores a loan request with the current rules and stores the result
+func ScoreLoan(loan *models.Loan) (*LoanScore, error) {
+	if err := EnsureScoringTablesExist(); err != nil {
+		return nil, err
+	}
+
+	profile, err := BuildCreditProfile(loan)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := GetScoringRules()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := GetScoringSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	score := &LoanScore{
+		LoanID:   loan.ID,
+		Result:   scoring.Evaluate(profile, rules, settings),
+		Profile:  profile,
+		ScoredAt: time.Now().Unix(),
+	}
+
+	reasons, err := json.Marshal(score.Reasons)
+	if err != nil {
+		return nil, err
+	}
+	rawProfile, err := json.Marshal(score.Profile)
+	if err != nil {
+		return nil, err
+	}
+	_, err = DB.Exec(`
+		INSERT INTO loan_scores (loan_id, score, decision, recommended_rate, max_amount, debt_to_income, reasons, profile, scored_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(loan_id) DO UPDATE SET
+			score = excluded.score,
+			decision = excluded.decision,
+			recommended_rate = excluded.recommended_rate,
+			max_amount = excluded.max_amount,
+			debt_to_income = excluded.debt_to_income,
+			reasons = excluded.reasons,
+			profile = excluded.profile,
+			scored_at = excluded.scored_at
+	`, score.LoanID, score.Score, score.Decision, score.RecommendedRate, score.MaxAmount,
+		score.DebtToIncome, string(reasons), string(rawProfile), score.ScoredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return score, nil
+}
+
+// GetLoanScore returns the stored scoring of a loan
+func GetLoanScore(loanID int64) (*LoanScore, error) {
+	if err := EnsureScoringTablesExist(); err != nil {
+		return nil, err
+	}
+
+	var score LoanScore
+	var reasons, rawProfile string
+	err := DB.QueryRow(`
+		SELECT loan_id, score, decision, recommended_rate, max_amount, debt_to_income, reasons, profile, scored_at
+		FROM loan_scores WHERE loan_id = ?
+	`, loanID).Scan(&score.LoanID, &score.Score, &score.Decision, &score.RecommendedRate, &score.MaxAmount,
+		&score.DebtToIncome, &reasons, &rawProfile, &score.ScoredAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLoanScoreNotFound
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(reasons), &score.Reasons); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(rawProfile), &score.Profile); err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
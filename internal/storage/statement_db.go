@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"finance/internal/models"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// DefaultDepositCurrency is the currency of deposits, which do not record one of their own
+const DefaultDepositCurrency = "BYN"
+
+// depositMovementDescriptions are the statement texts of deposit movement types
+var depositMovementDescriptions = map[string]string{
+	models.MovementOpening:          "Deposit opened",
+	models.MovementTopUp:            "Top-up",
+	models.MovementWithdrawal:       "Withdrawal",
+	models.MovementTransferIn:       "Incoming transfer",
+	models.MovementTransferOut:      "Outgoing transfer",
+	models.MovementSalary:           "Salary",
+	models.MovementInterest:         "Interest",
+	models.MovementFee:              "Fee",
+	models.MovementCancelTopUp:      "Top-up cancelled",
+	models.MovementCancelWithdrawal: "Withdrawal cancelled",
+	models.MovementPaymentReturn:    "Returned payment",
+}
+
+// GetDepositStatement builds the statement of a deposit for movements booked between from
+// and to (inclusive). The opening balance is the current balance less everything booked since
+// from, so deposits opened before the ledger existed still get correct balances.
+func GetDepositStatement(depositID int64, from, to time.Time) (*models.Statement, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+	deposit, err := getDepositByID(depositID)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookedSince float64
+	err = DB.QueryRow(`
+		SELECT COALESCE(SUM(`+signedMovementAmount+`), 0) FROM deposit_movements
+		WHERE deposit_id = ? AND created_at >= ?
+	`, depositID, from).Scan(&bookedSince)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner string
+	DB.QueryRow("SELECT username FROM users WHERE id = ?", deposit.ClientID).Scan(&owner)
+
+	statement := &models.Statement{
+		AccountID:      strconv.FormatInt(deposit.DepositID, 10),
+		AccountName:    fmt.Sprintf("Deposit #%d", deposit.DepositID),
+		Owner:          owner,
+		Servicer:       deposit.BankName,
+		Currency:       DefaultDepositCurrency,
+		From:           from,
+		To:             to,
+		OpeningBalance: math.Round((deposit.Amount-bookedSince)*100) / 100,
+		Entries:        []models.StatementEntry{},
+	}
+	statement.ClosingBalance = statement.OpeningBalance
+
+	rows, err := DB.Query(`
+		SELECT `+depositMovementColumns+` FROM deposit_movements
+		WHERE deposit_id = ? AND created_at >= ? AND created_at <= ?
+		ORDER BY created_at, id
+	`, depositID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movement models.DepositMovement
+		if err := scanDepositMovement(rows, &movement); err != nil {
+			return nil, err
+		}
+		description := depositMovementDescriptions[movement.Type]
+		if movement.Channel == models.ChannelCashDesk {
+			description += " at the cash desk, branch " + movement.Branch
+		}
+		statement.Add(models.StatementEntry{
+			ID:           movement.ID,
+			BookedAt:     movement.CreatedAt,
+			Type:         movement.Type,
+			Description:  description,
+			Counterparty: movement.Counterparty,
+			Reference:    movement.Reference,
+			Amount:       movement.Signed(),
+		})
+		switch movement.Type {
+		case models.MovementInterest:
+			statement.TotalInterest += movement.Amount
+		case models.MovementFee:
+			statement.TotalFees += movement.Amount
+		}
+	}
+	return statement, rows.Err()
+}
+
+// GetEnterpriseAccountStatements builds one statement per settlement account of an enterprise
+// for movements created between from and to (inclusive)
+func GetEnterpriseAccountStatements(enterpriseID int, from, to time.Time) ([]models.Statement, error) {
+	enterpriseStatement, err := GetEnterpriseStatement(enterpriseID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	var owner string
+	DB.QueryRow("SELECT name FROM enterprises WHERE id = ?", enterpriseID).Scan(&owner)
+
+	statements := make([]models.Statement, 0, len(enterpriseStatement.Accounts))
+	for _, account := range enterpriseStatement.Accounts {
+		statement := models.Statement{
+			AccountID:      account.Account.AccountNumber,
+			AccountName:    fmt.Sprintf("Settlement account #%d", account.Account.ID),
+			Owner:          owner,
+			Currency:       account.Account.Currency,
+			From:           from,
+			To:             to,
+			OpeningBalance: account.OpeningBalance,
+			ClosingBalance: account.OpeningBalance,
+			Entries:        []models.StatementEntry{},
+		}
+		for _, movement := range account.Movements {
+			statement.Add(models.StatementEntry{
+				ID:          movement.ID,
+				BookedAt:    time.Unix(movement.CreatedAt, 0),
+				Type:        movement.Kind,
+				Description: movement.Description,
+				Reference:   movement.Reference,
+				Amount:      movement.Amount,
+			})
+		}
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
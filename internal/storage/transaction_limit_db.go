@@ -0,0 +1,624 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Operations that transaction limits apply to. They match the transaction types logged for
+// the operations, which is how the usage of a limit is counted.
+const (
+	LimitOperationTransfer          = "transfer"
+	LimitOperationWithdrawal        = "withdrawal"
+	LimitOperationInterbankTransfer = "interbank_transfer"
+	LimitOperationLoanPayment       = "loan_payment"
+)
+
+// Levels a limit is configured at. A more specific level overrides a broader one for every
+// kind of limit it sets; product limits refer to the deposit product for deposit operations
+// and to the loan product for loan payments.
+const (
+	LimitLevelGlobal  = "global"
+	LimitLevelProduct = "product"
+	LimitLevelUser    = "user"
+)
+
+// Error codes reported when an operation is over a limit
+const (
+	LimitCodeSingle      = "single_limit_exceeded"
+	LimitCodeDaily       = "daily_limit_exceeded"
+	LimitCodeMonthly     = "monthly_limit_exceeded"
+	LimitCodeHourlyCount = "hourly_count_exceeded"
+)
+
+var (
+	// ErrTransactionLimitExceeded is returned when an operation would go over a limit of the client
+	ErrTransactionLimitExceeded = errors.New("transaction limit exceeded")
+	// ErrTransactionLimitNotFound is returned for an unknown limit
+	ErrTransactionLimitNotFound = errors.New("transaction limit not found")
+	// ErrLimitRaiseNotFound is returned for an unknown or already revoked limit raise
+	ErrLimitRaiseNotFound = errors.New("limit raise not found or already revoked")
+	// ErrInvalidLimitOperation is returned for an operation that limits do not apply to
+	ErrInvalidLimitOperation = errors.New("operation must be one of transfer, withdrawal, interbank_transfer, loan_payment")
+)
+
+// TransactionLimit caps one operation at one level. Unset values leave the kind of limit to
+// the broader levels.
+type TransactionLimit struct {
+	ID          int64    `json:"id"`
+	Level       string   `json:"level"`
+	LevelID     int64    `json:"level_id,omitempty"` // Product or user ID; 0 for the global level
+	Operation   string   `json:"operation"`
+	SingleMax   *float64 `json:"single_max,omitempty"`
+	DailyMax    *float64 `json:"daily_max,omitempty"`
+	MonthlyMax  *float64 `json:"monthly_max,omitempty"`
+	HourlyCount *int     `json:"hourly_count,omitempty"`
+	UpdatedBy   int64    `json:"updated_by"`
+	UpdatedAt   int64    `json:"updated_at"`
+}
+
+// LimitRaise temporarily replaces the limits of a user for an operation until it expires
+type LimitRaise struct {
+	ID          int64    `json:"id"`
+	UserID      int64    `json:"user_id"`
+	Operation   string   `json:"operation"`
+	SingleMax   *float64 `json:"single_max,omitempty"`
+	DailyMax    *float64 `json:"daily_max,omitempty"`
+	MonthlyMax  *float64 `json:"monthly_max,omitempty"`
+	HourlyCount *int     `json:"hourly_count,omitempty"`
+	Reason      string   `json:"reason"`
+	GrantedBy   int64    `json:"granted_by"`
+	GrantedAt   int64    `json:"granted_at"`
+	ExpiresAt   int64    `json:"expires_at"`
+	RevokedBy   *int64   `json:"revoked_by,omitempty"`
+	RevokedAt   *int64   `json:"revoked_at,omitempty"`
+}
+
+// EffectiveLimit is what applies to a user for an operation after combining the levels and
+// any active raise, together with what the user has used so far
+type EffectiveLimit struct {
+	Operation    string   `json:"operation"`
+	SingleMax    *float64 `json:"single_max,omitempty"`
+	DailyMax     *float64 `json:"daily_max,omitempty"`
+	MonthlyMax   *float64 `json:"monthly_max,omitempty"`
+	HourlyCount  *int     `json:"hourly_count,omitempty"`
+	RaiseID      *int64   `json:"raise_id,omitempty"`
+	RaiseExpires *int64   `json:"raise_expires_at,omitempty"`
+	DailyUsed    float64  `json:"daily_used"`
+	MonthlyUsed  float64  `json:"monthly_used"`
+	HourlyUsed   int      `json:"hourly_used"`
+}
+
+// LimitExceededError tells which limit an operation would go over. It wraps ErrTransactionLimitExceeded.
+type LimitExceededError struct {
+	Code      string
+	Operation string
+	Limit     float64
+	Used      float64
+	Requested float64
+}
+
+func (e *LimitExceededError) Error() string {
+	if e.Code == LimitCodeHourlyCount {
+		return fmt.Sprintf("%s limit of %.0f operations per hour reached", e.Operation, e.Limit)
+	}
+	if e.Code == LimitCodeSingle {
+		return fmt.Sprintf("%s of %.2f is over the single operation limit of %.2f", e.Operation, e.Requested, e.Limit)
+	}
+	period := "daily"
+	if e.Code == LimitCodeMonthly {
+		period = "monthly"
+	}
+	return fmt.Sprintf("%s of %.2f would exceed the %s limit of %.2f (%.2f already used)",
+		e.Operation, e.Requested, period, e.Limit, e.Used)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return ErrTransactionLimitExceeded
+}
+
+// IsValidLimitOperation reports whether transaction limits apply to operation
+func IsValidLimitOperation(operation string) bool {
+	switch operation {
+	case LimitOperationTransfer, LimitOperationWithdrawal, LimitOperationInterbankTransfer, LimitOperationLoanPayment:
+		return true
+	}
+	return false
+}
+
+// LimitOperations lists the operations transaction limits apply to
+func LimitOperations() []string {
+	return []string{LimitOperationTransfer, LimitOperationWithdrawal, LimitOperationInterbankTransfer, LimitOperationLoanPayment}
+}
+
+// EnsureTransactionLimitTablesExist creates the limit configuration and limit raise tables
+func EnsureTransactionLimitTablesExist() error {
+	_, err := DB.Exec(`
+		CREATE TABLE IF NOT EXISTS transaction_limits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			level TEXT NOT NULL,
+			level_id INTEGER NOT NULL DEFAULT 0,
+			operation TEXT NOT NULL,
+			single_max REAL,
+			daily_max REAL,
+			monthly_max REAL,
+			hourly_count INTEGER,
+			updated_by INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			UNIQUE(level, level_id, operation)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec(`
+		CREATE TABLE IF NOT EXISTS limit_raises (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			operation TEXT NOT NULL,
+			single_max REAL,
+			daily_max REAL,
+			monthly_max REAL,
+			hourly_count INTEGER,
+			reason TEXT NOT NULL,
+			granted_by INTEGER NOT NULL,
+			granted_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL,
+			revoked_by INTEGER,
+			revoked_at INTEGER
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = DB.Exec(`CREATE INDEX IF NOT EXISTS idx_limit_raises_user ON limit_raises(user_id, operation, expires_at)`)
+	return err
+}
+
+// validateLimitValues checks the caps shared by limits and raises
+func validateLimitValues(singleMax, dailyMax, monthlyMax *float64, hourlyCount *int) error {
+	if singleMax == nil && dailyMax == nil && monthlyMax == nil && hourlyCount == nil {
+		return errors.New("at least one of single_max, daily_max, monthly_max and hourly_count is required")
+	}
+	for _, value := range []*float64{singleMax, dailyMax, monthlyMax} {
+		if value != nil && *value <= 0 {
+			return errors.New("limit amounts must be greater than 0")
+		}
+	}
+	if hourlyCount != nil && *hourlyCount <= 0 {
+		return errors.New("hourly_count must be greater than 0")
+	}
+	return nil
+}
+
+// SetTransactionLimit creates or replaces the limit of an operation at a level
+func SetTransactionLimit(limit *TransactionLimit) error {
+	if !IsValidLimitOperation(limit.Operation) {
+		return ErrInvalidLimitOperation
+	}
+	switch limit.Level {
+	case LimitLevelGlobal:
+		limit.LevelID = 0
+	case LimitLevelProduct, LimitLevelUser:
+		if limit.LevelID <= 0 {
+			return fmt.Errorf("level_id is required for %s limits", limit.Level)
+		}
+	default:
+		return errors.New("level must be one of global, product, user")
+	}
+	if err := validateLimitValues(limit.SingleMax, limit.DailyMax, limit.MonthlyMax, limit.HourlyCount); err != nil {
+		return err
+	}
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return err
+	}
+
+	limit.UpdatedAt = time.Now().Unix()
+	_, err := DB.Exec(`
+		INSERT INTO transaction_limits (level, level_id, operation, single_max, daily_max, monthly_max, hourly_count, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(level, level_id, operation) DO UPDATE SET
+			single_max = excluded.single_max,
+			daily_max = excluded.daily_max,
+			monthly_max = excluded.monthly_max,
+			hourly_count = excluded.hourly_count,
+			updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, limit.Level, limit.LevelID, limit.Operation, limit.SingleMax, limit.DailyMax, limit.MonthlyMax,
+		limit.HourlyCount, limit.UpdatedBy, limit.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	err = DB.QueryRow("SELECT id FROM transaction_limits WHERE level = ? AND level_id = ? AND operation = ?",
+		limit.Level, limit.LevelID, limit.Operation).Scan(&limit.ID)
+	if err != nil {
+		return err
+	}
+
+	metadata := fmt.Sprintf("Transaction limit #%d for %s at %s level %d set", limit.ID, limit.Operation, limit.Level, limit.LevelID)
+	LogTransaction(limit.UpdatedBy, "transaction_limit_update", nil, metadata)
+	return nil
+}
+
+// transactionLimitColumns lists the columns read by scanTransactionLimit
+const transactionLimitColumns = `id, level, level_id, operation, single_max, daily_max, monthly_max, hourly_count, updated_by, updated_at`
+
+// scanTransactionLimit reads a limit row selected with transactionLimitColumns
+func scanTransactionLimit(row interface{ Scan(...interface{}) error }, limit *TransactionLimit) error {
+	var singleMax, dailyMax, monthlyMax sql.NullFloat64
+	var hourlyCount sql.NullInt64
+	err := row.Scan(&limit.ID, &limit.Level, &limit.LevelID, &limit.Operation, &singleMax, &dailyMax, &monthlyMax,
+		&hourlyCount, &limit.UpdatedBy, &limit.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	limit.SingleMax = nullFloatPtr(singleMax)
+	limit.DailyMax = nullFloatPtr(dailyMax)
+	limit.MonthlyMax = nullFloatPtr(monthlyMax)
+	limit.HourlyCount = nullIntPtr(hourlyCount)
+	return nil
+}
+
+// GetTransactionLimits lists the configured limits, optionally of one level
+func GetTransactionLimits(level string) ([]TransactionLimit, error) {
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return nil, err
+	}
+	query := "SELECT " + transactionLimitColumns + " FROM transaction_limits"
+	var args []interface{}
+	if level != "" {
+		query += " WHERE level = ?"
+		args = append(args, level)
+	}
+	query += " ORDER BY operation, level, level_id"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	limits := []TransactionLimit{}
+	for rows.Next() {
+		var limit TransactionLimit
+		if err := scanTransactionLimit(rows, &limit); err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	return limits, rows.Err()
+}
+
+// DeleteTransactionLimit removes a limit, leaving the operation to the broader levels
+func DeleteTransactionLimit(limitID int64, adminID int64) error {
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return err
+	}
+	result, err := DB.Exec("DELETE FROM transaction_limits WHERE id = ?", limitID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrTransactionLimitNotFound
+	}
+
+	LogTransaction(adminID, "transaction_limit_delete", nil, fmt.Sprintf("Transaction limit #%d removed", limitID))
+	return nil
+}
+
+// GrantLimitRaise gives a user higher limits for an operation for a while
+func GrantLimitRaise(raise *LimitRaise) error {
+	if !IsValidLimitOperation(raise.Operation) {
+		return ErrInvalidLimitOperation
+	}
+	if raise.UserID <= 0 {
+		return errors.New("user_id is required")
+	}
+	if raise.Reason == "" {
+		return errors.New("reason is required")
+	}
+	if err := validateLimitValues(raise.SingleMax, raise.DailyMax, raise.MonthlyMax, raise.HourlyCount); err != nil {
+		return err
+	}
+	raise.GrantedAt = time.Now().Unix()
+	if raise.ExpiresAt <= raise.GrantedAt {
+		return errors.New("the raise must expire in the future")
+	}
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return err
+	}
+
+	result, err := DB.Exec(`
+		INSERT INTO limit_raises (user_id, operation, single_max, daily_max, monthly_max, hourly_count, reason, granted_by, granted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, raise.UserID, raise.Operation, raise.SingleMax, raise.DailyMax, raise.MonthlyMax, raise.HourlyCount,
+		raise.Reason, raise.GrantedBy, raise.GrantedAt, raise.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	if raise.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	metadata := fmt.Sprintf("Limit raise #%d for %s granted to user %d until %s: %s", raise.ID, raise.Operation,
+		raise.UserID, time.Unix(raise.ExpiresAt, 0).Format(time.RFC3339), raise.Reason)
+	LogTransaction(raise.GrantedBy, "limit_raise_grant", nil, metadata)
+	return nil
+}
+
+// RevokeLimitRaise ends a raise before it expires
+func RevokeLimitRaise(raiseID int64, operatorID int64) error {
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	result, err := DB.Exec(`
+		UPDATE limit_raises SET revoked_by = ?, revoked_at = ?
+		WHERE id = ? AND revoked_at IS NULL AND expires_at > ?
+	`, operatorID, now, raiseID, now)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrLimitRaiseNotFound
+	}
+
+	LogTransaction(operatorID, "limit_raise_revoke", nil, fmt.Sprintf("Limit raise #%d revoked", raiseID))
+	return nil
+}
+
+// limitRaiseColumns lists the columns read by scanLimitRaise
+const limitRaiseColumns = `id, user_id, operation, single_max, daily_max, monthly_max, hourly_count, reason,
+	granted_by, granted_at, expires_at, revoked_by, revoked_at`
+
+// scanLimitRaise reads a raise row selected with limitRaiseColumns
+func scanLimitRaise(row interface{ Scan(...interface{}) error }, raise *LimitRaise) error {
+	var singleMax, dailyMax, monthlyMax sql.NullFloat64
+	var hourlyCount, revokedBy, revokedAt sql.NullInt64
+	err := row.Scan(&raise.ID, &raise.UserID, &raise.Operation, &singleMax, &dailyMax, &monthlyMax, &hourlyCount,
+		&raise.Reason, &raise.GrantedBy, &raise.GrantedAt, &raise.ExpiresAt, &revokedBy, &revokedAt)
+	if err != nil {
+		return err
+	}
+	raise.SingleMax = nullFloatPtr(singleMax)
+	raise.DailyMax = nullFloatPtr(dailyMax)
+	raise.MonthlyMax = nullFloatPtr(monthlyMax)
+	raise.HourlyCount = nullIntPtr(hourlyCount)
+	if revokedBy.Valid {
+		raise.RevokedBy = &revokedBy.Int64
+	}
+	if revokedAt.Valid {
+		raise.RevokedAt = &revokedAt.Int64
+	}
+	return nil
+}
+
+// GetLimitRaises lists the raises of a user, or of all users when userID is 0, newest first.
+// With activeOnly set, expired and revoked raises are left out.
+func GetLimitRaises(userID int64, activeOnly bool) ([]LimitRaise, error) {
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return nil, err
+	}
+	query := "SELECT " + limitRaiseColumns + " FROM limit_raises WHERE 1 = 1"
+	var args []interface{}
+	if userID > 0 {
+		query += " AND user_id = ?"
+		args = append(args, userID)
+	}
+	if activeOnly {
+		query += " AND revoked_at IS NULL AND expires_at > ?"
+		args = append(args, time.Now().Unix())
+	}
+	query += " ORDER BY granted_at DESC, id DESC"
+
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	raises := []LimitRaise{}
+	for rows.Next() {
+		var raise LimitRaise
+		if err := scanLimitRaise(rows, &raise); err != nil {
+			return nil, err
+		}
+		raises = append(raises, raise)
+	}
+	return raises, rows.Err()
+}
+
+// GetEffectiveLimit combines the global, product and user limits of an operation with the
+// latest active raise of the user, and adds the user's usage of the current hour, day and month.
+// productID may be nil when the operation is not tied to a product.
+func GetEffectiveLimit(userID int64, operation string, productID *int64) (*EffectiveLimit, error) {
+	if !IsValidLimitOperation(operation) {
+		return nil, ErrInvalidLimitOperation
+	}
+	if err := ensureLimitCheckTablesExist(); err != nil {
+		return nil, err
+	}
+	return effectiveLimit(DB.QueryRow, userID, operation, productID)
+}
+
+// effectiveLimit builds the EffectiveLimit of GetEffectiveLimit, reading through queryRow
+func effectiveLimit(queryRow func(string, ...interface{}) *sql.Row, userID int64, operation string,
+	productID *int64) (*EffectiveLimit, error) {
+	effective := &EffectiveLimit{Operation: operation}
+	type levelKey struct {
+		level string
+		id    int64
+	}
+	levels := []levelKey{{LimitLevelGlobal, 0}}
+	if productID != nil {
+		levels = append(levels, levelKey{LimitLevelProduct, *productID})
+	}
+	levels = append(levels, levelKey{LimitLevelUser, userID})
+
+	for _, level := range levels {
+		var limit TransactionLimit
+		err := scanTransactionLimit(queryRow("SELECT "+transactionLimitColumns+
+			" FROM transaction_limits WHERE level = ? AND level_id = ? AND operation = ?", level.level, level.id, operation), &limit)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		effective.apply(limit.SingleMax, limit.DailyMax, limit.MonthlyMax, limit.HourlyCount)
+	}
+
+	now := time.Now()
+	var raise LimitRaise
+	err := scanLimitRaise(queryRow("SELECT "+limitRaiseColumns+` FROM limit_raises
+		WHERE user_id = ? AND operation = ? AND revoked_at IS NULL AND expires_at > ?
+		ORDER BY granted_at DESC, id DESC LIMIT 1`, userID, operation, now.Unix()), &raise)
+	switch {
+	case err == nil:
+		effective.apply(raise.SingleMax, raise.DailyMax, raise.MonthlyMax, raise.HourlyCount)
+		effective.RaiseID = &raise.ID
+		effective.RaiseExpires = &raise.ExpiresAt
+	case err != sql.ErrNoRows:
+		return nil, err
+	}
+
+	if err := effective.loadUsage(queryRow, userID, now); err != nil {
+		return nil, err
+	}
+	return effective, nil
+}
+
+// apply overrides the limits with the values set at a more specific level
+func (e *EffectiveLimit) apply(singleMax, dailyMax, monthlyMax *float64, hourlyCount *int) {
+	if singleMax != nil {
+		e.SingleMax = singleMax
+	}
+	if dailyMax != nil {
+		e.DailyMax = dailyMax
+	}
+	if monthlyMax != nil {
+		e.MonthlyMax = monthlyMax
+	}
+	if hourlyCount != nil {
+		e.HourlyCount = hourlyCount
+	}
+}
+
+// loadUsage counts the user's operations from the transaction history, leaving out cancelled ones
+func (e *EffectiveLimit) loadUsage(queryRow func(string, ...interface{}) *sql.Row, userID int64, now time.Time) error {
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	return queryRow(`
+		SELECT
+			COALESCE(SUM(CASE WHEN timestamp >= ? THEN amount END), 0),
+			COALESCE(SUM(amount), 0),
+			COUNT(CASE WHEN timestamp >= ? THEN 1 END)
+		FROM transaction_history th
+		WHERE user_id = ? AND transaction_type = ? AND timestamp >= ?
+		  AND NOT EXISTS (SELECT 1 FROM cancellation_tracking ct WHERE ct.transaction_id = th.id)
+	`, dayStart, now.Add(-time.Hour), userID, e.Operation, monthStart).Scan(&e.DailyUsed, &e.MonthlyUsed, &e.HourlyUsed)
+}
+
+// check returns the first limit that amount would go over
+func (e *EffectiveLimit) check(amount float64) error {
+	exceeded := func(code string, limit, used float64) error {
+		return &LimitExceededError{Code: code, Operation: e.Operation, Limit: limit, Used: used, Requested: amount}
+	}
+	if e.SingleMax != nil && amount > *e.SingleMax {
+		return exceeded(LimitCodeSingle, *e.SingleMax, 0)
+	}
+	if e.DailyMax != nil && e.DailyUsed+amount > *e.DailyMax {
+		return exceeded(LimitCodeDaily, *e.DailyMax, e.DailyUsed)
+	}
+	if e.MonthlyMax != nil && e.MonthlyUsed+amount > *e.MonthlyMax {
+		return exceeded(LimitCodeMonthly, *e.MonthlyMax, e.MonthlyUsed)
+	}
+	if e.HourlyCount != nil && e.HourlyUsed >= *e.HourlyCount {
+		return exceeded(LimitCodeHourlyCount, float64(*e.HourlyCount), float64(e.HourlyUsed))
+	}
+	return nil
+}
+
+// ensureLimitCheckTablesExist creates the tables a limit check reads. Operations that check
+// limits in their own transaction call it before opening the transaction.
+func ensureLimitCheckTablesExist() error {
+	if err := EnsureTransactionLimitTablesExist(); err != nil {
+		return err
+	}
+	return EnsureTransactionTablesExist()
+}
+
+// checkTransactionLimitTx returns a *LimitExceededError when the user may not make an operation
+// of amount. It runs in the transaction that debits the money and logs the operation, so that
+// concurrent operations cannot each pass the caps on the same usage.
+func checkTransactionLimitTx(tx *sql.Tx, userID int64, operation string, productID *int64, amount float64) error {
+	effective, err := effectiveLimit(tx.QueryRow, userID, operation, productID)
+	if err != nil {
+		return err
+	}
+	return effective.check(amount)
+}
+
+// GetClientLimits returns the effective limits of a client for every operation. When depositID
+// is set, the deposit operations include the limits of that deposit's product.
+func GetClientLimits(clientID, depositID int64) ([]*EffectiveLimit, error) {
+	var productID *int64
+	if depositID > 0 {
+		var err error
+		if productID, err = clientDepositProductID(clientID, depositID); err != nil {
+			return nil, err
+		}
+	}
+	limits := []*EffectiveLimit{}
+	for _, operation := range LimitOperations() {
+		operationProduct := productID
+		if operation == LimitOperationLoanPayment {
+			operationProduct = nil
+		}
+		limit, err := GetEffectiveLimit(clientID, operation, operationProduct)
+		if err != nil {
+			return nil, err
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// clientDepositProductID returns the product of a deposit of the client, or nil when the
+// deposit has no product or is not the client's
+func clientDepositProductID(clientID, depositID int64) (*int64, error) {
+	if err := EnsureDepositsTableExists(); err != nil {
+		return nil, err
+	}
+	var productID sql.NullInt64
+	err := DB.QueryRow("SELECT product_id FROM deposits WHERE deposit_id = ? AND client_id = ?", depositID, clientID).Scan(&productID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if !productID.Valid {
+		return nil, nil
+	}
+	return &productID.Int64, nil
+}
+
+func nullFloatPtr(value sql.NullFloat64) *float64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Float64
+}
+
+func nullIntPtr(value sql.NullInt64) *int {
+	if !value.Valid {
+		return nil
+	}
+	v := int(value.Int64)
+	return &v
+}
@@ -63,13 +63,18 @@ func EnsureTransactionTablesExist() error {
 		return err
 	}
 
-	// Drop the old cancellation tracking table if it exists to update the schema
-	dropTableQuery := `
-        DROP TABLE IF EXISTS cancellation_tracking
-    `
-	if _, err := DB.Exec(dropTableQuery); err != nil {
+	// Replace a cancellation tracking table of the old schema. The table is only dropped while it
+	// lacks the unique transaction_id, since dropping it every time would forget all cancellations.
+	var trackingSchema string
+	err := DB.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'cancellation_tracking'`).Scan(&trackingSchema)
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
+	if err == nil && !strings.Contains(trackingSchema, "transaction_id INTEGER NOT NULL UNIQUE") {
+		if _, err := DB.Exec(`DROP TABLE cancellation_tracking`); err != nil {
+			return err
+		}
+	}
 
 	// Create cancellation tracking table with transaction_id as the only unique constraint
 	cancellationTrackingQuery := `
@@ -81,7 +86,7 @@ func EnsureTransactionTablesExist() error {
             cancelled_at TIMESTAMP NOT NULL
         )
     `
-	_, err := DB.Exec(cancellationTrackingQuery)
+	_, err = DB.Exec(cancellationTrackingQuery)
 	return err
 }
 
@@ -91,6 +96,24 @@ func LogTransaction(userID int64, txType string, amount *float64, metadata strin
 		return 0, err
 	}
 
+	transactionID, err := insertTransaction(DB.Exec, userID, txType, amount, metadata)
+	if err != nil {
+		return 0, err
+	}
+	transactionLogged(userID, txType, amount, metadata)
+	return transactionID, nil
+}
+
+// logTransactionTx adds a transaction to the history inside tx, so that it commits or rolls
+// back together with the operation it records. The caller runs EnsureTransactionTablesExist
+// before opening tx and transactionLogged once tx has committed.
+func logTransactionTx(tx *sql.Tx, userID int64, txType string, amount *float64, metadata string) (int64, error) {
+	return insertTransaction(tx.Exec, userID, txType, amount, metadata)
+}
+
+// insertTransaction writes the encrypted history row through exec and returns its ID
+func insertTransaction(exec func(string, ...interface{}) (sql.Result, error), userID int64, txType string,
+	amount *float64, metadata string) (int64, error) {
 	// Create log data structure
 	logData := map[string]interface{}{
 		"user_id":   userID,
@@ -112,15 +135,21 @@ func LogTransaction(userID int64, txType string, amount *float64, metadata strin
         INSERT INTO transaction_history (user_id, transaction_type, amount, metadata, timestamp)
         VALUES (?, ?, ?, ?, ?)
     `
-	result, err := DB.Exec(query, userID, txType, amount, encryptedMetadata, time.Now())
+	result, err := exec(query, userID, txType, amount, encryptedMetadata, time.Now())
 	if err != nil {
 		return 0, err
 	}
+	return result.LastInsertId()
+}
 
-	// Also write to system log file
+// transactionLogged writes a logged transaction to the system log file and screens it
+func transactionLogged(userID int64, txType string, amount *float64, metadata string) {
 	logToFile(userID, txType, amount, metadata)
 
-	return result.LastInsertId()
+	// Screen the deposit movements the transaction posted
+	if !isAMLTransactionType(txType) {
+		requestAMLScreening()
+	}
 }
 
 // logToFile writes logs to an encrypted file
@@ -270,6 +299,13 @@ func CancelTransaction(transactionID int64, operatorID int) error {
 	if err := EnsureTransactionTablesExist(); err != nil {
 		return err
 	}
+	// Pledged deposits must stay blocked when a block is cancelled
+	if err := EnsureLoanSecurityTablesExist(); err != nil {
+		return err
+	}
+	if err := EnsureDepositsTableExists(); err != nil {
+		return err
+	}
 
 	// Start a transaction
 	tx, err := DB.Begin()
@@ -314,6 +350,9 @@ func CancelTransaction(transactionID int64, operatorID int) error {
 	if txDetails.Type == "delete" {
 		return errors.New("delete operations cannot be cancelled")
 	}
+	if txDetails.Type == "close" {
+		return ErrClosureNotCancellable
+	}
 
 	// Get deposit ID (needed for record keeping)
 	var depositID int64
@@ -350,8 +389,7 @@ func CancelTransaction(transactionID int64, operatorID int) error {
 		_, err = tx.Exec(`
 			UPDATE deposits
 			SET is_blocked = 0
-			WHERE client_id = ? AND deposit_id = ?
-		`, txDetails.UserID, depositID)
+			WHERE client_id = ? AND deposit_id = ? AND `+unpledgedDepositCondition, txDetails.UserID, depositID)
 
 	case "unblock":
 		// Re-block the deposit
@@ -361,6 +399,14 @@ func CancelTransaction(transactionID int64, operatorID int) error {
 			WHERE client_id = ? AND deposit_id = ?
 		`, txDetails.UserID, depositID)
 
+	case "top_up", "withdrawal":
+		// Give back or take back the money and keep the deposit it moved on
+		depositID, err = reverseDepositMovementTx(tx, transactionID)
+
+	case "interbank_transfer":
+		// Only transfers that have not been sent for clearing yet can be taken back
+		depositID, err = cancelInterbankTransferTx(tx, transactionID, int64(operatorID))
+
 	case "create":
 		// Simply log cancellation for deposit creation
 		// We don't actually delete the deposit
@@ -506,6 +552,13 @@ func CancelAllUserActions(userID, adminID int) (int, error) {
 	if err := EnsureTransactionTablesExist(); err != nil {
 		return 0, err
 	}
+	// Pledged deposits must stay blocked when a block is cancelled
+	if err := EnsureLoanSecurityTablesExist(); err != nil {
+		return 0, err
+	}
+	if err := EnsureDepositsTableExists(); err != nil {
+		return 0, err
+	}
 
 	// Start a transaction
 	tx, err := DB.Begin()
@@ -568,7 +621,7 @@ func CancelAllUserActions(userID, adminID int) (int, error) {
 			FROM transaction_history th
 			LEFT JOIN cancellation_tracking ct ON th.id = ct.transaction_id
 			WHERE th.user_id = ? 
-			AND th.transaction_type NOT IN ('delete', 'cancel_transfer', 'cancel_freeze', 'cancel_block', 'cancel_unblock')
+			AND th.transaction_type NOT IN ('delete', 'close', 'cancel_transfer', 'cancel_freeze', 'cancel_block', 'cancel_unblock', 'cancel_top_up', 'cancel_withdrawal', 'cancel_interbank_transfer')
 			AND ct.transaction_id IS NULL
 			ORDER BY th.timestamp DESC
 			LIMIT 1
@@ -600,8 +653,7 @@ func CancelAllUserActions(userID, adminID int) (int, error) {
 			_, err = tx.Exec(`
 				UPDATE deposits
 				SET is_blocked = 0
-				WHERE client_id = ? AND deposit_id = ?
-			`, userID, depositID)
+				WHERE client_id = ? AND deposit_id = ? AND `+unpledgedDepositCondition, userID, depositID)
 
 		case "unblock":
 			// Re-block the deposit
@@ -610,6 +662,16 @@ func CancelAllUserActions(userID, adminID int) (int, error) {
 				SET is_blocked = 1
 				WHERE client_id = ? AND deposit_id = ?
 			`, userID, depositID)
+
+		case "top_up", "withdrawal":
+			_, err = reverseDepositMovementTx(tx, txID)
+
+		case "interbank_transfer":
+			_, err = cancelInterbankTransferTx(tx, txID, int64(adminID))
+			if errors.Is(err, ErrOutgoingPaymentSent) {
+				// The money has left the bank, the transfer stays in place
+				continue
+			}
 		}
 
 		if err != nil {
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// unsafeFileChars matches characters that are replaced in stored file names
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// StoredFile describes an uploaded file saved to local storage
+type StoredFile struct {
+	Path     string `json:"path"`
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256"`
+}
+
+// UploadDir returns the root directory for uploaded files
+func UploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(".", "uploads")
+}
+
+// SaveUploadedFile stores an uploaded file under UploadDir()/subdir and
+// returns its location together with its SHA-256 checksum
+func SaveUploadedFile(fileHeader *multipart.FileHeader, subdir string) (*StoredFile, error) {
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	dir := filepath.Join(UploadDir(), subdir)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, err
+	}
+
+	baseName := unsafeFileChars.ReplaceAllString(filepath.Base(fileHeader.Filename), "_")
+	storedName := fmt.Sprintf("%d_%s", time.Now().UnixNano(), baseName)
+	path := filepath.Join(dir, storedName)
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0640)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+
+	return &StoredFile{
+		Path:     path,
+		FileName: fileHeader.Filename,
+		Size:     size,
+		SHA256:   hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
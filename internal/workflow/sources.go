@@ -0,0 +1,246 @@
+package workflow
+
+import (
+	"errors"
+	"finance/internal/models"
+	"finance/internal/storage"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Names of the built-in inbox sources
+const (
+	SourceUserRegistration      = "user_registration"
+	SourceLoan                  = "loan"
+	SourceSalaryProject         = "salary_project"
+	SourceEnterpriseTransfer    = "enterprise_transfer"
+	SourceEnterpriseApplication = "enterprise_application"
+	SourceAMLAlert              = "aml_alert"
+)
+
+func init() {
+	Register(Source{
+		Name:    SourceUserRegistration,
+		Roles:   []string{"admin"},
+		SLA:     24 * time.Hour,
+		Pending: pendingUsers,
+		Approve: func(id int64, _ Reviewer, _ string) error { return storage.ApproveUser(int(id)) },
+		Reject:  func(id int64, _ Reviewer, _ string) error { return storage.RejectUser(int(id)) },
+	})
+	Register(Source{
+		Name:    SourceLoan,
+		Roles:   []string{"admin", "manager"},
+		SLA:     48 * time.Hour,
+		Pending: pendingLoans,
+		Approve: approveLoan,
+		Reject:  func(id int64, reviewer Reviewer, _ string) error { return storage.RejectLoan(id, reviewer.ID) },
+	})
+	Register(Source{
+		Name:    SourceSalaryProject,
+		Roles:   []string{"admin"},
+		SLA:     24 * time.Hour,
+		Pending: pendingSalaryProjects,
+		Approve: approveSalaryProject,
+		Reject: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.RejectSalaryProject(id, reviewer.ID, comment)
+		},
+	})
+	Register(Source{
+		Name:    SourceEnterpriseTransfer,
+		Roles:   []string{"admin"},
+		SLA:     8 * time.Hour,
+		Pending: pendingEnterpriseTransfers,
+		Approve: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.ApproveEnterpriseTransfer(id, reviewer.ID, comment)
+		},
+		Reject: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.RejectEnterpriseTransfer(id, reviewer.ID, comment)
+		},
+	})
+	// Approving an AML alert reports it as suspicious activity, rejecting dismisses it
+	Register(Source{
+		Name:    SourceAMLAlert,
+		Roles:   []string{"operator", "admin"},
+		SLA:     24 * time.Hour,
+		Pending: pendingAMLAlerts,
+		Approve: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.ReportAMLAlert(id, reviewer.ID, comment)
+		},
+		Reject: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.DismissAMLAlert(id, reviewer.ID, comment)
+		},
+	})
+	Register(Source{
+		Name:    SourceEnterpriseApplication,
+		Roles:   []string{"admin"},
+		SLA:     72 * time.Hour,
+		Pending: pendingEnterpriseApplications,
+		Approve: func(id int64, reviewer Reviewer, comment string) error {
+			_, err := storage.ApproveEnterpriseApplication(id, reviewer.ID, comment)
+			return err
+		},
+		Reject: func(id int64, reviewer Reviewer, comment string) error {
+			return storage.RejectEnterpriseApplication(id, reviewer.ID, comment)
+		},
+	})
+}
+
+func pendingUsers() ([]Item, error) {
+	users, err := storage.GetPendingUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(users))
+	for _, user := range users {
+		items = append(items, Item{
+			ID:          int64(user.ID),
+			Title:       fmt.Sprintf("Registration of %s (%s)", user.Username, user.Role),
+			RequestedBy: int64(user.ID),
+			SubmittedAt: user.CreatedAt,
+			Details:     user,
+		})
+	}
+	return items, nil
+}
+
+func pendingLoans() ([]Item, error) {
+	loans, err := storage.GetPendingLoans()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(loans))
+	for _, loan := range loans {
+		amount := loan.Amount
+		items = append(items, Item{
+			ID:          loan.ID,
+			Title:       fmt.Sprintf("%s loan for %s, %d months", loan.Type, loan.Username, loan.Term),
+			Amount:      &amount,
+			RequestedBy: loan.UserID,
+			SubmittedAt: loan.CreatedAt,
+			Details:     loan,
+		})
+	}
+	return items, nil
+}
+
+// approveLoan follows the role specific loan flow: managers approve and activate in one step,
+// admins approve and leave activation to a manager
+func approveLoan(id int64, reviewer Reviewer, _ string) error {
+	if reviewer.Role != "manager" {
+		return storage.ApproveLoan(id, reviewer.ID)
+	}
+	if err := storage.ManagerApproveLoan(id, reviewer.ID); err != nil {
+		return err
+	}
+	loan, err := storage.GetLoan(id)
+	if err != nil {
+		return err
+	}
+	if loan.Status != models.Approved {
+		return nil
+	}
+	// A loan with guarantors is activated when the last of them accepts
+	if err := storage.ActivateLoan(id, reviewer.ID); err != nil && !errors.Is(err, storage.ErrGuarantorsPending) {
+		return err
+	}
+	return nil
+}
+
+func pendingSalaryProjects() ([]Item, error) {
+	projects, err := storage.GetPendingSalaryProjects()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(projects))
+	for _, project := range projects {
+		amount := project.TotalAmount
+		items = append(items, Item{
+			ID:          project.ID,
+			Title:       fmt.Sprintf("Salary project of %s, %d employees", project.EnterpriseName, project.EmployeeCount),
+			Amount:      &amount,
+			RequestedBy: int64(project.SubmittedBy),
+			SubmittedAt: time.Unix(project.SubmittedAt, 0),
+			Details:     project,
+		})
+	}
+	return items, nil
+}
+
+// approveSalaryProject approves a payroll and pays it out right away, or on its pay date when
+// that is later. A failed payout does not undo the approval; it is resumed later.
+func approveSalaryProject(id int64, reviewer Reviewer, comment string) error {
+	if err := storage.ApproveSalaryProject(id, reviewer.ID, comment); err != nil {
+		return err
+	}
+	_, err := storage.DisburseSalaryProject(id, reviewer.ID)
+	if err != nil && !errors.Is(err, storage.ErrSalaryProjectNotDue) {
+		log.Printf("Error disbursing salary project %d: %v", id, err)
+	}
+	return nil
+}
+
+func pendingEnterpriseTransfers() ([]Item, error) {
+	transfers, err := storage.GetPendingTransfers()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(transfers))
+	for _, transfer := range transfers {
+		amount := transfer.Amount
+		items = append(items, Item{
+			ID: transfer.ID,
+			Title: fmt.Sprintf("Transfer from enterprise #%d to enterprise #%d: %s",
+				transfer.FromEnterpriseID, transfer.ToEnterpriseID, transfer.Purpose),
+			Amount:      &amount,
+			RequestedBy: int64(transfer.RequestedBy),
+			SubmittedAt: time.Unix(transfer.RequestedAt, 0),
+			Details:     transfer,
+		})
+	}
+	return items, nil
+}
+
+func pendingEnterpriseApplications() ([]Item, error) {
+	applications, err := storage.GetEnterpriseApplications("pending", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(applications))
+	for _, application := range applications {
+		items = append(items, Item{
+			ID:          application.ID,
+			Title:       fmt.Sprintf("Onboarding of %s (%s)", application.Name, application.RegistrationNumber),
+			RequestedBy: int64(application.ApplicantID),
+			SubmittedAt: time.Unix(application.SubmittedAt, 0),
+			Details:     application,
+		})
+	}
+	return items, nil
+}
+
+func pendingAMLAlerts() ([]Item, error) {
+	alerts, err := storage.GetAMLAlerts(storage.AMLAlertOpen, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0, len(alerts))
+	for _, alert := range alerts {
+		amount := alert.Amount
+		items = append(items, Item{
+			ID:          alert.ID,
+			Title:       fmt.Sprintf("%s AML alert on deposit %d: %s", alert.Severity, alert.DepositID, alert.Reason),
+			Amount:      &amount,
+			RequestedBy: alert.ClientID,
+			SubmittedAt: time.Unix(alert.CreatedAt, 0),
+			Details:     alert,
+		})
+	}
+	return items, nil
+}
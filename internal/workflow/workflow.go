@@ -0,0 +1,259 @@
+// Package workflow gathers the pending work of the back office into one approval inbox.
+// Every approvable entity registers a Source that lists its pending items and knows how
+// to approve or reject them.
+package workflow
+
+import (
+	"errors"
+	"finance/internal/storage"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Inbox decisions
+const (
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+)
+
+var (
+	// ErrUnknownSource is returned for a source that was never registered
+	ErrUnknownSource = errors.New("unknown inbox source")
+	// ErrNotAllowed is returned when the reviewer's role cannot work a source
+	ErrNotAllowed = errors.New("reviewer is not allowed to process this source")
+	// ErrInvalidAction is returned for a decision other than approve or reject
+	ErrInvalidAction = errors.New("action must be either 'approve' or 'reject'")
+)
+
+// Reviewer is the staff member working the inbox
+type Reviewer struct {
+	ID   int64
+	Role string
+}
+
+// Item is a pending piece of work shown in the inbox
+type Item struct {
+	Source      string      `json:"source"`
+	ID          int64       `json:"id"`
+	Title       string      `json:"title"`
+	Amount      *float64    `json:"amount,omitempty"`
+	RequestedBy int64       `json:"requested_by,omitempty"`
+	SubmittedAt time.Time   `json:"submitted_at"`
+	AssignedTo  int64       `json:"assigned_to,omitempty"`
+	AgeHours    float64     `json:"age_hours"`
+	SLAHours    float64     `json:"sla_hours"`
+	Overdue     bool        `json:"overdue"`
+	Details     interface{} `json:"details,omitempty"`
+}
+
+// Source is an approvable entity registered with the inbox
+type Source struct {
+	// Name identifies the source in the inbox and in decisions
+	Name string
+	// Roles lists the staff roles that may work the source
+	Roles []string
+	// SLA is how long an item may wait before it is overdue
+	SLA time.Duration
+	// Pending lists the items waiting for a decision
+	Pending func() ([]Item, error)
+	// Approve and Reject carry out a decision on a single item
+	Approve func(id int64, reviewer Reviewer, comment string) error
+	Reject  func(id int64, reviewer Reviewer, comment string) error
+}
+
+// allows reports whether role may work the source
+func (s *Source) allows(role string) bool {
+	for _, r := range s.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   []*Source
+)
+
+// Register adds a source to the inbox. Registering a name twice replaces the earlier source.
+func Register(source Source) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for i, existing := range registry {
+		if existing.Name == source.Name {
+			registry[i] = &source
+			return
+		}
+	}
+	registry = append(registry, &source)
+}
+
+// Sources returns the names of the sources the role may work
+func Sources(role string) []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := []string{}
+	for _, source := range registry {
+		if source.allows(role) {
+			names = append(names, source.Name)
+		}
+	}
+	return names
+}
+
+// lookup returns the named source if the reviewer may work it
+func lookup(name string, reviewer Reviewer) (*Source, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	for _, source := range registry {
+		if source.Name == name {
+			if !source.allows(reviewer.Role) {
+				return nil, ErrNotAllowed
+			}
+			return source, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownSource, name)
+}
+
+// Filter narrows the inbox. Zero values do not filter.
+type Filter struct {
+	Source      string
+	AssignedTo  int64
+	Unassigned  bool
+	OverdueOnly bool
+	MinAmount   float64
+}
+
+// matches reports whether item passes the filter
+func (f Filter) matches(item *Item) bool {
+	if f.AssignedTo > 0 && item.AssignedTo != f.AssignedTo {
+		return false
+	}
+	if f.Unassigned && item.AssignedTo != 0 {
+		return false
+	}
+	if f.OverdueOnly && !item.Overdue {
+		return false
+	}
+	if f.MinAmount > 0 && (item.Amount == nil || *item.Amount < f.MinAmount) {
+		return false
+	}
+	return true
+}
+
+// Inbox lists the pending items the reviewer may work, oldest first
+func Inbox(reviewer Reviewer, filter Filter, now time.Time) ([]Item, error) {
+	if filter.Source != "" {
+		if _, err := lookup(filter.Source, reviewer); err != nil {
+			return nil, err
+		}
+	}
+
+	assignments, err := storage.GetInboxAssignments()
+	if err != nil {
+		return nil, err
+	}
+	assignees := make(map[string]int64, len(assignments))
+	for _, assignment := range assignments {
+		assignees[itemKey(assignment.Source, assignment.ItemID)] = assignment.AssigneeID
+	}
+
+	registryMu.RLock()
+	sources := make([]*Source, 0, len(registry))
+	for _, source := range registry {
+		if source.allows(reviewer.Role) && (filter.Source == "" || filter.Source == source.Name) {
+			sources = append(sources, source)
+		}
+	}
+	registryMu.RUnlock()
+
+	items := []Item{}
+	for _, source := range sources {
+		pending, err := source.Pending()
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", source.Name, err)
+		}
+		for _, item := range pending {
+			item.Source = source.Name
+			item.AssignedTo = assignees[itemKey(source.Name, item.ID)]
+			age := now.Sub(item.SubmittedAt)
+			item.AgeHours = roundHours(age)
+			item.SLAHours = roundHours(source.SLA)
+			item.Overdue = source.SLA > 0 && age > source.SLA
+			if filter.matches(&item) {
+				items = append(items, item)
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].SubmittedAt.Before(items[j].SubmittedAt)
+	})
+	return items, nil
+}
+
+// Assign hands an item to a reviewer. An assigneeID of 0 clears the assignment.
+// The assignee must be able to work the source.
+func Assign(reviewer Reviewer, sourceName string, itemID, assigneeID int64) error {
+	source, err := lookup(sourceName, reviewer)
+	if err != nil {
+		return err
+	}
+	if assigneeID == 0 {
+		return storage.DeleteInboxAssignment(sourceName, itemID)
+	}
+
+	allowed, err := storage.CheckUserRole(int(assigneeID), source.Roles...)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("%w: assignee #%d", ErrNotAllowed, assigneeID)
+	}
+
+	return storage.AssignInboxItem(&storage.InboxAssignment{
+		Source:     sourceName,
+		ItemID:     itemID,
+		AssigneeID: assigneeID,
+		AssignedBy: reviewer.ID,
+	})
+}
+
+// Decide approves or rejects a single item and clears its assignment once it is done
+func Decide(reviewer Reviewer, sourceName string, itemID int64, action, comment string) error {
+	source, err := lookup(sourceName, reviewer)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case ActionApprove:
+		err = source.Approve(itemID, reviewer, comment)
+	case ActionReject:
+		err = source.Reject(itemID, reviewer, comment)
+	default:
+		return ErrInvalidAction
+	}
+	if err != nil {
+		return err
+	}
+
+	return storage.DeleteInboxAssignment(sourceName, itemID)
+}
+
+// itemKey identifies an item across sources
+func itemKey(source string, itemID int64) string {
+	return fmt.Sprintf("%s:%d", source, itemID)
+}
+
+// roundHours converts a duration to hours with one decimal
+func roundHours(d time.Duration) float64 {
+	return float64(int64(d.Hours()*10)) / 10
+}
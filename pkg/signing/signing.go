@@ -0,0 +1,170 @@
+// Package signing implements HMAC request signing for the enterprise
+// integration API exposed under /external. Enterprise partners use it to sign
+// outgoing requests; the bank uses the same canonical form to verify them.
+//
+// Every request carries three headers:
+//
+//	X-Timestamp: unix time in seconds
+//	X-Nonce:     random value, never reused
+//	X-Signature: hex(HMAC-SHA256(secret, METHOD\nPATH?QUERY\nTIMESTAMP\nNONCE\nhex(SHA256(body))))
+//
+// Partners can either call SignRequest on each request or use NewClient:
+//
+//	client := signing.NewClient([]byte(secret))
+//	req, _ := http.NewRequest("POST", "http://localhost:8082/external/transfer-request", body)
+//	req.Header.Set("Authorization", "Bearer "+token)
+//	resp, err := client.Do(req)
+package signing
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header names carried by every signed request
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderNonce     = "X-Nonce"
+)
+
+// DefaultMaxSkew is how far a request timestamp may drift from the server clock
+const DefaultMaxSkew = 5 * time.Minute
+
+var (
+	ErrMissingHeaders   = errors.New("signature, timestamp and nonce headers are required")
+	ErrInvalidTimestamp = errors.New("invalid request timestamp")
+	ErrStaleTimestamp   = errors.New("request timestamp is outside the allowed window")
+	ErrInvalidSignature = errors.New("invalid request signature")
+)
+
+// CanonicalString builds the string that is signed for a request.
+// The body is represented by its SHA-256 hash so large payloads are not copied.
+func CanonicalString(method, path, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		strings.ToUpper(method),
+		path,
+		timestamp,
+		nonce,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+// ComputeSignature returns the hex encoded HMAC-SHA256 signature for a request
+func ComputeSignature(secret []byte, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(CanonicalString(method, path, timestamp, nonce, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewNonce generates a random nonce suitable for the X-Nonce header
+func NewNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// SignRequest signs req in place with the shared secret.
+// The request body is read and replaced so it can still be sent afterwards.
+func SignRequest(req *http.Request, secret []byte) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, ComputeSignature(secret, req.Method, req.URL.RequestURI(), timestamp, nonce, body))
+	return nil
+}
+
+// Verify checks the signature headers of a request against the shared secret.
+// It does not track nonces; callers must reject nonces they have already seen.
+func Verify(secret []byte, method, path string, header http.Header, body []byte, now time.Time, maxSkew time.Duration) error {
+	signature := header.Get(HeaderSignature)
+	timestamp := header.Get(HeaderTimestamp)
+	nonce := header.Get(HeaderNonce)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return ErrMissingHeaders
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	skew := now.Sub(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > maxSkew {
+		return ErrStaleTimestamp
+	}
+
+	expected := ComputeSignature(secret, method, path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Transport is an http.RoundTripper that signs every request it sends
+type Transport struct {
+	Secret []byte
+	Base   http.RoundTripper
+}
+
+// RoundTrip signs a copy of the request and passes it to the base transport
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	signed := req.Clone(req.Context())
+	if req.Body != nil && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		signed.Body = body
+	}
+	if err := SignRequest(signed, t.Secret); err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// NewClient returns an http.Client that signs all requests with secret
+func NewClient(secret []byte) *http.Client {
+	return &http.Client{
+		Transport: &Transport{Secret: secret},
+		Timeout:   30 * time.Second,
+	}
+}
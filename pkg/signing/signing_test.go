@@ -0,0 +1,78 @@
+package signing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("test-secret")
+
+// signedRequest builds a request to the transfer endpoint and signs it with testSecret
+func signedRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://bank.test/external/transfer-request?enterprise=7", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRequest(req, testSecret); err != nil {
+		t.Fatalf("signing request: %v", err)
+	}
+	return req
+}
+
+// readBody returns the body the request will send, which SignRequest must leave in place
+func readBody(t *testing.T, req *http.Request) []byte {
+	t.Helper()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestSignRequestVerifies(t *testing.T) {
+	req := signedRequest(t, `{"amount":100}`)
+	body := readBody(t, req)
+	if string(body) != `{"amount":100}` {
+		t.Fatalf("body after signing = %q", body)
+	}
+
+	err := Verify(testSecret, req.Method, req.URL.RequestURI(), req.Header, body, time.Now(), DefaultMaxSkew)
+	if err != nil {
+		t.Fatalf("verifying signed request: %v", err)
+	}
+}
+
+func TestVerifyRejectsStaleTimestamp(t *testing.T) {
+	req := signedRequest(t, `{"amount":100}`)
+	body := readBody(t, req)
+
+	later := time.Now().Add(DefaultMaxSkew + time.Minute)
+	err := Verify(testSecret, req.Method, req.URL.RequestURI(), req.Header, body, later, DefaultMaxSkew)
+	if !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("verifying request signed %v ago: got %v, want %v", DefaultMaxSkew+time.Minute, err, ErrStaleTimestamp)
+	}
+
+	// Re-signing an old timestamp does not help either
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	req.Header.Set(HeaderTimestamp, old)
+	req.Header.Set(HeaderSignature, ComputeSignature(testSecret, req.Method, req.URL.RequestURI(), old, req.Header.Get(HeaderNonce), body))
+	err = Verify(testSecret, req.Method, req.URL.RequestURI(), req.Header, body, time.Now(), DefaultMaxSkew)
+	if !errors.Is(err, ErrStaleTimestamp) {
+		t.Fatalf("verifying request with an hour old timestamp: got %v, want %v", err, ErrStaleTimestamp)
+	}
+}
+
+func TestVerifyRejectsTamperedBody(t *testing.T) {
+	req := signedRequest(t, `{"amount":100}`)
+
+	err := Verify(testSecret, req.Method, req.URL.RequestURI(), req.Header, []byte(`{"amount":100000}`), time.Now(), DefaultMaxSkew)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("verifying tampered body: got %v, want %v", err, ErrInvalidSignature)
+	}
+}